@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ralph/pkg/ralph"
+)
+
+// maxAuditSummary bounds the input shown for a tool call in the table.
+const maxAuditSummary = 100
+
+// runAudit lists the tool calls recorded in AuditFile by runs with --audit,
+// those of the latest run unless --all is given. `ralph audit record` is
+// what the agent's hooks run to record one.
+func runAudit(argv []string) int {
+	if len(argv) > 0 && argv[0] == "record" {
+		return recordAudit()
+	}
+	fs := flag.NewFlagSet("ralph audit", flag.ExitOnError)
+	all := fs.Bool("all", false, "Show the tool calls of every run, not just the latest.")
+	iteration := fs.Int("iteration", 0, "Show only the tool calls of this iteration.")
+	tool := fs.String("tool", "", "Show only the calls of this tool, e.g. Bash.")
+	asJSON := fs.Bool("json", false, "Print the tool calls as JSON.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: ralph audit [flags]\n       ralph audit record < hook-input.json\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(argv)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	entries, err := ralph.ReadAudit(AuditFile)
+	if os.IsNotExist(err) {
+		fmt.Printf("❌ Error: no audit log in this directory (%s); run with --audit\n", AuditFile)
+		return 1
+	}
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return 1
+	}
+	var latest time.Time
+	for _, e := range entries {
+		if e.Run.After(latest) {
+			latest = e.Run
+		}
+	}
+	shown := []ralph.AuditEntry{}
+	for _, e := range entries {
+		if (*all || e.Run.Equal(latest)) && (*iteration == 0 || e.Iteration == *iteration) && (*tool == "" || strings.EqualFold(e.Tool, *tool)) {
+			shown = append(shown, e)
+		}
+	}
+	if *asJSON {
+		printJSON(shown)
+		return 0
+	}
+	if len(shown) == 0 {
+		fmt.Println("No tool calls recorded.")
+		return 0
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if *all {
+		fmt.Fprint(tw, "RUN\t")
+	}
+	fmt.Fprintln(tw, "TIME\tITERATION\tAGENT\tTOOL\tINPUT")
+	for _, e := range shown {
+		if *all {
+			fmt.Fprintf(tw, "%s\t", e.Run.Local().Format(time.DateTime))
+		}
+		summary := strings.Join(strings.Fields(e.Summary()), " ")
+		if r := []rune(summary); len(r) > maxAuditSummary {
+			summary = string(r[:maxAuditSummary-3]) + "..."
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", e.Time.Local().Format(time.TimeOnly), e.Iteration, e.Agent, e.Tool, summary)
+	}
+	tw.Flush()
+	return 0
+}
+
+// recordAudit appends the tool call a hook passes on stdin to the audit
+// log named in its environment. It never blocks the tool call: a failure
+// is only reported.
+func recordAudit() int {
+	input, err := io.ReadAll(os.Stdin)
+	if err == nil {
+		err = ralph.RecordToolCall(input, os.Getenv)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ralph audit record: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"ralph/pkg/ralph"
+)
+
+// benchRun is the outcome of one run of a bench.
+type benchRun struct {
+	Agent string `json:"agent"`
+	// Prompt is the prompt variant the run used, if the bench has --prompts.
+	Prompt string `json:"prompt,omitempty"`
+	Run    int    `json:"run"`
+	// Event and StopReason are those of the run's final event.
+	Event      string       `json:"event"`
+	StopReason string       `json:"stop_reason,omitempty"`
+	Completed  bool         `json:"completed"`
+	Iterations int          `json:"iterations"`
+	WallMS     int64        `json:"wall_ms"`
+	ExitCode   int          `json:"exit_code"`
+	Usage      *ralph.Usage `json:"usage,omitempty"`
+	// Verified reports whether the --verify command passed on the run's work
+	// tree, if there is one.
+	Verified *bool `json:"verified,omitempty"`
+}
+
+// benchFlags are the flags of ralph bench itself, not passed on to the runs.
+var benchFlags = map[string]bool{"agents": true, "prompts": true, "runs": true, "verify": true, "keep": true, "report": true}
+
+// benchVariant is one agent and prompt combination a bench compares.
+type benchVariant struct {
+	Agent, Prompt string
+}
+
+// runBench runs the same prompt several times with each of several agents,
+// or several prompts with the same agent, one run at a time, each as a
+// child `ralph run` in a fresh worktree, and compares how they did.
+func runBench(argv []string) int {
+	var (
+		agentList, promptList, verify, report string
+		runs                                  int
+		keep                                  bool
+	)
+	cfg, _, err := parseConfig("ralph bench", argv, func(fs *flag.FlagSet) {
+		fs.StringVar(&agentList, "agents", "", "Comma-separated agents to compare, e.g. claude,gemini.")
+		fs.StringVar(&promptList, "prompts", "", "Comma-separated prompt files to compare, e.g. PROMPT_A.md,PROMPT_B.md.")
+		fs.IntVar(&runs, "runs", 3, "Runs per agent.")
+		fs.StringVar(&verify, "verify", "", "Shell command that checks a run's work once it is over, e.g. \"go test ./...\" (default: --validate, else --check).")
+		fs.BoolVar(&keep, "keep", false, "Keep the runs' worktrees and branches for review.")
+		fs.StringVar(&report, "report", "", "Also write the report, with every run, to this file as JSON.")
+	})
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return 2
+	}
+	agents, prompts := splitList(agentList), splitList(promptList)
+	flags, agentArgs, passthrough := splitAgentArgs(argv)
+	switch {
+	case len(agents) == 0 && len(prompts) == 0:
+		fmt.Println("❌ Error: ralph bench needs --agents or --prompts, e.g. --agents claude,gemini")
+		return 2
+	case len(prompts) > 0 && len(stripFlags(flags, map[string]bool{"prompt": true, "prompt-text": true})) != len(flags):
+		fmt.Println("❌ Error: --prompts and --prompt or --prompt-text are mutually exclusive")
+		return 2
+	case runs < 1:
+		fmt.Println("❌ Error: --runs must be at least 1")
+		return 2
+	case cfg.AgentCmd != "":
+		fmt.Println("❌ Error: ralph bench and --agent-cmd are mutually exclusive; define custom agents under agents: in ralph.yaml")
+		return 2
+	case cfg.Race != "", len(cfg.Workdirs.values) > 0, cfg.Resume, cfg.Interactive, cfg.TUI:
+		fmt.Println("❌ Error: ralph bench cannot be combined with --race, --workdir, --resume, --interactive or --tui")
+		return 2
+	}
+	if verify == "" {
+		verify = orDefault(cfg.Validate, cfg.Check)
+	}
+	if len(agents) == 0 {
+		agents = []string{cfg.Agent}
+	}
+	for i, p := range prompts {
+		if _, err := os.Stat(p); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return 2
+		}
+		prompts[i], _ = filepath.Abs(p)
+	}
+	// Every run of a bench is under the same conditions: the variants take
+	// turns, rather than one running all its runs before the next.
+	var variants []benchVariant
+	for _, agent := range agents {
+		if len(prompts) == 0 {
+			variants = append(variants, benchVariant{Agent: agent})
+		}
+		for _, prompt := range prompts {
+			variants = append(variants, benchVariant{Agent: agent, Prompt: prompt})
+		}
+	}
+
+	ctx := context.Background()
+	orig, err := os.Getwd()
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return 1
+	}
+	root, err := ralph.GitTopLevel(ctx)
+	if err != nil {
+		fmt.Printf("❌ Error: ralph bench needs a git repository: %v\n", err)
+		return 2
+	}
+	rel, err := filepath.Rel(root, orig)
+	if err != nil {
+		rel = "."
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return 1
+	}
+	// The bench records the runs in its own history, as those in the
+	// worktrees go away with them.
+	childArgs := append(childRunArgs(orig, stripFlags(flags, benchFlags)), "--no-history")
+
+	// Ctrl+C reaches the running child through the terminal; either way the
+	// bench stops after it and reports what it has.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	name := time.Now().Format("20060102-150405")
+	var results []benchRun
+	names := make([]string, len(variants))
+	for i, v := range variants {
+		names[i] = v.name()
+	}
+	fmt.Printf("🏋️  Benchmarking %s, %d runs each\n", strings.Join(names, ", "), runs)
+bench:
+	for n := 1; n <= runs; n++ {
+		for i, v := range variants {
+			select {
+			case <-sigs:
+				fmt.Println("\n🛑 Interrupted. Reporting the runs so far.")
+				break bench
+			default:
+			}
+			id := v.Agent
+			if v.Prompt != "" {
+				id = fmt.Sprintf("%s-p%d", v.Agent, i%len(prompts)+1)
+			}
+			branch := fmt.Sprintf("%sbench-%s-%s-%d", WorktreeBranchPrefix, name, id, n)
+			dir := filepath.Join(root, WorktreesDir, fmt.Sprintf("bench-%s-%s-%d", name, id, n))
+			if err := ralph.GitAddWorktree(ctx, dir, branch); err != nil {
+				fmt.Printf("❌ Error: %v\n", err)
+				break bench
+			}
+			fmt.Printf("\n▶️  %s, run %d/%d (%s)\n", v.name(), n, runs, dir)
+			args := append(childArgs[:len(childArgs):len(childArgs)], "--agent", v.Agent)
+			if v.Prompt != "" {
+				args = append(args, "--prompt", v.Prompt, "--prompt-text=")
+			}
+			if passthrough {
+				args = append(append(args, "--"), agentArgs...)
+			}
+			var history *ralph.History
+			if !cfg.NoHistory {
+				if history, err = ralph.OpenHistory(HistoryFile); err != nil {
+					fmt.Printf("⚠️ Cannot record the run in the history: %v\n", err)
+				} else {
+					history.Label = fmt.Sprintf("bench %s: %s", name, v.name())
+					history.OnError = func(err error) {
+						fmt.Printf("⚠️ Failed to record the run in the history: %v\n", err)
+						history.OnError = nil
+					}
+				}
+			}
+			r := benchChild(exe, args, filepath.Join(dir, rel), fmt.Sprintf("[%s #%d] ", v.name(), n), sigs, history)
+			if history != nil {
+				history.Close()
+			}
+			r.Agent, r.Prompt, r.Run = v.Agent, v.Prompt, n
+			if verify != "" {
+				ok := benchVerify(ctx, verify, filepath.Join(dir, rel))
+				r.Verified = &ok
+			}
+			results = append(results, r)
+			fmt.Printf("   %s\n", describeBenchRun(r))
+
+			if keep {
+				continue
+			}
+			if err := ralph.GitRemoveWorktree(ctx, dir); err != nil {
+				fmt.Printf("⚠️ Failed to remove %s: %v\n", dir, err)
+			} else if err := ralph.GitDeleteBranch(ctx, branch); err != nil {
+				fmt.Printf("⚠️ Failed to delete branch %s: %v\n", branch, err)
+			}
+		}
+	}
+
+	summaries := summarizeBench(variants, results)
+	fmt.Println()
+	printBenchReport(summaries, verify != "", len(prompts) > 0)
+	if report != "" {
+		data, err := json.MarshalIndent(struct {
+			Variants []benchSummary `json:"variants"`
+			Runs     []benchRun     `json:"runs"`
+		}{summaries, results}, "", "  ")
+		if err == nil {
+			err = os.WriteFile(report, append(data, '\n'), 0644)
+		}
+		if err != nil {
+			fmt.Printf("❌ Error: cannot write the report: %v\n", err)
+			return 1
+		}
+		fmt.Printf("📝 Report written to %s\n", report)
+	}
+	return 0
+}
+
+// name describes v in the bench's output.
+func (v benchVariant) name() string {
+	if v.Prompt == "" {
+		return v.Agent
+	}
+	return v.Agent + " with " + filepath.Base(v.Prompt)
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// benchChild runs one child ralph, printing its log with label, and gathers
+// its result from its events, which are also recorded in history if it is
+// not nil. A signal on sigs is passed on to it; sigs is then refilled so the
+// bench stops too.
+func benchChild(exe string, args []string, dir, label string, sigs chan os.Signal, history *ralph.History) benchRun {
+	var r benchRun
+	start := time.Now()
+	out := &prefixPrinter{}
+	defer out.flush()
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = dir
+	cmd.Stderr = out.writer(label)
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		r.ExitCode = -1
+		return r
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigs:
+			abortProcess(cmd.Process)
+			sigs <- sig
+		case <-done:
+		}
+	}()
+	dec := json.NewDecoder(stdout)
+	for {
+		var ev ralph.StatusEvent
+		if err := dec.Decode(&ev); err != nil {
+			io.Copy(io.Discard, stdout)
+			break
+		}
+		if ev.Event == ralph.EventAgentOutput {
+			continue
+		}
+		if history != nil {
+			history.Observe(ev)
+		}
+		r.Iterations = max(r.Iterations, ev.Iteration)
+		if ev.Terminal() {
+			r.Event, r.StopReason, r.Usage = ev.Event, ev.StopReason, ev.TotalUsage
+			r.Completed = ev.Event == ralph.EventComplete
+		}
+	}
+	cmd.Wait()
+	close(done)
+	r.ExitCode = cmd.ProcessState.ExitCode()
+	r.WallMS = time.Since(start).Milliseconds()
+	if r.Event == "" {
+		r.Event = "no final event"
+	}
+	return r
+}
+
+// benchVerify runs the verify command in dir and reports whether it passed.
+func benchVerify(ctx context.Context, command, dir string) bool {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		fmt.Printf("   ❌ Verify failed: %v\n", err)
+		for _, line := range strings.Split(tailLines(ralph.StripANSI(string(out)), 5), "\n") {
+			fmt.Printf("      %s\n", line)
+		}
+	}
+	return err == nil
+}
+
+func describeBenchRun(r benchRun) string {
+	icon := "❌"
+	if r.Completed {
+		icon = "✅"
+	}
+	s := fmt.Sprintf("%s %s after %d iterations in %s", icon, orDefault(r.StopReason, r.Event), r.Iterations, (time.Duration(r.WallMS) * time.Millisecond).Round(time.Second))
+	if r.Usage != nil {
+		s += ", " + r.Usage.String()
+	}
+	if r.Verified != nil {
+		s += map[bool]string{true: ", verified", false: ", not verified"}[*r.Verified]
+	}
+	return s
+}
+
+// benchSummary aggregates the runs of one variant.
+type benchSummary struct {
+	Agent     string `json:"agent"`
+	Prompt    string `json:"prompt,omitempty"`
+	Runs      int    `json:"runs"`
+	Completed int    `json:"completed"`
+	// AvgIterationsToDone averages the iterations of completed runs.
+	AvgIterationsToDone float64 `json:"avg_iterations_to_done"`
+	AvgWallSeconds      float64 `json:"avg_wall_seconds"`
+	// Verified counts the runs whose work passed --verify, of VerifyRuns.
+	Verified   int `json:"verified"`
+	VerifyRuns int `json:"verify_runs"`
+	// AvgCostUSD averages the runs that reported a cost (CostRuns).
+	AvgCostUSD float64 `json:"avg_cost_usd"`
+	CostRuns   int     `json:"cost_runs"`
+}
+
+func summarizeBench(variants []benchVariant, results []benchRun) []benchSummary {
+	summaries := make([]benchSummary, len(variants))
+	for i, v := range variants {
+		s := &summaries[i]
+		s.Agent, s.Prompt = v.Agent, v.Prompt
+		var iterations, wall, cost float64
+		for _, r := range results {
+			if r.Agent != v.Agent || r.Prompt != v.Prompt {
+				continue
+			}
+			s.Runs++
+			wall += float64(r.WallMS) / 1000
+			if r.Completed {
+				s.Completed++
+				iterations += float64(r.Iterations)
+			}
+			if r.Verified != nil {
+				s.VerifyRuns++
+				if *r.Verified {
+					s.Verified++
+				}
+			}
+			if r.Usage != nil {
+				s.CostRuns++
+				cost += r.Usage.CostUSD
+			}
+		}
+		if s.Runs > 0 {
+			s.AvgWallSeconds = wall / float64(s.Runs)
+		}
+		if s.Completed > 0 {
+			s.AvgIterationsToDone = iterations / float64(s.Completed)
+		}
+		if s.CostRuns > 0 {
+			s.AvgCostUSD = cost / float64(s.CostRuns)
+		}
+	}
+	return summaries
+}
+
+func printBenchReport(summaries []benchSummary, verified, prompts bool) {
+	fmt.Println("📊 Bench results")
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if prompts {
+		fmt.Fprint(tw, "PROMPT\t")
+	}
+	fmt.Fprintln(tw, "AGENT\tRUNS\tDONE\tITERATIONS\tWALL TIME\tVERIFIED\tCOST/RUN")
+	for _, s := range summaries {
+		iterations, verify, cost := "-", "-", "-"
+		if s.Completed > 0 {
+			iterations = fmt.Sprintf("%.1f", s.AvgIterationsToDone)
+		}
+		if verified && s.VerifyRuns > 0 {
+			verify = fmt.Sprintf("%.0f%% (%d/%d)", 100*float64(s.Verified)/float64(s.VerifyRuns), s.Verified, s.VerifyRuns)
+		}
+		if s.CostRuns > 0 {
+			cost = fmt.Sprintf("$%.2f", s.AvgCostUSD)
+		}
+		wall := time.Duration(s.AvgWallSeconds * float64(time.Second)).Round(time.Second)
+		if prompts {
+			fmt.Fprintf(tw, "%s\t", filepath.Base(s.Prompt))
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t%s\n", s.Agent, s.Runs, s.Completed, iterations, wall, verify, cost)
+	}
+	tw.Flush()
+	if len(summaries) < 2 {
+		return
+	}
+
+	// The fastest to converge completes in the fewest iterations, then the
+	// least time; the cheapest costs the least per run.
+	var fastest, cheapest *benchSummary
+	for i := range summaries {
+		s := &summaries[i]
+		if s.Completed > 0 && (fastest == nil || s.AvgIterationsToDone < fastest.AvgIterationsToDone ||
+			s.AvgIterationsToDone == fastest.AvgIterationsToDone && s.AvgWallSeconds < fastest.AvgWallSeconds) {
+			fastest = s
+		}
+		if s.CostRuns > 0 && (cheapest == nil || s.AvgCostUSD < cheapest.AvgCostUSD) {
+			cheapest = s
+		}
+	}
+	if fastest != nil {
+		fmt.Printf("🏆 Fastest to converge: %s (%.1f iterations on average)\n", fastest.variant().name(), fastest.AvgIterationsToDone)
+	}
+	if cheapest != nil {
+		fmt.Printf("💰 Cheapest: %s ($%.2f per run)\n", cheapest.variant().name(), cheapest.AvgCostUSD)
+	}
+}
+
+func (s benchSummary) variant() benchVariant {
+	return benchVariant{Agent: s.Agent, Prompt: s.Prompt}
+}
+
+// stripFlags removes the flags in names, with their values, from argv.
+func stripFlags(argv []string, names map[string]bool) []string {
+	var out []string
+	for i := 0; i < len(argv); i++ {
+		a := argv[i]
+		name, hasValue := strings.TrimLeft(a, "-"), false
+		if !strings.HasPrefix(a, "-") {
+			out = append(out, a)
+			continue
+		}
+		if n, _, ok := strings.Cut(name, "="); ok {
+			name, hasValue = n, true
+		}
+		if !names[name] {
+			out = append(out, a)
+			continue
+		}
+		// Boolean flags take no separate value.
+		if !hasValue && name != "keep" && i+1 < len(argv) {
+			i++
+		}
+	}
+	return out
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ralph/pkg/ralph"
+)
+
+// Values for --ci.
+const (
+	CIGitHub = "github"
+)
+
+// Files GitHub Actions reads the step summary and outputs from.
+const (
+	GitHubStepSummaryEnv = "GITHUB_STEP_SUMMARY"
+	GitHubOutputEnv      = "GITHUB_OUTPUT"
+)
+
+// githubActions makes a run readable in a GitHub Actions job: its log is
+// folded into one group per iteration, problems become annotations, and
+// once the run is over it writes a step summary and step outputs.
+type githubActions struct {
+	mu         sync.Mutex
+	loop       *ralph.Loop
+	grouped    bool
+	iterations []ralph.StatusEvent
+	final      *ralph.StatusEvent
+}
+
+// useGitHubActions sets up --ci github for the loop: it masks the secrets
+// in the job log and returns the sink that follows the run.
+func useGitHubActions(cfg *Config, loop *ralph.Loop) *githubActions {
+	if !cfg.NoRedact {
+		for _, secret := range cfg.secretValues() {
+			// Masking the lines of a secret one by one is what Actions
+			// supports; short ones would mask too much.
+			for _, line := range strings.Split(secret, "\n") {
+				if line = strings.TrimSpace(line); len(line) >= 8 {
+					fmt.Fprintf(console, "::add-mask::%s\n", escapeWorkflowData(line))
+				}
+			}
+		}
+	}
+	return &githubActions{loop: loop}
+}
+
+// Observe follows the loop's status events.
+func (g *githubActions) Observe(ev ralph.StatusEvent) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch {
+	case ev.Event == ralph.EventIterationStart:
+		g.endGroup()
+		title := fmt.Sprintf("Iteration %d (%s)", ev.Iteration, ev.Agent)
+		if ev.Phase != "" {
+			title += ", phase " + ev.Phase
+		}
+		fmt.Fprintf(console, "::group::%s\n", escapeWorkflowData(title))
+		g.grouped = true
+	case ev.Event == ralph.EventIterationEnd:
+		g.iterations = append(g.iterations, ev)
+		if ev.AgentExitCode != nil && *ev.AgentExitCode != 0 {
+			annotate("warning", "Agent failed", fmt.Sprintf("Iteration %d: %s exited with status %d", ev.Iteration, ev.Agent, *ev.AgentExitCode))
+		}
+	case ev.Terminal():
+		g.endGroup()
+		final := ev
+		g.final = &final
+		level := "error"
+		switch ev.Event {
+		case ralph.EventComplete:
+			level = "notice"
+		case ralph.EventCancelled, ralph.EventCancelledGraceful:
+			level = "warning"
+		}
+		annotate(level, "Ralph: "+ev.Event, orDefault(ev.Message, ev.StopReason))
+	default:
+		switch ev.Event {
+		case ralph.EventTimeout, ralph.EventOOMKilled, ralph.EventRateLimited, ralph.EventValidationFailed, ralph.EventGuardFailed, ralph.EventReviewRejected, ralph.EventPromptRejected:
+			annotate("warning", "Ralph: "+ev.Event, fmt.Sprintf("Iteration %d: %s", ev.Iteration, orDefault(ev.Message, ev.Event)))
+		}
+	}
+}
+
+func (g *githubActions) endGroup() {
+	if g.grouped {
+		fmt.Fprintln(console, "::endgroup::")
+		g.grouped = false
+	}
+}
+
+// finish writes the step summary and outputs of the run, which exited
+// with code.
+func (g *githubActions) finish(code int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.endGroup()
+	if err := appendEnvFile(GitHubStepSummaryEnv, g.summary(code)); err != nil {
+		fmt.Fprintf(console, "⚠️ Failed to write the step summary: %v\n", err)
+	}
+	if err := appendEnvFile(GitHubOutputEnv, g.outputs(code)); err != nil {
+		fmt.Fprintf(console, "⚠️ Failed to write the step outputs: %v\n", err)
+	}
+}
+
+// status returns the final event of the run, or "error" if it ended
+// without one.
+func (g *githubActions) status() string {
+	if g.final == nil {
+		return "error"
+	}
+	return g.final.Event
+}
+
+// summary renders the run as Markdown for the job's summary page.
+func (g *githubActions) summary(code int) string {
+	var b strings.Builder
+	icon := "❌"
+	switch g.status() {
+	case ralph.EventComplete:
+		icon = "✅"
+	case ralph.EventCancelled, ralph.EventCancelledGraceful:
+		icon = "⏹️"
+	}
+	fmt.Fprintf(&b, "## %s Ralph: %s\n\n", icon, g.status())
+	b.WriteString("| | |\n|---|---|\n")
+	row := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "| %s | %s |\n", name, markdownCell(value))
+		}
+	}
+	agent := g.loop.AgentName
+	if g.loop.AgentVersion != "" {
+		agent += " (" + g.loop.AgentVersion + ")"
+	}
+	row("Agent", agent)
+	row("Task", g.loop.Task)
+	if g.final != nil {
+		row("Stop reason", g.final.StopReason)
+		row("Message", g.final.Message)
+		if !g.final.StartedAt.IsZero() {
+			row("Duration", g.final.Timestamp.Sub(g.final.StartedAt).Round(time.Second).String())
+		}
+	}
+	row("Iterations", strconv.Itoa(g.loop.Iteration()))
+	if usage, ok := g.loop.Usage(); ok {
+		row("Usage", usage.String())
+	}
+	row("Exit code", strconv.Itoa(code))
+
+	if payload := g.loop.Payload(); len(payload) > 0 {
+		b.WriteString("\n### Completion payload\n\n")
+		keys := make([]string, 0, len(payload))
+		for k := range payload {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, "- **%s:** %s\n", k, markdownCell(fmt.Sprint(payload[k])))
+		}
+	}
+
+	if len(g.iterations) > 0 {
+		b.WriteString("\n### Iterations\n\n| # | Agent | Duration | Exit | Usage |\n|---|---|---|---|---|\n")
+		for _, ev := range g.iterations {
+			exit, usage := "", ""
+			if ev.AgentExitCode != nil {
+				exit = strconv.Itoa(*ev.AgentExitCode)
+			}
+			if ev.Usage != nil {
+				usage = ev.Usage.String()
+			}
+			fmt.Fprintf(&b, "| %d | %s | %s | %s | %s |\n", ev.Iteration, markdownCell(ev.Agent), (time.Duration(ev.DurationMS) * time.Millisecond).Round(time.Second), exit, usage)
+		}
+	}
+	return b.String() + "\n"
+}
+
+// outputs renders the step outputs: status (the final event), stop_reason,
+// exit_code, completed, iterations, cost_usd and the agent's summary.
+func (g *githubActions) outputs(code int) string {
+	var b strings.Builder
+	set := func(name, value string) {
+		if strings.ContainsAny(value, "\r\n") {
+			delim := fmt.Sprintf("ralph_%d", time.Now().UnixNano())
+			fmt.Fprintf(&b, "%s<<%s\n%s\n%s\n", name, delim, value, delim)
+			return
+		}
+		fmt.Fprintf(&b, "%s=%s\n", name, value)
+	}
+	set("status", g.status())
+	stopReason := ""
+	if g.final != nil {
+		stopReason = g.final.StopReason
+	}
+	set("stop_reason", stopReason)
+	set("exit_code", strconv.Itoa(code))
+	set("completed", strconv.FormatBool(g.status() == ralph.EventComplete))
+	set("iterations", strconv.Itoa(g.loop.Iteration()))
+	if usage, ok := g.loop.Usage(); ok {
+		set("cost_usd", strconv.FormatFloat(usage.CostUSD, 'f', 4, 64))
+	}
+	summary, _ := g.loop.Payload()["summary"].(string)
+	set("summary", summary)
+	return b.String()
+}
+
+// annotate prints a workflow command that makes a problem annotation.
+func annotate(level, title, message string) {
+	title = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(title)
+	fmt.Fprintf(console, "::%s title=%s::%s\n", level, title, escapeWorkflowData(message))
+}
+
+// escapeWorkflowData escapes the data of a workflow command.
+func escapeWorkflowData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// markdownCell keeps a value on one line of a Markdown table.
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\r", "", "\n", "<br>").Replace(strings.TrimSpace(s))
+}
+
+// appendEnvFile appends text to the file named by env, if set.
+func appendEnvFile(env, text string) error {
+	path := os.Getenv(env)
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(text); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,704 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"ralph/pkg/ralph"
+)
+
+const (
+	// ConfigFile is the project configuration file read from the working directory.
+	ConfigFile = "ralph.yaml"
+	// StopSignalEnv overrides the default stop signal when --stop-signal is not given.
+	StopSignalEnv = "RALPH_STOP_SIGNAL"
+)
+
+// Values for --sandbox, which also takes docker:IMAGE.
+const (
+	SandboxDocker       = "docker"
+	SandboxDevcontainer = "devcontainer"
+)
+
+// Values for --stop-on-signal.
+const (
+	StopOnSignalWait      = "wait"
+	StopOnSignalImmediate = "immediate"
+)
+
+// Config holds every setting of a run. Values come from, in increasing order
+// of precedence: built-in defaults, ralph.yaml, environment variables, flags.
+type Config struct {
+	Agent                string                    `yaml:"agent"`
+	AgentCmd             string                    `yaml:"agent_cmd"`
+	AgentBin             string                    `yaml:"agent_bin"`
+	AgentArgs            []string                  `yaml:"agent_args"`
+	AgentModel           string                    `yaml:"agent_model"`
+	AgentBaseURL         string                    `yaml:"agent_base_url"`
+	AgentHeaders         headerVars                `yaml:"agent_headers"`
+	AgentEnv             envVars                   `yaml:"agent_env"`
+	AgentEnvFiles        envVars                   `yaml:"agent_env_files"`
+	MockFixture          string                    `yaml:"mock_fixture"`
+	MockDelay            time.Duration             `yaml:"mock_delay"`
+	MockDoneAfter        int                       `yaml:"mock_done_after"`
+	Agents               map[string]ralph.AgentDef `yaml:"agents"`
+	PTY                  bool                      `yaml:"pty"`
+	Sandbox              string                    `yaml:"sandbox"`
+	SandboxNetwork       string                    `yaml:"sandbox_network"`
+	SandboxCPUs          string                    `yaml:"sandbox_cpus"`
+	SandboxMemory        string                    `yaml:"sandbox_memory"`
+	SandboxMounts        stringList                `yaml:"sandbox_mounts"`
+	SandboxEnv           stringList                `yaml:"sandbox_env"`
+	CPULimit             float64                   `yaml:"cpu_limit"`
+	MemLimit             string                    `yaml:"mem_limit"`
+	Nice                 int                       `yaml:"nice"`
+	IONice               string                    `yaml:"ionice"`
+	AllowHosts           stringList                `yaml:"allow_hosts"`
+	Prompt               stringList                `yaml:"prompt"`
+	PromptText           string                    `yaml:"prompt_text"`
+	PromptBase           string                    `yaml:"-"`
+	Todo                 string                    `yaml:"todo"`
+	Task                 string                    `yaml:"-"`
+	Check                string                    `yaml:"check"`
+	Sleep                time.Duration             `yaml:"sleep"`
+	MaxBackoff           time.Duration             `yaml:"max_backoff"`
+	RateLimitWait        time.Duration             `yaml:"rate_limit_wait"`
+	RateLimitPattern     string                    `yaml:"rate_limit_pattern"`
+	StopSignal           string                    `yaml:"stop_signal"`
+	StopRegex            string                    `yaml:"stop_regex"`
+	DoneFile             string                    `yaml:"done_file"`
+	BlockedSignal        string                    `yaml:"blocked_signal"`
+	StopOnSignal         string                    `yaml:"stop_on_signal"`
+	Completion           *ralph.DetectorSpec       `yaml:"completion"`
+	Validate             string                    `yaml:"validate_cmd"`
+	Guard                string                    `yaml:"guard_cmd"`
+	RevertOnFail         bool                      `yaml:"revert_on_fail"`
+	PreHook              string                    `yaml:"pre_hook"`
+	PostHook             string                    `yaml:"post_hook"`
+	PostHookAbort        bool                      `yaml:"post_hook_abort"`
+	Hooks                ralph.LifecycleHooks      `yaml:"hooks"`
+	Reviewer             string                    `yaml:"reviewer"`
+	ReviewPrompt         string                    `yaml:"review_prompt"`
+	ReviewEvery          int                       `yaml:"review_every"`
+	FeedbackLines        int                       `yaml:"feedback_lines"`
+	Carryover            string                    `yaml:"carryover"`
+	CarryoverBytes       int                       `yaml:"carryover_bytes"`
+	NoCarryover          bool                      `yaml:"-"`
+	Memory               bool                      `yaml:"memory"`
+	SignalFooter         bool                      `yaml:"signal_footer"`
+	Specs                string                    `yaml:"specs"`
+	SpecsInclude         stringList                `yaml:"specs_include"`
+	SpecsExclude         stringList                `yaml:"specs_exclude"`
+	IgnoreFile           string                    `yaml:"ignore_file"`
+	InjectDiff           string                    `yaml:"inject_diff"`
+	InjectDiffBytes      int                       `yaml:"inject_diff_bytes"`
+	PromptWarnTokens     int                       `yaml:"prompt_warn_tokens"`
+	PromptMaxTokens      int                       `yaml:"prompt_max_tokens"`
+	PromptOverflow       string                    `yaml:"prompt_overflow"`
+	MaxIterations        int                       `yaml:"max_iterations"`
+	MaxDuration          time.Duration             `yaml:"max_duration"`
+	MaxCostUSD           float64                   `yaml:"max_cost_usd"`
+	MaxTokens            int                       `yaml:"max_tokens"`
+	Phases               []ralph.Phase             `yaml:"phases"`
+	Phase                string                    `yaml:"-"`
+	IterationTimeout     time.Duration             `yaml:"iteration_timeout"`
+	StatusFile           string                    `yaml:"status_file"`
+	StatusMode           string                    `yaml:"status_mode"`
+	StatusMaxBytes       int64                     `yaml:"status_max_bytes"`
+	WebhookURL           string                    `yaml:"webhook_url"`
+	WebhookSecret        string                    `yaml:"webhook_secret"`
+	NotifySlack          string                    `yaml:"notify_slack"`
+	NotifySlackEvery     int                       `yaml:"notify_slack_every"`
+	NotifyDesktop        bool                      `yaml:"notify_desktop"`
+	MetricsAddr          string                    `yaml:"metrics_addr"`
+	Web                  string                    `yaml:"web"`
+	WebToken             string                    `yaml:"web_token"`
+	APIAddr              string                    `yaml:"api_addr"`
+	APIToken             string                    `yaml:"api_token"`
+	Output               string                    `yaml:"output"`
+	CI                   string                    `yaml:"ci"`
+	Interactive          bool                      `yaml:"interactive"`
+	ConfirmPromptChanges bool                      `yaml:"confirm_prompt_changes"`
+	PromptHook           string                    `yaml:"prompt_hook"`
+	Quiet                bool                      `yaml:"quiet"`
+	NoKeys               bool                      `yaml:"no_keys"`
+	TUI                  bool                      `yaml:"tui"`
+	Verbose              bool                      `yaml:"verbose"`
+	LogFile              string                    `yaml:"log_file"`
+	LogMaxBytes          int64                     `yaml:"log_max_bytes"`
+	LogTimestamps        bool                      `yaml:"log_timestamps"`
+	MaxOutputBytes       int                       `yaml:"max_output_bytes"`
+	ArtifactsDir         string                    `yaml:"artifacts_dir"`
+	GitCommit            bool                      `yaml:"git_commit"`
+	GitNoVerify          bool                      `yaml:"git_no_verify"`
+	Worktree             bool                      `yaml:"worktree"`
+	GithubPR             bool                      `yaml:"github_pr"`
+	GithubPRBase         string                    `yaml:"github_pr_base"`
+	GithubPRDraft        bool                      `yaml:"github_pr_draft"`
+	PromptFromIssue      string                    `yaml:"prompt_from_issue"`
+	IssueComments        bool                      `yaml:"issue_comments"`
+	ProtectedBranches    stringList                `yaml:"protected_branches"`
+	AllowProtectedBranch bool                      `yaml:"allow_protected_branch"`
+	AllowDirty           bool                      `yaml:"allow_dirty"`
+	AllowNoGit           bool                      `yaml:"allow_no_git"`
+	StallAfter           int                       `yaml:"stall_after"`
+	MaxConsecutiveErrors int                       `yaml:"max_consecutive_errors"`
+	FallbackAfter        int                       `yaml:"fallback_after"`
+	Rotate               string                    `yaml:"rotate"`
+	Force                bool                      `yaml:"-"`
+	Resume               bool                      `yaml:"-"`
+	Schedule             string                    `yaml:"schedule"`
+	Race                 string                    `yaml:"race"`
+	Workdirs             stringList                `yaml:"-"`
+	NoHistory            bool                      `yaml:"no_history"`
+	Redact               stringList                `yaml:"redact"`
+	RedactEnv            stringList                `yaml:"redact_env"`
+	NoRedact             bool                      `yaml:"no_redact"`
+	Audit                bool                      `yaml:"audit"`
+	Record               string                    `yaml:"-"`
+	Replay               string                    `yaml:"-"`
+
+	// egressProxy, recorder, replay, redact and audit are shared by all
+	// agents of the run; see egress, session, redactor and auditLog.
+	egressProxy *ralph.EgressProxy
+	recorder    *ralph.SessionRecorder
+	replay      *ralph.SessionReplay
+	redact      *ralph.Redactor
+	audit       *ralph.AuditLog
+	// report collects what the run's loops did for --github-pr and
+	// --prompt-from-issue; issue is the issue fetched for the latter.
+	report *runReport
+	issue  *ralph.Issue
+}
+
+func defaultConfig() Config {
+	return Config{
+		Agent:             "claude",
+		Prompt:            stringList{values: []string{ralph.PromptFile}},
+		Sleep:             ralph.DefaultSleep,
+		MaxBackoff:        ralph.DefaultMaxBackoff,
+		RateLimitWait:     ralph.DefaultRateLimitWait,
+		CarryoverBytes:    ralph.DefaultCarryoverBytes,
+		InjectDiffBytes:   ralph.DefaultInjectDiffBytes,
+		PromptOverflow:    ralph.PromptOverflowRefuse,
+		Specs:             ralph.SpecsDir,
+		IgnoreFile:        ralph.IgnoreFile,
+		FallbackAfter:     ralph.DefaultFallbackAfter,
+		StopSignal:        ralph.DefaultStopSignal,
+		DoneFile:          ralph.DefaultDoneFile,
+		BlockedSignal:     ralph.DefaultBlockedSignal,
+		StatusMode:        ralph.StatusModeOverwrite,
+		StatusMaxBytes:    ralph.DefaultStatusMaxBytes,
+		Output:            OutputText,
+		StopOnSignal:      StopOnSignalWait,
+		LogMaxBytes:       ralph.DefaultLogMaxBytes,
+		MaxOutputBytes:    ralph.DefaultMaxOutputBytes,
+		ProtectedBranches: stringList{values: []string{"main", "master"}},
+	}
+}
+
+// parseConfig builds the run configuration from ralph.yaml, the environment
+// and the command line. It returns the remaining positional arguments.
+// Arguments after -- are passed to the agent. Subcommands that accept the
+// run flags plus a few of their own register those through extra.
+func parseConfig(name string, args []string, extra func(fs *flag.FlagSet)) (Config, []string, error) {
+	args, agentArgs, passthrough := splitAgentArgs(args)
+	cfg := defaultConfig()
+	configPath := ConfigFile
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage:\n  ralph [run] [flags] [agent] [-- agent args]\n  ralph doctor [--no-probe] [flags] [agent]\n  ralph lint-prompt [--json] [--strict] [flags] [agent]\n  ralph init [--force]\n  ralph status\n  ralph audit [--all] [--iteration n] [--tool name] [--json]\n  ralph pause | resume\n  ralph serve [--web addr] [flags] [agent]\n  ralph daemon [--dir dir] [--listen addr] [--token token]\n  ralph submit [--workdir dir] [--agent name] [--prompt file | --prompt-text text] [-- run flags]\n  ralph queue [--cancel id]\n  ralph version\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if extra != nil {
+		extra(fs)
+	}
+	fs.Var(&cfg.Workdirs, "C", "Shorthand for --workdir.")
+	fs.Var(&cfg.Workdirs, "workdir", "Run in this directory instead of the current one; repeat to run several directories one after another, each with its own ralph.yaml and prompt.")
+	fs.StringVar(&configPath, "config", configPath, "Path to the project configuration file.")
+	fs.StringVar(&cfg.Agent, "agent", cfg.Agent, "The AI agent to use (claude, gemini, copilot, codex, aider, vibe, opencode, anthropic, openai or openrouter for direct API calls, ollama for a local model, mock for scripted responses that cost nothing, or one defined under agents: in ralph.yaml). A comma-separated list such as claude,gemini falls back to the next agent when one keeps failing or hits a rate limit.")
+	fs.StringVar(&cfg.AgentCmd, "agent-cmd", cfg.AgentCmd, "Custom agent command template, e.g. 'aider --yes --message {{prompt}}'. Use {{prompt_file}} to pass a file path, or neither to pipe the prompt on stdin.")
+	fs.StringVar(&cfg.AgentBin, "agent-bin", cfg.AgentBin, "Run this executable instead of the one named in the agent's command, e.g. /opt/claude-1.0/bin/claude.")
+	fs.StringVar(&cfg.AgentModel, "agent-model", cfg.AgentModel, "Model for an API agent (anthropic, openai, openrouter, ollama or api: in ralph.yaml), e.g. gpt-4o.")
+	fs.StringVar(&cfg.AgentBaseURL, "agent-base-url", cfg.AgentBaseURL, "Base URL of an API agent, e.g. a LiteLLM or corporate gateway: --agent openai --agent-base-url http://litellm:4000/v1.")
+	fs.Var(&cfg.AgentHeaders, "agent-header", "Extra HTTP header for an API agent, as 'Name: value'; ${VAR} in the value reads an environment variable. Repeat for several.")
+	fs.Var(&cfg.AgentEnv, "agent-env", "Set an environment variable for the agent only, as NAME=value; repeat for several.")
+	fs.Var(&cfg.AgentEnvFiles, "agent-env-file", "Set an environment variable for the agent only to the contents of a file, as NAME=path (e.g. ANTHROPIC_API_KEY=/run/secrets/anthropic); repeat for several.")
+	fs.StringVar(&cfg.MockFixture, "mock-fixture", cfg.MockFixture, "YAML file of scripted responses for --agent mock: a list of {output, delay, exit_code, files}, one per run, the last repeating.")
+	fs.DurationVar(&cfg.MockDelay, "mock-delay", cfg.MockDelay, "How long every run of --agent mock takes.")
+	fs.IntVar(&cfg.MockDoneAfter, "mock-done-after", cfg.MockDoneAfter, "Run of --agent mock that prints the stop signal (default 3, or never with a --mock-fixture, which then decides).")
+	fs.StringVar(&cfg.Record, "record", cfg.Record, "Record every agent run, prompt and output, to this file (e.g. session.ralph) for --replay.")
+	fs.StringVar(&cfg.Replay, "replay", cfg.Replay, "Play back a --record file instead of running the agent, to debug detectors, hooks and summaries deterministically.")
+	fs.Var(&cfg.Redact, "redact", "Mask the matches of this regular expression in agent output, logs, artifacts, recordings and status events, besides well-known API key formats; repeat for several.")
+	fs.Var(&cfg.RedactEnv, "redact-env", "Mask the values of the environment variables matching this name pattern, e.g. DATABASE_URL, besides *_API_KEY, *_TOKEN, *_SECRET, *_PASSWORD and agent env files; repeat for several.")
+	fs.BoolVar(&cfg.NoRedact, "no-redact", cfg.NoRedact, "Mask no secrets at all.")
+	fs.BoolVar(&cfg.Audit, "audit", cfg.Audit, "Record every tool call of the agent, such as its shell commands and file edits, in "+AuditFile+" through the agent's hooks (set up for claude; other agents' hooks must run `ralph audit record`). See ralph audit.")
+	fs.BoolVar(&cfg.NoHistory, "no-history", cfg.NoHistory, "Do not record the run in "+HistoryFile+" (see ralph history).")
+	fs.BoolVar(&cfg.PTY, "pty", cfg.PTY, "Run the agent under a pseudo-terminal, for CLIs that misbehave without a TTY (not on Windows).")
+	fs.StringVar(&cfg.Sandbox, "sandbox", cfg.Sandbox, "Run every agent invocation in a container: docker or docker:IMAGE (default image "+ralph.DefaultSandboxImage+", which must have the agent installed; the working directory is mounted at the same path), or devcontainer for the project's devcontainer, via the devcontainer CLI.")
+	fs.StringVar(&cfg.SandboxNetwork, "sandbox-network", cfg.SandboxNetwork, "Docker network for the sandbox, e.g. none (default: Docker's bridge network).")
+	fs.StringVar(&cfg.SandboxCPUs, "sandbox-cpus", cfg.SandboxCPUs, "CPU limit for the sandbox, e.g. 2.")
+	fs.StringVar(&cfg.SandboxMemory, "sandbox-memory", cfg.SandboxMemory, "Memory limit for the sandbox, e.g. 4g.")
+	fs.Var(&cfg.SandboxMounts, "sandbox-mount", "Extra bind mount for the sandbox as src:dst[:ro], e.g. ~/.claude:/tmp/.claude for credentials (HOME is /tmp); repeat for several.")
+	fs.Var(&cfg.SandboxEnv, "sandbox-env", "Name of a host environment variable to pass into the sandbox, e.g. ANTHROPIC_API_KEY; repeat for several.")
+	fs.Float64Var(&cfg.CPULimit, "cpu-limit", cfg.CPULimit, "Limit the agent and everything it starts to this many CPUs, e.g. 1.5 (Linux, cgroups v2; 0 = no limit).")
+	fs.StringVar(&cfg.MemLimit, "mem-limit", cfg.MemLimit, "Limit the agent's memory, e.g. 4g; on Linux its processes are killed together when they exceed it (cgroups v2), elsewhere it is an rlimit.")
+	fs.IntVar(&cfg.Nice, "nice", cfg.Nice, "Run the agent at this niceness, e.g. 10 to keep the machine responsive.")
+	fs.StringVar(&cfg.IONice, "ionice", cfg.IONice, "I/O scheduling class for the agent: idle, best-effort or realtime (Linux).")
+	fs.Var(&cfg.AllowHosts, "allow-host", "Only let the agent reach this host over HTTP(S), e.g. api.anthropic.com or *.github.com, optionally with :port; repeat for several. ralph runs a local proxy and points the agent at it with HTTPS_PROXY and friends.")
+	fs.Var(&cfg.Prompt, "prompt", "Prompt file or glob re-read before every iteration; repeat to concatenate several (default PROMPT.md). Use - to read the prompt once from stdin.")
+	fs.StringVar(&cfg.PromptText, "prompt-text", cfg.PromptText, "Inline prompt text to use instead of a prompt file.")
+	fs.StringVar(&cfg.PromptFromIssue, "prompt-from-issue", cfg.PromptFromIssue, "Work on a GitHub issue, owner/repo#123 or its URL: its title and body go into the prompt (use {{issue}} to place them; without a prompt file the issue is the prompt), and a comment goes back to it once the run completes. Uses $GITHUB_TOKEN or $GH_TOKEN, or else the gh CLI.")
+	fs.BoolVar(&cfg.IssueComments, "issue-comments", cfg.IssueComments, "Also put the comments of the --prompt-from-issue issue into the prompt.")
+	fs.StringVar(&cfg.Todo, "todo", cfg.Todo, "Work through the open '- [ ]' items of this Markdown plan (e.g. fix_plan.md) one loop at a time, checking each off when its loop completes.")
+	fs.StringVar(&cfg.PromptBase, "prompt-base", cfg.PromptBase, "Directory to read relative prompt files from when they do not exist in the working directory.")
+	fs.StringVar(&cfg.Check, "check", cfg.Check, "The verification command (e.g., 'go test ./...'). Loop stops when this passes.")
+	fs.StringVar(&cfg.Validate, "validate-cmd", cfg.Validate, "Command that must pass before a stop signal is accepted (e.g., 'go test ./...').")
+	fs.StringVar(&cfg.Guard, "guard-cmd", cfg.Guard, "Command run after every iteration (e.g. 'go build ./...'); its failures are fed back to the agent.")
+	fs.BoolVar(&cfg.RevertOnFail, "revert-on-fail", cfg.RevertOnFail, "Revert an iteration's git changes when --guard-cmd fails after it.")
+	fs.StringVar(&cfg.PreHook, "pre-hook", cfg.PreHook, "Command run before every agent run, with "+ralph.HookIterationEnv+" and "+ralph.HookAgentEnv+" set.")
+	fs.StringVar(&cfg.PostHook, "post-hook", cfg.PostHook, "Command run after every agent run, with "+ralph.HookIterationEnv+", "+ralph.HookAgentEnv+", "+ralph.HookOutputFileEnv+" (the agent's output) and "+ralph.HookExitCodeEnv+" set.")
+	fs.BoolVar(&cfg.PostHookAbort, "post-hook-abort", cfg.PostHookAbort, "Stop the loop when --post-hook fails.")
+	fs.StringVar(&cfg.Reviewer, "reviewer", cfg.Reviewer, "Second agent that reviews the diff before a stop signal is accepted; its rejections are fed back to the builder.")
+	fs.StringVar(&cfg.ReviewPrompt, "review-prompt", cfg.ReviewPrompt, "File with the reviewer's instructions (default: a built-in review prompt). The task and the diff are appended.")
+	fs.IntVar(&cfg.ReviewEvery, "review-every", cfg.ReviewEvery, "Also run the reviewer every N iterations (0 = only before accepting a stop signal).")
+	fs.IntVar(&cfg.FeedbackLines, "feedback-lines", cfg.FeedbackLines, "Trailing lines of failed check/validation output fed into the next prompt (use {{feedback}} in the prompt to place them).")
+	fs.StringVar(&cfg.Carryover, "carryover", cfg.Carryover, "Feed the previous iteration's output into the next prompt: tail (its end) or summary (condensed by an extra agent run). Off by default; use {{carryover}} in the prompt to place it.")
+	fs.IntVar(&cfg.CarryoverBytes, "carryover-bytes", cfg.CarryoverBytes, "Maximum size of the carried-over output.")
+	fs.BoolVar(&cfg.NoCarryover, "no-carryover", cfg.NoCarryover, "Disable a carryover set in ralph.yaml.")
+	fs.StringVar(&cfg.Specs, "specs", cfg.Specs, "Directory of specs added to every prompt, each file under its path, if it exists (\"\" = off). Use {{specs}} in the prompt to place them.")
+	fs.Var(&cfg.SpecsInclude, "specs-include", "Only add the spec files matching this pattern, e.g. api/*.md (default *.md); repeat for several.")
+	fs.Var(&cfg.SpecsExclude, "specs-exclude", "Leave out the spec files matching this pattern, e.g. drafts/**; repeat for several.")
+	fs.StringVar(&cfg.IgnoreFile, "ignore-file", cfg.IgnoreFile, "File of gitignore-style patterns for paths kept out of the prompt: prompt and spec files matched by globs, and changes in injected diffs (\"\" = off).")
+	fs.BoolVar(&cfg.SignalFooter, "signal-footer", cfg.SignalFooter, "Append instructions on when and how to print the stop and blocked signals to every prompt (use {{signals}} in the prompt to place them).")
+	fs.BoolVar(&cfg.Memory, "memory", cfg.Memory, "Record the lines the agent prints starting with "+ralph.MemoryNotePrefix+" in "+filepath.ToSlash(MemoryFile)+" and feed them into every prompt (use {{memory}} in the prompt to place them).")
+	fs.StringVar(&cfg.InjectDiff, "inject-diff", cfg.InjectDiff, "Add the changes made since the run started to every prompt: stat (git diff --stat) or full (stat and diff). Use {{diffstat}} or {{diff}} in the prompt to place them.")
+	fs.IntVar(&cfg.InjectDiffBytes, "inject-diff-bytes", cfg.InjectDiffBytes, "Maximum size of the diff added by --inject-diff full or {{diff}}.")
+	fs.IntVar(&cfg.PromptWarnTokens, "prompt-warn-tokens", cfg.PromptWarnTokens, "Warn when the prompt, context included, grows over this many estimated tokens (0 = half the agent's context window, if known; -1 = never).")
+	fs.IntVar(&cfg.PromptMaxTokens, "prompt-max-tokens", cfg.PromptMaxTokens, "Hard cap on the estimated tokens of the prompt, context included; see --prompt-overflow (0 = the agent's context window, if known; -1 = none).")
+	fs.StringVar(&cfg.PromptOverflow, "prompt-overflow", cfg.PromptOverflow, fmt.Sprintf("What to do with a prompt over --prompt-max-tokens: refuse (stop with exit code %d) or truncate (cut out its middle, keeping the instructions and the latest feedback).", ExitPromptTooLarge))
+	fs.DurationVar(&cfg.Sleep, "sleep", cfg.Sleep, "Rest between iterations.")
+	fs.DurationVar(&cfg.MaxBackoff, "max-backoff", cfg.MaxBackoff, "Cap for the exponential backoff applied after consecutive agent errors.")
+	fs.DurationVar(&cfg.RateLimitWait, "rate-limit-wait", cfg.RateLimitWait, "Wait after the agent reports a rate limit; doubles for consecutive hits.")
+	fs.StringVar(&cfg.RateLimitPattern, "rate-limit-regex", cfg.RateLimitPattern, "Regular expression recognizing rate-limit errors in failed agent output (default: built-in patterns).")
+	fs.IntVar(&cfg.MaxIterations, "max-iterations", cfg.MaxIterations, "Stop after this many agent iterations (0 = unlimited).")
+	fs.DurationVar(&cfg.MaxDuration, "max-duration", cfg.MaxDuration, "Stop at the first iteration boundary after the run has been going this long, e.g. 4h (0 = unlimited).")
+	fs.Float64Var(&cfg.MaxCostUSD, "max-cost-usd", cfg.MaxCostUSD, "Stop once the agent's reported cost reaches this many US dollars (0 = unlimited; needs an agent that reports usage, like claude).")
+	fs.IntVar(&cfg.MaxTokens, "max-tokens", cfg.MaxTokens, "Stop once the agent's reported token count reaches this (0 = unlimited).")
+	fs.StringVar(&cfg.Phase, "phase", cfg.Phase, "Start at this phase of the phases: defined in ralph.yaml, skipping the ones before it.")
+	fs.DurationVar(&cfg.IterationTimeout, "iteration-timeout", cfg.IterationTimeout, "Kill the agent if a single iteration runs longer than this (e.g. 30m). 0 disables.")
+	fs.StringVar(&cfg.StopSignal, "stop-signal", cfg.StopSignal, "Comma-separated tokens that mark the task complete when printed by the agent (env: "+StopSignalEnv+").")
+	fs.StringVar(&cfg.StopRegex, "stop-regex", cfg.StopRegex, "Regular expression that marks the task complete when it matches the agent output.")
+	fs.StringVar(&cfg.BlockedSignal, "blocked-signal", cfg.BlockedSignal, fmt.Sprintf("Comma-separated tokens the agent prints, followed by the reason, when it is stuck and needs a human; they stop the loop with exit code %d (\"\" = off).", ExitBlocked))
+	fs.StringVar(&cfg.DoneFile, "done-file", cfg.DoneFile, "File the agent can create instead of printing a stop signal; its contents become the completion message. Deleted when the run starts (\"\" = off).")
+	fs.StringVar(&cfg.StopOnSignal, "stop-on-signal", cfg.StopOnSignal, "When the agent prints a stop signal: wait for it to exit, or stop it immediately.")
+	fs.StringVar(&cfg.StatusFile, "status-file", cfg.StatusFile, "Write the latest loop status as JSON to this file.")
+	fs.StringVar(&cfg.StatusMode, "status-mode", cfg.StatusMode, "How to write the status file: overwrite (latest event only) or append (one JSON event per line).")
+	fs.Int64Var(&cfg.StatusMaxBytes, "status-max-bytes", cfg.StatusMaxBytes, "Rotate an append-mode status file to <file>.1 once it exceeds this many bytes (0 = never).")
+	fs.StringVar(&cfg.ArtifactsDir, "artifacts-dir", cfg.ArtifactsDir, "Save each iteration's prompt, output and timing under <dir>/iter-NNNN/.")
+	fs.StringVar(&cfg.WebhookURL, "webhook-url", cfg.WebhookURL, "POST every status event as JSON to this URL.")
+	fs.StringVar(&cfg.WebhookSecret, "webhook-secret", cfg.WebhookSecret, "Sign webhook bodies with this HMAC-SHA256 secret (env: "+WebhookSecretEnv+").")
+	fs.StringVar(&cfg.NotifySlack, "notify-slack", cfg.NotifySlack, "Slack incoming webhook URL to notify when the loop completes, is cancelled or aborts.")
+	fs.IntVar(&cfg.NotifySlackEvery, "notify-slack-every", cfg.NotifySlackEvery, "Also notify Slack after every N iterations (0 = only when the loop ends).")
+	fs.BoolVar(&cfg.NotifyDesktop, "notify-desktop", cfg.NotifyDesktop, "Show a desktop notification when the loop completes, aborts or stalls.")
+	fs.StringVar(&cfg.Web, "web", cfg.Web, "Serve a web dashboard with live output and pause/stop buttons on this address, e.g. :8080.")
+	fs.StringVar(&cfg.WebToken, "web-token", cfg.WebToken, "Token required by the web dashboard (default: $"+WebTokenEnv+" or a random one).")
+	fs.StringVar(&cfg.APIAddr, "api-addr", cfg.APIAddr, "Serve an HTTP API to query and control the loop on this address, e.g. 127.0.0.1:7070.")
+	fs.StringVar(&cfg.APIToken, "api-token", cfg.APIToken, "Bearer token required by the control API (default: $"+APITokenEnv+" or a random one).")
+	fs.StringVar(&cfg.MetricsAddr, "metrics-addr", cfg.MetricsAddr, "Serve Prometheus metrics on this address at /metrics (e.g. :9090).")
+	fs.StringVar(&cfg.Output, "output", cfg.Output, "Output format: text (human-readable) or json (NDJSON events on stdout, messages on stderr).")
+	fs.StringVar(&cfg.CI, "ci", cfg.CI, "Integrate with a CI system: github folds the log into one group per iteration, annotates errors and stalls, masks secrets, and writes a summary to $"+GitHubStepSummaryEnv+" and the outcome to $"+GitHubOutputEnv+" (status, stop_reason, exit_code, completed, iterations, cost_usd, summary).")
+	fs.BoolVar(&cfg.Interactive, "interactive", cfg.Interactive, "After every iteration, show its changes and ask whether to keep them, revert them, edit the prompt or stop.")
+	fs.BoolVar(&cfg.ConfirmPromptChanges, "confirm-prompt-changes", cfg.ConfirmPromptChanges, "When the prompt changed since the last iteration (e.g. the agent edited it), show the change and ask whether to use it, keep the previous prompt or stop.")
+	fs.StringVar(&cfg.PromptHook, "prompt-hook", cfg.PromptHook, "Command that approves a prompt changed since the last iteration by exiting 0, with "+ralph.HookPromptOldFileEnv+" and "+ralph.HookPromptNewFileEnv+" set; otherwise the previous prompt is kept.")
+	fs.BoolVar(&cfg.TUI, "tui", cfg.TUI, "Show a full-screen dashboard with the iteration history and a tail of the agent output.")
+	fs.BoolVar(&cfg.NoKeys, "no-keys", cfg.NoKeys, "Disable the single-key controls (p, s, v, q) read from the terminal during a run.")
+	fs.BoolVar(&cfg.Quiet, "quiet", cfg.Quiet, "Hide the agent's output and print only ralph's own progress lines.")
+	fs.BoolVar(&cfg.Verbose, "verbose", cfg.Verbose, "Also log the exact agent command, its environment, prompt sizes and timings.")
+	fs.StringVar(&cfg.LogFile, "log-file", cfg.LogFile, "Also write all loop and agent output to this file.")
+	fs.Int64Var(&cfg.LogMaxBytes, "log-max-bytes", cfg.LogMaxBytes, "Rotate the log file to <file>.1 once it exceeds this many bytes (0 = never).")
+	fs.BoolVar(&cfg.LogTimestamps, "log-timestamps", cfg.LogTimestamps, "Prefix every line in the log file with a timestamp.")
+	fs.IntVar(&cfg.MaxOutputBytes, "max-output-bytes", cfg.MaxOutputBytes, "Keep at most this many bytes of agent output per iteration for stop-signal detection and transcripts; older output is dropped.")
+	fs.BoolVar(&cfg.GitCommit, "git-commit", cfg.GitCommit, "Stage and commit all changes after every iteration, except the files ralph writes itself.")
+	fs.BoolVar(&cfg.GitNoVerify, "git-no-verify", cfg.GitNoVerify, "Skip the repository's commit hooks when ralph commits: with --git-commit, checking off --todo items and at the end of --worktree, --race and --github-pr runs.")
+
+	fs.BoolVar(&cfg.Worktree, "worktree", cfg.Worktree, "Run on a new ralph/<timestamp> branch in its own git worktree under .ralph/worktrees, then offer to merge it.")
+	fs.BoolVar(&cfg.GithubPR, "github-pr", cfg.GithubPR, "Once the run completes, push its branch to "+PRRemote+" and open a GitHub pull request titled and described from the agent's completion payload, with $GITHUB_TOKEN or $GH_TOKEN, or else the gh CLI. Use with --worktree, or on a branch of its own.")
+	fs.StringVar(&cfg.GithubPRBase, "github-pr-base", cfg.GithubPRBase, "Branch the --github-pr pull request targets (default: the branch --worktree started from, else the remote's default branch).")
+	fs.BoolVar(&cfg.GithubPRDraft, "github-pr-draft", cfg.GithubPRDraft, "Open the --github-pr pull request as a draft.")
+	fs.Var(&cfg.ProtectedBranches, "protected-branch", "Branch (or glob) ralph refuses to run on; repeat for several (default main, master).")
+	fs.BoolVar(&cfg.AllowProtectedBranch, "allow-protected-branch", cfg.AllowProtectedBranch, "Run even on a protected branch.")
+	fs.BoolVar(&cfg.AllowDirty, "allow-dirty", cfg.AllowDirty, "Run even if tracked files have uncommitted changes.")
+	fs.BoolVar(&cfg.AllowNoGit, "allow-no-git", cfg.AllowNoGit, "Run even outside a git repository.")
+	fs.IntVar(&cfg.StallAfter, "stall-after", cfg.StallAfter, "Abort after this many consecutive iterations without work tree changes or with repeated output (0 = disabled).")
+
+	fs.IntVar(&cfg.MaxConsecutiveErrors, "max-consecutive-errors", cfg.MaxConsecutiveErrors, "Abort after this many agent failures in a row: non-zero exit, timeout or no output (0 = never).")
+	fs.IntVar(&cfg.FallbackAfter, "fallback-after", cfg.FallbackAfter, "With --agent a,b,c: switch to the next agent after this many agent failures in a row (a rate limit switches at once).")
+	fs.StringVar(&cfg.Rotate, "rotate", cfg.Rotate, "Comma-separated agents that take turns, one iteration each, instead of --agent; weight one with name:N, e.g. claude:2,gemini.")
+	fs.StringVar(&cfg.Race, "race", cfg.Race, "Comma-separated agents to race on the same prompt, each in its own git worktree; the first to complete wins.")
+	fs.StringVar(&cfg.Schedule, "schedule", cfg.Schedule, "Stay idle and start a fresh run whenever this cron expression fires, e.g. \"0 2 * * *\" or @daily.")
+	fs.BoolVar(&cfg.Resume, "resume", cfg.Resume, "Continue the previous run from its checkpoint in "+filepath.ToSlash(StateFile)+", keeping its iteration count and start time.")
+	fs.BoolVar(&cfg.Force, "force", cfg.Force, "Start even if the project lock file says another loop is running.")
+
+	// First pass only locates the config file; the second pass re-applies
+	// the flags on top of the file values so the command line always wins.
+	_ = fs.Parse(args)
+	explicit := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			explicit = true
+		}
+	})
+
+	cfg = defaultConfig()
+	if err := loadConfigFile(configPath, &cfg); err != nil {
+		if explicit || !errors.Is(err, os.ErrNotExist) {
+			return cfg, nil, err
+		}
+	}
+	if v, ok := os.LookupEnv(StopSignalEnv); ok {
+		cfg.StopSignal = v
+	}
+	_ = fs.Parse(args)
+	if passthrough {
+		cfg.AgentArgs = agentArgs
+	}
+
+	return cfg, fs.Args(), nil
+}
+
+// splitAgentArgs splits the command line at the first --: what follows is
+// appended to the agent's command line. passthrough reports whether there
+// was a --.
+func splitAgentArgs(argv []string) (flags, agentArgs []string, passthrough bool) {
+	for i, a := range argv {
+		if a == "--" {
+			return argv[:i:i], argv[i+1:], true
+		}
+	}
+	return argv, nil, false
+}
+
+// loadConfigFile decodes the YAML file at path into cfg. Keys missing from the
+// file keep their current values; unknown keys are rejected to catch typos.
+func loadConfigFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// stringList is a flag and YAML value holding one or more strings. The first
+// occurrence on the command line replaces the default; later ones append.
+type stringList struct {
+	values []string
+	set    bool
+}
+
+func (s *stringList) String() string {
+	if s == nil {
+		return ""
+	}
+	return strings.Join(s.values, ", ")
+}
+
+func (s *stringList) Set(v string) error {
+	if !s.set {
+		s.values = nil
+		s.set = true
+	}
+	s.values = append(s.values, v)
+	return nil
+}
+
+// UnmarshalYAML accepts either a single string or a list of strings.
+func (s *stringList) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		var v string
+		if err := n.Decode(&v); err != nil {
+			return err
+		}
+		s.values = []string{v}
+		return nil
+	}
+	return n.Decode(&s.values)
+}
+
+// envVars is a flag and YAML value holding environment variables, set on the
+// command line as NAME=value, one per flag. Flags add to the variables from
+// ralph.yaml.
+type envVars map[string]string
+
+func (e *envVars) String() string {
+	if e == nil {
+		return ""
+	}
+	var vars []string
+	for k, v := range *e {
+		vars = append(vars, k+"="+v)
+	}
+	sort.Strings(vars)
+	return strings.Join(vars, ", ")
+}
+
+func (e *envVars) Set(v string) error {
+	name, value, ok := strings.Cut(v, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("want NAME=value, got %q", v)
+	}
+	if *e == nil {
+		*e = envVars{}
+	}
+	(*e)[name] = value
+	return nil
+}
+
+// headerVars is a flag and YAML value holding HTTP headers, set on the
+// command line as "Name: value", one per flag. Flags add to the headers from
+// ralph.yaml.
+type headerVars map[string]string
+
+func (h *headerVars) String() string {
+	if h == nil {
+		return ""
+	}
+	var headers []string
+	for k, v := range *h {
+		headers = append(headers, k+": "+v)
+	}
+	sort.Strings(headers)
+	return strings.Join(headers, ", ")
+}
+
+func (h *headerVars) Set(v string) error {
+	name, value, ok := strings.Cut(v, ":")
+	if name = strings.TrimSpace(name); !ok || name == "" {
+		return fmt.Errorf("want 'Name: value', got %q", v)
+	}
+	if *h == nil {
+		*h = headerVars{}
+	}
+	(*h)[name] = strings.TrimSpace(value)
+	return nil
+}
+
+// egress returns the proxy that enforces --allow-host, or nil without it.
+func (cfg *Config) egress() (*ralph.EgressProxy, error) {
+	if len(cfg.AllowHosts.values) == 0 {
+		return nil, nil
+	}
+	if cfg.Sandbox != "" {
+		return nil, errors.New("--allow-host does not apply in a sandbox, whose agent cannot reach ralph's proxy; use --sandbox-network")
+	}
+	if cfg.egressProxy == nil {
+		var hosts []string
+		for _, h := range cfg.AllowHosts.values {
+			for _, h := range strings.Split(h, ",") {
+				if h = strings.TrimSpace(h); h != "" {
+					hosts = append(hosts, h)
+				}
+			}
+		}
+		cfg.egressProxy = &ralph.EgressProxy{Allow: hosts}
+	}
+	return cfg.egressProxy, nil
+}
+
+// session returns the recorder for --record and the recording played back
+// for --replay, either of which may be nil. The recording is opened once.
+func (cfg *Config) session() (*ralph.SessionRecorder, *ralph.SessionReplay, error) {
+	if cfg.Record != "" && cfg.Replay != "" {
+		return nil, nil, errors.New("--record and --replay are mutually exclusive")
+	}
+	var err error
+	if cfg.Record != "" && cfg.recorder == nil {
+		if cfg.recorder, err = ralph.CreateSession(cfg.Record); err != nil {
+			return nil, nil, fmt.Errorf("--record: %w", err)
+		}
+	}
+	if cfg.Replay != "" && cfg.replay == nil {
+		if cfg.replay, err = ralph.OpenSession(cfg.Replay); err != nil {
+			return nil, nil, fmt.Errorf("--replay: %w", err)
+		}
+	}
+	return cfg.recorder, cfg.replay, nil
+}
+
+// limits returns the resource limits described by --cpu-limit, --mem-limit,
+// --nice and --ionice, or nil without them.
+func (cfg *Config) limits() (*ralph.ResourceLimits, error) {
+	if cfg.CPULimit == 0 && cfg.MemLimit == "" && cfg.Nice == 0 && cfg.IONice == "" {
+		return nil, nil
+	}
+	if cfg.Sandbox != "" {
+		return nil, errors.New("--cpu-limit, --mem-limit, --nice and --ionice do not apply in a sandbox; use --sandbox-cpus and --sandbox-memory")
+	}
+	if cfg.CPULimit < 0 {
+		return nil, fmt.Errorf("invalid --cpu-limit %g", cfg.CPULimit)
+	}
+	switch cfg.IONice {
+	case "", ralph.IOClassIdle, ralph.IOClassBestEffort, ralph.IOClassRealtime:
+	default:
+		return nil, fmt.Errorf("invalid --ionice %q (want %s, %s or %s)", cfg.IONice, ralph.IOClassIdle, ralph.IOClassBestEffort, ralph.IOClassRealtime)
+	}
+	limits := &ralph.ResourceLimits{CPUs: cfg.CPULimit, Nice: cfg.Nice, IOClass: cfg.IONice}
+	if cfg.MemLimit != "" {
+		n, err := parseByteSize(cfg.MemLimit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --mem-limit: %w", err)
+		}
+		limits.MemoryBytes = n
+	}
+	return limits, nil
+}
+
+// parseByteSize parses a size such as 512m, 4g or 1.5G; a plain number is
+// bytes.
+func parseByteSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "b")
+	shift := 0
+	if n := len(num); n > 0 {
+		if i := strings.IndexByte("kmgt", num[n-1]); i >= 0 {
+			shift = 10 * (i + 1)
+			num = num[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("%q is not a size like 512m or 4g", s)
+	}
+	return int64(v * float64(int64(1)<<shift)), nil
+}
+
+// gitCommitOptions returns how ralph commits: without the hooks with
+// --git-no-verify, and never staging its own state, logs, status file,
+// done file, artifacts or recording.
+func (cfg *Config) gitCommitOptions() ralph.GitCommitOptions {
+	exclude := []string{StateDir, ralph.ErrorLogFile, cfg.DoneFile, cfg.ArtifactsDir, cfg.Record}
+	for _, f := range []string{cfg.StatusFile, cfg.LogFile} {
+		if f != "" {
+			exclude = append(exclude, f, f+".1")
+		}
+	}
+	return ralph.GitCommitOptions{NoVerify: cfg.GitNoVerify, Exclude: exclude}
+}
+
+// redactor returns the redactor of the default secret patterns and
+// variables, --redact and --redact-env, or nil with --no-redact. The
+// contents of agent env files are secrets whatever their name.
+func (cfg *Config) redactor() (*ralph.Redactor, error) {
+	if cfg.NoRedact || cfg.redact != nil {
+		return cfg.redact, nil
+	}
+	patterns := append(ralph.DefaultSecretPatterns[:len(ralph.DefaultSecretPatterns):len(ralph.DefaultSecretPatterns)], cfg.Redact.values...)
+	var err error
+	cfg.redact, err = ralph.NewRedactor(patterns, cfg.secretValues())
+	return cfg.redact, err
+}
+
+// secretValues returns the secrets the redactor masks literally: the values
+// of the environment variables of DefaultSecretEnv and --redact-env, the
+// agents' included, and the contents of the agents' env files.
+func (cfg *Config) secretValues() []string {
+	env := os.Environ()
+	var secrets []string
+	defs := []ralph.AgentDef{{Env: cfg.AgentEnv, EnvFiles: cfg.AgentEnvFiles}}
+	for _, def := range cfg.Agents {
+		defs = append(defs, def)
+	}
+	for _, def := range defs {
+		for k, v := range def.Env {
+			env = append(env, k+"="+v)
+		}
+		for _, path := range def.EnvFiles {
+			if data, err := os.ReadFile(path); err == nil {
+				secrets = append(secrets, strings.TrimRight(string(data), "\r\n"))
+			}
+		}
+	}
+	names := append(ralph.DefaultSecretEnv[:len(ralph.DefaultSecretEnv):len(ralph.DefaultSecretEnv)], cfg.RedactEnv.values...)
+	return append(secrets, ralph.SecretEnvValues(env, names)...)
+}
+
+// auditLog returns the audit log of --audit, or nil without it.
+func (cfg *Config) auditLog() (*ralph.AuditLog, error) {
+	if !cfg.Audit || cfg.audit != nil {
+		return cfg.audit, nil
+	}
+	if cfg.Sandbox != "" {
+		return nil, errors.New("it does not work in a --sandbox: the agent's hooks run ralph")
+	}
+	path, err := filepath.Abs(AuditFile)
+	if err != nil {
+		return nil, err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	quoted := "'" + strings.ReplaceAll(exe, "'", `'\''`) + "'"
+	cfg.audit = &ralph.AuditLog{Path: path, HookCommand: quoted + " audit record"}
+	return cfg.audit, nil
+}
+
+// fetchIssue returns the issue of --prompt-from-issue, fetched once.
+func (cfg *Config) fetchIssue() (*ralph.Issue, error) {
+	if cfg.issue != nil {
+		return cfg.issue, nil
+	}
+	ref, err := ralph.ParseIssueRef(cfg.PromptFromIssue)
+	if err != nil {
+		return nil, err
+	}
+	cfg.issue, err = ralph.FetchIssue(context.Background(), ref, cfg.IssueComments)
+	return cfg.issue, err
+}
+
+// sandbox returns the sandbox described by --sandbox and its companion
+// flags, or nil without --sandbox.
+func (cfg *Config) sandbox() (*ralph.Sandbox, error) {
+	if cfg.Sandbox == "" {
+		return nil, nil
+	}
+	if cfg.Sandbox == SandboxDevcontainer {
+		if cfg.SandboxNetwork != "" || cfg.SandboxCPUs != "" || cfg.SandboxMemory != "" || len(cfg.SandboxMounts.values) > 0 {
+			return nil, errors.New("--sandbox-network, --sandbox-cpus, --sandbox-memory and --sandbox-mount do not apply to a devcontainer; set them in devcontainer.json")
+		}
+		return &ralph.Sandbox{Devcontainer: true, Env: cfg.SandboxEnv.values}, nil
+	}
+	kind, image, _ := strings.Cut(cfg.Sandbox, ":")
+	if kind != SandboxDocker {
+		return nil, fmt.Errorf("invalid --sandbox %q (want %s, %s:IMAGE or %s)", cfg.Sandbox, SandboxDocker, SandboxDocker, SandboxDevcontainer)
+	}
+	return &ralph.Sandbox{
+		Image:   image,
+		Network: cfg.SandboxNetwork,
+		CPUs:    cfg.SandboxCPUs,
+		Memory:  cfg.SandboxMemory,
+		Mounts:  cfg.SandboxMounts.values,
+		Env:     cfg.SandboxEnv.values,
+	}, nil
+}
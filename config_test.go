@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"1024", 1024},
+		{"512k", 512 << 10},
+		{"512m", 512 << 20},
+		{"4g", 4 << 30},
+		{"4G", 4 << 30},
+		{"4gb", 4 << 30},
+		{"1.5G", 3 << 29},
+		{"2t", 2 << 40},
+		{" 64M ", 64 << 20},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "g", "-1g", "0", "4x", "lots"} {
+		if _, err := parseByteSize(in); err == nil {
+			t.Errorf("parseByteSize(%q) succeeded, want an error", in)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Daemon files, relative to the daemon directory (default ~/.ralph).
+const (
+	DaemonSocket   = "daemon.sock"
+	DaemonQueue    = "queue.json"
+	DaemonLogsDir  = "tasks"
+	unixAddrPrefix = "unix:"
+)
+
+// DaemonTokenEnv holds the token a daemon listening on HOST:PORT requires,
+// for the daemon and its clients alike.
+const DaemonTokenEnv = "RALPH_DAEMON_TOKEN"
+
+// Task states.
+const (
+	TaskQueued      = "queued"
+	TaskRunning     = "running"
+	TaskDone        = "done"
+	TaskFailed      = "failed"
+	TaskCancelled   = "cancelled"
+	TaskInterrupted = "interrupted"
+)
+
+// Task is a loop submitted to the daemon. It runs as `ralph run` in Workdir
+// with the given agent, prompt and extra flags.
+type Task struct {
+	ID          int        `json:"id"`
+	Workdir     string     `json:"workdir"`
+	Agent       string     `json:"agent,omitempty"`
+	Prompt      string     `json:"prompt,omitempty"`
+	PromptText  string     `json:"prompt_text,omitempty"`
+	Args        []string   `json:"args,omitempty"`
+	State       string     `json:"state"`
+	ExitCode    *int       `json:"exit_code,omitempty"`
+	Log         string     `json:"log"`
+	SubmittedAt time.Time  `json:"submitted_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// daemon queues submitted tasks and runs them one at a time.
+type daemon struct {
+	dir string
+
+	mu        sync.Mutex
+	tasks     []*Task
+	nextID    int
+	running   *exec.Cmd
+	runningID int
+	cancelled bool
+	wake      chan struct{}
+}
+
+// runDaemon serves the task queue until interrupted.
+func runDaemon(argv []string) int {
+	fs := flag.NewFlagSet("ralph daemon", flag.ExitOnError)
+	dir := fs.String("dir", defaultDaemonDir(), "Directory holding the queue, the task logs and the default socket.")
+	listen := fs.String("listen", "", "Address to accept submissions on: unix:PATH or HOST:PORT (default: unix:<dir>/"+DaemonSocket+").")
+	token := fs.String("token", "", "Bearer token required when listening on HOST:PORT (default: $"+DaemonTokenEnv+" or a random one).")
+	_ = fs.Parse(argv)
+	if *listen == "" {
+		*listen = unixAddrPrefix + filepath.Join(*dir, DaemonSocket)
+	}
+	// A unix socket is guarded by the permissions of its directory; anyone
+	// who can reach a TCP port needs the token.
+	requireToken := ""
+	if !strings.HasPrefix(*listen, unixAddrPrefix) {
+		var err error
+		if requireToken, err = httpToken(*token, DaemonTokenEnv); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return 1
+		}
+	}
+
+	d := &daemon{dir: *dir, wake: make(chan struct{}, 1), nextID: 1}
+	if err := d.load(); err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return 1
+	}
+	ln, err := listenDaemon(*listen)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return 1
+	}
+	defer ln.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tasks", d.handleList)
+	mux.HandleFunc("POST /tasks", d.handleSubmit)
+	mux.HandleFunc("DELETE /tasks/{id}", d.handleCancel)
+	srv := &http.Server{Handler: guardDaemon(requireToken, mux)}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("⚠️ Daemon server stopped: %v\n", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Printf("🛰️  ralph daemon listening on %s (queue in %s)\n", *listen, *dir)
+	if requireToken != "" {
+		fmt.Printf("🔑 Token: %s (pass it to submit and queue with --token or $%s)\n", requireToken, DaemonTokenEnv)
+	}
+	d.work(ctx)
+
+	shutdown, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	srv.Shutdown(shutdown)
+	fmt.Println("👋 Daemon stopped.")
+	return 0
+}
+
+func defaultDaemonDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".ralph"
+	}
+	return filepath.Join(home, ".ralph")
+}
+
+// listenDaemon listens on a unix:PATH or HOST:PORT address, replacing a
+// socket left behind by a daemon that is no longer running.
+func listenDaemon(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixAddrPrefix)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("a daemon is already listening on %s", path)
+	}
+	_ = os.Remove(path)
+	return net.Listen("unix", path)
+}
+
+// load restores the queue saved by a previous daemon. Tasks that were
+// running when it stopped are marked interrupted.
+func (d *daemon) load() error {
+	if err := os.MkdirAll(filepath.Join(d.dir, DaemonLogsDir), 0o700); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filepath.Join(d.dir, DaemonQueue))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &d.tasks); err != nil {
+		return fmt.Errorf("%s: %w", DaemonQueue, err)
+	}
+	for _, t := range d.tasks {
+		if t.State == TaskRunning {
+			t.State = TaskInterrupted
+		}
+		d.nextID = max(d.nextID, t.ID+1)
+	}
+	return nil
+}
+
+// save writes the queue; the caller holds d.mu.
+func (d *daemon) save() {
+	data, err := json.MarshalIndent(d.tasks, "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(d.dir, DaemonQueue), data, 0o600)
+	}
+	if err != nil {
+		fmt.Printf("⚠️ Failed to save the queue: %v\n", err)
+	}
+}
+
+// work runs queued tasks in submission order until ctx is cancelled, then
+// asks the running task to stop and waits for it.
+func (d *daemon) work(ctx context.Context) {
+	for {
+		d.mu.Lock()
+		var next *Task
+		for _, t := range d.tasks {
+			if t.State == TaskQueued {
+				next = t
+				break
+			}
+		}
+		d.mu.Unlock()
+
+		if next == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-d.wake:
+				continue
+			}
+		}
+		d.run(ctx, next)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// run executes t as a child `ralph run` and records the outcome.
+func (d *daemon) run(ctx context.Context, t *Task) {
+	exe, err := os.Executable()
+	if err != nil {
+		d.finish(t, -1, err)
+		return
+	}
+	args := []string{"run"}
+	if t.Agent != "" {
+		args = append(args, "--agent", t.Agent)
+	}
+	if t.Prompt != "" {
+		args = append(args, "--prompt", t.Prompt)
+	}
+	if t.PromptText != "" {
+		args = append(args, "--prompt-text", t.PromptText)
+	}
+	args = append(args, t.Args...)
+
+	log, err := os.OpenFile(t.Log, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		d.finish(t, -1, err)
+		return
+	}
+	defer log.Close()
+
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = t.Workdir
+	cmd.Stdout = log
+	cmd.Stderr = log
+
+	d.mu.Lock()
+	now := time.Now()
+	t.State = TaskRunning
+	t.StartedAt = &now
+	d.running, d.runningID, d.cancelled = cmd, t.ID, false
+	err = cmd.Start()
+	d.save()
+	d.mu.Unlock()
+	if err != nil {
+		d.finish(t, -1, err)
+		return
+	}
+	fmt.Printf("⚡ Task %d started in %s\n", t.ID, t.Workdir)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Let the loop finish its iteration, as Ctrl+C would.
+			interruptProcess(cmd.Process)
+		case <-done:
+		}
+	}()
+	err = cmd.Wait()
+	close(done)
+	d.finish(t, cmd.ProcessState.ExitCode(), err)
+}
+
+func (d *daemon) finish(t *Task, code int, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	t.FinishedAt = &now
+	t.ExitCode = &code
+	switch {
+	case d.cancelled && d.runningID == t.ID:
+		t.State = TaskCancelled
+	case code == 0 && err == nil:
+		t.State = TaskDone
+	default:
+		t.State = TaskFailed
+	}
+	d.running, d.runningID = nil, 0
+	d.save()
+	fmt.Printf("🏁 Task %d %s (exit %d)\n", t.ID, t.State, code)
+}
+
+// guardDaemon refuses requests to h that lack the token, if there is one,
+// and POST and DELETE requests without a JSON content type, which a web
+// page cannot send to another origin without a preflight.
+func guardDaemon(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token != "" && subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+			http.Error(w, "missing or invalid token", http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodPost || r.Method == http.MethodDelete {
+			if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+				http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (d *daemon) handleList(w http.ResponseWriter, _ *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.tasks)
+}
+
+func (d *daemon) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var t Task
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !filepath.IsAbs(t.Workdir) {
+		http.Error(w, "workdir must be an absolute path", http.StatusBadRequest)
+		return
+	}
+	if info, err := os.Stat(t.Workdir); err != nil || !info.IsDir() {
+		http.Error(w, fmt.Sprintf("workdir %s is not a directory", t.Workdir), http.StatusBadRequest)
+		return
+	}
+
+	d.mu.Lock()
+	t.ID = d.nextID
+	d.nextID++
+	t.State = TaskQueued
+	t.ExitCode, t.StartedAt, t.FinishedAt = nil, nil, nil
+	t.SubmittedAt = time.Now()
+	t.Log = filepath.Join(d.dir, DaemonLogsDir, fmt.Sprintf("%d.log", t.ID))
+	d.tasks = append(d.tasks, &t)
+	d.save()
+	d.mu.Unlock()
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	fmt.Printf("📥 Task %d queued for %s\n", t.ID, t.Workdir)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&t)
+}
+
+// handleCancel drops a queued task, or stops a running one after its
+// current iteration.
+func (d *daemon) handleCancel(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid task id", http.StatusBadRequest)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, t := range d.tasks {
+		if t.ID != id {
+			continue
+		}
+		switch {
+		case t.State == TaskQueued:
+			now := time.Now()
+			t.State = TaskCancelled
+			t.FinishedAt = &now
+			d.save()
+		case d.runningID == id && d.running != nil:
+			d.cancelled = true
+			interruptProcess(d.running.Process)
+		default:
+			http.Error(w, fmt.Sprintf("task %d already %s", id, t.State), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.NotFound(w, r)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGuardDaemon(t *testing.T) {
+	tests := []struct {
+		name        string
+		token       string
+		method      string
+		auth        string
+		contentType string
+		want        int
+	}{
+		{"unix socket list", "", http.MethodGet, "", "", http.StatusOK},
+		{"unix socket submit", "", http.MethodPost, "", "application/json", http.StatusOK},
+		{"form submit", "", http.MethodPost, "", "text/plain", http.StatusUnsupportedMediaType},
+		{"cancel without content type", "", http.MethodDelete, "", "", http.StatusUnsupportedMediaType},
+		{"tcp without token", "secret", http.MethodGet, "", "", http.StatusUnauthorized},
+		{"tcp wrong token", "secret", http.MethodPost, "Bearer nope", "application/json", http.StatusUnauthorized},
+		{"tcp submit", "secret", http.MethodPost, "Bearer secret", "application/json; charset=utf-8", http.StatusOK},
+	}
+	ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/tasks", nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			guardDaemon(tt.token, ok).ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"ralph/pkg/ralph"
+)
+
+// ProbeTimeout bounds the authentication probe run by `ralph doctor`.
+const ProbeTimeout = 2 * time.Minute
+
+// VersionTimeout bounds the agent's version query at the start of a loop.
+const VersionTimeout = 10 * time.Second
+
+// probePrompt is sent to the agent to confirm it is installed and logged in.
+const probePrompt = "This is a connectivity check. Reply with the single word OK and do nothing else."
+
+// Finding severities.
+const (
+	findingOK = iota
+	findingWarn
+	findingFail
+)
+
+// finding is the result of one diagnostic check.
+type finding struct {
+	level int
+	topic string
+	msg   string
+}
+
+func (f finding) String() string {
+	icon := [...]string{"✅", "⚠️ ", "❌"}[f.level]
+	return fmt.Sprintf("%s %-7s %s", icon, f.topic+":", f.msg)
+}
+
+// runDoctor diagnoses the setup for a run with the same flags, including a
+// live probe of the agent.
+func runDoctor(argv []string) int {
+	var noProbe bool
+	cfg, args, err := parseConfig("ralph doctor", argv, func(fs *flag.FlagSet) {
+		fs.BoolVar(&noProbe, "no-probe", false, "Skip sending a probe prompt to the agent.")
+	})
+	if err != nil {
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		return 2
+	}
+	loop, agent, err := newLoop(&cfg, args)
+	if err != nil {
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		return 2
+	}
+
+	fmt.Fprintln(console, "🩺 ralph doctor")
+	findings := diagnose(context.Background(), agent, loop, &cfg)
+	if !noProbe && findings[0].level == findingOK {
+		findings = append(findings, probeAgent(agent))
+	}
+
+	failed := false
+	for _, f := range findings {
+		fmt.Fprintln(console, f)
+		failed = failed || f.level == findingFail
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// preflight runs the quick doctor checks before a loop starts. Problems that
+// would make every iteration fail, and unsafe git states that were not
+// explicitly allowed, abort the run; the rest are warnings.
+func preflight(agent *ralph.CommandAgent, loop *ralph.Loop, cfg *Config) bool {
+	ok := true
+	for _, f := range diagnose(context.Background(), agent, loop, cfg) {
+		if f.level == findingOK {
+			continue
+		}
+		fmt.Fprintln(console, f)
+		ok = ok && f.level != findingFail
+	}
+	return ok
+}
+
+// diagnose checks the agent binary, the prompt and the git repository. The
+// agent check always comes first.
+func diagnose(ctx context.Context, agent *ralph.CommandAgent, loop *ralph.Loop, cfg *Config) []finding {
+	var findings []finding
+
+	if where, err := locateAgent(agent); err != nil {
+		findings = append(findings, finding{findingFail, "agent", err.Error()})
+	} else {
+		findings = append(findings, finding{findingOK, "agent", fmt.Sprintf("%s found at %s", loop.AgentName, where)})
+	}
+
+	for _, fallback := range loop.Fallbacks {
+		if _, err := locateAgent(fallback.Agent.(*ralph.CommandAgent)); err != nil {
+			findings = append(findings, finding{findingWarn, "agent", fmt.Sprintf("fallback %s: %v", fallback.Name, err)})
+		}
+	}
+	seen := map[string]bool{loop.AgentName: true}
+	for _, r := range loop.Rotation {
+		if seen[r.Name] {
+			continue
+		}
+		seen[r.Name] = true
+		if _, err := locateAgent(r.Agent.(*ralph.CommandAgent)); err != nil {
+			findings = append(findings, finding{findingFail, "agent", fmt.Sprintf("%s: %v", r.Name, err)})
+		}
+	}
+	if agent.Sandbox != nil && agent.API == "" && agent.Mock == nil && agent.Replay == nil && findings[0].level == findingOK {
+		if err := agent.Sandbox.CheckImage(ctx); err != nil {
+			findings = append(findings, finding{findingWarn, "agent", err.Error()})
+		}
+	}
+	if agent.Limits != nil && agent.API == "" && agent.Mock == nil && agent.Replay == nil {
+		if how, err := agent.Limits.Check(); err != nil {
+			findings = append(findings, finding{findingFail, "agent", fmt.Sprintf("resource limits: %v", err)})
+		} else {
+			findings = append(findings, finding{findingOK, "agent", fmt.Sprintf("resource limits (%s) enforced with %s", agent.Limits, how)})
+		}
+	}
+	if agent.Sandbox == nil {
+		if ws, ok := ralph.FindDevcontainer("."); ok {
+			findings = append(findings, finding{findingOK, "agent", fmt.Sprintf("devcontainer.json found in %s; --sandbox devcontainer runs the agent in it", ws)})
+		}
+	}
+	for name, path := range agent.EnvFiles {
+		if _, err := os.Stat(path); err != nil {
+			findings = append(findings, finding{findingFail, "agent", fmt.Sprintf("cannot read %s for %s: %v", path, name, err)})
+		}
+	}
+
+	findings = append(findings, diagnosePrompt(loop, agent)...)
+
+	findings = append(findings, diagnoseGit(ctx, cfg)...)
+	return findings
+}
+
+// locateAgent returns where the agent's binary or API is, or why it cannot
+// be used.
+func locateAgent(agent *ralph.CommandAgent) (string, error) {
+	if agent.API != "" {
+		return agent.CheckAPI()
+	}
+	if agent.Replay != nil {
+		return fmt.Sprintf("not needed, replaying %d recorded runs", agent.Replay.Len()), nil
+	}
+	if agent.Mock != nil {
+		if agent.Mock.Fixture == "" {
+			return "built in", nil
+		}
+		if _, err := ralph.LoadMockSteps(agent.Mock.Fixture); err != nil {
+			return "", err
+		}
+		return "built in, playing " + agent.Mock.Fixture, nil
+	}
+	bin := agent.Binary()
+	if agent.Sandbox != nil {
+		if _, err := agent.Sandbox.Check(context.Background()); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s in the %s sandbox", bin, agent.Sandbox), nil
+	}
+	path, err := exec.LookPath(bin)
+	if err != nil {
+		return "", fmt.Errorf("%s not found on PATH (install it or point --agent-cmd at it)", bin)
+	}
+	return path, nil
+}
+
+// diagnoseGit applies the safety gates: ralph refuses to run outside a git
+// repository, on a protected branch or with uncommitted changes to tracked
+// files, unless the matching --allow-* flag is set.
+func diagnoseGit(ctx context.Context, cfg *Config) []finding {
+	gate := func(allowed bool) int {
+		if allowed {
+			return findingWarn
+		}
+		return findingFail
+	}
+
+	info := ralph.GitState(ctx)
+	if !info.Repo {
+		return []finding{{gate(cfg.AllowNoGit), "git", "not a git repository; agent changes cannot be reviewed or reverted (--allow-no-git to run anyway)"}}
+	}
+
+	var findings []finding
+	if protectedBranch(info.Branch, cfg.ProtectedBranches.values) {
+		findings = append(findings, finding{gate(cfg.AllowProtectedBranch), "git", fmt.Sprintf("on protected branch %s; use --worktree or a feature branch (--allow-protected-branch to run anyway)", info.Branch)})
+	}
+	switch {
+	case info.Modified > 0:
+		findings = append(findings, finding{gate(cfg.AllowDirty), "git", fmt.Sprintf("on branch %s with %d uncommitted changes to tracked files (--allow-dirty to run anyway)", orDetached(info.Branch), info.Modified)})
+	case info.Dirty > 0:
+		findings = append(findings, finding{findingWarn, "git", fmt.Sprintf("on branch %s with %d untracked files", orDetached(info.Branch), info.Dirty)})
+	case len(findings) == 0:
+		findings = append(findings, finding{findingOK, "git", fmt.Sprintf("on branch %s, clean work tree", orDetached(info.Branch))})
+	}
+	return findings
+}
+
+// protectedBranch reports whether branch matches one of the patterns.
+func protectedBranch(branch string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, branch); ok && branch != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// promptSource is the prompt of a loop or of one of its phases.
+type promptSource struct {
+	// prefix labels the findings, e.g. "plan: ".
+	prefix string
+	text   string
+	files  []string
+	// signals are those the prompt should mention one of, if any.
+	signals []string
+	// ignore drops matches of the files' globs.
+	ignore *ralph.Ignore
+}
+
+// promptSources returns the loop's prompt, or every phase's.
+func promptSources(loop *ralph.Loop) []promptSource {
+	// A completion detector or stop regex may not need the agent to print
+	// any signal, and the signal footer tells it about them.
+	withDoneFile := func(signals []string) []string {
+		if loop.StopRegex != nil || loop.SignalFooter {
+			return nil
+		}
+		if len(signals) > 0 && loop.DoneFile != "" {
+			signals = append(signals[:len(signals):len(signals)], loop.DoneFile)
+		}
+		return signals
+	}
+	if len(loop.Phases) == 0 {
+		signals := loop.StopSignals
+		if loop.Detector != nil {
+			signals = nil
+		}
+		return []promptSource{{text: loop.PromptText, files: loop.PromptFiles, signals: withDoneFile(signals), ignore: loop.Ignore}}
+	}
+	var sources []promptSource
+	for i, p := range loop.Phases {
+		text, files, signals := loop.PromptText, loop.PromptFiles, loop.StopSignals
+		if p.Prompt != "" {
+			text, files = "", []string{p.Prompt}
+		}
+		if s := ralph.ParseStopSignals(p.StopSignal); len(s) > 0 {
+			signals = s
+		}
+		if loop.Detector != nil && i == len(loop.Phases)-1 {
+			signals = nil
+		}
+		sources = append(sources, promptSource{prefix: p.Name + ": ", text: text, files: files, signals: withDoneFile(signals), ignore: loop.Ignore})
+	}
+	return sources
+}
+
+// read returns the prompt's text, its files joined by newlines, and the
+// name of the inline prompt or its files. locate turns a line of the text
+// into the file it comes from and the line in that file.
+func (src promptSource) read() (text, name string, locate func(line int) (string, int), err error) {
+	if src.text != "" {
+		return src.text, "inline prompt", func(line int) (string, int) { return "", line }, nil
+	}
+	var (
+		files  []string
+		starts []int
+		lines  int
+	)
+	for _, p := range src.files {
+		matches := []string{p}
+		glob := strings.ContainsAny(p, "*?[")
+		if glob {
+			matches, _ = filepath.Glob(p)
+		}
+		for _, f := range matches {
+			if glob && src.ignore.Match(f) {
+				continue
+			}
+			data, err := os.ReadFile(f)
+			if err != nil {
+				return "", "", nil, fmt.Errorf("%s not found", f)
+			}
+			files, starts = append(files, f), append(starts, lines)
+			chunk := strings.TrimSuffix(string(data), "\n")
+			text += chunk + "\n"
+			lines += strings.Count(chunk, "\n") + 1
+		}
+	}
+	locate = func(line int) (string, int) {
+		i := sort.SearchInts(starts, line) - 1
+		if i < 0 {
+			return "", line
+		}
+		return files[i], line - starts[i]
+	}
+	return text, strings.Join(src.files, ", "), locate, nil
+}
+
+// promptLint returns the linter for a prompt of the loop.
+func promptLint(loop *ralph.Loop, agent *ralph.CommandAgent, src promptSource) ralph.PromptLint {
+	return ralph.PromptLint{
+		StopSignals:   src.signals,
+		ContextTokens: agent.ContextTokens,
+		Ignore:        []string{loop.DoneFile, loop.ErrorLogFile, loop.MemoryFile},
+	}
+}
+
+// diagnosePrompt checks the loop's prompt, or every phase's. Lint findings
+// are warnings: the prompt may well be fine.
+func diagnosePrompt(loop *ralph.Loop, agent *ralph.CommandAgent) []finding {
+	var findings []finding
+	for _, src := range promptSources(loop) {
+		text, name, locate, err := src.read()
+		if err != nil {
+			findings = append(findings, finding{findingWarn, "prompt", fmt.Sprintf("%s%v; the loop will wait for it", src.prefix, err)})
+			continue
+		}
+		findings = append(findings, finding{findingOK, "prompt", fmt.Sprintf("%s%s (%d bytes)", src.prefix, name, len(text))})
+		for _, f := range promptLint(loop, agent, src).Check(text) {
+			msg := f.Message
+			if f.Line > 0 {
+				if file, line := locate(f.Line); file != "" {
+					msg = fmt.Sprintf("%s:%d: %s", file, line, msg)
+				} else {
+					msg = fmt.Sprintf("line %d: %s", line, msg)
+				}
+			}
+			findings = append(findings, finding{findingWarn, "prompt", src.prefix + msg})
+		}
+	}
+	return findings
+}
+
+// probeAgent sends a trivial prompt to confirm the agent is authenticated.
+func probeAgent(agent *ralph.CommandAgent) finding {
+	ctx, cancel := context.WithTimeout(context.Background(), ProbeTimeout)
+	defer cancel()
+
+	probe := *agent
+	probe.Stream = nil
+	res, err := probe.Run(ctx, probePrompt)
+	out := strings.TrimSpace(res.Output)
+	if len(out) > 200 {
+		out = out[:200] + "..."
+	}
+	switch {
+	case err != nil:
+		return finding{findingFail, "auth", fmt.Sprintf("probe failed: %v: %s", err, out)}
+	case out == "":
+		return finding{findingFail, "auth", "probe returned no output"}
+	default:
+		return finding{findingOK, "auth", fmt.Sprintf("agent answered the probe: %q", out)}
+	}
+}
+
+func orDetached(branch string) string {
+	if branch == "" {
+		return "(detached HEAD)"
+	}
+	return branch
+}
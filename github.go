@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"ralph/pkg/ralph"
+)
+
+// PRRemote is the remote --github-pr pushes the branch to.
+const PRRemote = "origin"
+
+// maxPRTitle bounds the length of a generated pull request title.
+const maxPRTitle = 72
+
+// defaultIssuePrompt is the prompt of --prompt-from-issue when there is no
+// prompt file.
+const defaultIssuePrompt = `Resolve the GitHub issue below in this repository. Study the code first,
+make the change, and verify it with the project's tests.
+
+` + ralph.IssuePlaceholder + `
+
+` + ralph.SignalsPlaceholder + `
+`
+
+// runReport collects what the loops of a run did, for the pull request
+// --github-pr opens and the comment --prompt-from-issue posts at the end.
+type runReport struct {
+	loops []loopReport
+	issue *ralph.Issue
+}
+
+// loopReport is what one completed loop did.
+type loopReport struct {
+	task       string
+	agent      string
+	version    string
+	iterations int
+	usage      *ralph.Usage
+	payload    map[string]any
+}
+
+// add records a loop of cfg that completed.
+func (r *runReport) add(cfg *Config, loop *ralph.Loop) {
+	if cfg.issue != nil {
+		r.issue = cfg.issue
+	}
+	lr := loopReport{
+		task:       loop.Task,
+		agent:      loop.AgentName,
+		version:    loop.AgentVersion,
+		iterations: loop.Iteration(),
+		payload:    loop.Payload(),
+	}
+	if usage, ok := loop.Usage(); ok {
+		lr.usage = &usage
+	}
+	r.loops = append(r.loops, lr)
+}
+
+// title returns the pull request title: the title or first line of the
+// summary the agent sent with its stop signal, else the issue's title or
+// the task.
+func (r *runReport) title(branch string) string {
+	var title string
+	for i := len(r.loops) - 1; i >= 0 && title == ""; i-- {
+		title = firstLine(payloadString(r.loops[i].payload, "title"))
+		if title == "" {
+			title = firstLine(payloadString(r.loops[i].payload, "summary"))
+		}
+	}
+	if title == "" && r.issue != nil {
+		title = r.issue.Title
+	}
+	if title == "" && len(r.loops) == 1 {
+		title = firstLine(r.loops[0].task)
+	}
+	if title == "" {
+		title = "Ralph run on " + branch
+	}
+	if t := []rune(title); len(t) > maxPRTitle {
+		title = string(t[:maxPRTitle-3]) + "..."
+	}
+	return title
+}
+
+// prBody returns the pull request description, which closes the issue the
+// run worked on.
+func (r *runReport) prBody() string {
+	body := r.body() + "\n_Opened by ralph._\n"
+	if r.issue != nil {
+		body = "Closes " + r.issue.Ref.String() + "\n\n" + body
+	}
+	return body
+}
+
+// body returns the agent's summaries, the tasks done, the run's statistics
+// and the rest of the completion payloads.
+func (r *runReport) body() string {
+	var b strings.Builder
+	var summaries []string
+	for _, lr := range r.loops {
+		if s := strings.TrimSpace(payloadString(lr.payload, "summary")); s != "" {
+			if len(r.loops) > 1 && lr.task != "" {
+				s = "### " + firstLine(lr.task) + "\n\n" + s
+			}
+			summaries = append(summaries, s)
+		}
+	}
+	if len(summaries) > 0 {
+		b.WriteString("## Summary\n\n" + strings.Join(summaries, "\n\n") + "\n\n")
+	}
+
+	var tasks []string
+	for _, lr := range r.loops {
+		if lr.task != "" {
+			tasks = append(tasks, "- [x] "+firstLine(lr.task))
+		}
+	}
+	if len(tasks) > 0 {
+		b.WriteString("## Tasks\n\n" + strings.Join(tasks, "\n") + "\n\n")
+	}
+
+	b.WriteString("## Run\n\n")
+	agents := map[string]bool{}
+	var names []string
+	iterations := 0
+	var usage ralph.Usage
+	hasUsage := false
+	for _, lr := range r.loops {
+		name := lr.agent
+		if lr.version != "" {
+			name += " (" + lr.version + ")"
+		}
+		if !agents[name] {
+			agents[name] = true
+			names = append(names, name)
+		}
+		iterations += lr.iterations
+		if lr.usage != nil {
+			usage.Add(*lr.usage)
+			hasUsage = true
+		}
+	}
+	fmt.Fprintf(&b, "- Agent: %s\n- Iterations: %d\n", strings.Join(names, ", "), iterations)
+	if hasUsage {
+		fmt.Fprintf(&b, "- Usage: %s\n", usage)
+	}
+
+	var details []string
+	for _, lr := range r.loops {
+		keys := make([]string, 0, len(lr.payload))
+		for k := range lr.payload {
+			if k != "title" && k != "summary" {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			value, ok := lr.payload[k].(string)
+			if !ok {
+				data, _ := json.Marshal(lr.payload[k])
+				value = string(data)
+			}
+			if len(r.loops) > 1 && lr.task != "" {
+				k = firstLine(lr.task) + ": " + k
+			}
+			details = append(details, fmt.Sprintf("- %s: %s", k, value))
+		}
+	}
+	if len(details) > 0 {
+		b.WriteString("\n<details><summary>Completion payload</summary>\n\n" + strings.Join(details, "\n") + "\n\n</details>\n")
+	}
+	return b.String()
+}
+
+// payloadString returns the string field key of a completion payload.
+func payloadString(payload map[string]any, key string) string {
+	s, _ := payload[key].(string)
+	return s
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(line)
+}
+
+// prBase returns the branch the pull request of --github-pr targets:
+// --github-pr-base, else fallback, else the remote's default branch.
+func prBase(ctx context.Context, cfg *Config, fallback string) (string, error) {
+	base := cfg.GithubPRBase
+	if base == "" {
+		base = fallback
+	}
+	if base == "" {
+		base = ralph.GitDefaultBranch(ctx, PRRemote)
+	}
+	if base == "" {
+		return "", errors.New("--github-pr: cannot tell which branch to target; set --github-pr-base")
+	}
+	return base, nil
+}
+
+// openPR pushes branch and opens a pull request for it into base, as
+// --github-pr does once the run completed, and returns its URL.
+func openPR(ctx context.Context, cfg *Config, report *runReport, branch, base string) (string, error) {
+	fmt.Fprintf(console, "⬆️  Pushing %s to %s\n", branch, PRRemote)
+	if err := ralph.GitPush(ctx, PRRemote, branch); err != nil {
+		return "", fmt.Errorf("pushing %s: %w", branch, err)
+	}
+	url, existed, err := ralph.OpenPullRequest(ctx, PRRemote, ralph.PullRequest{
+		Title: report.title(branch),
+		Body:  report.prBody(),
+		Head:  branch,
+		Base:  base,
+		Draft: cfg.GithubPRDraft,
+	})
+	if err != nil {
+		return "", fmt.Errorf("opening the pull request: %w", err)
+	}
+	if existed {
+		fmt.Fprintf(console, "🔀 Pull request (updated): %s\n", url)
+	} else {
+		fmt.Fprintf(console, "🔀 Pull request: %s\n", url)
+	}
+	return url, nil
+}
+
+// commentOnIssue tells the issue of --prompt-from-issue that the run
+// completed, and where its pull request is if it opened one. Failing to is
+// only a warning: the work is done.
+func commentOnIssue(ctx context.Context, report *runReport, prURL string) {
+	if report.issue == nil {
+		return
+	}
+	comment := "Ralph completed the work on this issue"
+	if prURL != "" {
+		comment += " in " + prURL
+	}
+	comment += ".\n\n" + report.body()
+	url, err := ralph.CommentOnIssue(ctx, report.issue.Ref, comment)
+	if err != nil {
+		fmt.Fprintf(console, "⚠️ Failed to comment on %s: %v\n", report.issue.Ref, err)
+		return
+	}
+	fmt.Fprintf(console, "💬 Commented on %s: %s\n", report.issue.Ref, url)
+}
+
+// runReported runs the loop, or the todo plan, on the current branch and
+// once it completes opens a pull request for the branch with --github-pr
+// and comments on the issue of --prompt-from-issue.
+func runReported(cfg *Config, argv []string) (int, bool) {
+	ctx := context.Background()
+	branch, base := "", ""
+	if cfg.GithubPR {
+		if branch = ralph.GitState(ctx).Branch; branch == "" {
+			fmt.Fprintln(console, "❌ Error: --github-pr needs a git branch to push")
+			return 2, false
+		}
+		var err error
+		if base, err = prBase(ctx, cfg, ""); err != nil {
+			fmt.Fprintf(console, "❌ Error: %v\n", err)
+			return 2, false
+		}
+		if branch == base {
+			fmt.Fprintf(console, "❌ Error: --github-pr: the run is on %s, the branch the pull request would target; add --worktree or check out a new branch\n", base)
+			return 2, false
+		}
+	}
+
+	report := &runReport{}
+	code, interrupted := runTasks(cfg.Todo, argv, func(cfg *Config) {
+		cfg.report = report
+	})
+	if code != 0 || interrupted {
+		return code, interrupted
+	}
+	if !cfg.GithubPR {
+		commentOnIssue(ctx, report, "")
+		return code, interrupted
+	}
+	if hash, err := ralph.GitCommitAll(ctx, "ralph: uncommitted changes at the end of the run", cfg.gitCommitOptions()); err != nil {
+		fmt.Fprintf(console, "❌ Error: committing the remaining changes: %v\n", err)
+		return 1, false
+	} else if hash != "" {
+		fmt.Fprintf(console, "📝 Committed remaining changes as %s\n", hash)
+	}
+	prURL, err := openPR(ctx, cfg, report, branch, base)
+	if err != nil {
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		return 1, false
+	}
+	commentOnIssue(ctx, report, prURL)
+	return code, interrupted
+}
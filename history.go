@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ralph/pkg/ralph"
+)
+
+// runHistory lists the runs recorded in HistoryFile, with `show ID` the
+// iterations of one, or with `prompt ID ITERATION` the prompt of one.
+func runHistory(argv []string) int {
+	fs := flag.NewFlagSet("ralph history", flag.ExitOnError)
+	limit := fs.Int("limit", 20, "Show at most this many of the latest runs (0 = all).")
+	asJSON := fs.Bool("json", false, "Print the runs as JSON.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: ralph history [flags]\n       ralph history show [--json] ID\n       ralph history prompt ID ITERATION\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(argv)
+
+	if _, err := os.Stat(HistoryFile); err != nil {
+		fmt.Printf("❌ Error: no history in this directory (%s)\n", HistoryFile)
+		return 1
+	}
+	history, err := ralph.OpenHistory(HistoryFile)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return 1
+	}
+	defer history.Close()
+
+	switch fs.Arg(0) {
+	case "show":
+		return showHistoryRun(history, fs.Args()[1:], *asJSON)
+	case "prompt":
+		return showHistoryPrompt(history, fs.Args()[1:])
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	runs, err := history.Runs(*limit)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return 1
+	}
+	if *asJSON {
+		printJSON(runs)
+		return 0
+	}
+	if len(runs) == 0 {
+		fmt.Println("No runs recorded yet.")
+		return 0
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTARTED\tAGENT\tITERATIONS\tDURATION\tRESULT\tCOST")
+	for _, r := range runs {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%s\t%s\n", r.ID, r.StartedAt.Local().Format(time.DateTime), r.Agent, r.Iterations, runDuration(r), runResult(r), runCost(r.Usage))
+	}
+	tw.Flush()
+	return 0
+}
+
+func showHistoryRun(history *ralph.History, args []string, asJSON bool) int {
+	fs := flag.NewFlagSet("ralph history show", flag.ExitOnError)
+	fs.BoolVar(&asJSON, "json", asJSON, "Print the run as JSON.")
+	_ = fs.Parse(args)
+	id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+	if fs.NArg() != 1 || err != nil {
+		fmt.Println("❌ Error: usage: ralph history show ID")
+		return 2
+	}
+	run, iterations, err := history.Run(id)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return 1
+	}
+	if asJSON {
+		printJSON(struct {
+			ralph.HistoryRun
+			IterationList []ralph.HistoryIteration `json:"iteration_list"`
+		}{run, iterations})
+		return 0
+	}
+
+	fmt.Printf("📜 Run %d\n", run.ID)
+	if run.AgentVersion != "" {
+		fmt.Printf("   Agent:      %s (%s)\n", run.Agent, run.AgentVersion)
+	} else {
+		fmt.Printf("   Agent:      %s\n", run.Agent)
+	}
+	fmt.Printf("   Directory:  %s\n", run.Dir)
+	if run.Label != "" {
+		fmt.Printf("   Label:      %s\n", run.Label)
+	}
+	fmt.Printf("   Started:    %s (pid %d)\n", run.StartedAt.Local().Format(time.DateTime), run.PID)
+	fmt.Printf("   Duration:   %s\n", runDuration(run))
+	fmt.Printf("   Iterations: %d\n", run.Iterations)
+	result := runResult(run)
+	if run.Message != "" {
+		result += ": " + run.Message
+	}
+	fmt.Printf("   Result:     %s\n", result)
+	if run.Usage != nil {
+		fmt.Printf("   Usage:      %s\n", run.Usage)
+	}
+	if len(iterations) == 0 {
+		return 0
+	}
+
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ITERATION\tAGENT\tPHASE\tSTARTED\tDURATION\tEXIT\tOUTPUT\tCOST\tPROMPT")
+	previous := ""
+	for _, it := range iterations {
+		exit, phase, prompt := "-", it.Phase, "-"
+		if it.ExitCode != nil {
+			exit = strconv.Itoa(*it.ExitCode)
+		}
+		if phase == "" {
+			phase = "-"
+		}
+		// The hash tells the prompts apart; a star marks a change.
+		if it.PromptHash != "" {
+			prompt = it.PromptHash[:8]
+			if previous != "" && it.PromptHash != previous {
+				prompt += " *"
+			}
+			previous = it.PromptHash
+		}
+		duration := (time.Duration(it.DurationMS) * time.Millisecond).Round(time.Second)
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%d B\t%s\t%s\n", it.Iteration, it.Agent, phase, it.StartedAt.Local().Format(time.TimeOnly), duration, exit, it.OutputBytes, runCost(it.Usage), prompt)
+	}
+	tw.Flush()
+	return 0
+}
+
+// showHistoryPrompt prints the exact prompt an iteration of a run used.
+func showHistoryPrompt(history *ralph.History, args []string) int {
+	var (
+		id        int64
+		iteration int
+		err       error
+	)
+	if len(args) == 2 {
+		if id, err = strconv.ParseInt(args[0], 10, 64); err == nil {
+			iteration, err = strconv.Atoi(args[1])
+		}
+	}
+	if len(args) != 2 || err != nil {
+		fmt.Println("❌ Error: usage: ralph history prompt ID ITERATION")
+		return 2
+	}
+	prompt, err := history.Prompt(id, iteration)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return 1
+	}
+	fmt.Print(prompt)
+	if !strings.HasSuffix(prompt, "\n") {
+		fmt.Println()
+	}
+	return 0
+}
+
+// runDuration is how long a recorded run took, or "-" if it never ended.
+func runDuration(r ralph.HistoryRun) string {
+	if r.EndedAt == nil {
+		return "-"
+	}
+	return r.EndedAt.Sub(r.StartedAt).Round(time.Second).String()
+}
+
+// runResult is the final event of a recorded run: why it stopped.
+func runResult(r ralph.HistoryRun) string {
+	switch {
+	case r.StopReason != "":
+		return r.StopReason
+	case r.Event != "":
+		return r.Event
+	case processAlive(r.PID) && r.EndedAt == nil:
+		return "running"
+	default:
+		return "unfinished"
+	}
+}
+
+func runCost(u *ralph.Usage) string {
+	if u == nil {
+		return "-"
+	}
+	return fmt.Sprintf("$%.2f", u.CostUSD)
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
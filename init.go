@@ -0,0 +1,254 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ralph/pkg/ralph"
+)
+
+// DefaultStatusFile is the status file configured by `ralph init`.
+const DefaultStatusFile = "ralph-status.json"
+
+const promptTemplate = `# Task
+
+Describe what you want built or fixed here. Be specific about the expected
+result and how to verify it.
+
+# Rules
+
+- Work in small steps. Each run of this prompt is a fresh session: read the
+  code and any notes you left before changing things.
+- Run the tests before you finish.
+
+# Completion
+
+When, and only when, the task is fully done and verified, print the line:
+
+` + ralph.DefaultStopSignal + `
+
+Do not print it otherwise.
+
+If you are stuck and need a human (missing access, unclear requirements),
+print ` + ralph.DefaultBlockedSignal + ` followed by what you need, and stop.
+{{feedback}}
+`
+
+const configTemplate = `# ralph.yaml - project settings for the Ralph loop.
+# Command-line flags override anything set here.
+
+agent: claude
+prompt: ` + ralph.PromptFile + `
+
+# Environment variables for the agent only. Read secrets from files to keep
+# them out of this file and off the command line:
+# agent_env:
+#   HTTPS_PROXY: http://proxy.internal:3128
+# agent_env_files:
+#   ANTHROPIC_API_KEY: /run/secrets/anthropic
+
+# Run the agent in a container instead of on the host. The image must have
+# the agent installed; HOME is /tmp inside, so mount credentials there:
+# sandbox: docker:my-agent-image
+# sandbox_network: bridge
+# sandbox_memory: 4g
+# sandbox_mounts: ["~/.claude:/tmp/.claude"]
+# Or run it in the project's devcontainer (needs the devcontainer CLI):
+# sandbox: devcontainer
+
+# Or keep it on the host, within limits (CPU and memory need cgroups v2):
+# cpu_limit: 2
+# mem_limit: 4g
+# nice: 10
+# ionice: idle
+
+# Only let the agent reach these hosts over HTTP(S), through a local proxy:
+# allow_hosts: [api.anthropic.com, "*.github.com"]
+
+# Shell command that ends the loop as soon as it passes, e.g. "go test ./...".
+check: ""
+
+# Token the agent prints when done (comma-separate several).
+stop_signal: ` + ralph.DefaultStopSignal + `
+# Or the file it creates, whose contents become the completion message.
+done_file: ` + ralph.DefaultDoneFile + `
+# Token the agent prints, followed by the reason, when it needs a human.
+blocked_signal: ` + ralph.DefaultBlockedSignal + `
+# Tell the agent about these in every prompt, so PROMPT.md need not:
+# signal_footer: true
+
+sleep: 2s
+max_iterations: 50
+
+# Optional phases run one after another, each until its own stop signal,
+# e.g. a planning loop before the building loop:
+# phases:
+#   - name: plan
+#     prompt: PROMPT_PLAN.md
+#     stop_signal: PLAN_DONE
+#     max_iterations: 5
+#   - name: build
+#     prompt: PROMPT_BUILD.md
+iteration_timeout: 30m
+
+# Commands run when the run starts and ends (on_start, on_complete,
+# on_error, on_stall, on_cancel), with RALPH_EVENT, RALPH_ITERATION,
+# RALPH_STOP_REASON, RALPH_MESSAGE and RALPH_EVENT_JSON set:
+# hooks:
+#   on_complete: ./scripts/open-pr.sh
+#   on_error: 'echo "ralph gave up: $RALPH_MESSAGE" | mail -s ralph me@example.com'
+
+# Markdown files under specs/ are added to every prompt, in path order;
+# pick them with globs, or turn them off with specs: "".
+# specs_include: ["*.md"]
+# specs_exclude: [drafts/**]
+
+# Work on a GitHub issue: its title and body (and comments) go into the
+# prompt, at {{issue}} or appended, and a comment goes back to it once the
+# run completes. Without a prompt file the issue is the whole prompt.
+# prompt_from_issue: owner/repo#123
+# issue_comments: true
+
+# In GitHub Actions: group the log by iteration, annotate problems, mask
+# secrets, and write a step summary and step outputs.
+# ci: github
+
+# Once the run completes, push its branch and open a GitHub pull request
+# described from the agent's completion payload (title, summary...), with
+# $GITHUB_TOKEN or the gh CLI. Best with --worktree.
+# github_pr: true
+# github_pr_base: main
+# github_pr_draft: true
+
+# Record every tool call of the agent (shell commands, file edits...) in
+# .ralph/audit.jsonl through its hooks, for ralph audit. Built in for
+# claude; other agents' hooks must run "ralph audit record".
+# audit: true
+
+# Well-known API keys and the values of *_API_KEY, *_TOKEN, *_SECRET and
+# *_PASSWORD variables are masked in agent output, logs, artifacts and
+# status events. Mask more with regular expressions and variable names.
+# redact: ['internal-[a-z0-9]{32}']
+# redact_env: [DATABASE_URL]
+
+# The prompt is checked against the agent's context window before every
+# iteration: a warning over half of it, and over all of it the run stops
+# (refuse) or the middle of the prompt is cut out (truncate).
+# prompt_warn_tokens: 50000
+# prompt_max_tokens: 100000
+# prompt_overflow: truncate
+
+# Paths in .ralphignore (gitignore syntax) are kept out of the prompt: out
+# of prompt and spec globs and out of injected diffs. Point elsewhere, or
+# turn it off with ignore_file: "".
+# ignore_file: .ralphignore
+
+# Ask before using a prompt changed during the run, e.g. by the agent: on
+# the terminal, or with a command that approves by exiting 0.
+# confirm_prompt_changes: true
+# prompt_hook: ./scripts/approve-prompt.sh
+
+status_file: ` + DefaultStatusFile + `
+
+# Branches ralph refuses to run on (--allow-protected-branch overrides).
+protected_branches: [main, master]
+`
+
+// gitignoreEntries are the files ralph writes that should not be committed.
+var gitignoreEntries = []string{
+	ralph.ErrorLogFile,
+	ralph.DefaultDoneFile,
+	DefaultStatusFile,
+	DefaultStatusFile + ".1",
+	filepath.ToSlash(LockFile),
+	filepath.ToSlash(StateFile),
+	filepath.ToSlash(HistoryFile) + "*",
+	StateDir + "/ralph-prompt-*.md",
+	filepath.ToSlash(WorktreesDir) + "/",
+}
+
+// runInit scaffolds PROMPT.md, ralph.yaml and .gitignore entries in the
+// current directory.
+func runInit(args []string) int {
+	fs := flag.NewFlagSet("ralph init", flag.ExitOnError)
+	force := fs.Bool("force", false, "Overwrite existing PROMPT.md and ralph.yaml.")
+	_ = fs.Parse(args)
+
+	ok := true
+	for _, f := range []struct{ path, content string }{
+		{ralph.PromptFile, promptTemplate},
+		{ConfigFile, configTemplate},
+	} {
+		if _, err := os.Stat(f.path); err == nil && !*force {
+			fmt.Printf("⏭️  %s already exists, skipping (use --force to overwrite)\n", f.path)
+			continue
+		}
+		if err := os.WriteFile(f.path, []byte(f.content), 0644); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			ok = false
+			continue
+		}
+		fmt.Printf("✅ Created %s\n", f.path)
+	}
+
+	added, err := appendGitignore(".gitignore", gitignoreEntries)
+	switch {
+	case err != nil:
+		fmt.Printf("❌ Error: %v\n", err)
+		ok = false
+	case len(added) > 0:
+		fmt.Printf("✅ Added %s to .gitignore\n", strings.Join(added, ", "))
+	}
+
+	if !ok {
+		return 1
+	}
+	fmt.Printf("\n🎯 Edit %s, then run: ralph\n", ralph.PromptFile)
+	return 0
+}
+
+// appendGitignore adds the entries missing from the ignore file at path and
+// returns the ones it added.
+func appendGitignore(path string, entries []string) ([]string, error) {
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	present := map[string]bool{}
+	for _, line := range strings.Split(string(existing), "\n") {
+		present[strings.TrimPrefix(strings.TrimSpace(line), "/")] = true
+	}
+
+	var added []string
+	for _, e := range entries {
+		if !present[e] {
+			added = append(added, e)
+		}
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+
+	var sb strings.Builder
+	if len(existing) > 0 && !strings.HasSuffix(string(existing), "\n") {
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\n# ralph\n")
+	for _, e := range added {
+		sb.WriteString(e + "\n")
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.WriteString(sb.String())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return added, err
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"ralph/pkg/ralph"
+)
+
+// reviewTailLines is how much agent output an interactive review shows.
+const reviewTailLines = 15
+
+// stdin is shared by the terminal reviews and approvals so that neither
+// loses what the other has buffered.
+var stdin = bufio.NewReader(os.Stdin)
+
+// terminalReview asks the user on the terminal what to do with each
+// finished iteration. The prompt file, if any, can be edited in between.
+func terminalReview(loop *ralph.Loop) func(ralph.IterationReview) ralph.ReviewDecision {
+	return func(r ralph.IterationReview) ralph.ReviewDecision {
+		fmt.Fprintf(console, "\n──── Review of iteration %d ────\n", r.Iteration)
+		if r.DiffStat != "" {
+			fmt.Fprintln(console, r.DiffStat)
+		} else {
+			fmt.Fprintln(console, "(no changes to the git work tree)")
+		}
+		fmt.Fprintln(console, "\nOutput (tail):")
+		fmt.Fprintln(console, tailLines(r.Output, reviewTailLines))
+
+		for {
+			fmt.Fprint(console, "\n[a]pprove  [s]kip and revert  [e]dit prompt  [q]uit > ")
+			answer, err := stdin.ReadString('\n')
+			if err != nil {
+				// stdin closed: nobody is there to approve anything.
+				return ralph.ReviewAbort
+			}
+			switch strings.ToLower(strings.TrimSpace(answer)) {
+			case "", "a", "approve", "y":
+				return ralph.ReviewApprove
+			case "s", "skip":
+				return ralph.ReviewSkip
+			case "q", "quit", "abort":
+				return ralph.ReviewAbort
+			case "e", "edit":
+				editPrompt(loop)
+			}
+		}
+	}
+}
+
+// terminalPromptApproval asks the user on the terminal whether to use a
+// prompt changed during the run, showing what changed.
+func terminalPromptApproval() func(ralph.PromptChange) ralph.ReviewDecision {
+	return func(c ralph.PromptChange) ralph.ReviewDecision {
+		fmt.Fprintf(console, "\n──── Prompt change before iteration %d ────\n", c.Iteration)
+		fmt.Fprintln(console, promptDiff(c.Old, c.New))
+		for {
+			fmt.Fprint(console, "\n[u]se the new prompt  [k]eep the previous one  [q]uit > ")
+			answer, err := stdin.ReadString('\n')
+			if err != nil {
+				return ralph.ReviewAbort
+			}
+			switch strings.ToLower(strings.TrimSpace(answer)) {
+			case "u", "use", "y":
+				return ralph.ReviewApprove
+			case "k", "keep", "n":
+				return ralph.ReviewSkip
+			case "q", "quit", "abort":
+				return ralph.ReviewAbort
+			}
+		}
+	}
+}
+
+// promptDiff shows how a prompt changed, with git diff if it can, else by
+// listing the lines that are gone and the lines that are new.
+func promptDiff(old, new string) string {
+	if dir, err := os.MkdirTemp("", "ralph-prompt-"); err == nil {
+		defer os.RemoveAll(dir)
+		a, b := filepath.Join(dir, "previous"), filepath.Join(dir, "changed")
+		if os.WriteFile(a, []byte(old), 0644) == nil && os.WriteFile(b, []byte(new), 0644) == nil {
+			out, _ := exec.Command("git", "diff", "--no-index", "--no-color", "--no-prefix", a, b).Output()
+			if i := strings.Index(string(out), "@@"); i >= 0 {
+				return strings.TrimRight(string(out[i:]), "\n")
+			}
+		}
+	}
+	count := func(text string) map[string]int {
+		m := map[string]int{}
+		for _, line := range strings.Split(text, "\n") {
+			m[line]++
+		}
+		return m
+	}
+	before, after := count(old), count(new)
+	var lines []string
+	for _, line := range strings.Split(old, "\n") {
+		if after[line] > 0 {
+			after[line]--
+		} else {
+			lines = append(lines, "-"+line)
+		}
+	}
+	for _, line := range strings.Split(new, "\n") {
+		if before[line] > 0 {
+			before[line]--
+		} else {
+			lines = append(lines, "+"+line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// editPrompt opens the first prompt file in $EDITOR. The loop re-reads it
+// before the next iteration.
+func editPrompt(loop *ralph.Loop) {
+	if loop.PromptText != "" || len(loop.PromptFiles) == 0 {
+		fmt.Fprintln(console, "⚠️ The prompt is inline and cannot be edited.")
+		return
+	}
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+	args := append(strings.Fields(editor), loop.PromptFiles[0])
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(console, "⚠️ Editor failed: %v\n", err)
+	}
+}
+
+func tailLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"ralph/pkg/ralph"
+)
+
+// toggleWriter forwards to w only while enabled.
+type toggleWriter struct {
+	w  io.Writer
+	on atomic.Bool
+}
+
+func (t *toggleWriter) Write(p []byte) (int, error) {
+	if t.on.Load() {
+		return t.w.Write(p)
+	}
+	return len(p), nil
+}
+
+// keyboardControls reads single keys from the terminal while the loop runs:
+// p pauses or resumes, s skips the rest, v toggles the agent output and q
+// stops gracefully. It returns a function restoring the terminal, or nil if
+// stdin is not a terminal that supports it.
+func keyboardControls(loop *ralph.Loop, agent *ralph.CommandAgent) func() {
+	if !isTerminal(os.Stdin) {
+		return nil
+	}
+	restore, err := cbreak(os.Stdin)
+	if err != nil {
+		return nil
+	}
+
+	stream := &toggleWriter{w: agent.Stream}
+	stream.on.Store(true)
+	agent.Stream = stream
+
+	fmt.Fprintln(console, "⌨️  Keys: p pause/resume · s skip rest · v show/hide agent output · q quit after this iteration")
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buf); err != nil {
+				return
+			}
+			switch buf[0] {
+			case 'p', 'P':
+				if loop.Paused() {
+					fmt.Fprintln(console, "\n▶️  Resuming.")
+					loop.Continue()
+				} else {
+					fmt.Fprintln(console, "\n⏸️  Pausing after the current iteration. Press p again to resume.")
+					loop.Pause()
+				}
+			case 's', 'S':
+				loop.SkipRest()
+			case 'v', 'V':
+				on := !stream.on.Load()
+				stream.on.Store(on)
+				if on {
+					fmt.Fprintln(console, "\n👀 Showing agent output.")
+				} else {
+					fmt.Fprintln(console, "\n🙈 Hiding agent output.")
+				}
+			case 'q', 'Q':
+				fmt.Fprintln(console, "\n✋ Finishing the current iteration, then stopping.")
+				loop.Stop()
+			}
+		}
+	}()
+	return restore
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"ralph/pkg/ralph"
+)
+
+// promptFinding is a lint finding located in the prompt files.
+type promptFinding struct {
+	Phase string `json:"phase,omitempty"`
+	File  string `json:"file,omitempty"`
+	ralph.PromptFinding
+}
+
+// runLintPrompt checks the prompt a run with the same flags would use.
+// It fails if there are errors, or with --strict any findings at all.
+func runLintPrompt(argv []string) int {
+	var asJSON, strict bool
+	cfg, args, err := parseConfig("ralph lint-prompt", argv, func(fs *flag.FlagSet) {
+		fs.BoolVar(&asJSON, "json", false, "Print the findings as JSON.")
+		fs.BoolVar(&strict, "strict", false, "Fail on warnings too.")
+	})
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return 2
+	}
+	loop, agent, err := newLoop(&cfg, args)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return 2
+	}
+
+	findings := []promptFinding{}
+	for _, src := range promptSources(loop) {
+		phase := strings.TrimSuffix(src.prefix, ": ")
+		text, name, locate, err := src.read()
+		if err != nil {
+			fmt.Printf("❌ Error: %s%v\n", src.prefix, err)
+			return 1
+		}
+		for _, f := range promptLint(loop, agent, src).Check(text) {
+			pf := promptFinding{Phase: phase, PromptFinding: f}
+			switch {
+			case f.Line > 0:
+				pf.File, pf.Line = locate(f.Line)
+			case src.text == "":
+				pf.File = name
+			}
+			findings = append(findings, pf)
+		}
+	}
+
+	failed := false
+	for _, f := range findings {
+		failed = failed || strict || f.Severity == ralph.SeverityError
+	}
+	if asJSON {
+		printJSON(findings)
+	} else {
+		for _, f := range findings {
+			icon := "⚠️ "
+			if f.Severity == ralph.SeverityError {
+				icon = "❌"
+			}
+			where := orDefault(f.File, "prompt")
+			if f.Line > 0 {
+				where = fmt.Sprintf("%s:%d", orDefault(f.File, "prompt"), f.Line)
+			}
+			if f.Phase != "" {
+				where = f.Phase + ": " + where
+			}
+			fmt.Printf("%s %s: %s [%s]\n", icon, where, f.Message, f.Rule)
+		}
+		if len(findings) == 0 {
+			fmt.Println("✅ No problems found in the prompt")
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// StateDir holds ralph's runtime files inside the project.
+const StateDir = ".ralph"
+
+// LockFile guards a project against concurrent loops.
+var LockFile = filepath.Join(StateDir, "ralph.lock")
+
+// StateFile holds the checkpoint used by --resume.
+var StateFile = filepath.Join(StateDir, "state.json")
+
+// HistoryFile is the database of past runs, see `ralph history`.
+var HistoryFile = filepath.Join(StateDir, "history.db")
+
+// AuditFile records the agent's tool calls when --audit is set, see
+// `ralph audit`.
+var AuditFile = filepath.Join(StateDir, "audit.jsonl")
+
+// MemoryFile collects the agent's notes when --memory is set.
+var MemoryFile = filepath.Join(StateDir, "memory.md")
+
+// errLocked is returned by acquireLock when another loop holds the lock.
+var errLocked = errors.New("another ralph loop is running in this directory")
+
+// acquireLock creates the lock file containing our PID. Unless force is set
+// it refuses when the file already exists, reporting the holder's PID and
+// whether that process still exists. The returned function releases the lock.
+func acquireLock(path string, force bool) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_EXCL
+	if force {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if errors.Is(err, os.ErrExist) {
+		pid := readLockPID(path)
+		if processAlive(pid) {
+			return nil, fmt.Errorf("%w (pid %d, lock %s)", errLocked, pid, path)
+		}
+		return nil, fmt.Errorf("%w: stale lock %s from pid %d; rerun with --force if no loop is running", errLocked, path, pid)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, err
+	}
+
+	return func() {
+		// Only remove the lock if it is still ours (--force may have taken it).
+		if readLockPID(path) == os.Getpid() {
+			_ = os.Remove(path)
+		}
+	}, nil
+}
+
+func readLockPID(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
@@ -1,162 +1,1040 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"flag"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
+
+	"ralph/pkg/ralph"
 )
 
-// Configuration
+// Exit codes
 const (
-	PromptFile   = "PROMPT.md"
-	ErrorLogFile = "ralph-error.log"
-	MaxLogLines  = 300
+	ExitMaxIterations  = 3
+	ExitStalled        = 4
+	ExitAgentErrors    = 5
+	ExitBudget         = 6
+	ExitDeadline       = 7
+	ExitBlocked        = 8
+	ExitPromptTooLarge = 9
 )
 
+// commands maps subcommand names to their entry points. Running ralph
+// without a known subcommand is the same as `ralph run`.
+var commands = map[string]func(args []string) int{
+	"run":         run,
+	"doctor":      runDoctor,
+	"status":      runStatus,
+	"history":     runHistory,
+	"audit":       runAudit,
+	"stats":       runStats,
+	"bench":       runBench,
+	"lint-prompt": runLintPrompt,
+	"init":        runInit,
+	"pause":       runPause,
+	"resume":      runResume,
+	"serve":       runServe,
+	"daemon":      runDaemon,
+	"submit":      runSubmit,
+	"queue":       runQueue,
+	"version":     runVersion,
+}
+
 func main() {
-	// Parse flags
-	agentPtr := flag.String("agent", "claude", "The AI agent to use (claude, gemini, copilot, codex, vibe, opencode)")
-	checkCmdPtr := flag.String("check", "", "The verification command (e.g., 'go test ./...'). Loop stops when this passes.")
-	flag.Parse()
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
+	os.Exit(run(os.Args[1:]))
+}
 
-	agent := *agentPtr
-	if len(flag.Args()) > 0 {
-		agent = flag.Args()[0]
+func run(argv []string) int {
+	cfg, _, err := parseConfig("ralph run", argv, nil)
+	if err != nil {
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		return 2
+	}
+	if cfg.Output == OutputJSON {
+		// Keep stdout for the NDJSON stream from the start, before the
+		// first loop or todo item prints anything.
+		console = os.Stderr
 	}
+	if cfg.Schedule != "" {
+		return runScheduled(cfg, argv)
+	}
+	code, _ := runOnce(cfg, argv)
+	return code
+}
 
-	fmt.Printf("🎯 Starting Ralph Loop using: %s\n", agent)
-	if *checkCmdPtr != "" {
-		fmt.Printf("🛡️  Verification Command: %s\n", *checkCmdPtr)
+// runOnce runs the loop here, in every --workdir or as a --race, and reports
+// whether the user interrupted it.
+func runOnce(cfg Config, argv []string) (int, bool) {
+	if cfg.Race != "" {
+		return runRace(cfg, argv), false
 	}
-	fmt.Println("----------------------------------------")
+	if len(cfg.Workdirs.values) > 0 {
+		return runWorkdirs(cfg.Workdirs.values, argv)
+	}
+	return runHere(argv)
+}
 
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer stop()
+// runLoop runs one loop in the current directory and returns its exit code
+// and whether the user interrupted it. adjust, if set, may amend the parsed
+// configuration.
+func runLoop(argv []string, adjust func(*Config)) (int, bool) {
+	cfg, args, err := parseConfig("ralph run", argv, nil)
+	if err != nil {
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		return 2, false
+	}
+	if adjust != nil {
+		adjust(&cfg)
+	}
 
-	for {
-		if ctx.Err() != nil {
-			return
+	loop, agent, err := newLoop(&cfg, args)
+	if err != nil {
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		return 2, false
+	}
+	if agent.Egress != nil {
+		defer agent.Egress.Close()
+		agent.Egress.OnDenied = func(host string) {
+			fmt.Fprintf(console, "\n🚫 Blocked the agent's request to %s (not an --allow-host)\n", host)
+		}
+	}
+	if agent.Record != nil {
+		defer func() {
+			if err := agent.Record.Close(); err != nil {
+				fmt.Fprintf(console, "⚠️ Recording to %s failed: %v\n", cfg.Record, err)
+			}
+		}()
+	}
+	if replay := agent.Replay; replay != nil {
+		mismatched := false
+		replay.OnMismatch = func(run int) {
+			if !mismatched {
+				mismatched = true
+				fmt.Fprintf(console, "\n⚠️ The prompt of run %d differs from the recording; the replay has diverged.\n", run)
+			}
+		}
+		replay.OnExhausted = func() {
+			fmt.Fprintf(console, "\n⏹️  The recording has no more agent runs. Stopping.\n")
+			loop.Stop()
+		}
+	}
+	loop.StateFile = StateFile
+	if cfg.Memory {
+		loop.MemoryFile = MemoryFile
+	}
+	if cfg.Interactive {
+		if !isTerminal(os.Stdin) {
+			fmt.Fprintln(console, "❌ Error: --interactive needs a terminal on stdin")
+			return 2, false
+		}
+		loop.Review = terminalReview(loop)
+	}
+	if cfg.ConfirmPromptChanges {
+		if !isTerminal(os.Stdin) {
+			fmt.Fprintln(console, "❌ Error: --confirm-prompt-changes needs a terminal on stdin; use --prompt-hook to approve changes unattended")
+			return 2, false
 		}
+		loop.ApprovePrompt = terminalPromptApproval()
+	}
+	if cfg.Resume {
+		st, err := ralph.LoadState(StateFile)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			fmt.Fprintln(console, "❌ Error: no run to resume")
+			return 2, false
+		case err != nil:
+			fmt.Fprintf(console, "❌ Error: %v\n", err)
+			return 2, false
+		case st.Finished:
+			fmt.Fprintf(console, "❌ Error: the last run already ended (%s); start a new one without --resume\n", st.StopReason)
+			return 2, false
+		}
+		loop.Resume(st)
+		fmt.Fprintf(console, "⏯️  Resuming the run started %s after iteration %d\n", st.StartedAt.Local().Format(time.DateTime), st.Iteration)
+	}
 
-		// 1. Run Verification (Physics Check)
-		if *checkCmdPtr != "" {
-			fmt.Printf("\n🔎 Running check: %s ...\n", *checkCmdPtr)
-			output, err := runShellCommand(ctx, *checkCmdPtr)
+	var extraSinks []func(ralph.StatusEvent)
+	if cfg.Output == OutputJSON {
+		extraSinks = append(extraSinks, useJSONOutput(loop, agent))
+	}
+	var ci *githubActions
+	if cfg.CI == CIGitHub {
+		ci = useGitHubActions(&cfg, loop)
+		extraSinks = append(extraSinks, ci.Observe)
+	}
+	var tui *dashboard
+	if cfg.TUI {
+		switch {
+		case !isTerminal(os.Stdin) || !isTerminal(os.Stdout):
+			fmt.Fprintln(console, "❌ Error: --tui needs a terminal")
+			return 2, false
+		case loop.Review != nil, loop.ApprovePrompt != nil:
+			fmt.Fprintln(console, "❌ Error: --tui and --interactive or --confirm-prompt-changes are mutually exclusive")
+			return 2, false
+		case cfg.Output == OutputJSON:
+			fmt.Fprintln(console, "❌ Error: --tui and --output json are mutually exclusive")
+			return 2, false
+		}
+		var sink func(ralph.StatusEvent)
+		tui, sink = useTUI(loop, agent)
+		extraSinks = append(extraSinks, sink)
+	}
+	var webURL string
+	if cfg.Web != "" {
+		sink, url, closeWeb, err := serveWeb(&cfg, loop, agent)
+		if err != nil {
+			fmt.Fprintf(console, "❌ Error: %v\n", err)
+			return 2, false
+		}
+		defer closeWeb()
+		extraSinks = append(extraSinks, sink)
+		webURL = url
+	}
+	var apiURL string
+	if cfg.APIAddr != "" {
+		sink, url, closeAPI, err := serveAPI(&cfg, loop, agent)
+		if err != nil {
+			fmt.Fprintf(console, "❌ Error: %v\n", err)
+			return 2, false
+		}
+		defer closeAPI()
+		extraSinks = append(extraSinks, sink)
+		apiURL = url
+	}
+	if cfg.LogFile != "" {
+		logFile := &ralph.LogFile{Path: cfg.LogFile, MaxBytes: cfg.LogMaxBytes, Timestamps: cfg.LogTimestamps}
+		defer logFile.Close()
+		teeLog(logFile, loop, agent)
+	}
 
-			if err == nil {
-				// Success! Clean up the error log so we don't confuse future runs
-				_ = os.Remove(ErrorLogFile)
-				fmt.Println("\n✅ Verification PASSED! Task complete.")
-				return
+	loop.AgentVersion = agentVersion(agent)
+	versions := map[string]string{loop.AgentName: loop.AgentVersion}
+	for _, named := range [][]ralph.NamedAgent{loop.Fallbacks, loop.Rotation} {
+		for i, a := range named {
+			v, ok := versions[a.Name]
+			if !ok {
+				v = agentVersion(a.Agent.(*ralph.CommandAgent))
+				versions[a.Name] = v
+			}
+			named[i].Version = v
+		}
+	}
+	if loop.AgentVersion != "" {
+		fmt.Fprintf(console, "🎯 Starting Ralph Loop using: %s (%s)\n", loop.AgentName, loop.AgentVersion)
+	} else {
+		fmt.Fprintf(console, "🎯 Starting Ralph Loop using: %s\n", loop.AgentName)
+	}
+	if agent.Bin != "" {
+		fmt.Fprintf(console, "📍 Agent Binary: %s\n", agent.Bin)
+	}
+	if cfg.Rotate != "" {
+		fmt.Fprintf(console, "🔁 Rotation: %s\n", cfg.Rotate)
+	}
+	if len(loop.Fallbacks) > 0 {
+		names := make([]string, len(loop.Fallbacks))
+		for i, fallback := range loop.Fallbacks {
+			names[i] = fallback.Name
+		}
+		fmt.Fprintf(console, "🔀 Fallbacks: %s (after a rate limit or %d failures in a row)\n", strings.Join(names, ", "), loop.FallbackAfter)
+	}
+	if len(cfg.AgentArgs) > 0 {
+		fmt.Fprintf(console, "🧩 Agent Args: %s\n", strings.Join(cfg.AgentArgs, " "))
+	}
+	if agent.Sandbox != nil {
+		fmt.Fprintf(console, "🐳 Sandbox: %s\n", agent.Sandbox)
+	}
+	if agent.Limits != nil {
+		fmt.Fprintf(console, "⚖️  Limits: %s\n", agent.Limits)
+	}
+	if cfg.Record != "" {
+		fmt.Fprintf(console, "⏺️  Recording: %s\n", cfg.Record)
+	}
+	if agent.Replay != nil {
+		fmt.Fprintf(console, "▶️  Replaying: %s (%d agent runs)\n", cfg.Replay, agent.Replay.Len())
+	}
+	if agent.Egress != nil {
+		fmt.Fprintf(console, "🌐 Egress: only %s\n", strings.Join(agent.Egress.Allow, ", "))
+	}
+	if names := envNames(agent); len(names) > 0 {
+		fmt.Fprintf(console, "🔑 Agent Env: %s\n", strings.Join(names, ", "))
+	}
+	prompt := strings.Join(loop.PromptFiles, ", ")
+	if loop.PromptText != "" {
+		prompt = fmt.Sprintf("inline (%d bytes)", len(loop.PromptText))
+	}
+	if len(loop.Phases) == 0 {
+		fmt.Fprintf(console, "📄 Prompt: %s\n", prompt)
+	}
+	if loop.Task != "" {
+		fmt.Fprintf(console, "📋 Task: %s\n", loop.Task)
+	}
+	if cfg.issue != nil {
+		fmt.Fprintf(console, "🐛 Issue: %s %s\n", cfg.issue.Ref, cfg.issue.Title)
+	}
+	if loop.Check != "" {
+		fmt.Fprintf(console, "🛡️  Verification Command: %s\n", loop.Check)
+	}
+	if cfg.Completion != nil {
+		fmt.Fprintf(console, "🏁 Completion: %s\n", cfg.Completion)
+	} else if len(loop.StopSignals) > 0 && len(loop.Phases) == 0 {
+		fmt.Fprintf(console, "🏁 Stop Signal: %s\n", strings.Join(loop.StopSignals, ", "))
+	}
+	if len(loop.Phases) > 0 {
+		fmt.Fprintln(console, "📐 Phases:")
+		for i, p := range loop.Phases {
+			signals := strings.Join(loop.StopSignals, ", ")
+			if p.StopSignal != "" {
+				signals = strings.Join(ralph.ParseStopSignals(p.StopSignal), ", ")
 			}
+			line := fmt.Sprintf("   %d. %s: %s", i+1, p.Name, orDefault(p.Prompt, prompt))
+			if signals != "" {
+				line += " until " + signals
+			}
+			if p.MaxIterations > 0 {
+				line += fmt.Sprintf(" (max %d iterations)", p.MaxIterations)
+			}
+			fmt.Fprintln(console, line)
+		}
+	}
+	if loop.StopRegex != nil {
+		fmt.Fprintf(console, "🏁 Stop Regex: %s\n", loop.StopRegex)
+	}
+	if loop.DoneFile != "" {
+		fmt.Fprintf(console, "🏁 Done File: %s\n", loop.DoneFile)
+	}
+	if len(loop.BlockedSignals) > 0 {
+		fmt.Fprintf(console, "🚧 Blocked Signal: %s\n", strings.Join(loop.BlockedSignals, ", "))
+	}
+	if loop.Validate != "" {
+		fmt.Fprintf(console, "🔎 Completion Validator: %s\n", loop.Validate)
+	}
+	if loop.Guard != "" {
+		revert := ""
+		if loop.RevertOnFail {
+			revert = " (reverting failed iterations)"
+		}
+		fmt.Fprintf(console, "🛡️  Guard: %s%s\n", loop.Guard, revert)
+	}
+	if loop.PreHook != "" {
+		fmt.Fprintf(console, "🪝 Pre-hook: %s\n", loop.PreHook)
+	}
+	if loop.PromptHook != "" {
+		fmt.Fprintf(console, "🪝 Prompt hook: %s (approves prompt changes)\n", loop.PromptHook)
+	}
+	if loop.ApprovePrompt != nil {
+		fmt.Fprintln(console, "📝 Prompt changes: confirmed on the terminal")
+	}
+	if loop.PostHook != "" {
+		abort := ""
+		if loop.PostHookAbort {
+			abort = " (stopping when it fails)"
+		}
+		fmt.Fprintf(console, "🪝 Post-hook: %s%s\n", loop.PostHook, abort)
+	}
+	if names := cfg.Hooks.Names(); len(names) > 0 {
+		fmt.Fprintf(console, "🪝 Lifecycle hooks: %s\n", strings.Join(names, ", "))
+	}
+	if loop.InjectDiff != "" {
+		fmt.Fprintf(console, "🧮 Diff in prompt: %s of the changes since the start of the run\n", loop.InjectDiff)
+	}
+	if loop.MemoryFile != "" {
+		fmt.Fprintf(console, "🧠 Memory: %s\n", loop.MemoryFile)
+	}
+	if loop.SpecsDir != "" {
+		if specs, _ := ralph.ReadSpecs(loop.SpecsDir, loop.SpecsInclude, loop.SpecsExclude, loop.Ignore); len(specs) > 0 {
+			fmt.Fprintf(console, "📚 Specs: %d files in %s\n", len(specs), loop.SpecsDir)
+		}
+	}
+	if loop.PromptMaxTokens > 0 {
+		fmt.Fprintf(console, "📏 Prompt limit: %d tokens (%s beyond)\n", loop.PromptMaxTokens, loop.PromptOverflow)
+	}
+	if agent.Audit != nil {
+		how := "through its hooks"
+		if agent.AuditHooks == "" {
+			how = "if its hooks run `ralph audit record`"
+		}
+		fmt.Fprintf(console, "🕵️  Audit: the agent's tool calls go to %s %s\n", AuditFile, how)
+	}
+	if loop.Ignore != nil {
+		fmt.Fprintf(console, "🙈 Ignored paths: %s\n", cfg.IgnoreFile)
+	}
+	if loop.SignalFooter {
+		fmt.Fprintln(console, "🪧 Signal instructions: appended to the prompt")
+	}
+	if loop.Carryover != "" {
+		fmt.Fprintf(console, "🧵 Carryover: %s of the previous iteration, up to %d bytes\n", loop.Carryover, loop.CarryoverBytes)
+	}
+	if loop.MaxIterations > 0 {
+		fmt.Fprintf(console, "🔢 Max Iterations: %d\n", loop.MaxIterations)
+	}
+	if loop.MaxDuration > 0 {
+		fmt.Fprintf(console, "⏰ Max Duration: %s\n", loop.MaxDuration)
+	}
+	if loop.MaxCostUSD > 0 {
+		fmt.Fprintf(console, "💸 Max Cost: $%.2f\n", loop.MaxCostUSD)
+	}
+	if loop.MaxTokens > 0 {
+		fmt.Fprintf(console, "💸 Max Tokens: %d\n", loop.MaxTokens)
+	}
+	if loop.IterationTimeout > 0 {
+		fmt.Fprintf(console, "⏱️  Iteration Timeout: %s\n", loop.IterationTimeout)
+	}
+	if loop.StallAfter > 0 {
+		fmt.Fprintf(console, "🧊 Stall Detection: after %d idle iterations\n", loop.StallAfter)
+	}
+	if loop.ArtifactsDir != "" {
+		fmt.Fprintf(console, "🗂️  Artifacts: %s\n", loop.ArtifactsDir)
+	}
+	if cfg.MetricsAddr != "" {
+		fmt.Fprintf(console, "📈 Metrics: http://%s/metrics\n", cfg.MetricsAddr)
+	}
+	if apiURL != "" {
+		fmt.Fprintf(console, "🔌 Control API: %s\n", apiURL)
+	}
+	if webURL != "" {
+		fmt.Fprintf(console, "🌐 Dashboard: %s\n", webURL)
+	}
+	if loop.Reviewer != nil {
+		every := ""
+		if loop.ReviewEvery > 0 {
+			every = fmt.Sprintf(" every %d iterations and", loop.ReviewEvery)
+		}
+		fmt.Fprintf(console, "🧑‍⚖️ Reviewer: %s,%s before accepting a stop signal\n", loop.ReviewerName, every)
+	}
+	if loop.Review != nil {
+		fmt.Fprintln(console, "🙋 Interactive: reviewing every iteration")
+	}
+	if loop.GitCommit {
+		fmt.Fprintln(console, "📝 Git: committing after every iteration")
+	}
+	if ci != nil {
+		fmt.Fprintln(console, "🐙 CI: GitHub Actions (log groups, annotations, step summary and outputs)")
+	}
+	if cfg.report != nil && cfg.GithubPR {
+		draft := ""
+		if cfg.GithubPRDraft {
+			draft = " (draft)"
+		}
+		fmt.Fprintf(console, "🔀 GitHub: opening a pull request%s once the run completes\n", draft)
+	}
+	fmt.Fprintln(console, "----------------------------------------")
+
+	if !preflight(agent, loop, &cfg) {
+		return 2, false
+	}
+
+	unlock, err := acquireLock(LockFile, cfg.Force)
+	if err != nil {
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		return 1, false
+	}
+	defer unlock()
+
+	flushSinks, err := attachSinks(&cfg, loop, extraSinks...)
+	if err != nil {
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		return 2, false
+	}
+	defer flushSinks()
+
+	if !cfg.NoKeys && loop.Review == nil && loop.ApprovePrompt == nil && tui == nil && !cfg.Worktree {
+		if restore := keyboardControls(loop, agent); restore != nil {
+			defer restore()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopSignals := handleSignals(loop, cancel)
+	defer signal.Stop(stopSignals)
+
+	if tui != nil {
+		if err := tui.start(cancel); err != nil {
+			fmt.Fprintf(console, "❌ Error: %v\n", err)
+			return 1, false
+		}
+		defer tui.stop()
+	}
+
+	err = loop.Run(ctx)
+	if err == nil && cfg.report != nil {
+		cfg.report.add(&cfg, loop)
+	}
+	if usage, ok := loop.Usage(); ok {
+		fmt.Fprintf(console, "💰 Run total: %s\n", usage)
+	}
+	if loop.AgentVersion != "" {
+		fmt.Fprintf(console, "🏷️  Agent version: %s\n", loop.AgentVersion)
+	}
+	if payload := loop.Payload(); len(payload) > 0 {
+		printPayload(payload)
+	}
+	code := exitCode(err)
+	if ci != nil {
+		ci.finish(code)
+	}
+	return code, errors.Is(err, context.Canceled) || errors.Is(err, ralph.ErrStopped)
+}
+
+// printPayload lists the fields the agent sent along with its stop signal.
+func printPayload(payload map[string]any) {
+	fmt.Fprintln(console, "📦 Completion payload:")
+	keys := make([]string, 0, len(payload))
+	for k := range payload {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		value, ok := payload[k].(string)
+		if !ok {
+			data, _ := json.Marshal(payload[k])
+			value = string(data)
+		}
+		fmt.Fprintf(console, "   %s: %s\n", k, value)
+	}
+}
 
-			// Failure! PERSIST the error to a file (The Ralph Way)
-			fmt.Println("❌ Verification FAILED. Writing error tail to disk...")
-			writeErrorLog(output)
+// newLoop builds the loop described by cfg and the positional arguments.
+func newLoop(cfg *Config, args []string) (*ralph.Loop, *ralph.CommandAgent, error) {
+	var rotation []rotationEntry
+	if cfg.Rotate != "" {
+		var err error
+		if rotation, err = parseRotation(cfg.Rotate); err != nil {
+			return nil, nil, fmt.Errorf("invalid --rotate: %w", err)
 		}
+		if cfg.AgentCmd != "" || len(agentChain(cfg, args)) > 1 {
+			return nil, nil, errors.New("--rotate replaces --agent-cmd and agent fallbacks; define custom agents under agents: in ralph.yaml")
+		}
+		cfg.Agent, args = rotation[0].name, nil
+	}
+	agentName, agent, err := resolveAgent(cfg, args)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		// 2. Read Base Prompt
-		instructions, err := os.ReadFile(PromptFile)
+	if len(cfg.Prompt.values) == 1 && cfg.Prompt.values[0] == "-" && cfg.PromptText == "" {
+		data, err := io.ReadAll(os.Stdin)
 		if err != nil {
-			fmt.Printf("❌ Error: %s not found.\n", PromptFile)
-			time.Sleep(2 * time.Second)
-			continue
+			return nil, nil, fmt.Errorf("reading prompt from stdin: %w", err)
+		}
+		cfg.PromptText = string(data)
+	}
+	if cfg.PromptText != "" && strings.TrimSpace(cfg.PromptText) == "" {
+		return nil, nil, errors.New("prompt is empty")
+	}
+
+	defaultPrompt := len(cfg.Prompt.values) == 1 && cfg.Prompt.values[0] == ralph.PromptFile
+	if cfg.PromptBase != "" {
+		for i, p := range cfg.Prompt.values {
+			if matches, _ := filepath.Glob(p); len(matches) == 0 && !filepath.IsAbs(p) && p != "-" {
+				cfg.Prompt.values[i] = filepath.Join(cfg.PromptBase, p)
+			}
 		}
+	}
+	issue := ""
+	if cfg.PromptFromIssue != "" {
+		fetched, err := cfg.fetchIssue()
+		if err != nil {
+			return nil, nil, fmt.Errorf("--prompt-from-issue: %w", err)
+		}
+		issue = fetched.Markdown()
+		// Without a prompt of its own, the run works on the issue alone.
+		if _, err := os.Stat(cfg.Prompt.values[0]); defaultPrompt && cfg.PromptText == "" && os.IsNotExist(err) {
+			cfg.PromptText = defaultIssuePrompt
+		}
+	}
+
+	names := map[string]bool{}
+	for i, p := range cfg.Phases {
+		switch {
+		case p.Name == "":
+			return nil, nil, fmt.Errorf("phase %d has no name", i+1)
+		case names[p.Name]:
+			return nil, nil, fmt.Errorf("phase %q is defined twice", p.Name)
+		case p.Prompt == "-":
+			return nil, nil, fmt.Errorf("phase %q cannot read its prompt from stdin", p.Name)
+		}
+		names[p.Name] = true
+		if cfg.PromptBase != "" && p.Prompt != "" {
+			if matches, _ := filepath.Glob(p.Prompt); len(matches) == 0 && !filepath.IsAbs(p.Prompt) {
+				cfg.Phases[i].Prompt = filepath.Join(cfg.PromptBase, p.Prompt)
+			}
+		}
+	}
 
-		// 3. Construct Prompt with Context
-		fullPrompt := string(instructions)
+	var stopRegex *regexp.Regexp
+	if cfg.StopRegex != "" {
+		stopRegex, err = regexp.Compile(cfg.StopRegex)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid --stop-regex: %w", err)
+		}
+	}
 
-		// Check if an error log exists from the verification step
-		if _, err := os.Stat(ErrorLogFile); err == nil {
-			errorContent, _ := os.ReadFile(ErrorLogFile)
-			// Inject the error (Feedback Loop)
-			fullPrompt = fmt.Sprintf("%s\n\n!!! PREVIOUS ATTEMPT FAILED !!!\nI have written the verification logs to '%s'.\nHere is the TAIL of the output (most relevant errors):\n```\n%s\n```\nFix this error based on the file content.", string(instructions), ErrorLogFile, string(errorContent))
+	var rateLimitPattern *regexp.Regexp
+	if cfg.RateLimitPattern != "" {
+		rateLimitPattern, err = regexp.Compile(cfg.RateLimitPattern)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid --rate-limit-regex: %w", err)
 		}
+	}
 
-		fmt.Println("\n⚡ Running Agent iteration...")
+	if cfg.Quiet && cfg.Verbose {
+		return nil, nil, errors.New("--quiet and --verbose are mutually exclusive")
+	}
+	if cfg.Quiet {
+		agent.Stream = io.Discard
+	}
+	if _, err := cfg.sandbox(); err != nil {
+		return nil, nil, err
+	}
+	redactor, err := cfg.redactor()
+	if err != nil {
+		return nil, nil, fmt.Errorf("--redact: %w", err)
+	}
+	if _, err := cfg.auditLog(); err != nil {
+		return nil, nil, fmt.Errorf("--audit: %w", err)
+	}
+	if _, err := cfg.limits(); err != nil {
+		return nil, nil, err
+	}
+	if _, err := cfg.egress(); err != nil {
+		return nil, nil, err
+	}
+	if _, _, err := cfg.session(); err != nil {
+		return nil, nil, err
+	}
+	configureAgent(agent, cfg)
+	agent.Args = append(agent.Args[:len(agent.Args):len(agent.Args)], cfg.AgentArgs...)
+	if cfg.AgentBin != "" {
+		agent.Bin = cfg.AgentBin
+	}
+	if cfg.AgentModel != "" || cfg.AgentBaseURL != "" || len(cfg.AgentHeaders) > 0 {
+		if agent.API == "" {
+			return nil, nil, fmt.Errorf("--agent-model, --agent-base-url and --agent-header need an API agent, not %s", agentName)
+		}
+		if cfg.AgentModel != "" {
+			agent.Model = cfg.AgentModel
+		}
+		if cfg.AgentBaseURL != "" {
+			agent.BaseURL = cfg.AgentBaseURL
+		}
+		agent.Headers = mergeMap(agent.Headers, cfg.AgentHeaders)
+	}
+	if (cfg.MockFixture != "" || cfg.MockDelay > 0 || cfg.MockDoneAfter > 0) && agent.Mock == nil {
+		return nil, nil, fmt.Errorf("--mock-fixture, --mock-delay and --mock-done-after need the mock agent, not %s", agentName)
+	}
+	var ignore *ralph.Ignore
+	if cfg.IgnoreFile != "" {
+		var err error
+		if ignore, err = ralph.LoadIgnore(cfg.IgnoreFile); err != nil {
+			return nil, nil, fmt.Errorf("--ignore-file: %w", err)
+		}
+	}
+	if _, err := ralph.ReadSpecs(cfg.Specs, cfg.SpecsInclude.values, cfg.SpecsExclude.values, ignore); cfg.Specs != "" && err != nil {
+		return nil, nil, fmt.Errorf("--specs: %w", err)
+	}
+	if cfg.RevertOnFail && cfg.Guard == "" {
+		return nil, nil, errors.New("--revert-on-fail needs a --guard-cmd")
+	}
+	if cfg.PostHookAbort && cfg.PostHook == "" {
+		return nil, nil, errors.New("--post-hook-abort needs a --post-hook")
+	}
+	if cfg.StopOnSignal != StopOnSignalWait && cfg.StopOnSignal != StopOnSignalImmediate {
+		return nil, nil, fmt.Errorf("invalid --stop-on-signal %q (want wait or immediate)", cfg.StopOnSignal)
+	}
+	if cfg.NoCarryover {
+		cfg.Carryover = ""
+	}
+	if cfg.Carryover != "" && cfg.Carryover != ralph.CarryoverTail && cfg.Carryover != ralph.CarryoverSummary {
+		return nil, nil, fmt.Errorf("invalid --carryover %q (want tail or summary)", cfg.Carryover)
+	}
+	if cfg.PromptOverflow != ralph.PromptOverflowRefuse && cfg.PromptOverflow != ralph.PromptOverflowTruncate {
+		return nil, nil, fmt.Errorf("invalid --prompt-overflow %q (want refuse or truncate)", cfg.PromptOverflow)
+	}
+	if cfg.PromptMaxTokens == 0 {
+		cfg.PromptMaxTokens = agent.ContextTokens
+	}
+	if cfg.PromptWarnTokens == 0 {
+		cfg.PromptWarnTokens = agent.ContextTokens / 2
+	}
+	if cfg.InjectDiff != "" && cfg.InjectDiff != ralph.InjectDiffStat && cfg.InjectDiff != ralph.InjectDiffFull {
+		return nil, nil, fmt.Errorf("invalid --inject-diff %q (want stat or full)", cfg.InjectDiff)
+	}
+	if cfg.Output != OutputText && cfg.Output != OutputJSON {
+		return nil, nil, fmt.Errorf("invalid --output %q (want text or json)", cfg.Output)
+	}
+	if cfg.CI != "" && cfg.CI != CIGitHub {
+		return nil, nil, fmt.Errorf("invalid --ci %q (want github)", cfg.CI)
+	}
 
-		// 4. Run Agent (Fresh Malloc)
-		_, err = runAgent(ctx, agent, fullPrompt)
+	if cfg.StatusMode != ralph.StatusModeOverwrite && cfg.StatusMode != ralph.StatusModeAppend {
+		return nil, nil, fmt.Errorf("invalid --status-mode %q (want overwrite or append)", cfg.StatusMode)
+	}
 
+	loop := &ralph.Loop{
+		Agent:                agent,
+		AgentName:            agentName,
+		PromptFiles:          cfg.Prompt.values,
+		PromptText:           cfg.PromptText,
+		Task:                 cfg.Task,
+		Issue:                issue,
+		Check:                cfg.Check,
+		StopSignals:          ralph.ParseStopSignals(cfg.StopSignal),
+		StopRegex:            stopRegex,
+		DoneFile:             cfg.DoneFile,
+		BlockedSignals:       ralph.ParseStopSignals(cfg.BlockedSignal),
+		StopImmediately:      cfg.StopOnSignal == StopOnSignalImmediate,
+		Validate:             cfg.Validate,
+		Guard:                cfg.Guard,
+		PromptHook:           cfg.PromptHook,
+		RevertOnFail:         cfg.RevertOnFail,
+		PreHook:              cfg.PreHook,
+		PostHook:             cfg.PostHook,
+		PostHookAbort:        cfg.PostHookAbort,
+		FeedbackLines:        cfg.FeedbackLines,
+		Carryover:            cfg.Carryover,
+		InjectDiff:           cfg.InjectDiff,
+		InjectDiffBytes:      cfg.InjectDiffBytes,
+		PromptWarnTokens:     max(cfg.PromptWarnTokens, 0),
+		PromptMaxTokens:      max(cfg.PromptMaxTokens, 0),
+		PromptOverflow:       cfg.PromptOverflow,
+		SignalFooter:         cfg.SignalFooter,
+		SpecsDir:             cfg.Specs,
+		SpecsInclude:         cfg.SpecsInclude.values,
+		SpecsExclude:         cfg.SpecsExclude.values,
+		Ignore:               ignore,
+		CarryoverBytes:       cfg.CarryoverBytes,
+		Phases:               cfg.Phases,
+		MaxIterations:        cfg.MaxIterations,
+		MaxDuration:          cfg.MaxDuration,
+		MaxCostUSD:           cfg.MaxCostUSD,
+		MaxTokens:            cfg.MaxTokens,
+		IterationTimeout:     cfg.IterationTimeout,
+		Sleep:                cfg.Sleep,
+		MaxBackoff:           cfg.MaxBackoff,
+		RateLimitWait:        cfg.RateLimitWait,
+		RateLimitPattern:     rateLimitPattern,
+		ArtifactsDir:         cfg.ArtifactsDir,
+		GitCommit:            cfg.GitCommit,
+		GitCommitOptions:     cfg.gitCommitOptions(),
+		StallAfter:           cfg.StallAfter,
+		MaxConsecutiveErrors: cfg.MaxConsecutiveErrors,
+		FallbackAfter:        cfg.FallbackAfter,
+		Log:                  console,
+		Verbose:              cfg.Verbose,
+		Redactor:             redactor,
+		NoPromptCommands:     agent.Sandbox != nil || agent.Egress != nil,
+	}
+	if cfg.AgentCmd == "" {
+		// Fallbacks follow the first agent's output redirections.
+		for _, name := range agentChain(cfg, args)[1:] {
+			fallback, err := ralph.NewAgent(name, cfg.Agents, agentStream{agent})
+			if err != nil {
+				return nil, nil, fmt.Errorf("fallback agent: %w", err)
+			}
+			configureAgent(fallback, cfg)
+			loop.Fallbacks = append(loop.Fallbacks, ralph.NamedAgent{Name: name, Agent: fallback})
+		}
+	}
+	agents := map[string]*ralph.CommandAgent{agentName: agent}
+	for _, r := range rotation {
+		rotating, ok := agents[r.name]
+		if !ok {
+			if rotating, err = ralph.NewAgent(r.name, cfg.Agents, agentStream{agent}); err != nil {
+				return nil, nil, fmt.Errorf("--rotate: %w", err)
+			}
+			configureAgent(rotating, cfg)
+			agents[r.name] = rotating
+		}
+		for range r.weight {
+			loop.Rotation = append(loop.Rotation, ralph.NamedAgent{Name: r.name, Agent: rotating})
+		}
+	}
+	if cfg.Completion != nil {
+		if loop.Detector, err = cfg.Completion.Build(); err != nil {
+			return nil, nil, fmt.Errorf("completion: %w", err)
+		}
+	}
+	if cfg.Phase != "" {
+		if err := loop.SkipToPhase(cfg.Phase); err != nil {
+			return nil, nil, fmt.Errorf("--phase: %w", err)
+		}
+	}
+	if cfg.Reviewer != "" {
+		// The reviewer's output shows up wherever the builder's goes.
+		reviewer, err := ralph.NewAgent(cfg.Reviewer, cfg.Agents, agentStream{agent})
 		if err != nil {
-			if ctx.Err() != nil {
-				return
+			return nil, nil, fmt.Errorf("--reviewer: %w", err)
+		}
+		configureAgent(reviewer, cfg)
+		loop.Reviewer = reviewer
+		loop.ReviewerName = cfg.Reviewer
+		loop.ReviewEvery = cfg.ReviewEvery
+		if cfg.ReviewPrompt != "" {
+			data, err := os.ReadFile(cfg.ReviewPrompt)
+			if err != nil {
+				return nil, nil, fmt.Errorf("--review-prompt: %w", err)
+			}
+			loop.ReviewPrompt = string(data)
+		}
+	}
+	if cfg.Verbose {
+		agent.Trace = console
+		for _, other := range append(loop.Fallbacks, loop.Rotation...) {
+			if other.Agent != ralph.Agent(agent) {
+				other.Agent.(*ralph.CommandAgent).Trace = agentTrace{agent}
 			}
-			fmt.Printf("\n⚠️ Agent process exited with error: %v\n", err)
 		}
+	}
+	return loop, agent, nil
+}
+
+// configureAgent applies the settings that hold for every agent of a run.
+func configureAgent(agent *ralph.CommandAgent, cfg *Config) {
+	agent.MaxOutputBytes = cfg.MaxOutputBytes
+	agent.PTY = cfg.PTY
+	agent.Env = mergeMap(agent.Env, cfg.AgentEnv)
+	agent.EnvFiles = mergeMap(agent.EnvFiles, cfg.AgentEnvFiles)
+	agent.Sandbox, _ = cfg.sandbox()
+	agent.Redactor, _ = cfg.redactor()
+	agent.Audit, _ = cfg.auditLog()
+	agent.Limits, _ = cfg.limits()
+	agent.Egress, _ = cfg.egress()
+	agent.Record, agent.Replay, _ = cfg.session()
+	if agent.Mock != nil {
+		mock := *agent.Mock
+		if cfg.MockFixture != "" {
+			mock.Fixture, mock.DoneAfter = cfg.MockFixture, 0
+		}
+		if cfg.MockDelay > 0 {
+			mock.Delay = cfg.MockDelay
+		}
+		if cfg.MockDoneAfter > 0 {
+			mock.DoneAfter = cfg.MockDoneAfter
+		}
+		if signals := ralph.ParseStopSignals(cfg.StopSignal); mock.Signal == "" && len(signals) > 0 {
+			mock.Signal = signals[0]
+		}
+		agent.Mock = &mock
+	}
+}
+
+// agentVersion returns what the agent prints for its VersionArgs, or "" if
+// it has none or that fails (the agent may not be installed).
+func agentVersion(agent *ralph.CommandAgent) string {
+	ctx, cancel := context.WithTimeout(context.Background(), VersionTimeout)
+	defer cancel()
+	version, err := agent.Version(ctx)
+	if err != nil && agent.Trace != nil {
+		fmt.Fprintf(agent.Trace, "🔧 Agent version unknown: %v\n", err)
+	}
+	return version
+}
+
+// agentChain returns the agents named by the positional argument or --agent,
+// in that order of precedence: the agent to use followed by its fallbacks.
+func agentChain(cfg *Config, args []string) []string {
+	list := cfg.Agent
+	if len(args) > 0 {
+		list = args[0]
+	}
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		names = []string{""}
+	}
+	return names
+}
 
-		fmt.Println("\n🔄 Iteration finished. Resting for 2 seconds...")
+// rotationEntry is one agent of --rotate and the number of consecutive
+// turns it gets.
+type rotationEntry struct {
+	name   string
+	weight int
+}
 
-		select {
-		case <-ctx.Done():
-			return
-		case <-time.After(2 * time.Second):
+// parseRotation parses a --rotate list such as "claude:2,gemini".
+func parseRotation(list string) ([]rotationEntry, error) {
+	var entries []rotationEntry
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
 			continue
 		}
+		e := rotationEntry{name: item, weight: 1}
+		if i := strings.LastIndexByte(item, ':'); i >= 0 {
+			w, err := strconv.Atoi(item[i+1:])
+			if err != nil || w < 1 {
+				return nil, fmt.Errorf("weight of %q must be a positive number", item[:i])
+			}
+			e.name, e.weight = item[:i], w
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("no agents")
+	}
+	return entries, nil
+}
+
+// mergeMap returns the entries of base overridden by those of extra,
+// without modifying either.
+func mergeMap(base, extra map[string]string) map[string]string {
+	if len(extra) == 0 {
+		return base
+	}
+	merged := make(map[string]string, len(base)+len(extra))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range extra {
+		merged[k] = v
 	}
+	return merged
 }
 
-func writeErrorLog(content string) {
-	lines := strings.Split(content, "\n")
+// envNames returns the names of the environment variables set for agent,
+// leaving out the values, which may be secrets.
+func envNames(agent *ralph.CommandAgent) []string {
+	var names []string
+	for k := range agent.Env {
+		names = append(names, k)
+	}
+	for k := range agent.EnvFiles {
+		if _, ok := agent.Env[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
 
-	var finalContent string
+// agentStream writes to whatever the agent's Stream is at the time, so that
+// a second agent follows the first one's output redirections.
+type agentStream struct{ agent *ralph.CommandAgent }
 
-	if len(lines) > MaxLogLines {
-		startIndex := len(lines) - MaxLogLines
-		tail := strings.Join(lines[startIndex:], "\n")
-		finalContent = fmt.Sprintf("... [TRUNCATED: Removed %d lines of earlier output. Showing last %d lines] ...\n%s", startIndex, MaxLogLines, tail)
-	} else {
-		finalContent = content
+func (s agentStream) Write(p []byte) (int, error) {
+	if s.agent.Stream == nil {
+		return len(p), nil
 	}
+	return s.agent.Stream.Write(p)
+}
 
-	err := os.WriteFile(ErrorLogFile, []byte(finalContent), 0644)
-	if err != nil {
-		fmt.Printf("⚠️ Failed to write error log: %v\n", err)
-	}
-}
-
-func runShellCommand(ctx context.Context, command string) (string, error) {
-	cmd := exec.CommandContext(ctx, "sh", "-c", command)
-	output, err := cmd.CombinedOutput()
-	return string(output), err
-}
-
-func runAgent(ctx context.Context, agent string, prompt string) (string, error) {
-	var cmd *exec.Cmd
-	switch agent {
-	case "claude":
-		cmd = exec.CommandContext(ctx, "claude", "-p", prompt, "--dangerously-skip-permissions")
-	case "gemini":
-		cmd = exec.CommandContext(ctx, "gemini", "--yolo")
-		cmd.Stdin = strings.NewReader(prompt)
-	case "copilot":
-		cmd = exec.CommandContext(ctx, "copilot", "-p", prompt, "--allow-all-tools")
-	case "codex":
-		cmd = exec.CommandContext(ctx, "codex", "exec", "--dangerously-bypass-approvals-and-sandbox", "-")
-		cmd.Stdin = strings.NewReader(prompt)
-	case "vibe":
-		// Mistral Vibe: Uses --prompt argument and --agent auto-approve for headless mode
-		cmd = exec.CommandContext(ctx, "vibe", "--prompt", prompt, "--agent", "auto-approve")
-	case "opencode":
-		// OpenCode: Uses run command with prompt, auto-approves by default
-		cmd = exec.CommandContext(ctx, "opencode", "run", prompt)
-	default:
-		return "", fmt.Errorf("unknown agent: %s", agent)
+// agentTrace is agentStream for the agent's Trace.
+type agentTrace struct{ agent *ralph.CommandAgent }
+
+func (t agentTrace) Write(p []byte) (int, error) {
+	if t.agent.Trace == nil {
+		return len(p), nil
+	}
+	return t.agent.Trace.Write(p)
+}
+
+// teeLog copies the loop's and the agent's output to w, without ANSI escape
+// sequences. Agent output hidden by --quiet or wrapped in JSON events is
+// logged all the same.
+func teeLog(w io.Writer, loop *ralph.Loop, agent *ralph.CommandAgent) {
+	w = ralph.NewANSIStripper(w)
+	loop.Log = io.MultiWriter(loop.Log, w)
+	agent.Stream = io.MultiWriter(agent.Stream, w)
+	if agent.Trace != nil {
+		agent.Trace = io.MultiWriter(agent.Trace, w)
+	}
+}
+
+// resolveAgent picks the agent from --agent-cmd, the positional argument or
+// --agent, in that order of precedence. Of a comma-separated fallback chain
+// it picks the first agent.
+func resolveAgent(cfg *Config, args []string) (string, *ralph.CommandAgent, error) {
+	name := agentChain(cfg, args)[0]
+	if cfg.AgentCmd != "" {
+		name = ralph.AgentName(cfg.AgentCmd)
+		if cfg.Agents == nil {
+			cfg.Agents = map[string]ralph.AgentDef{}
+		}
+		cfg.Agents[name] = ralph.AgentDef{Command: cfg.AgentCmd}
 	}
+	agent, err := ralph.NewAgent(name, cfg.Agents, os.Stdout)
+	return name, agent, err
+}
 
-	var captureBuf bytes.Buffer
-	multiWriter := io.MultiWriter(os.Stdout, &captureBuf)
-	cmd.Stdout = multiWriter
-	cmd.Stderr = multiWriter
+// handleSignals wires up interrupt handling: the first Ctrl+C lets the
+// current iteration finish before exiting, a second one (or SIGTERM) aborts
+// immediately. SIGUSR1 and SIGUSR2 pause and resume the loop. On Windows,
+// Ctrl+Break arrives as os.Interrupt too, and closing the console window as
+// SIGTERM.
+func handleSignals(loop *ralph.Loop, cancel context.CancelFunc) chan os.Signal {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	if pauseSignal != nil {
+		signal.Notify(sigs, pauseSignal, resumeSignal)
+	}
+	go func() {
+		interrupts := 0
+		for sig := range sigs {
+			switch sig {
+			case pauseSignal:
+				fmt.Fprintln(console, "\n⏸️  Pausing after the current iteration. Run `ralph resume` to continue.")
+				loop.Pause()
+				continue
+			case resumeSignal:
+				loop.Continue()
+				continue
+			}
+			interrupts++
+			if sig == os.Interrupt && interrupts == 1 {
+				fmt.Fprintln(console, "\n✋ Finishing the current iteration, then stopping. Press Ctrl+C again to abort now.")
+				loop.Stop()
+				continue
+			}
+			fmt.Fprintln(console, "\n🛑 Aborting.")
+			cancel()
+		}
+	}()
+	return sigs
+}
 
-	err := cmd.Run()
-	return captureBuf.String(), err
+// exitCode maps the result of Loop.Run to the process exit status.
+func exitCode(err error) int {
+	switch {
+	case err == nil, errors.Is(err, context.Canceled), errors.Is(err, ralph.ErrStopped):
+		return 0
+	case errors.Is(err, ralph.ErrMaxIterations):
+		return ExitMaxIterations
+	case errors.Is(err, ralph.ErrStalled):
+		return ExitStalled
+	case errors.Is(err, ralph.ErrTooManyErrors):
+		return ExitAgentErrors
+	case errors.Is(err, ralph.ErrBudgetExceeded):
+		return ExitBudget
+	case errors.Is(err, ralph.ErrDeadlineExceeded):
+		return ExitDeadline
+	case errors.Is(err, ralph.ErrBlocked):
+		return ExitBlocked
+	case errors.Is(err, ralph.ErrPromptTooLarge):
+		return ExitPromptTooLarge
+	default:
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		return 1
+	}
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"ralph/pkg/ralph"
+)
+
+func TestParseRotation(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []rotationEntry
+	}{
+		{"claude", []rotationEntry{{"claude", 1}}},
+		{"claude:2,gemini", []rotationEntry{{"claude", 2}, {"gemini", 1}}},
+		{" claude , , codex:3 ", []rotationEntry{{"claude", 1}, {"codex", 3}}},
+	}
+	for _, tt := range tests {
+		got, err := parseRotation(tt.in)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRotation(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", " , ", "claude:0", "claude:-1", "claude:x"} {
+		if _, err := parseRotation(in); err == nil {
+			t.Errorf("parseRotation(%q) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	orig := console
+	console = io.Discard
+	t.Cleanup(func() { console = orig })
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, 0},
+		{context.Canceled, 0},
+		{ralph.ErrStopped, 0},
+		{errors.New("boom"), 1},
+		{ralph.ErrMaxIterations, ExitMaxIterations},
+		{ralph.ErrStalled, ExitStalled},
+		{ralph.ErrTooManyErrors, ExitAgentErrors},
+		{ralph.ErrBudgetExceeded, ExitBudget},
+		{ralph.ErrDeadlineExceeded, ExitDeadline},
+		{ralph.ErrBlocked, ExitBlocked},
+		{fmt.Errorf("phase build: %w", ralph.ErrPromptTooLarge), ExitPromptTooLarge},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"ralph/pkg/ralph"
+)
+
+// Output formats for --output.
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// stdout is the process's standard output, taken before anything can swap
+// os.Stdout, so that the NDJSON stream of --output json always lands there.
+var stdout = os.Stdout
+
+// console is where ralph prints its messages for humans: stdout, or stderr
+// when stdout carries the NDJSON stream of --output json.
+var console io.Writer = os.Stdout
+
+// jsonOutput writes status events and live agent output to stdout as NDJSON.
+type jsonOutput struct {
+	mu        sync.Mutex
+	enc       *json.Encoder
+	loop      *ralph.Loop
+	startedAt time.Time
+}
+
+// useJSONOutput turns stdout into an NDJSON event stream. Everything ralph
+// would otherwise print for humans, including its own messages, moves to
+// stderr; the agent's output is wrapped in agent_output_chunk events. The
+// returned sink writes the loop's status events.
+func useJSONOutput(loop *ralph.Loop, agent *ralph.CommandAgent) func(ralph.StatusEvent) {
+	out := &jsonOutput{enc: json.NewEncoder(stdout), loop: loop}
+	console = os.Stderr
+	loop.Log = os.Stderr
+	if agent.Trace != nil {
+		agent.Trace = os.Stderr
+	}
+	agent.Stream = out
+	return out.event
+}
+
+func (o *jsonOutput) event(ev ralph.StatusEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if ev.StartedAt.IsZero() {
+		ev.StartedAt = o.startedAt
+	}
+	o.startedAt = ev.StartedAt
+	o.enc.Encode(ev)
+}
+
+// Write wraps a chunk of agent output in an event.
+func (o *jsonOutput) Write(p []byte) (int, error) {
+	o.event(ralph.StatusEvent{
+		Event:     ralph.EventAgentOutput,
+		Agent:     o.loop.AgentName,
+		Iteration: o.loop.Iteration(),
+		Timestamp: time.Now(),
+		PID:       os.Getpid(),
+		Chunk:     string(p),
+	})
+	return len(p), nil
+}
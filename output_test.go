@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJSONOutputTodoStream(t *testing.T) {
+	chdirRepo(t, map[string]string{
+		"PROMPT.md": "Work on the task. Print RALPH_DONE when it is complete.\n",
+		"TODO.md":   "- [ ] first\n- [ ] second\n",
+	})
+	f, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	origStdout, origConsole := stdout, console
+	stdout = f
+	t.Cleanup(func() { stdout, console = origStdout, origConsole })
+
+	argv := []string{"--todo", "TODO.md", "--output", "json", "--agent", "mock", "--mock-done-after", "1", "--no-keys", "--no-history", "--sleep", "1ms", "--status-file", ""}
+	if code := run(argv); code != 0 {
+		t.Fatalf("run = %d, want 0", code)
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var ev struct {
+			Event string `json:"event"`
+		}
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("stdout line %q is not JSON: %v", line, err)
+		}
+		if ev.Event != "agent_output_chunk" {
+			events = append(events, ev.Event)
+		}
+	}
+	want := "iteration_start iteration_end complete iteration_start iteration_end complete"
+	if got := strings.Join(events, " "); got != want {
+		t.Errorf("events = %s, want %s", got, want)
+	}
+}
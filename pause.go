@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// runPause asks the loop running in the current directory to wait after its
+// current iteration.
+func runPause(args []string) int {
+	return signalLoop(pauseSignal, "⏸️  Asked ralph (PID %d) to pause after the current iteration.\n")
+}
+
+// runResume lets a paused loop continue.
+func runResume(args []string) int {
+	return signalLoop(resumeSignal, "▶️  Asked ralph (PID %d) to resume.\n")
+}
+
+// signalLoop sends sig to the loop holding the project lock.
+func signalLoop(sig os.Signal, done string) int {
+	if sig == nil {
+		fmt.Println("❌ Error: pausing a loop is not supported on this platform")
+		return 1
+	}
+	pid := readLockPID(LockFile)
+	if pid <= 0 || !processAlive(pid) {
+		fmt.Println("❌ Error: no ralph loop is running in this directory")
+		return 1
+	}
+	p, err := os.FindProcess(pid)
+	if err == nil {
+		err = p.Signal(sig)
+	}
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
+		fmt.Printf("❌ Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf(done, pid)
+	return 0
+}
@@ -0,0 +1,619 @@
+package ralph
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Placeholders understood by agent command templates.
+const (
+	PromptPlaceholder     = "{{prompt}}"
+	PromptFilePlaceholder = "{{prompt_file}}"
+)
+
+// AgentWaitDelay bounds how long we wait for the agent's output pipes to
+// close after it has been killed (children may still be holding them).
+const AgentWaitDelay = 5 * time.Second
+
+// AgentDef describes a user-defined agent CLI.
+type AgentDef struct {
+	// Command is the command line template, e.g. "aider --yes --message {{prompt}}".
+	Command string `yaml:"command"`
+	// Bin, if set, replaces the executable named in Command, e.g. to pin
+	// one of several installed versions.
+	Bin string `yaml:"bin"`
+	// VersionArgs make the executable print its version, e.g. ["--version"].
+	// Without them the version is not recorded: running an unknown command
+	// with --version might well run the agent.
+	VersionArgs []string `yaml:"version_args"`
+	// Input selects how the prompt is passed: "arg", "stdin" or "file".
+	// When empty it is inferred from the placeholders in Command.
+	Input string `yaml:"input"`
+	// Args are extra arguments appended to Command, e.g. a model choice.
+	Args []string `yaml:"args"`
+	// Format is the agent's output format: FormatText (default) or
+	// FormatClaudeJSON.
+	Format string `yaml:"format"`
+	// CommitPattern is a regular expression matching the lines in which an
+	// agent that commits its own work reports a commit; its first group is
+	// the commit hash. The loop reports those commits instead of making its
+	// own, see Result.Commits.
+	CommitPattern string `yaml:"commit_pattern"`
+	// Env sets environment variables for the agent process only, on top of
+	// ralph's own environment.
+	Env map[string]string `yaml:"env"`
+	// API, if set, makes the agent call a model's HTTP API (APIAnthropic,
+	// APIOpenAI or APIOllama) with the prompt instead of running Command.
+	// The model only answers; it has no tools to change files with.
+	API string `yaml:"api"`
+	// Model, BaseURL (default: the vendor's, or $OLLAMA_HOST), APIKeyEnv
+	// (default ANTHROPIC_API_KEY, OPENAI_API_KEY or OLLAMA_API_KEY) and
+	// MaxTokens configure an API agent. Env and EnvFiles can provide the key.
+	Model     string `yaml:"model"`
+	BaseURL   string `yaml:"base_url"`
+	APIKeyEnv string `yaml:"api_key_env"`
+	MaxTokens int    `yaml:"max_tokens"`
+	// Headers are extra HTTP headers for an API agent, e.g. for a gateway.
+	// Values may refer to environment variables as ${NAME}.
+	Headers map[string]string `yaml:"headers"`
+	// EnvFiles sets environment variables to the contents of files, read
+	// before every run, so secrets stay off the command line and out of
+	// ralph.yaml. A trailing newline is dropped.
+	EnvFiles map[string]string `yaml:"env_files"`
+	// Mock, if set, makes the agent play back scripted responses instead of
+	// running Command, see MockAgent.
+	Mock *MockAgent `yaml:"mock"`
+	// ContextTokens is the context window of the agent's model, to check
+	// the prompt fits (0 = unknown). Update it along with Model or Args.
+	ContextTokens int `yaml:"context_tokens"`
+	// AuditHooks is how to make the agent report its tool calls to an
+	// AuditLog: AuditHooksClaude, or "" for agents whose hooks are set up
+	// by hand to run AuditLog.HookCommand.
+	AuditHooks string `yaml:"audit_hooks"`
+}
+
+// environ returns the agent process's environment, or nil to inherit
+// ralph's unchanged.
+func (d AgentDef) environ() ([]string, error) {
+	if len(d.Env) == 0 && len(d.EnvFiles) == 0 {
+		return nil, nil
+	}
+	env := os.Environ()
+	for _, k := range sortedKeys(d.Env) {
+		if k == "" || strings.Contains(k, "=") {
+			return nil, fmt.Errorf("invalid agent environment variable name %q", k)
+		}
+		env = append(env, k+"="+d.Env[k])
+	}
+	for _, k := range sortedKeys(d.EnvFiles) {
+		if k == "" || strings.Contains(k, "=") {
+			return nil, fmt.Errorf("invalid agent environment variable name %q", k)
+		}
+		data, err := os.ReadFile(d.EnvFiles[k])
+		if err != nil {
+			return nil, fmt.Errorf("agent environment variable %s: %w", k, err)
+		}
+		env = append(env, k+"="+strings.TrimRight(string(data), "\r\n"))
+	}
+	return env, nil
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// inputMode returns the effective prompt passing mode.
+func (d AgentDef) inputMode() string {
+	switch {
+	case d.Input != "":
+		return d.Input
+	case strings.Contains(d.Command, PromptFilePlaceholder):
+		return "file"
+	case strings.Contains(d.Command, PromptPlaceholder):
+		return "arg"
+	default:
+		return "stdin"
+	}
+}
+
+// command builds the agent process for prompt. The returned cleanup function
+// removes any temporary prompt file and must always be called.
+func (a *CommandAgent) command(ctx context.Context, prompt string) (*exec.Cmd, func(), error) {
+	cleanup := func() {}
+
+	args, err := splitCommand(a.Command)
+	if err != nil {
+		return nil, cleanup, err
+	}
+	if len(args) == 0 {
+		return nil, cleanup, fmt.Errorf("empty agent command")
+	}
+	if a.Bin != "" {
+		args[0] = a.Bin
+	}
+	args = append(args, a.Args...)
+	if a.Audit != nil {
+		hookArgs, err := a.Audit.hookArgs(a.AuditHooks)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		args = append(args, hookArgs...)
+	}
+
+	var stdin io.Reader
+	var promptFile string
+	switch mode := a.inputMode(); mode {
+	case "arg":
+		args = substitute(args, PromptPlaceholder, prompt)
+	case "stdin":
+		stdin = strings.NewReader(prompt)
+	case "file":
+		dir, err := a.Sandbox.promptDir()
+		if err != nil {
+			return nil, cleanup, err
+		}
+		f, err := os.CreateTemp(dir, "ralph-prompt-*.md")
+		if err != nil {
+			return nil, cleanup, err
+		}
+		cleanup = func() { _ = os.Remove(f.Name()) }
+		_, err = f.WriteString(prompt)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			cleanup()
+			return nil, func() {}, err
+		}
+		promptFile = f.Name()
+		args = substitute(args, PromptFilePlaceholder, promptFile)
+	default:
+		return nil, cleanup, fmt.Errorf("unknown agent input mode %q (want arg, stdin or file)", mode)
+	}
+
+	env, err := a.environ()
+	if err != nil {
+		cleanup()
+		return nil, func() {}, err
+	}
+	if a.Audit != nil {
+		if env == nil {
+			env = os.Environ()
+		}
+		env = append(env, a.Audit.environ()...)
+	}
+	if a.Egress != nil {
+		if env == nil {
+			env = os.Environ()
+		}
+		if env, err = a.Egress.environ(env); err != nil {
+			cleanup()
+			return nil, func() {}, err
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = stdin
+	cmd.Env = env
+	setProcessGroup(cmd)
+	if a.Sandbox != nil {
+		if err := a.Sandbox.wrap(ctx, cmd, promptFile, a.AgentDef, a.PTY); err != nil {
+			cleanup()
+			return nil, func() {}, err
+		}
+	}
+	return cmd, cleanup, nil
+}
+
+// substitute replaces placeholder in every argument, appending value as the
+// last argument when the template does not mention the placeholder at all.
+func substitute(args []string, placeholder, value string) []string {
+	found := false
+	out := make([]string, len(args))
+	for i, a := range args {
+		if strings.Contains(a, placeholder) {
+			found = true
+			a = strings.ReplaceAll(a, placeholder, value)
+		}
+		out[i] = a
+	}
+	if !found {
+		out = append(out, value)
+	}
+	return out
+}
+
+// splitCommand splits a command line into arguments using shell-like quoting
+// rules: single quotes are literal, double quotes allow backslash escapes.
+func splitCommand(s string) ([]string, error) {
+	var (
+		args    []string
+		cur     strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == '\\':
+			escaped = true
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in command %q", s)
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// AgentName derives a display name for a command template (its binary name).
+func AgentName(command string) string {
+	bin := AgentDef{Command: command}.Binary()
+	if bin == "" {
+		return "custom"
+	}
+	return filepath.Base(bin)
+}
+
+// Binary returns the executable the agent command runs, or "" if the
+// command template is malformed.
+func (d AgentDef) Binary() string {
+	if d.Bin != "" {
+		return d.Bin
+	}
+	args, err := splitCommand(d.Command)
+	if err != nil || len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
+
+// Version runs the agent executable with VersionArgs and returns the first
+// line it prints, or "" if the agent has no VersionArgs. For API agents it
+// names the API and model; for mock agents it is "mock" and for a replay
+// "replay". A sandboxed agent's version is the one in the container.
+func (a *CommandAgent) Version(ctx context.Context) (string, error) {
+	if a.API != "" {
+		return fmt.Sprintf("%s API, model %s", a.API, a.Model), nil
+	}
+	if a.Mock != nil {
+		return "mock", nil
+	}
+	if a.Replay != nil {
+		return "replay", nil
+	}
+	if len(a.VersionArgs) == 0 {
+		return "", nil
+	}
+	bin := a.Binary()
+	if bin == "" {
+		return "", fmt.Errorf("empty agent command")
+	}
+	env, err := a.environ()
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.CommandContext(ctx, bin, a.VersionArgs...)
+	cmd.Env = env
+	if a.Sandbox != nil {
+		if err := a.Sandbox.wrap(ctx, cmd, "", a.AgentDef, false); err != nil {
+			return "", err
+		}
+	}
+	cmd.WaitDelay = AgentWaitDelay
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w", bin, strings.Join(a.VersionArgs, " "), err)
+	}
+	for _, line := range strings.Split(StripANSI(string(out)), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("%s %s printed nothing", bin, strings.Join(a.VersionArgs, " "))
+}
+
+// versionFlag makes most agent CLIs print their version.
+var versionFlag = []string{"--version"}
+
+// BuiltinAgents are the agent CLIs ralph knows how to drive out of the box.
+var BuiltinAgents = map[string]AgentDef{
+	"claude":  {Command: "claude -p {{prompt}} --dangerously-skip-permissions --output-format stream-json --verbose", Format: FormatClaudeJSON, VersionArgs: versionFlag, ContextTokens: 200000, AuditHooks: AuditHooksClaude},
+	"gemini":  {Command: "gemini --yolo", Input: "stdin", VersionArgs: versionFlag, ContextTokens: 1000000},
+	"copilot": {Command: "copilot -p {{prompt}} --allow-all-tools", VersionArgs: versionFlag},
+	"codex":   {Command: "codex exec --dangerously-bypass-approvals-and-sandbox -", Input: "stdin", VersionArgs: versionFlag, ContextTokens: 400000},
+	// Aider: runs the message in the prompt file, then exits; it commits
+	// its edits itself and reports them as "Commit <hash> <message>".
+	"aider": {Command: "aider --yes-always --no-pretty --no-check-update --message-file {{prompt_file}}", CommitPattern: `(?m)^Commit ([0-9a-f]{7,40}) `, VersionArgs: versionFlag},
+	// Direct model API calls, for when the vendor CLIs cannot be installed.
+	"anthropic": {API: APIAnthropic, Model: "claude-sonnet-4-5", ContextTokens: 200000},
+	"openai":    {API: APIOpenAI, Model: "gpt-4.1", ContextTokens: 1000000},
+	// OpenRouter: an OpenAI-compatible gateway to many vendors' models
+	"openrouter": {API: APIOpenAI, BaseURL: "https://openrouter.ai/api/v1", APIKeyEnv: "OPENROUTER_API_KEY", Model: "anthropic/claude-sonnet-4.5", ContextTokens: 200000},
+	// Ollama: a local model server, for fully offline loops
+	"ollama": {API: APIOllama, Model: "qwen2.5-coder", ContextTokens: 32768},
+	// Mock: scripted responses for trying ralph out, see MockAgent
+	"mock": {Mock: &MockAgent{DoneAfter: 3}},
+	// Mistral Vibe: Uses --prompt argument and --agent auto-approve for headless mode
+	"vibe": {Command: "vibe --prompt {{prompt}} --agent auto-approve", VersionArgs: versionFlag},
+	// OpenCode: Uses run command with prompt, auto-approves by default
+	"opencode": {Command: "opencode run {{prompt}}", VersionArgs: versionFlag},
+}
+
+// CommandAgent runs an agent CLI as a subprocess.
+type CommandAgent struct {
+	AgentDef
+	// Stream receives the agent's combined output live (default: discarded).
+	Stream io.Writer
+	// Trace, if set, receives the exact command line, input mode and
+	// environment of every run.
+	Trace io.Writer
+	// PTY runs the agent with its output on a pseudo-terminal, for CLIs
+	// that buffer their output or refuse to run without a TTY.
+	PTY bool
+	// MaxOutputBytes bounds the output kept in Result.Output; only the tail
+	// is retained (default DefaultMaxOutputBytes).
+	MaxOutputBytes int
+	// Sandbox, if set, runs the agent in a container. API agents run in
+	// ralph itself and are not sandboxed.
+	Sandbox *Sandbox
+	// Limits, if set, bound the CPU, memory and scheduling priority of the
+	// agent and the processes it starts. They do not apply to API agents
+	// or in a Sandbox, which has its own limits.
+	Limits *ResourceLimits
+	// Record, if set, records every run of the agent.
+	Record *SessionRecorder
+	// Replay, if set, plays back a recording instead of running the agent.
+	Replay *SessionReplay
+	// Egress, if set, restricts the agent's outbound HTTP(S) traffic to the
+	// hosts it allows. API agents are not restricted.
+	Egress *EgressProxy
+	// Audit, if set, has the agent's hooks record its tool calls; see
+	// AuditLog.
+	Audit *AuditLog
+	// Redactor, if set, masks secrets in the output streamed, traced,
+	// recorded and returned in Result.Output.
+	Redactor *Redactor
+
+	// mockRuns counts the runs of a mock agent.
+	mockRuns int
+}
+
+// NewAgent resolves name against custom definitions first, then BuiltinAgents.
+func NewAgent(name string, custom map[string]AgentDef, stream io.Writer) (*CommandAgent, error) {
+	def, ok := custom[name]
+	if !ok {
+		def, ok = BuiltinAgents[name]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown agent: %s", name)
+	}
+	return &CommandAgent{AgentDef: def, Stream: stream}, nil
+}
+
+func (a *CommandAgent) trace(cmd *exec.Cmd) {
+	trace := a.Redactor.Writer(a.Trace)
+	defer trace.Flush()
+	quoted := make([]string, len(cmd.Args))
+	for i, arg := range cmd.Args {
+		quoted[i] = strconv.Quote(arg)
+		if len(arg) > 80 {
+			quoted[i] = fmt.Sprintf("<%d bytes>", len(arg))
+		}
+		// Variables handed to a devcontainer may be secrets.
+		if name, _, ok := strings.Cut(arg, "="); ok && i > 0 && cmd.Args[i-1] == "--remote-env" {
+			quoted[i] = strconv.Quote(name + "=…")
+		}
+	}
+	fmt.Fprintf(trace, "🔧 Exec: %s\n", strings.Join(quoted, " "))
+	fmt.Fprintf(trace, "🔧 Input: %s\n", a.inputMode())
+	fmt.Fprintf(trace, "🔧 Env: inherited (%d variables)\n", len(os.Environ()))
+	// Values read from files are likely secrets.
+	for _, k := range sortedKeys(a.Env) {
+		if _, ok := a.EnvFiles[k]; !ok {
+			fmt.Fprintf(trace, "🔧 Env: %s=%s\n", k, a.Env[k])
+		}
+	}
+	for _, k := range sortedKeys(a.EnvFiles) {
+		fmt.Fprintf(trace, "🔧 Env: %s=<contents of %s>\n", k, a.EnvFiles[k])
+	}
+	if cmd.Dir != "" {
+		fmt.Fprintf(trace, "🔧 Dir: %s\n", cmd.Dir)
+	}
+}
+
+// Run executes the agent once, streaming its output while capturing it.
+func (a *CommandAgent) Run(ctx context.Context, prompt string) (Result, error) {
+	return a.RunStreaming(ctx, prompt, nil)
+}
+
+// RunStreaming is Run, additionally passing every chunk of output to
+// onOutput (if non-nil) as it arrives.
+func (a *CommandAgent) RunStreaming(ctx context.Context, prompt string, onOutput func([]byte)) (Result, error) {
+	stream := a.Stream
+	if stream == nil {
+		stream = io.Discard
+	}
+	if a.Redactor != nil {
+		w := a.Redactor.Writer(stream)
+		defer w.Flush()
+		stream = w
+	}
+	if onOutput != nil {
+		// Listeners such as the status stream and the dashboard must not
+		// see secrets either, whether live or from a recording made
+		// without redaction.
+		out := a.Redactor.Writer(outputFunc(onOutput))
+		defer out.Flush()
+		onOutput = func(p []byte) { out.Write(p) }
+	}
+	if a.Replay != nil {
+		w := stream
+		if onOutput != nil {
+			w = io.MultiWriter(stream, outputFunc(onOutput))
+		}
+		result, err := a.runReplay(ctx, prompt, w)
+		result.Output = a.Redactor.Redact(result.Output)
+		return result, err
+	}
+	start := time.Now()
+	result, err := a.run(ctx, prompt, stream, onOutput)
+	result.Output = a.Redactor.Redact(result.Output)
+	a.recordRun(a.Redactor.Redact(prompt), start, result, err)
+	return result, err
+}
+
+// run runs the agent for RunStreaming, writing its output to stream.
+func (a *CommandAgent) run(ctx context.Context, prompt string, stream io.Writer, onOutput func([]byte)) (Result, error) {
+	maxOutput := a.MaxOutputBytes
+	if maxOutput <= 0 {
+		maxOutput = DefaultMaxOutputBytes
+	}
+	captureBuf := newRingBuffer(maxOutput)
+	writers := []io.Writer{stream, captureBuf}
+	if onOutput != nil {
+		writers = append(writers, outputFunc(onOutput))
+	}
+	multiWriter := io.MultiWriter(writers...)
+	var decoder *claudeJSONWriter
+	switch a.Format {
+	case "", FormatText:
+	case FormatClaudeJSON:
+		decoder = &claudeJSONWriter{w: multiWriter}
+		multiWriter = decoder
+	default:
+		return Result{ExitCode: -1}, fmt.Errorf("unknown agent output format %q (want %s or %s)", a.Format, FormatText, FormatClaudeJSON)
+	}
+	if a.API != "" {
+		return a.runAPI(ctx, prompt, multiWriter, captureBuf)
+	}
+	if a.Mock != nil {
+		return a.runMock(ctx, prompt, multiWriter, captureBuf)
+	}
+
+	cmd, cleanup, err := a.command(ctx, prompt)
+	defer cleanup()
+	if err != nil {
+		return Result{ExitCode: -1}, err
+	}
+	var limited *limitedRun
+	if a.Limits != nil && a.Sandbox == nil {
+		if limited, err = a.Limits.apply(cmd); err != nil {
+			return Result{ExitCode: -1}, fmt.Errorf("resource limits: %w", err)
+		}
+	}
+	if a.Trace != nil {
+		a.trace(cmd)
+	}
+	cmd.WaitDelay = AgentWaitDelay
+	if a.PTY {
+		err = runWithPTY(cmd, multiWriter)
+	} else {
+		cmd.Stdout = multiWriter
+		cmd.Stderr = multiWriter
+		err = cmd.Run()
+	}
+	exitCode := -1
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+	oomKilled := limited.close()
+	var usage *Usage
+	if decoder != nil {
+		usage = decoder.close()
+	}
+	// Colors stay in the live stream but would defeat stop-signal matching
+	// and clutter transcripts.
+	output := StripANSI(captureBuf.String())
+	commits, perr := a.commits(output)
+	if err == nil {
+		err = perr
+	}
+	return Result{Output: output, ExitCode: exitCode, Usage: usage, Commits: commits, OOMKilled: oomKilled}, err
+}
+
+// commits returns the hashes of the commits output reports, per
+// CommitPattern.
+func (d AgentDef) commits(output string) ([]string, error) {
+	if d.CommitPattern == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(d.CommitPattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid agent commit_pattern: %w", err)
+	}
+	var hashes []string
+	for _, m := range re.FindAllStringSubmatch(output, -1) {
+		if len(m) > 1 && m[1] != "" {
+			hashes = append(hashes, m[1])
+		}
+	}
+	return hashes, nil
+}
+
+// runAPI is RunStreaming for API agents. ExitCode is 0 on success and 1 on
+// failure.
+func (a *CommandAgent) runAPI(ctx context.Context, prompt string, w io.Writer, capture *ringBuffer) (Result, error) {
+	usage, err := a.callAPI(ctx, prompt, w, a.Trace)
+	exitCode := 0
+	if err != nil {
+		exitCode = 1
+	}
+	return Result{Output: StripANSI(capture.String()), ExitCode: exitCode, Usage: usage}, err
+}
+
+// outputFunc adapts an output callback to io.Writer.
+type outputFunc func([]byte)
+
+func (f outputFunc) Write(p []byte) (int, error) {
+	f(p)
+	return len(p), nil
+}
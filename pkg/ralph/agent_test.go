@@ -0,0 +1,38 @@
+package ralph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"claude", []string{"claude"}},
+		{"  claude  -p\t{{prompt}}\n", []string{"claude", "-p", "{{prompt}}"}},
+		{`aider --message 'fix the bug'`, []string{"aider", "--message", "fix the bug"}},
+		{`echo "a \"quoted\" word"`, []string{"echo", `a "quoted" word`}},
+		{`echo 'single \ backslash'`, []string{"echo", `single \ backslash`}},
+		{`echo a\ b`, []string{"echo", "a b"}},
+		{`echo "" ''`, []string{"echo", "", ""}},
+		{`x"y"'z'`, []string{"xyz"}},
+	}
+	for _, tt := range tests {
+		got, err := splitCommand(tt.in)
+		if err != nil {
+			t.Errorf("splitCommand(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCommand(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	for _, in := range []string{`echo 'open`, `echo "open`, `echo trailing\`} {
+		if _, err := splitCommand(in); err == nil {
+			t.Errorf("splitCommand(%q) succeeded, want an error", in)
+		}
+	}
+}
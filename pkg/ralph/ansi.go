@@ -0,0 +1,87 @@
+package ralph
+
+import "io"
+
+// StripANSI removes ANSI escape sequences (colors, cursor movement, window
+// titles) from s.
+func StripANSI(s string) string {
+	var st ansiState
+	return string(st.strip(nil, []byte(s)))
+}
+
+// NewANSIStripper returns a writer that forwards to w with ANSI escape
+// sequences removed. Sequences split across writes are handled.
+func NewANSIStripper(w io.Writer) io.Writer {
+	return &ansiStripper{w: w}
+}
+
+type ansiStripper struct {
+	w  io.Writer
+	st ansiState
+}
+
+func (a *ansiStripper) Write(p []byte) (int, error) {
+	if out := a.st.strip(nil, p); len(out) > 0 {
+		if _, err := a.w.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// ansiState is the position of a small ECMA-48 parser between inputs.
+type ansiState int
+
+const (
+	ansiText   ansiState = iota
+	ansiEscape           // after ESC
+	ansiCSI              // inside ESC [ ... final byte
+	ansiString           // inside ESC ] / P / X / ^ / _ ... terminated by BEL or ST
+	ansiStrEsc           // ESC seen inside a string, expecting \
+)
+
+// strip appends the printable parts of p to dst.
+func (st *ansiState) strip(dst, p []byte) []byte {
+	for _, c := range p {
+		switch *st {
+		case ansiText:
+			if c == 0x1b {
+				*st = ansiEscape
+				continue
+			}
+			dst = append(dst, c)
+		case ansiEscape:
+			switch c {
+			case '[':
+				*st = ansiCSI
+			case ']', 'P', 'X', '^', '_':
+				*st = ansiString
+			default:
+				// Two-byte sequence, or an intermediate byte followed by
+				// one more; either way the next final byte ends it.
+				if c >= 0x20 && c <= 0x2f {
+					continue
+				}
+				*st = ansiText
+			}
+		case ansiCSI:
+			if c >= 0x40 && c <= 0x7e {
+				*st = ansiText
+			}
+		case ansiString:
+			switch c {
+			case 0x07:
+				*st = ansiText
+			case 0x1b:
+				*st = ansiStrEsc
+			}
+		case ansiStrEsc:
+			if c == '\\' {
+				*st = ansiText
+			} else {
+				*st = ansiString
+			}
+		}
+	}
+	return dst
+}
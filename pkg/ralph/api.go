@@ -0,0 +1,280 @@
+package ralph
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIHistory is how many events, agent output chunks included, the API
+// replays to a newly connected event stream.
+const APIHistory = 2000
+
+// MaxPromptBytes bounds the prompt accepted by POST /prompt.
+const MaxPromptBytes = 1 << 20
+
+// apiSubscriberBuffer is how far an event stream may fall behind before it
+// is disconnected.
+const apiSubscriberBuffer = 256
+
+// API is an HTTP interface for driving a running loop:
+//
+//	GET  /status  the loop's current state as JSON
+//	GET  /events  status events and agent output as server-sent events
+//	POST /pause   pause after the current iteration
+//	POST /resume  resume a paused loop
+//	POST /stop    stop after the current iteration
+//	POST /prompt  replace the prompt from the next iteration on
+//
+// Every request needs the token. POST requests must present it as a bearer
+// token and carry a JSON body, so that a web page cannot forge them; GET
+// requests may pass it as a token query parameter instead. Cross-origin
+// requests are refused.
+//
+// Feed it from Loop.OnEvent via Observe and from the agent's Stream via
+// Write.
+type API struct {
+	Loop *Loop
+	// Token is required on every request. Without one the API refuses
+	// everything.
+	Token string
+
+	mu      sync.Mutex
+	history [][]byte
+	last    *StatusEvent
+	subs    map[chan []byte]struct{}
+	closed  bool
+}
+
+// APIPrompt is the body of POST /prompt.
+type APIPrompt struct {
+	Prompt string `json:"prompt"`
+}
+
+// APIStatus is the body of GET /status.
+type APIStatus struct {
+	Agent     string    `json:"agent"`
+	Iteration int       `json:"iteration"`
+	Paused    bool      `json:"paused"`
+	Finished  bool      `json:"finished"`
+	StartedAt time.Time `json:"started_at,omitempty"`
+	// LastEvent is the most recent status event, agent output aside.
+	LastEvent *StatusEvent `json:"last_event,omitempty"`
+}
+
+// Observe records ev and forwards it to every connected event stream.
+func (a *API) Observe(ev StatusEvent) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return
+	}
+	if ev.Event != EventAgentOutput {
+		a.last = &ev
+	}
+	a.history = append(a.history, data)
+	if len(a.history) > APIHistory {
+		a.history = a.history[len(a.history)-APIHistory:]
+	}
+	for ch := range a.subs {
+		select {
+		case ch <- data:
+		default:
+			// Too slow; the client reconnects and gets the history again.
+			delete(a.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Write publishes a chunk of agent output as an agent_output_chunk event.
+func (a *API) Write(p []byte) (int, error) {
+	a.Observe(StatusEvent{
+		Event:     EventAgentOutput,
+		Agent:     a.Loop.AgentName,
+		Iteration: a.Loop.Iteration(),
+		Timestamp: time.Now(),
+		PID:       os.Getpid(),
+		Chunk:     StripANSI(string(p)),
+	})
+	return len(p), nil
+}
+
+// Close disconnects every event stream so that the server can shut down.
+func (a *API) Close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closed = true
+	for ch := range a.subs {
+		close(ch)
+	}
+	a.subs = nil
+}
+
+// ServeHTTP routes the API's endpoints.
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin request refused", http.StatusForbidden)
+		return
+	}
+	if !a.authorized(r) {
+		http.Error(w, "missing or invalid token", http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path == "/status" || r.URL.Path == "/events" {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+	} else if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	} else if !isJSON(r) {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/status":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.status())
+		return
+	case "/events":
+		a.serveEvents(w, r)
+		return
+	case "/pause":
+		a.Loop.Pause()
+	case "/resume":
+		a.Loop.Continue()
+	case "/stop":
+		a.Loop.Stop()
+	case "/prompt":
+		var body APIPrompt
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxPromptBytes)).Decode(&body); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			} else {
+				http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		a.Loop.SetPrompt(body.Prompt)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) status() APIStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	st := APIStatus{
+		Agent:     a.Loop.AgentName,
+		Iteration: a.Loop.Iteration(),
+		Paused:    a.Loop.Paused(),
+		LastEvent: a.last,
+	}
+	if a.last != nil {
+		st.StartedAt = a.last.StartedAt
+		st.Finished = a.last.Terminal()
+	}
+	return st
+}
+
+func (a *API) authorized(r *http.Request) bool {
+	if a.Token == "" {
+		return false
+	}
+	var token string
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	} else if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		// EventSource and plain links cannot set headers.
+		token = r.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) == 1
+}
+
+// sameOrigin reports whether r, if it comes from a browser, comes from a
+// page served by this host.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// isJSON reports whether r declares a JSON body. Browsers cannot send one
+// cross-origin without a preflight, which the API never answers.
+func isJSON(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// serveEvents streams the event history followed by live events.
+func (a *API) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := make(chan []byte, apiSubscriberBuffer)
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		http.Error(w, "loop finished", http.StatusServiceUnavailable)
+		return
+	}
+	history := append([][]byte(nil), a.history...)
+	if a.subs == nil {
+		a.subs = map[chan []byte]struct{}{}
+	}
+	a.subs[ch] = struct{}{}
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		if _, ok := a.subs[ch]; ok {
+			delete(a.subs, ch)
+			close(ch)
+		}
+		a.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	for _, data := range history {
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data, ok := <-ch:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package ralph
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		method  string
+		target  string
+		header  map[string]string
+		body    string
+		want    int
+		prompt  string
+		stopped bool
+	}{
+		{name: "no token configured", method: "GET", target: "/status", header: map[string]string{"Authorization": "Bearer "}, want: http.StatusUnauthorized},
+		{name: "status with query token", token: "t", method: "GET", target: "/status?token=t", want: http.StatusOK},
+		{name: "status without token", token: "t", method: "GET", target: "/status", want: http.StatusUnauthorized},
+		{name: "post with query token", token: "t", method: "POST", target: "/stop?token=t", header: map[string]string{"Content-Type": "application/json"}, want: http.StatusUnauthorized},
+		{name: "post without json", token: "t", method: "POST", target: "/stop", header: map[string]string{"Authorization": "Bearer t", "Content-Type": "text/plain"}, want: http.StatusUnsupportedMediaType},
+		{name: "cross origin", token: "t", method: "POST", target: "/stop", header: map[string]string{"Authorization": "Bearer t", "Content-Type": "application/json", "Origin": "http://evil.example"}, want: http.StatusForbidden},
+		{name: "stop", token: "t", method: "POST", target: "/stop", header: map[string]string{"Authorization": "Bearer t", "Content-Type": "application/json", "Origin": "http://example.com"}, want: http.StatusNoContent, stopped: true},
+		{name: "prompt", token: "t", method: "POST", target: "/prompt", header: map[string]string{"Authorization": "Bearer t", "Content-Type": "application/json; charset=utf-8"}, body: `{"prompt":"new"}`, want: http.StatusNoContent, prompt: "new"},
+		{name: "prompt not json", token: "t", method: "POST", target: "/prompt", header: map[string]string{"Authorization": "Bearer t", "Content-Type": "application/json"}, body: `{{shell "id"}}`, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loop := &Loop{}
+			api := &API{Loop: loop, Token: tt.token}
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			api.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d (%s), want %d", w.Code, strings.TrimSpace(w.Body.String()), tt.want)
+			}
+			if loop.takePromptUpdate() != (tt.prompt != "") || loop.promptOverride != tt.prompt {
+				t.Errorf("prompt = %q, want %q", loop.promptOverride, tt.prompt)
+			}
+			if loop.stopRequested() != tt.stopped {
+				t.Errorf("stopped = %v, want %v", loop.stopRequested(), tt.stopped)
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package ralph
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Model APIs an agent can call instead of running a CLI, see AgentDef.API.
+const (
+	// APIAnthropic is the Anthropic Messages API.
+	APIAnthropic = "anthropic"
+	// APIOpenAI is the OpenAI Chat Completions API, which many gateways and
+	// local model servers implement too.
+	APIOpenAI = "openai"
+	// APIOllama is a local Ollama server's chat API, whose reply is
+	// streamed as it is generated.
+	APIOllama = "ollama"
+)
+
+// Defaults for API agents.
+const (
+	DefaultAnthropicBaseURL = "https://api.anthropic.com"
+	DefaultOpenAIBaseURL    = "https://api.openai.com/v1"
+	// DefaultOllamaBaseURL is used when OLLAMA_HOST is not set either.
+	DefaultOllamaBaseURL = "http://localhost:11434"
+	// DefaultAPIMaxTokens bounds the reply of an Anthropic agent, for which
+	// the limit is mandatory.
+	DefaultAPIMaxTokens = 8192
+)
+
+// anthropicVersion is the Messages API version ralph speaks.
+const anthropicVersion = "2023-06-01"
+
+// maxAPIErrorBytes bounds the response body quoted in an API error.
+const maxAPIErrorBytes = 2000
+
+// baseURL returns the API's base URL, without a trailing slash.
+func (d AgentDef) baseURL() string {
+	base := d.BaseURL
+	switch {
+	case base != "":
+	case d.API == APIOpenAI:
+		base = DefaultOpenAIBaseURL
+	case d.API == APIOllama:
+		// Ollama's own variable, often a bare host:port.
+		base = os.Getenv("OLLAMA_HOST")
+		if base == "" {
+			base = DefaultOllamaBaseURL
+		} else if !strings.Contains(base, "://") {
+			base = "http://" + base
+		}
+	default:
+		base = DefaultAnthropicBaseURL
+	}
+	return strings.TrimRight(base, "/")
+}
+
+// apiKeyEnv returns the environment variable holding the API key.
+func (d AgentDef) apiKeyEnv() string {
+	switch {
+	case d.APIKeyEnv != "":
+		return d.APIKeyEnv
+	case d.API == APIOpenAI:
+		return "OPENAI_API_KEY"
+	case d.API == APIOllama:
+		return "OLLAMA_API_KEY"
+	default:
+		return "ANTHROPIC_API_KEY"
+	}
+}
+
+// getenv looks up name in the agent's environment.
+func (d AgentDef) getenv(name string) (string, error) {
+	env, err := d.environ()
+	if err != nil {
+		return "", err
+	}
+	if env == nil {
+		return os.Getenv(name), nil
+	}
+	for i := len(env) - 1; i >= 0; i-- {
+		if v, ok := strings.CutPrefix(env[i], name+"="); ok {
+			return v, nil
+		}
+	}
+	return "", nil
+}
+
+// apiKey looks up the API key in the agent's environment. A missing key is
+// an error for the vendor's own endpoint and when APIKeyEnv names the
+// variable; other gateways and local servers often need none.
+func (d AgentDef) apiKey() (string, error) {
+	name := d.apiKeyEnv()
+	key, err := d.getenv(name)
+	if err != nil {
+		return "", err
+	}
+	if key == "" && ((d.BaseURL == "" && d.API != APIOllama) || d.APIKeyEnv != "") {
+		return "", fmt.Errorf("%s is not set", name)
+	}
+	return key, nil
+}
+
+// headers returns Headers with ${VAR} references replaced by variables of
+// the agent's environment, so that secrets can stay out of ralph.yaml.
+func (d AgentDef) headers() (http.Header, error) {
+	h := http.Header{}
+	var err error
+	for _, name := range sortedKeys(d.Headers) {
+		h.Set(name, os.Expand(d.Headers[name], func(v string) string {
+			value, gerr := d.getenv(v)
+			if gerr != nil && err == nil {
+				err = gerr
+			}
+			return value
+		}))
+	}
+	return h, err
+}
+
+// CheckAPI reports where an API agent sends its requests, or why it cannot.
+func (d AgentDef) CheckAPI() (string, error) {
+	if _, err := d.apiKey(); err != nil {
+		return "", err
+	}
+	switch d.API {
+	case APIAnthropic, APIOpenAI, APIOllama:
+	default:
+		return "", fmt.Errorf("unknown agent API %q (want %s, %s or %s)", d.API, APIAnthropic, APIOpenAI, APIOllama)
+	}
+	if d.Model == "" {
+		return "", fmt.Errorf("%s agent has no model", d.API)
+	}
+	return d.baseURL(), nil
+}
+
+// callAPI sends prompt to the agent's model API as a single user message
+// and writes the reply to w. The model gets no tools: it can only answer.
+func (d AgentDef) callAPI(ctx context.Context, prompt string, w io.Writer, trace io.Writer) (*Usage, error) {
+	if _, err := d.CheckAPI(); err != nil {
+		return nil, err
+	}
+	key, _ := d.apiKey()
+	header, err := d.headers()
+	if err != nil {
+		return nil, err
+	}
+	header.Set("Content-Type", "application/json")
+
+	var url string
+	var body any
+	messages := []map[string]string{{"role": "user", "content": prompt}}
+	switch d.API {
+	case APIAnthropic:
+		url = d.baseURL() + "/v1/messages"
+		maxTokens := d.MaxTokens
+		if maxTokens <= 0 {
+			maxTokens = DefaultAPIMaxTokens
+		}
+		body = map[string]any{"model": d.Model, "max_tokens": maxTokens, "messages": messages}
+		header.Set("anthropic-version", anthropicVersion)
+		if key != "" {
+			header.Set("x-api-key", key)
+		}
+	case APIOpenAI:
+		url = d.baseURL() + "/chat/completions"
+		req := map[string]any{"model": d.Model, "messages": messages}
+		if d.MaxTokens > 0 {
+			req["max_tokens"] = d.MaxTokens
+		}
+		body = req
+		if key != "" {
+			header.Set("Authorization", "Bearer "+key)
+		}
+	case APIOllama:
+		url = d.baseURL() + "/api/chat"
+		req := map[string]any{"model": d.Model, "messages": messages, "stream": true}
+		if d.MaxTokens > 0 {
+			req["options"] = map[string]int{"num_predict": d.MaxTokens}
+		}
+		body = req
+		if key != "" {
+			header.Set("Authorization", "Bearer "+key)
+		}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	if trace != nil {
+		fmt.Fprintf(trace, "🔧 POST %s (model %s, %d bytes)\n", url, d.Model, len(data))
+		if len(d.Headers) > 0 {
+			fmt.Fprintf(trace, "🔧 Headers: %s\n", strings.Join(sortedKeys(d.Headers), ", "))
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// The status and body go to the output so that RateLimitPattern
+		// can recognize rate limits.
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		msg := fmt.Sprintf("%s API: %s: %s", d.API, resp.Status, strings.TrimSpace(tail(string(respBody), maxAPIErrorBytes)))
+		io.WriteString(w, msg+"\n")
+		return nil, fmt.Errorf("%s API: %s", d.API, resp.Status)
+	}
+	if d.API == APIOllama {
+		return readOllamaStream(resp.Body, w)
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var text string
+	var usage Usage
+	switch d.API {
+	case APIAnthropic:
+		var msg struct {
+			Content []struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"content"`
+			Usage struct {
+				InputTokens              int `json:"input_tokens"`
+				OutputTokens             int `json:"output_tokens"`
+				CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+				CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal(respBody, &msg); err != nil {
+			return nil, fmt.Errorf("%s API: decode response: %w", d.API, err)
+		}
+		var parts []string
+		for _, block := range msg.Content {
+			if block.Type == "text" {
+				parts = append(parts, block.Text)
+			}
+		}
+		text = strings.Join(parts, "\n")
+		usage = Usage{
+			InputTokens:         msg.Usage.InputTokens,
+			OutputTokens:        msg.Usage.OutputTokens,
+			CacheReadTokens:     msg.Usage.CacheReadInputTokens,
+			CacheCreationTokens: msg.Usage.CacheCreationInputTokens,
+			Turns:               1,
+		}
+	case APIOpenAI:
+		var completion struct {
+			Choices []struct {
+				Message struct {
+					Content string `json:"content"`
+				} `json:"message"`
+			} `json:"choices"`
+			Usage struct {
+				PromptTokens        int `json:"prompt_tokens"`
+				CompletionTokens    int `json:"completion_tokens"`
+				PromptTokensDetails struct {
+					CachedTokens int `json:"cached_tokens"`
+				} `json:"prompt_tokens_details"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal(respBody, &completion); err != nil {
+			return nil, fmt.Errorf("%s API: decode response: %w", d.API, err)
+		}
+		if len(completion.Choices) > 0 {
+			text = completion.Choices[0].Message.Content
+		}
+		cached := completion.Usage.PromptTokensDetails.CachedTokens
+		usage = Usage{
+			InputTokens:     completion.Usage.PromptTokens - cached,
+			OutputTokens:    completion.Usage.CompletionTokens,
+			CacheReadTokens: cached,
+			Turns:           1,
+		}
+	}
+	if text = strings.TrimSpace(text); text != "" {
+		io.WriteString(w, text+"\n")
+	}
+	return &usage, nil
+}
+
+// readOllamaStream copies the text of an Ollama chat stream to w as it
+// arrives and returns the token counts of its final message.
+func readOllamaStream(r io.Reader, w io.Writer) (*Usage, error) {
+	dec := json.NewDecoder(r)
+	wrote := false
+	for {
+		var chunk struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+			Done            bool   `json:"done"`
+			Error           string `json:"error"`
+			PromptEvalCount int    `json:"prompt_eval_count"`
+			EvalCount       int    `json:"eval_count"`
+		}
+		if err := dec.Decode(&chunk); err != nil {
+			if wrote {
+				io.WriteString(w, "\n")
+			}
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("%s API: read stream: %w", APIOllama, err)
+		}
+		if chunk.Error != "" {
+			io.WriteString(w, fmt.Sprintf("%s API: %s\n", APIOllama, chunk.Error))
+			return nil, fmt.Errorf("%s API: %s", APIOllama, chunk.Error)
+		}
+		if chunk.Message.Content != "" {
+			io.WriteString(w, chunk.Message.Content)
+			wrote = true
+		}
+		if chunk.Done {
+			if wrote {
+				io.WriteString(w, "\n")
+			}
+			return &Usage{InputTokens: chunk.PromptEvalCount, OutputTokens: chunk.EvalCount, Turns: 1}, nil
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package ralph
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// IterationMeta is saved as meta.json next to each iteration's transcript.
+type IterationMeta struct {
+	Iteration    int       `json:"iteration"`
+	Agent        string    `json:"agent"`
+	AgentVersion string    `json:"agent_version,omitempty"`
+	StartedAt    time.Time `json:"started_at"`
+	DurationMS   int64     `json:"duration_ms"`
+	ExitCode     int       `json:"exit_code"`
+	TimedOut     bool      `json:"timed_out,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// iterationDir returns the artifacts directory for the current iteration.
+func (l *Loop) iterationDir() string {
+	return filepath.Join(l.ArtifactsDir, fmt.Sprintf("iter-%04d", l.iteration))
+}
+
+// saveArtifacts writes the rendered prompt, the full agent output and the
+// run metadata for the current iteration. Failures are logged, not fatal.
+func (l *Loop) saveArtifacts(prompt string, result Result, meta IterationMeta) {
+	dir := l.iterationDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		l.logf("⚠️ Failed to save artifacts: %v\n", err)
+		return
+	}
+
+	metaJSON, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		l.logf("⚠️ Failed to save artifacts: %v\n", err)
+		return
+	}
+
+	for name, data := range map[string][]byte{
+		"prompt.md":  []byte(l.Redactor.Redact(prompt)),
+		"output.txt": []byte(l.Redactor.Redact(result.Output)),
+		"meta.json":  append(metaJSON, '\n'),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			l.logf("⚠️ Failed to save artifacts: %v\n", err)
+			return
+		}
+	}
+}
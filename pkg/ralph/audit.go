@@ -0,0 +1,173 @@
+package ralph
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment variables with which an agent's hooks find the audit log and
+// tie a tool call to the run and iteration, on top of RALPH_ITERATION and
+// RALPH_AGENT.
+const (
+	AuditFileEnv = "RALPH_AUDIT_FILE"
+	AuditRunEnv  = "RALPH_RUN"
+)
+
+// AuditHooksClaude makes AgentDef.AuditHooks pass hooks to Claude Code with
+// --settings.
+const AuditHooksClaude = "claude"
+
+// AuditEntry is a tool call of an agent, as recorded in an AuditLog.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Run is when the run the call belongs to started.
+	Run       time.Time `json:"run"`
+	Iteration int       `json:"iteration"`
+	Agent     string    `json:"agent,omitempty"`
+	Session   string    `json:"session,omitempty"`
+	Cwd       string    `json:"cwd,omitempty"`
+	Tool      string    `json:"tool"`
+	// Input holds the tool's arguments as the agent passed them, e.g. the
+	// command of a shell tool.
+	Input json.RawMessage `json:"input,omitempty"`
+}
+
+// Summary is the gist of the call's input: the command, path, pattern or
+// URL it acts on, or the whole input.
+func (e AuditEntry) Summary() string {
+	var input map[string]any
+	if json.Unmarshal(e.Input, &input) == nil {
+		for _, key := range []string{"command", "file_path", "path", "notebook_path", "pattern", "url", "query", "prompt"} {
+			if s, ok := input[key].(string); ok && s != "" {
+				return s
+			}
+		}
+	}
+	return string(e.Input)
+}
+
+// AuditLog hands an agent what its hooks need to append its tool calls to
+// a JSON lines file: see AgentDef.AuditHooks and RecordToolCall. Use its
+// Observe as (part of) Loop.OnEvent to tie them to the iteration.
+type AuditLog struct {
+	// Path is the file, absolute so that the hooks find it wherever they
+	// run.
+	Path string
+	// HookCommand is the command the agent's hooks run, which reads the
+	// hook's JSON input and calls RecordToolCall, e.g. `ralph audit record`.
+	HookCommand string
+
+	mu        sync.Mutex
+	run       time.Time
+	iteration int
+	agent     string
+}
+
+// Observe follows the run's iterations.
+func (a *AuditLog) Observe(ev StatusEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.run, a.iteration, a.agent = ev.StartedAt, ev.Iteration, ev.Agent
+}
+
+// environ returns the variables to set for the agent's hooks.
+func (a *AuditLog) environ() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return []string{
+		AuditFileEnv + "=" + a.Path,
+		AuditRunEnv + "=" + a.run.Format(time.RFC3339Nano),
+		HookIterationEnv + "=" + strconv.Itoa(a.iteration),
+		HookAgentEnv + "=" + a.agent,
+	}
+}
+
+// hookArgs returns the arguments that make an agent of the given
+// AuditHooks kind run HookCommand before every tool call.
+func (a *AuditLog) hookArgs(kind string) ([]string, error) {
+	switch kind {
+	case "":
+		return nil, nil
+	case AuditHooksClaude:
+		hook := map[string]any{"type": "command", "command": a.HookCommand}
+		settings := map[string]any{"hooks": map[string]any{
+			"PreToolUse": []any{map[string]any{"matcher": "*", "hooks": []any{hook}}},
+		}}
+		data, err := json.Marshal(settings)
+		if err != nil {
+			return nil, err
+		}
+		return []string{"--settings", string(data)}, nil
+	}
+	return nil, fmt.Errorf("unknown agent audit hooks %q (want %s)", kind, AuditHooksClaude)
+}
+
+// RecordToolCall appends the tool call described by input, the JSON a
+// Claude Code PreToolUse hook (or one of another agent with the same
+// session_id, cwd, tool_name and tool_input fields) reads on stdin, to the
+// audit log named by getenv(AuditFileEnv).
+func RecordToolCall(input []byte, getenv func(string) string) error {
+	path := getenv(AuditFileEnv)
+	if path == "" {
+		return fmt.Errorf("%s is not set", AuditFileEnv)
+	}
+	var hook struct {
+		SessionID string          `json:"session_id"`
+		Cwd       string          `json:"cwd"`
+		ToolName  string          `json:"tool_name"`
+		ToolInput json.RawMessage `json:"tool_input"`
+	}
+	if err := json.Unmarshal(input, &hook); err != nil {
+		return fmt.Errorf("bad hook input: %w", err)
+	}
+	entry := AuditEntry{
+		Time:    time.Now().UTC(),
+		Agent:   getenv(HookAgentEnv),
+		Session: hook.SessionID,
+		Cwd:     hook.Cwd,
+		Tool:    hook.ToolName,
+		Input:   hook.ToolInput,
+	}
+	entry.Run, _ = time.Parse(time.RFC3339Nano, getenv(AuditRunEnv))
+	entry.Iteration, _ = strconv.Atoi(getenv(HookIterationEnv))
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// A single write of a line is atomic enough for concurrent hooks.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadAudit returns the entries of an audit log in the order they were
+// recorded, skipping lines that do not parse.
+func ReadAudit(path string) ([]AuditEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []AuditEntry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 16<<20)
+	for sc.Scan() {
+		var e AuditEntry
+		if line := strings.TrimSpace(sc.Text()); line != "" && json.Unmarshal([]byte(line), &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, sc.Err()
+}
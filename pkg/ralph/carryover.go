@@ -0,0 +1,62 @@
+package ralph
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Carryover modes: how much of an iteration's output reaches the next
+// iteration's prompt.
+const (
+	// CarryoverTail carries over the end of the output.
+	CarryoverTail = "tail"
+	// CarryoverSummary has the agent condense the output first, at the cost
+	// of an extra agent run per iteration.
+	CarryoverSummary = "summary"
+)
+
+// DefaultCarryoverBytes bounds the carried-over text.
+const DefaultCarryoverBytes = 4000
+
+// maxSummaryInputBytes bounds the output handed to the summarizing run.
+const maxSummaryInputBytes = 100_000
+
+// carryoverSummaryPrompt asks the agent to condense its previous output.
+const carryoverSummaryPrompt = `Below is the output of your previous work session on this task.
+Do not modify any files. Summarize it for your next session in at most %d
+characters: what was done, what was learned, what failed and what is left to
+do. Reply with the summary only.`
+
+// carryover returns the text to carry over from the previous iteration's
+// output, or "" if there is none.
+func (l *Loop) carryover(ctx context.Context) string {
+	if l.Carryover == "" || strings.TrimSpace(l.previousOutput) == "" {
+		return ""
+	}
+	output := strings.TrimSpace(StripANSI(l.previousOutput))
+	if l.Carryover == CarryoverSummary {
+		l.logf("\n🧵 Summarizing iteration %d for the next one...\n", l.iteration)
+		prompt := fmt.Sprintf(carryoverSummaryPrompt, l.CarryoverBytes) + "\n\n```\n" + tail(output, maxSummaryInputBytes) + "\n```\n"
+		result, err := l.Agent.Run(ctx, prompt)
+		l.addUsage(result.Usage)
+		switch {
+		case ctx.Err() != nil:
+			return ""
+		case err != nil || strings.TrimSpace(result.Output) == "":
+			l.logf("⚠️ Summary failed, carrying over the end of the output instead.\n")
+		default:
+			output = strings.TrimSpace(StripANSI(result.Output))
+		}
+	}
+	return tail(output, l.CarryoverBytes)
+}
+
+// carryoverSection formats carried-over text for the prompt.
+func (l *Loop) carryoverSection(text string) string {
+	intro := "The end of your output in the previous iteration:"
+	if l.Carryover == CarryoverSummary {
+		intro = "A summary of your previous iteration:"
+	}
+	return fmt.Sprintf("## Previous iteration\n\n%s\n```\n%s\n```", intro, text)
+}
@@ -0,0 +1,118 @@
+package ralph
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Dashboard serves a small web UI for a running loop on top of its API: live
+// agent output and status events, an iteration timeline and buttons to
+// pause, resume and stop the loop. Feed it like an API.
+type Dashboard struct {
+	API
+}
+
+// ServeHTTP serves the page at / and the API everywhere else.
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		d.API.ServeHTTP(w, r)
+		return
+	}
+	if !d.authorized(r) {
+		http.Error(w, "missing or invalid token", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, dashboardPage)
+}
+
+const dashboardPage = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ralph</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 0; display: flex; flex-direction: column; height: 100vh; }
+header { padding: 8px 16px; background: #222; color: #eee; display: flex; gap: 16px; align-items: center; }
+header .grow { flex: 1; }
+main { flex: 1; display: flex; min-height: 0; }
+#timeline { width: 320px; overflow: auto; border-right: 1px solid #ccc; font-size: 13px; }
+#timeline table { border-collapse: collapse; width: 100%; }
+#timeline td { padding: 2px 8px; border-bottom: 1px solid #eee; }
+.fail { color: #b00; }
+#output { flex: 1; margin: 0; padding: 8px; overflow: auto; background: #111; color: #ddd; font-size: 12px; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<header>
+<strong id="agent">ralph</strong>
+<span id="iteration"></span>
+<span id="state">connecting</span>
+<span id="elapsed"></span>
+<span class="grow"></span>
+<button onclick="control('pause')">Pause</button>
+<button onclick="control('resume')">Resume</button>
+<button onclick="control('stop')">Stop</button>
+</header>
+<main>
+<div id="timeline"><table id="rows"></table></div>
+<pre id="output"></pre>
+</main>
+<script>
+const token = new URLSearchParams(location.search).get('token') || '';
+const q = token ? '?token=' + encodeURIComponent(token) : '';
+const $ = id => document.getElementById(id);
+const maxOutput = 200000;
+let startedAt = null;
+
+function control(action) {
+  fetch(action, {method: 'POST', headers: {'Authorization': 'Bearer ' + token, 'Content-Type': 'application/json'}, body: '{}'});
+}
+
+function row(ev) {
+  const tr = document.createElement('tr');
+  const code = ev.agent_exit_code === undefined ? '-' : ev.agent_exit_code;
+  if (code !== 0 && code !== '-') tr.className = 'fail';
+  tr.innerHTML = '<td>#' + ev.iteration + '</td><td>' + (ev.duration_ms / 1000).toFixed(1) + 's</td><td>exit ' + code + '</td>';
+  $('rows').prepend(tr);
+}
+
+function handle(ev) {
+  if (ev.started_at && !ev.started_at.startsWith('0001')) startedAt = new Date(ev.started_at);
+  $('agent').textContent = 'ralph · ' + ev.agent;
+  if (ev.iteration) $('iteration').textContent = 'iteration ' + ev.iteration;
+  switch (ev.event) {
+  case 'agent_output_chunk':
+    const out = $('output');
+    const follow = out.scrollTop + out.clientHeight >= out.scrollHeight - 20;
+    out.textContent = (out.textContent + ev.chunk).slice(-maxOutput);
+    if (follow) out.scrollTop = out.scrollHeight;
+    return;
+  case 'iteration_start':
+    $('state').textContent = 'running';
+    $('output').textContent += '\n=== iteration ' + ev.iteration + ' ===\n';
+    return;
+  case 'iteration_end':
+    row(ev);
+    $('state').textContent = 'resting';
+    return;
+  }
+  $('state').textContent = ev.stop_reason ? ev.event + ' (' + ev.stop_reason + ')' : ev.event;
+}
+
+function connect() {
+  const es = new EventSource('events' + q);
+  // Every (re)connect replays the history.
+  es.onopen = () => { $('output').textContent = ''; $('rows').textContent = ''; };
+  es.onmessage = m => handle(JSON.parse(m.data));
+  es.onerror = () => { $('state').textContent = 'disconnected'; };
+}
+
+setInterval(() => {
+  if (startedAt) $('elapsed').textContent = Math.round((Date.now() - startedAt) / 1000) + 's';
+}, 1000);
+connect();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,205 @@
+package ralph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Detector decides whether an iteration completed the task.
+type Detector interface {
+	// Detect reports whether output, the agent's output of the iteration
+	// that just ended, marks the task complete, and if so what gave it
+	// away, e.g. the stop signal found.
+	Detect(ctx context.Context, output string) (string, bool)
+}
+
+// TokenDetector completes when the output contains one of Tokens.
+type TokenDetector struct{ Tokens []string }
+
+func (d TokenDetector) Detect(_ context.Context, output string) (string, bool) {
+	signal, ok := DetectStopSignal(output, d.Tokens, nil)
+	return "stop signal " + signal, ok
+}
+
+// RegexDetector completes when Regex matches the output.
+type RegexDetector struct{ Regex *regexp.Regexp }
+
+func (d RegexDetector) Detect(_ context.Context, output string) (string, bool) {
+	signal, ok := DetectStopSignal(output, nil, d.Regex)
+	return "stop signal " + signal, ok
+}
+
+// FileDetector completes when the agent has created Path.
+type FileDetector struct{ Path string }
+
+func (d FileDetector) Detect(context.Context, string) (string, bool) {
+	if _, err := os.Stat(d.Path); err != nil {
+		return "", false
+	}
+	return "done file " + d.Path, true
+}
+
+// CommandDetector completes when the shell command Command exits 0.
+type CommandDetector struct{ Command string }
+
+func (d CommandDetector) Detect(ctx context.Context, _ string) (string, bool) {
+	if _, err := runShellCommand(ctx, d.Command); err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%q passing", d.Command), true
+}
+
+// JSONFieldDetector completes when a line of output holding a JSON object
+// has Field (dot-separated for nested objects) set to Value, e.g.
+// {"status": "done"}.
+type JSONFieldDetector struct{ Field, Value string }
+
+func (d JSONFieldDetector) Detect(_ context.Context, output string) (string, bool) {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		var v any
+		if json.Unmarshal([]byte(line), &v) != nil {
+			continue
+		}
+		for _, key := range strings.Split(d.Field, ".") {
+			obj, _ := v.(map[string]any)
+			v = obj[key]
+		}
+		if v != nil && fmt.Sprint(v) == d.Value {
+			return fmt.Sprintf("%s=%s", d.Field, d.Value), true
+		}
+	}
+	return "", false
+}
+
+// AllDetector completes when every one of its detectors does.
+type AllDetector []Detector
+
+func (d AllDetector) Detect(ctx context.Context, output string) (string, bool) {
+	var found []string
+	for _, det := range d {
+		what, ok := det.Detect(ctx, output)
+		if !ok {
+			return "", false
+		}
+		found = append(found, what)
+	}
+	return strings.Join(found, " and "), len(found) > 0
+}
+
+// AnyDetector completes as soon as one of its detectors does.
+type AnyDetector []Detector
+
+func (d AnyDetector) Detect(ctx context.Context, output string) (string, bool) {
+	for _, det := range d {
+		if what, ok := det.Detect(ctx, output); ok {
+			return what, true
+		}
+	}
+	return "", false
+}
+
+// DetectorSpec describes a Detector in ralph.yaml. Exactly one field must
+// be set; All and Any nest, e.g.
+//
+//	any:
+//	  - token: RALPH_DONE
+//	  - all: [{file: .ralph_done}, {command: go test ./...}]
+type DetectorSpec struct {
+	Token   string `yaml:"token"`
+	Regex   string `yaml:"regex"`
+	File    string `yaml:"file"`
+	Command string `yaml:"command"`
+	// JSON is "field=value", see JSONFieldDetector.
+	JSON string         `yaml:"json"`
+	All  []DetectorSpec `yaml:"all"`
+	Any  []DetectorSpec `yaml:"any"`
+}
+
+// Build returns the Detector s describes.
+func (s DetectorSpec) Build() (Detector, error) {
+	var detectors []Detector
+	if s.Token != "" {
+		detectors = append(detectors, TokenDetector{Tokens: []string{s.Token}})
+	}
+	if s.Regex != "" {
+		re, err := regexp.Compile(s.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("regex: %w", err)
+		}
+		detectors = append(detectors, RegexDetector{Regex: re})
+	}
+	if s.File != "" {
+		detectors = append(detectors, FileDetector{Path: s.File})
+	}
+	if s.Command != "" {
+		detectors = append(detectors, CommandDetector{Command: s.Command})
+	}
+	if s.JSON != "" {
+		field, value, ok := strings.Cut(s.JSON, "=")
+		if !ok || field == "" {
+			return nil, fmt.Errorf("json: want field=value, got %q", s.JSON)
+		}
+		detectors = append(detectors, JSONFieldDetector{Field: field, Value: value})
+	}
+	for _, group := range []struct {
+		specs []DetectorSpec
+		all   bool
+	}{{s.All, true}, {s.Any, false}} {
+		if group.specs == nil {
+			continue
+		}
+		if len(group.specs) == 0 {
+			return nil, fmt.Errorf("empty all or any")
+		}
+		children := make([]Detector, len(group.specs))
+		for i, spec := range group.specs {
+			child, err := spec.Build()
+			if err != nil {
+				return nil, err
+			}
+			children[i] = child
+		}
+		if group.all {
+			detectors = append(detectors, AllDetector(children))
+		} else {
+			detectors = append(detectors, AnyDetector(children))
+		}
+	}
+	if len(detectors) != 1 {
+		return nil, fmt.Errorf("want exactly one of token, regex, file, command, json, all or any, got %d", len(detectors))
+	}
+	return detectors[0], nil
+}
+
+// String describes s on one line, e.g. "any(token RALPH_DONE, file .ralph_done)".
+func (s DetectorSpec) String() string {
+	var parts []string
+	for _, f := range []struct{ name, value string }{
+		{"token", s.Token}, {"regex", s.Regex}, {"file", s.File}, {"command", s.Command}, {"json", s.JSON},
+	} {
+		if f.value != "" {
+			parts = append(parts, f.name+" "+f.value)
+		}
+	}
+	for _, group := range []struct {
+		name  string
+		specs []DetectorSpec
+	}{{"all", s.All}, {"any", s.Any}} {
+		if group.specs != nil {
+			children := make([]string, len(group.specs))
+			for i, spec := range group.specs {
+				children[i] = spec.String()
+			}
+			parts = append(parts, group.name+"("+strings.Join(children, ", ")+")")
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,196 @@
+package ralph
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DevcontainerConfigs are where a project keeps its devcontainer.json,
+// relative to the workspace folder.
+var DevcontainerConfigs = []string{
+	filepath.Join(".devcontainer", "devcontainer.json"),
+	".devcontainer.json",
+}
+
+// FindDevcontainer returns the workspace folder of the devcontainer that
+// dir belongs to: dir or the closest parent with a devcontainer.json.
+func FindDevcontainer(dir string) (string, bool) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	for {
+		for _, c := range DevcontainerConfigs {
+			if _, err := os.Stat(filepath.Join(dir, c)); err == nil {
+				return dir, true
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// devcontainer is a running devcontainer, as reported by devcontainer up.
+type devcontainer struct {
+	containerID     string
+	remoteWorkspace string
+}
+
+// workspace returns the devcontainer's workspace folder on the host.
+func (s *Sandbox) workspace() (string, error) {
+	if s.Workspace != "" {
+		return filepath.Abs(s.Workspace)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	ws, ok := FindDevcontainer(wd)
+	if !ok {
+		return "", fmt.Errorf("no devcontainer.json in %s or its parents", wd)
+	}
+	return ws, nil
+}
+
+// up starts the devcontainer, or finds it running, once per Sandbox. The
+// container is left running afterwards, like an editor would, so the next
+// run starts quickly.
+func (s *Sandbox) up(ctx context.Context) (*devcontainer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.container != nil {
+		return s.container, nil
+	}
+	ws, err := s.workspace()
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "devcontainer", "up", "--workspace-folder", ws)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("devcontainer up: %w: %s", err, tail(stderr.String()+stdout.String(), maxAPIErrorBytes))
+	}
+	// The result is the last line of output, after any build logs.
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	var result struct {
+		Outcome               string `json:"outcome"`
+		Message               string `json:"message"`
+		ContainerID           string `json:"containerId"`
+		RemoteWorkspaceFolder string `json:"remoteWorkspaceFolder"`
+	}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &result); err != nil {
+		return nil, fmt.Errorf("devcontainer up: unexpected output: %s", tail(stdout.String(), maxAPIErrorBytes))
+	}
+	if result.Outcome != "success" {
+		return nil, fmt.Errorf("devcontainer up: %s: %s", result.Outcome, result.Message)
+	}
+	s.container = &devcontainer{containerID: result.ContainerID, remoteWorkspace: result.RemoteWorkspaceFolder}
+	return s.container, nil
+}
+
+// promptDir is where prompt files go: inside the workspace for a
+// devcontainer, which sees nothing else of the host, otherwise "" for the
+// system's temporary directory.
+func (s *Sandbox) promptDir() (string, error) {
+	if s == nil || !s.Devcontainer {
+		return "", nil
+	}
+	dir := s.PromptDir
+	if dir == "" {
+		ws, err := s.workspace()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(ws, ".ralph")
+	}
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+// wrapDevcontainer turns cmd into a devcontainer exec of the same command
+// line, in the container's counterpart of cmd's directory.
+func (s *Sandbox) wrapDevcontainer(ctx context.Context, cmd *exec.Cmd, promptFile string, def AgentDef) error {
+	dc, err := s.up(ctx)
+	if err != nil {
+		return err
+	}
+	ws, err := s.workspace()
+	if err != nil {
+		return err
+	}
+	remote := func(hostPath string) (string, error) {
+		abs, err := filepath.Abs(hostPath)
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(ws, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("%s is outside the devcontainer workspace %s", hostPath, ws)
+		}
+		return path.Join(dc.remoteWorkspace, filepath.ToSlash(rel)), nil
+	}
+	dir := cmd.Dir
+	if dir == "" {
+		if dir, err = os.Getwd(); err != nil {
+			return err
+		}
+	}
+	remoteDir, err := remote(dir)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"exec", "--workspace-folder", ws}
+	// devcontainer exec has no way to pass a variable by name only.
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	lookup := func(name string) (string, bool) {
+		for i := len(env) - 1; i >= 0; i-- {
+			if v, ok := strings.CutPrefix(env[i], name+"="); ok {
+				return v, true
+			}
+		}
+		return "", false
+	}
+	names := append([]string{}, s.Env...)
+	names = append(names, sortedKeys(def.Env)...)
+	names = append(names, sortedKeys(def.EnvFiles)...)
+	seen := map[string]bool{}
+	for _, n := range names {
+		if v, ok := lookup(n); ok && !seen[n] {
+			seen[n] = true
+			args = append(args, "--remote-env", n+"="+v)
+		}
+	}
+	// Start in the working directory, which need not be the workspace root.
+	args = append(args, "sh", "-c", `cd "$0" && exec "$@"`, remoteDir)
+	for _, a := range cmd.Args {
+		if promptFile != "" && a == promptFile {
+			if a, err = remote(promptFile); err != nil {
+				return err
+			}
+		}
+		args = append(args, a)
+	}
+
+	bin, err := exec.LookPath("devcontainer")
+	if err != nil {
+		return fmt.Errorf("devcontainer CLI not found on PATH (npm install -g @devcontainers/cli)")
+	}
+	cmd.Path = bin
+	cmd.Args = append([]string{"devcontainer"}, args...)
+	cmd.Err = nil
+	return nil
+}
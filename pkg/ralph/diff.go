@@ -0,0 +1,53 @@
+package ralph
+
+import (
+	"context"
+	"fmt"
+)
+
+// Modes for Loop.InjectDiff.
+const (
+	// InjectDiffStat adds `git diff --stat` of the run's changes.
+	InjectDiffStat = "stat"
+	// InjectDiffFull adds the stat and the diff itself.
+	InjectDiffFull = "full"
+)
+
+// DefaultInjectDiffBytes bounds the diff added to the prompt.
+const DefaultInjectDiffBytes = 20_000
+
+// maxDiffStatBytes bounds the stat added to the prompt.
+const maxDiffStatBytes = 10_000
+
+// noChanges stands in for an empty diff.
+const noChanges = "(no changes yet)"
+
+// runDiff returns the changes made since the run started, as a stat or as
+// a diff, noChanges when there are none, or a note explaining why there is
+// no diff.
+func (l *Loop) runDiff(ctx context.Context, stat bool) string {
+	var diff string
+	var err error
+	if stat {
+		diff, err = gitWorkDiff(ctx, l.runBase, l.Ignore, maxDiffStatBytes, "--stat")
+	} else {
+		diff, err = gitWorkDiff(ctx, l.runBase, l.Ignore, l.InjectDiffBytes)
+	}
+	switch {
+	case err != nil:
+		return fmt.Sprintf("(diff unavailable: %v)", err)
+	case diff == "":
+		return noChanges
+	}
+	return diff
+}
+
+// diffSection formats the changes made since the run started for the prompt.
+func (l *Loop) diffSection(ctx context.Context) string {
+	stat := l.runDiff(ctx, true)
+	section := fmt.Sprintf("## Changes so far\n\nWhat changed since this run started (git diff --stat):\n```\n%s\n```", stat)
+	if l.InjectDiff == InjectDiffFull && stat != noChanges {
+		section += fmt.Sprintf("\n\nThe diff:\n```diff\n%s\n```", l.runDiff(ctx, false))
+	}
+	return section
+}
@@ -0,0 +1,191 @@
+package ralph
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EgressProxy is an HTTP proxy that only lets the agent reach the hosts in
+// Allow. Agents are pointed at it with the usual proxy environment
+// variables, which the agent CLIs and most tools honor; a program that
+// ignores them is not stopped, so where egress must be enforced, combine it
+// with a firewall or a sandbox without network.
+type EgressProxy struct {
+	// Allow lists the hosts that may be reached: "example.com", "*.example.com"
+	// for its subdomains, or either with ":port" to allow that port only.
+	Allow []string
+	// OnDenied, if set, is called the first time each host is refused.
+	OnDenied func(host string)
+
+	mu     sync.Mutex
+	ln     net.Listener
+	denied map[string]bool
+}
+
+// Allowed reports whether hostport ("host:port") may be reached.
+func (p *EgressProxy) Allowed(hostport string) bool {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host, port = hostport, ""
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range p.Allow {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if h, pp, err := net.SplitHostPort(pattern); err == nil {
+			if pp != port {
+				continue
+			}
+			pattern = h
+		}
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// start starts the proxy on a local port, once, and returns its address.
+func (p *EgressProxy) start() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ln == nil {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return "", fmt.Errorf("egress proxy: %w", err)
+		}
+		p.ln = ln
+		go (&http.Server{Handler: p, ReadHeaderTimeout: 30 * time.Second}).Serve(ln)
+	}
+	return p.ln.Addr().String(), nil
+}
+
+// Close stops the proxy.
+func (p *EgressProxy) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ln == nil {
+		return nil
+	}
+	err := p.ln.Close()
+	p.ln = nil
+	return err
+}
+
+// environ returns env with the proxy variables pointing at the proxy, which
+// is started if need be. Local addresses bypass it.
+func (p *EgressProxy) environ(env []string) ([]string, error) {
+	addr, err := p.start()
+	if err != nil {
+		return nil, err
+	}
+	url := "http://" + addr
+	noProxy := "localhost,127.0.0.1,::1"
+	return append(env,
+		"HTTP_PROXY="+url, "http_proxy="+url,
+		"HTTPS_PROXY="+url, "https_proxy="+url,
+		"ALL_PROXY="+url, "all_proxy="+url,
+		"NO_PROXY="+noProxy, "no_proxy="+noProxy,
+	), nil
+}
+
+func (p *EgressProxy) deny(w http.ResponseWriter, host string) {
+	p.mu.Lock()
+	first := !p.denied[host]
+	if p.denied == nil {
+		p.denied = map[string]bool{}
+	}
+	p.denied[host] = true
+	p.mu.Unlock()
+	if first && p.OnDenied != nil {
+		p.OnDenied(host)
+	}
+	http.Error(w, fmt.Sprintf("ralph: egress to %s is not allowed", host), http.StatusForbidden)
+}
+
+// ServeHTTP tunnels CONNECT requests and forwards plain HTTP ones to
+// allowed hosts.
+func (p *EgressProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		if !p.Allowed(r.Host) {
+			p.deny(w, r.Host)
+			return
+		}
+		p.tunnel(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "ralph: not a proxy request", http.StatusBadRequest)
+		return
+	}
+	host := r.URL.Host
+	if r.URL.Port() == "" {
+		host = net.JoinHostPort(r.URL.Hostname(), "80")
+	}
+	if !p.Allowed(host) {
+		p.deny(w, r.URL.Host)
+		return
+	}
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	out.Header.Del("Proxy-Connection")
+	out.Header.Del("Proxy-Authorization")
+	resp, err := egressTransport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// egressTransport forwards plain HTTP requests, never through another proxy.
+var egressTransport = &http.Transport{Proxy: nil, DialContext: (&net.Dialer{Timeout: 30 * time.Second}).DialContext}
+
+func (p *EgressProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	upstream, err := net.DialTimeout("tcp", r.Host, 30*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "ralph: cannot tunnel", http.StatusInternalServerError)
+		return
+	}
+	client, buf, err := hj.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	_, _ = client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	done := make(chan struct{}, 2)
+	go func() {
+		// Bytes the client sent after its CONNECT may be buffered already.
+		_, _ = io.Copy(upstream, buf)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(client, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+	client.Close()
+	upstream.Close()
+	<-done
+}
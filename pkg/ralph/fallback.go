@@ -0,0 +1,41 @@
+package ralph
+
+import "fmt"
+
+// DefaultFallbackAfter is how many failed agent runs in a row make the loop
+// switch to the next agent of its Fallbacks.
+const DefaultFallbackAfter = 3
+
+// NamedAgent is an agent with the name and version it is reported under.
+type NamedAgent struct {
+	Name    string
+	Version string
+	Agent   Agent
+}
+
+// fallBack switches to the next agent of Fallbacks when the current one hit
+// a rate limit or failed FallbackAfter times in a row, and reports whether
+// it did. The failure counters start over with the new agent.
+func (l *Loop) fallBack(rateLimited bool) bool {
+	if len(l.Fallbacks) == 0 {
+		return false
+	}
+	var reason string
+	switch {
+	case rateLimited:
+		reason = "hit a rate limit"
+	case l.agentErrors >= l.FallbackAfter:
+		reason = fmt.Sprintf("failed %d times in a row", l.agentErrors)
+	default:
+		return false
+	}
+
+	from := l.AgentName
+	next := l.Fallbacks[0]
+	l.Fallbacks = l.Fallbacks[1:]
+	l.Agent, l.AgentName, l.AgentVersion = next.Agent, next.Name, next.Version
+	l.agentErrors, l.rateLimits = 0, 0
+	l.logf("\n🔀 %s %s. Falling back to %s.\n", from, reason, next.Name)
+	l.emit(EventAgentSwitched, fmt.Sprintf("%s %s, switched to %s", from, reason, next.Name))
+	return true
+}
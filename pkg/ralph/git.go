@@ -0,0 +1,423 @@
+package ralph
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// maxSummaryLines and maxSummaryBytes bound the agent output excerpt used in
+// generated commit messages.
+const (
+	maxSummaryLines = 10
+	maxSummaryBytes = 2000
+)
+
+// git runs a git subcommand in the working directory and returns its
+// trimmed combined output.
+func git(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// GitCommitOptions are the settings of GitCommitAll and GitCommitFile.
+type GitCommitOptions struct {
+	// NoVerify skips the repository's pre-commit and commit-msg hooks.
+	NoVerify bool
+	// Exclude are files and directories GitCommitAll never stages, such as
+	// ralph's own logs and state. Paths outside the work tree are skipped.
+	Exclude []string
+}
+
+// commitArgs returns the git commit arguments for message.
+func (o GitCommitOptions) commitArgs(message string) []string {
+	args := []string{"commit", "--quiet", "-m", message}
+	if o.NoVerify {
+		args = append(args, "--no-verify")
+	}
+	return args
+}
+
+// excludePathspecs returns the pathspecs that leave out Exclude.
+func (o GitCommitOptions) excludePathspecs(ctx context.Context) []string {
+	if len(o.Exclude) == 0 {
+		return nil
+	}
+	top, err := git(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil
+	}
+	var pathspecs []string
+	for _, p := range o.Exclude {
+		if p == "" {
+			continue
+		}
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(top, abs)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		pathspecs = append(pathspecs, ":(top,exclude,literal)"+filepath.ToSlash(rel))
+	}
+	return pathspecs
+}
+
+// GitCommitAll stages every change but the excluded paths and commits it
+// with message. It returns the new commit hash, or "" if there was nothing
+// to commit.
+func GitCommitAll(ctx context.Context, message string, opts GitCommitOptions) (string, error) {
+	if _, err := git(ctx, append([]string{"add", "-A", "--", ":/"}, opts.excludePathspecs(ctx)...)...); err != nil {
+		return "", err
+	}
+	if err := exec.CommandContext(ctx, "git", "diff", "--cached", "--quiet").Run(); err == nil {
+		return "", nil
+	}
+	if _, err := git(ctx, opts.commitArgs(message)...); err != nil {
+		return "", err
+	}
+	return git(ctx, "rev-parse", "--short", "HEAD")
+}
+
+// GitCommitFile commits the changes to the file at path, and only those,
+// with message. It returns the new commit hash, or "" if the file has no
+// changes.
+func GitCommitFile(ctx context.Context, path, message string, opts GitCommitOptions) (string, error) {
+	if _, err := git(ctx, "add", "--", path); err != nil {
+		return "", err
+	}
+	if err := exec.CommandContext(ctx, "git", "diff", "--cached", "--quiet", "--", path).Run(); err == nil {
+		return "", nil
+	}
+	if _, err := git(ctx, append(opts.commitArgs(message), "--", path)...); err != nil {
+		return "", err
+	}
+	return git(ctx, "rev-parse", "--short", "HEAD")
+}
+
+// GitInfo describes the repository in the working directory.
+type GitInfo struct {
+	// Repo is false when the directory is not inside a git work tree.
+	Repo bool
+	// Branch is the current branch, or "" on a detached HEAD.
+	Branch string
+	// Dirty is the number of changed or untracked paths.
+	Dirty int
+	// Modified is the number of changed tracked paths, leaving out
+	// untracked files.
+	Modified int
+}
+
+// GitState inspects the repository in the working directory.
+func GitState(ctx context.Context) GitInfo {
+	var info GitInfo
+	if out, err := git(ctx, "rev-parse", "--is-inside-work-tree"); err != nil || out != "true" {
+		return info
+	}
+	info.Repo = true
+	info.Branch, _ = git(ctx, "symbolic-ref", "--quiet", "--short", "HEAD")
+	if status, err := git(ctx, "status", "--porcelain"); err == nil && status != "" {
+		for _, line := range strings.Split(status, "\n") {
+			info.Dirty++
+			if !strings.HasPrefix(line, "??") {
+				info.Modified++
+			}
+		}
+	}
+	return info
+}
+
+// GitTopLevel returns the root directory of the repository in the working
+// directory.
+func GitTopLevel(ctx context.Context) (string, error) {
+	return git(ctx, "rev-parse", "--show-toplevel")
+}
+
+// GitAddWorktree checks out a new branch, starting at HEAD, in a new
+// worktree at dir.
+func GitAddWorktree(ctx context.Context, dir, branch string) error {
+	_, err := git(ctx, "worktree", "add", "--quiet", "-b", branch, dir, "HEAD")
+	return err
+}
+
+// GitRemoveWorktree deletes the worktree at dir; its branch is kept.
+func GitRemoveWorktree(ctx context.Context, dir string) error {
+	_, err := git(ctx, "worktree", "remove", "--force", dir)
+	return err
+}
+
+// GitDeleteBranch deletes branch, merged or not.
+func GitDeleteBranch(ctx context.Context, branch string) error {
+	_, err := git(ctx, "branch", "-D", branch)
+	return err
+}
+
+// GitMerge merges branch into the current branch with a merge commit.
+func GitMerge(ctx context.Context, branch string) error {
+	_, err := git(ctx, "merge", "--no-ff", "--no-edit", branch)
+	return err
+}
+
+// GitPush pushes branch to remote and sets it as the branch's upstream.
+func GitPush(ctx context.Context, remote, branch string) error {
+	_, err := git(ctx, "push", "-u", remote, branch)
+	return err
+}
+
+// GitDefaultBranch returns the branch remote's HEAD points at, as of the
+// last fetch, or "" if it is not known.
+func GitDefaultBranch(ctx context.Context, remote string) string {
+	ref, err := git(ctx, "symbolic-ref", "--short", "refs/remotes/"+remote+"/HEAD")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(ref, remote+"/")
+}
+
+// gitSnapshot records the work tree so that later changes can be undone.
+type gitSnapshot struct {
+	head      string
+	stash     string // commit holding uncommitted tracked changes, if any
+	untracked map[string]bool
+	keep      []string
+}
+
+// takeGitSnapshot records HEAD, uncommitted changes and untracked files
+// without touching the work tree. Untracked files at or under the keep
+// paths, such as ralph's own state, are never reverted.
+func takeGitSnapshot(ctx context.Context, keep ...string) (*gitSnapshot, error) {
+	head, err := git(ctx, "rev-parse", "--verify", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	stash, err := git(ctx, "stash", "create")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := untrackedFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snap := &gitSnapshot{head: head, stash: stash, untracked: map[string]bool{}}
+	for _, k := range keep {
+		if k != "" {
+			snap.keep = append(snap.keep, filepath.ToSlash(filepath.Clean(k)))
+		}
+	}
+	for _, f := range untracked {
+		snap.untracked[f] = true
+	}
+	return snap, nil
+}
+
+// restore puts the work tree back the way it was when the snapshot was
+// taken: commits made since are dropped, tracked files are reset, and new
+// untracked files are deleted. Ignored files are left alone. Failing to
+// delete a file does not stop the uncommitted changes from being restored.
+func (s *gitSnapshot) restore(ctx context.Context) error {
+	if _, err := git(ctx, "reset", "--quiet", "--hard", s.head); err != nil {
+		return err
+	}
+	untracked, err := untrackedFiles(ctx)
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, f := range untracked {
+		if s.isNew(f) {
+			if err := os.Remove(f); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if s.stash != "" {
+		if _, err := git(ctx, "stash", "apply", "--quiet", s.stash); err != nil {
+			errs = append(errs, fmt.Errorf("restoring the uncommitted changes (git stash apply %s to retry): %w", s.stash, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isNew reports whether the untracked file f appeared after the snapshot
+// and is not one of the kept paths.
+func (s *gitSnapshot) isNew(f string) bool {
+	if s.untracked[f] {
+		return false
+	}
+	for _, k := range s.keep {
+		if f == k || strings.HasPrefix(f, k+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// untrackedFiles lists the untracked files that are not ignored. The paths
+// are NUL-separated so that git does not quote unusual ones.
+func untrackedFiles(ctx context.Context) ([]string, error) {
+	out, err := git(ctx, "ls-files", "-z", "--others", "--exclude-standard")
+	if err != nil || out == "" {
+		return nil, err
+	}
+	return strings.Split(strings.TrimRight(out, "\x00"), "\x00"), nil
+}
+
+// diffStat summarizes the changes made since the snapshot: a git diff --stat
+// against its HEAD plus the new untracked files.
+func (s *gitSnapshot) diffStat(ctx context.Context) string {
+	stat, _ := git(ctx, "diff", "--stat", s.head)
+	untracked, _ := untrackedFiles(ctx)
+	for _, f := range untracked {
+		if s.isNew(f) {
+			stat += "\n " + f + " (new)"
+		}
+	}
+	return strings.TrimPrefix(stat, "\n")
+}
+
+// WorkTreeFingerprint returns a digest of HEAD, tracked changes and untracked
+// file contents, so two calls return the same value only if nothing changed.
+func WorkTreeFingerprint(ctx context.Context) (string, error) {
+	h := sha256.New()
+
+	head, err := git(ctx, "rev-parse", "--verify", "--quiet", "HEAD")
+	if err != nil {
+		head = "" // no commits yet
+	}
+	diff, err := git(ctx, "diff", "HEAD", "--binary")
+	if err != nil && head != "" {
+		return "", err
+	}
+	status, err := git(ctx, "status", "--porcelain")
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "%s\n%s\n%s\n", head, diff, status)
+
+	untracked, err := untrackedFiles(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(untracked) > 0 {
+		cmd := exec.CommandContext(ctx, "git", "hash-object", "--stdin-paths")
+		cmd.Stdin = strings.NewReader(strings.Join(untracked, "\n") + "\n")
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("git hash-object: %w", err)
+		}
+		h.Write(out)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// iterationCommitMessage builds the commit message for an iteration from the
+// tail of the agent output.
+func iterationCommitMessage(iteration int, agent, output string) string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > maxSummaryLines {
+		lines = lines[len(lines)-maxSummaryLines:]
+	}
+	summary := strings.Join(lines, "\n")
+	if len(summary) > maxSummaryBytes {
+		summary = "..." + summary[runeStart(summary, len(summary)-maxSummaryBytes):]
+	}
+
+	msg := fmt.Sprintf("ralph: iteration %d (%s)", iteration, agent)
+	if summary != "" {
+		msg += "\n\nAgent output (tail):\n" + summary
+	}
+	return msg
+}
+
+// gitWorkDiff returns the changes in the work tree relative to base,
+// untracked files included, truncated to maxBytes. The changes to files
+// matched by ignore are left out. args are extra git diff options such as
+// --stat. It stages into a temporary index so the real one is left alone.
+func gitWorkDiff(ctx context.Context, base string, ignore *Ignore, maxBytes int, args ...string) (string, error) {
+	if base == "" {
+		return "", fmt.Errorf("not a git repository")
+	}
+	f, err := os.CreateTemp("", "ralph-index-*")
+	if err != nil {
+		return "", err
+	}
+	index := f.Name()
+	f.Close()
+	os.Remove(index)
+	defer os.Remove(index)
+
+	env := append(os.Environ(), "GIT_INDEX_FILE="+index)
+	run := func(args ...string) (string, error) {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Env = env
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("git %s: %w", args[0], err)
+		}
+		return string(out), nil
+	}
+	if _, err := run("read-tree", "HEAD"); err != nil {
+		return "", err
+	}
+	if _, err := run("add", "-A"); err != nil {
+		return "", err
+	}
+	pathspecs, err := ignoredPathspecs(ctx, run, base, ignore)
+	if err != nil {
+		return "", err
+	}
+	diff, err := run(append(append(append([]string{"diff", "--cached"}, args...), base), pathspecs...)...)
+	if err != nil {
+		return "", err
+	}
+	if len(diff) > maxBytes {
+		cut := runeStart(diff, maxBytes)
+		diff = diff[:cut] + fmt.Sprintf("\n... [diff truncated: %d more bytes] ...", len(diff)-cut)
+	}
+	return strings.TrimRight(diff, "\n"), nil
+}
+
+// ignoredPathspecs returns the pathspecs that leave the changed files
+// matched by ignore out of a git diff --cached against base, or nothing if
+// none is.
+func ignoredPathspecs(ctx context.Context, run func(args ...string) (string, error), base string, ignore *Ignore) ([]string, error) {
+	if ignore == nil {
+		return nil, nil
+	}
+	names, err := run("diff", "--cached", "--name-only", "--no-renames", "-z", base)
+	if err != nil || names == "" {
+		return nil, err
+	}
+	top, err := git(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	var pathspecs []string
+	for _, name := range strings.Split(strings.TrimRight(names, "\x00"), "\x00") {
+		if ignore.Match(filepath.Join(top, filepath.FromSlash(name))) {
+			pathspecs = append(pathspecs, ":(top,exclude,literal)"+name)
+		}
+	}
+	if len(pathspecs) == 0 {
+		return nil, nil
+	}
+	return append([]string{"--", ":(top)"}, pathspecs...), nil
+}
@@ -0,0 +1,149 @@
+package ralph
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// chdirRepo makes the working directory a new git repository holding files,
+// committed, for the duration of the test.
+func chdirRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	orig, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(orig) })
+	for _, kv := range []string{"GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com", "GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com"} {
+		k, v, _ := strings.Cut(kv, "=")
+		t.Setenv(k, v)
+	}
+	writeFiles(t, files)
+	runGit(t, "init", "-q", "-b", "work")
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-q", "-m", "init")
+	return dir
+}
+
+func writeFiles(t *testing.T, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func runGit(t *testing.T, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestGitSnapshotRestore(t *testing.T) {
+	chdirRepo(t, map[string]string{"tracked.txt": "committed\n"})
+	ctx := context.Background()
+	// The state to go back to: an uncommitted change and untracked files.
+	writeFiles(t, map[string]string{
+		"tracked.txt":    "uncommitted\n",
+		"vieux café.txt": "old\n",
+		".ralph/state":   "kept\n",
+	})
+	snap, err := takeGitSnapshot(ctx, ".ralph")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// What an iteration does: edit, commit, and add files.
+	writeFiles(t, map[string]string{
+		"tracked.txt":   "agent\n",
+		"committed.txt": "agent\n",
+	})
+	runGit(t, "add", "tracked.txt", "committed.txt")
+	runGit(t, "commit", "-q", "-m", "agent")
+	writeFiles(t, map[string]string{
+		"nouveau café.txt": "new\n",
+		"dir/new.txt":      "new\n",
+		".ralph/log":       "kept\n",
+	})
+	if stat := snap.diffStat(ctx); !strings.Contains(stat, "nouveau café.txt (new)") {
+		t.Errorf("diffStat = %q, want the new file by its real name", stat)
+	}
+
+	if err := snap.restore(ctx); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want string // "" if the file must be gone
+	}{
+		{"tracked.txt", "uncommitted\n"},
+		{"vieux café.txt", "old\n"},
+		{".ralph/state", "kept\n"},
+		{".ralph/log", "kept\n"},
+		{"committed.txt", ""},
+		{"nouveau café.txt", ""},
+		{"dir/new.txt", ""},
+	}
+	for _, tt := range tests {
+		data, err := os.ReadFile(tt.path)
+		switch {
+		case tt.want == "" && !os.IsNotExist(err):
+			t.Errorf("%s still exists after restore", tt.path)
+		case tt.want != "" && string(data) != tt.want:
+			t.Errorf("%s = %q, %v; want %q", tt.path, data, err, tt.want)
+		}
+	}
+	if head := runGit(t, "rev-parse", "HEAD"); head != snap.head {
+		t.Errorf("HEAD = %s, want %s", head, snap.head)
+	}
+}
+
+func TestGitCommitAll(t *testing.T) {
+	chdirRepo(t, map[string]string{"README.md": "test\n"})
+	ctx := context.Background()
+	writeFiles(t, map[string]string{
+		"README.md":         "changed\n",
+		"src/new.go":        "package src\n",
+		ErrorLogFile:        "failed\n",
+		DefaultDoneFile:     "done\n",
+		".ralph/state.json": "{}\n",
+		"status.json":       "{}\n",
+	})
+	// A hook that rejects every commit.
+	writeFiles(t, map[string]string{".git/hooks/pre-commit": "#!/bin/sh\nexit 1\n"})
+	if err := os.Chmod(".git/hooks/pre-commit", 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := GitCommitOptions{Exclude: []string{ErrorLogFile, DefaultDoneFile, ".ralph", "status.json", "../outside", ""}}
+	if _, err := GitCommitAll(ctx, "rejected", opts); err == nil {
+		t.Fatal("GitCommitAll ran past a failing pre-commit hook")
+	}
+	opts.NoVerify = true
+	hash, err := GitCommitAll(ctx, "committed", opts)
+	if err != nil || hash == "" {
+		t.Fatalf("GitCommitAll = %q, %v", hash, err)
+	}
+	files := strings.Fields(runGit(t, "show", "--name-only", "--format=", "HEAD"))
+	if want := []string{"README.md", "src/new.go"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("committed %q, want %q", files, want)
+	}
+}
@@ -0,0 +1,340 @@
+package ralph
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// GitHubTokenEnvs are the environment variables OpenPullRequest takes a
+// GitHub token from, in order.
+var GitHubTokenEnvs = []string{"GITHUB_TOKEN", "GH_TOKEN"}
+
+// GitHubAPIEnv overrides the GitHub API URL, e.g. for GitHub Enterprise.
+const GitHubAPIEnv = "GITHUB_API_URL"
+
+// DefaultGitHubAPI is the GitHub API URL.
+const DefaultGitHubAPI = "https://api.github.com"
+
+// PullRequest describes a pull request to open on GitHub.
+type PullRequest struct {
+	Title string
+	Body  string
+	// Head is the branch with the changes and Base the branch they are to
+	// be merged into.
+	Head  string
+	Base  string
+	Draft bool
+}
+
+// githubRemote matches the URLs of GitHub remotes: https, ssh and scp-like.
+var githubRemote = regexp.MustCompile(`^(?:https?://(?:[^@/]+@)?[^/]+/|ssh://git@[^/]+/|git@[^:]+:)([^/]+)/([^/]+?)(?:\.git)?/?$`)
+
+// GitHubRepo returns the owner and name of the GitHub repository that
+// remote points at.
+func GitHubRepo(ctx context.Context, remote string) (owner, repo string, err error) {
+	u, err := git(ctx, "remote", "get-url", remote)
+	if err != nil {
+		return "", "", err
+	}
+	m := githubRemote.FindStringSubmatch(u)
+	if m == nil {
+		return "", "", fmt.Errorf("remote %s (%s) is not a GitHub repository", remote, u)
+	}
+	return m[1], m[2], nil
+}
+
+// OpenPullRequest opens pr on the GitHub repository of remote and returns
+// its URL; if a pull request from pr.Head is already open, it returns that
+// one's URL and existed. It calls the GitHub API with a token from
+// GitHubTokenEnvs if one is set, and the gh CLI otherwise.
+func OpenPullRequest(ctx context.Context, remote string, pr PullRequest) (prURL string, existed bool, err error) {
+	token, err := githubAuth()
+	if err != nil {
+		return "", false, err
+	}
+	if token == "" {
+		return openPullRequestCLI(ctx, pr)
+	}
+	owner, repo, err := GitHubRepo(ctx, remote)
+	if err != nil {
+		return "", false, err
+	}
+	pulls := fmt.Sprintf("/repos/%s/%s/pulls", owner, repo)
+	var open []struct {
+		HTMLURL string `json:"html_url"`
+	}
+	query := url.Values{"head": {owner + ":" + pr.Head}, "state": {"open"}}
+	if err := githubAPI(ctx, token, http.MethodGet, pulls+"?"+query.Encode(), nil, &open); err != nil {
+		return "", false, err
+	}
+	if len(open) > 0 {
+		return open[0].HTMLURL, true, nil
+	}
+	var created struct {
+		HTMLURL string `json:"html_url"`
+	}
+	body := map[string]any{"title": pr.Title, "body": pr.Body, "head": pr.Head, "base": pr.Base, "draft": pr.Draft}
+	if err := githubAPI(ctx, token, http.MethodPost, pulls, body, &created); err != nil {
+		return "", false, err
+	}
+	return created.HTMLURL, false, nil
+}
+
+// openPullRequestCLI is OpenPullRequest with the gh CLI, which knows the
+// repository and credentials itself.
+func openPullRequestCLI(ctx context.Context, pr PullRequest) (string, bool, error) {
+	existing, err := ghCLI(ctx, "", "pr", "list", "--head", pr.Head, "--state", "open", "--json", "url", "--jq", ".[0].url")
+	if err != nil {
+		return "", false, err
+	}
+	if existing != "" {
+		return existing, true, nil
+	}
+	args := []string{"pr", "create", "--head", pr.Head, "--base", pr.Base, "--title", pr.Title, "--body-file", "-"}
+	if pr.Draft {
+		args = append(args, "--draft")
+	}
+	out, err := ghCLI(ctx, pr.Body, args...)
+	if err != nil {
+		return "", false, err
+	}
+	u, err := lastURL(out)
+	return u, false, err
+}
+
+// IssueRef names a GitHub issue.
+type IssueRef struct {
+	Owner  string
+	Repo   string
+	Number int
+}
+
+// issueRefPattern matches owner/repo#123 and issue URLs.
+var issueRefPattern = regexp.MustCompile(`^(?:https?://[^/]+/)?([\w.-]+)/([\w.-]+)(?:#|/issues/)(\d+)/?$`)
+
+// ParseIssueRef parses an issue reference: owner/repo#123 or the issue's
+// URL.
+func ParseIssueRef(s string) (IssueRef, error) {
+	m := issueRefPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return IssueRef{}, fmt.Errorf("bad issue %q (want owner/repo#123)", s)
+	}
+	n, _ := strconv.Atoi(m[3])
+	return IssueRef{Owner: m[1], Repo: m[2], Number: n}, nil
+}
+
+func (r IssueRef) String() string {
+	return fmt.Sprintf("%s/%s#%d", r.Owner, r.Repo, r.Number)
+}
+
+// Issue is a GitHub issue, as fetched by FetchIssue.
+type Issue struct {
+	Ref      IssueRef
+	Title    string
+	Body     string
+	URL      string
+	Comments []IssueComment
+}
+
+// IssueComment is a comment on an Issue.
+type IssueComment struct {
+	Author string
+	Body   string
+}
+
+// FetchIssue fetches the title and body of an issue, and its comments if
+// comments is set, the way OpenPullRequest reaches GitHub.
+func FetchIssue(ctx context.Context, ref IssueRef, comments bool) (*Issue, error) {
+	token, err := githubAuth()
+	if err != nil {
+		return nil, err
+	}
+	issue := &Issue{Ref: ref}
+	if token == "" {
+		var data struct {
+			Title    string `json:"title"`
+			Body     string `json:"body"`
+			URL      string `json:"url"`
+			Comments []struct {
+				Author struct {
+					Login string `json:"login"`
+				} `json:"author"`
+				Body string `json:"body"`
+			} `json:"comments"`
+		}
+		fields := "title,body,url"
+		if comments {
+			fields += ",comments"
+		}
+		out, err := ghCLI(ctx, "", "issue", "view", strconv.Itoa(ref.Number), "--repo", ref.Owner+"/"+ref.Repo, "--json", fields)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(out), &data); err != nil {
+			return nil, fmt.Errorf("gh issue view: %w", err)
+		}
+		issue.Title, issue.Body, issue.URL = data.Title, data.Body, data.URL
+		for _, c := range data.Comments {
+			issue.Comments = append(issue.Comments, IssueComment{Author: c.Author.Login, Body: c.Body})
+		}
+		return issue, nil
+	}
+
+	path := fmt.Sprintf("/repos/%s/%s/issues/%d", ref.Owner, ref.Repo, ref.Number)
+	var data struct {
+		Title   string `json:"title"`
+		Body    string `json:"body"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := githubAPI(ctx, token, http.MethodGet, path, nil, &data); err != nil {
+		return nil, err
+	}
+	issue.Title, issue.Body, issue.URL = data.Title, data.Body, data.HTMLURL
+	if comments {
+		var list []struct {
+			User struct {
+				Login string `json:"login"`
+			} `json:"user"`
+			Body string `json:"body"`
+		}
+		if err := githubAPI(ctx, token, http.MethodGet, path+"/comments?per_page=100", nil, &list); err != nil {
+			return nil, err
+		}
+		for _, c := range list {
+			issue.Comments = append(issue.Comments, IssueComment{Author: c.User.Login, Body: c.Body})
+		}
+	}
+	return issue, nil
+}
+
+// Markdown renders the issue for the prompt.
+func (i *Issue) Markdown() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "## GitHub issue %s: %s\n\n", i.Ref, i.Title)
+	if body := strings.TrimSpace(i.Body); body != "" {
+		sb.WriteString(body + "\n")
+	} else {
+		sb.WriteString("(no description)\n")
+	}
+	if len(i.Comments) > 0 {
+		sb.WriteString("\n### Comments\n")
+		for _, c := range i.Comments {
+			fmt.Fprintf(&sb, "\n**%s:**\n\n%s\n", c.Author, strings.TrimSpace(c.Body))
+		}
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// CommentOnIssue posts a comment on an issue and returns its URL.
+func CommentOnIssue(ctx context.Context, ref IssueRef, body string) (string, error) {
+	token, err := githubAuth()
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		out, err := ghCLI(ctx, body, "issue", "comment", strconv.Itoa(ref.Number), "--repo", ref.Owner+"/"+ref.Repo, "--body-file", "-")
+		if err != nil {
+			return "", err
+		}
+		return lastURL(out)
+	}
+	var created struct {
+		HTMLURL string `json:"html_url"`
+	}
+	path := fmt.Sprintf("/repos/%s/%s/issues/%d/comments", ref.Owner, ref.Repo, ref.Number)
+	if err := githubAPI(ctx, token, http.MethodPost, path, map[string]any{"body": body}, &created); err != nil {
+		return "", err
+	}
+	return created.HTMLURL, nil
+}
+
+// githubAuth returns the token from GitHubTokenEnvs, or "" if the gh CLI is
+// to be used instead.
+func githubAuth() (string, error) {
+	for _, env := range GitHubTokenEnvs {
+		if token := os.Getenv(env); token != "" {
+			return token, nil
+		}
+	}
+	if _, err := exec.LookPath("gh"); err != nil {
+		return "", fmt.Errorf("set %s or install the gh CLI", strings.Join(GitHubTokenEnvs, " or "))
+	}
+	return "", nil
+}
+
+// githubAPI calls the GitHub REST API at path, sending body as JSON unless
+// nil, and decodes the response into out.
+func githubAPI(ctx context.Context, token, method, path string, body, out any) error {
+	api := strings.TrimRight(os.Getenv(GitHubAPIEnv), "/")
+	if api == "" {
+		api = DefaultGitHubAPI
+	}
+	var data io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		data = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, api+path, data)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if resp.StatusCode/100 != 2 {
+		var msg struct {
+			Message string `json:"message"`
+			Errors  []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		}
+		_ = json.Unmarshal(respBody, &msg)
+		for _, e := range msg.Errors {
+			msg.Message += ": " + e.Message
+		}
+		return fmt.Errorf("GitHub API: %s: %s", resp.Status, msg.Message)
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// ghCLI runs the gh CLI with stdin and returns its trimmed output.
+func ghCLI(ctx context.Context, stdin string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "gh", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("gh %s %s: %w: %s", args[0], args[1], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// lastURL returns the URL gh prints last, after any notices.
+func lastURL(out string) (string, error) {
+	lines := strings.Split(out, "\n")
+	if u := strings.TrimSpace(lines[len(lines)-1]); strings.HasPrefix(u, "http") {
+		return u, nil
+	}
+	return "", errors.New("gh printed no URL")
+}
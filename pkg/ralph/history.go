@@ -0,0 +1,335 @@
+package ralph
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// historySchema creates the tables of a history database.
+const historySchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id            INTEGER PRIMARY KEY,
+	pid           INTEGER NOT NULL,
+	dir           TEXT NOT NULL,
+	agent         TEXT NOT NULL,
+	agent_version TEXT NOT NULL DEFAULT '',
+	started_at    TEXT NOT NULL,
+	ended_at      TEXT,
+	iterations    INTEGER NOT NULL DEFAULT 0,
+	event         TEXT NOT NULL DEFAULT '',
+	stop_reason   TEXT NOT NULL DEFAULT '',
+	message       TEXT NOT NULL DEFAULT '',
+	cost_usd      REAL,
+	input_tokens  INTEGER,
+	output_tokens INTEGER,
+	label         TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS iterations (
+	id            INTEGER PRIMARY KEY,
+	run_id        INTEGER NOT NULL REFERENCES runs(id),
+	iteration     INTEGER NOT NULL,
+	agent         TEXT NOT NULL,
+	phase         TEXT NOT NULL DEFAULT '',
+	started_at    TEXT NOT NULL,
+	duration_ms   INTEGER NOT NULL,
+	exit_code     INTEGER,
+	output_bytes  INTEGER NOT NULL,
+	cost_usd      REAL,
+	input_tokens  INTEGER,
+	output_tokens INTEGER,
+	prompt_hash   TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS iterations_run ON iterations(run_id);
+CREATE TABLE IF NOT EXISTS prompts (
+	hash TEXT PRIMARY KEY,
+	text TEXT NOT NULL
+);
+`
+
+// historyColumns are the columns added since the first schema, which older
+// databases lack.
+var historyColumns = []struct{ table, column, definition string }{
+	{"runs", "label", "TEXT NOT NULL DEFAULT ''"},
+	{"iterations", "prompt_hash", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// HistoryRun is a run recorded in a History.
+type HistoryRun struct {
+	ID           int64      `json:"id"`
+	PID          int        `json:"pid"`
+	Dir          string     `json:"dir"`
+	Agent        string     `json:"agent"`
+	AgentVersion string     `json:"agent_version,omitempty"`
+	StartedAt    time.Time  `json:"started_at"`
+	EndedAt      *time.Time `json:"ended_at,omitempty"`
+	Iterations   int        `json:"iterations"`
+	// Event and StopReason are those of the run's final event, if it had one.
+	Event      string `json:"event,omitempty"`
+	StopReason string `json:"stop_reason,omitempty"`
+	Message    string `json:"message,omitempty"`
+	Usage      *Usage `json:"usage,omitempty"`
+	Label      string `json:"label,omitempty"`
+}
+
+// HistoryIteration is an iteration recorded in a History.
+type HistoryIteration struct {
+	Iteration   int       `json:"iteration"`
+	Agent       string    `json:"agent"`
+	Phase       string    `json:"phase,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	DurationMS  int64     `json:"duration_ms"`
+	ExitCode    *int      `json:"exit_code,omitempty"`
+	OutputBytes int       `json:"output_bytes"`
+	Usage       *Usage    `json:"usage,omitempty"`
+	// PromptHash identifies the iteration's prompt snapshot, if one was
+	// recorded; see History.Prompt.
+	PromptHash string `json:"prompt_hash,omitempty"`
+}
+
+// History keeps every run and iteration in a SQLite database, for a long
+// view of how loops go. Use Observe as (part of) Loop.OnEvent, and
+// ObservePrompt as Loop.OnPrompt to keep the prompt of every iteration.
+type History struct {
+	// Label is recorded with the run, e.g. to tell the variants of a bench
+	// apart.
+	Label string
+	// OnError, if set, is called when an event cannot be recorded.
+	OnError func(err error)
+
+	db *sql.DB
+
+	mu             sync.Mutex
+	runID          int64
+	iterationStart time.Time
+	promptHash     string
+}
+
+// OpenHistory opens the history database at path, creating it if need be.
+func OpenHistory(path string) (*History, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", "file:"+filepath.ToSlash(path)+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	// One connection: SQLite serializes writers anyway.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, c := range historyColumns {
+		var exists bool
+		if err := db.QueryRow(`SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = ?`, c.table, c.column).Scan(&exists); err == nil && !exists {
+			if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, c.table, c.column, c.definition)); err != nil {
+				db.Close()
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	}
+	return &History{db: db}, nil
+}
+
+// Close closes the database.
+func (h *History) Close() error {
+	return h.db.Close()
+}
+
+// Observe records ev: the first event of a run adds it, iteration_end
+// events add iterations and the final event completes the run.
+func (h *History) Observe(ev StatusEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.observe(ev); err != nil && h.OnError != nil {
+		h.OnError(err)
+	}
+}
+
+func (h *History) observe(ev StatusEvent) error {
+	if ev.Event == EventAgentOutput {
+		return nil
+	}
+	if h.runID == 0 {
+		dir, _ := os.Getwd()
+		started := ev.StartedAt
+		if started.IsZero() {
+			started = ev.Timestamp
+		}
+		res, err := h.db.Exec(`INSERT INTO runs (pid, dir, agent, agent_version, started_at, label) VALUES (?, ?, ?, ?, ?, ?)`,
+			ev.PID, dir, ev.Agent, ev.AgentVersion, formatTime(started), h.Label)
+		if err != nil {
+			return err
+		}
+		if h.runID, err = res.LastInsertId(); err != nil {
+			return err
+		}
+	}
+	switch {
+	case ev.Event == EventIterationStart:
+		h.iterationStart, h.promptHash = ev.Timestamp, ""
+	case ev.Event == EventIterationEnd:
+		started := h.iterationStart
+		if started.IsZero() {
+			started = ev.Timestamp.Add(-time.Duration(ev.DurationMS) * time.Millisecond)
+		}
+		cost, in, out := usageColumns(ev.Usage)
+		_, err := h.db.Exec(`INSERT INTO iterations (run_id, iteration, agent, phase, started_at, duration_ms, exit_code, output_bytes, cost_usd, input_tokens, output_tokens, prompt_hash)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			h.runID, ev.Iteration, ev.Agent, ev.Phase, formatTime(started), ev.DurationMS, ev.AgentExitCode, ev.OutputBytes, cost, in, out, h.promptHash)
+		if err != nil {
+			return err
+		}
+		_, err = h.db.Exec(`UPDATE runs SET iterations = ?, agent_version = CASE WHEN ? != '' THEN ? ELSE agent_version END WHERE id = ?`,
+			ev.Iteration, ev.AgentVersion, ev.AgentVersion, h.runID)
+		return err
+	case ev.Terminal():
+		cost, in, out := usageColumns(ev.TotalUsage)
+		_, err := h.db.Exec(`UPDATE runs SET ended_at = ?, iterations = ?, event = ?, stop_reason = ?, message = ?, cost_usd = ?, input_tokens = ?, output_tokens = ? WHERE id = ?`,
+			formatTime(ev.Timestamp), ev.Iteration, ev.Event, ev.StopReason, ev.Message, cost, in, out, h.runID)
+		return err
+	}
+	return nil
+}
+
+// ObservePrompt records the prompt of the current iteration, which is
+// stored once however many iterations use it.
+func (h *History) ObservePrompt(iteration int, prompt string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sum := sha256.Sum256([]byte(prompt))
+	hash := hex.EncodeToString(sum[:])
+	if _, err := h.db.Exec(`INSERT OR IGNORE INTO prompts (hash, text) VALUES (?, ?)`, hash, prompt); err != nil {
+		if h.OnError != nil {
+			h.OnError(err)
+		}
+		return
+	}
+	h.promptHash = hash
+}
+
+// Prompt returns the prompt recorded for an iteration of a run.
+func (h *History) Prompt(runID int64, iteration int) (string, error) {
+	var text string
+	err := h.db.QueryRow(`SELECT p.text FROM iterations i JOIN prompts p ON p.hash = i.prompt_hash
+		WHERE i.run_id = ? AND i.iteration = ? ORDER BY i.id DESC LIMIT 1`, runID, iteration).Scan(&text)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("no prompt recorded for iteration %d of run %d", iteration, runID)
+	}
+	return text, err
+}
+
+// usageColumns returns the usage columns for u, NULL if it is unknown.
+func usageColumns(u *Usage) (cost, in, out any) {
+	if u == nil {
+		return nil, nil, nil
+	}
+	return u.CostUSD, u.InputTokens, u.OutputTokens
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// Runs returns the latest runs, newest first; limit <= 0 returns all.
+func (h *History) Runs(limit int) ([]HistoryRun, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := h.db.Query(`SELECT id, pid, dir, agent, agent_version, started_at, ended_at, iterations, event, stop_reason, message, cost_usd, input_tokens, output_tokens, label
+		FROM runs ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var runs []HistoryRun
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// Run returns the run with the given ID and its iterations.
+func (h *History) Run(id int64) (HistoryRun, []HistoryIteration, error) {
+	row := h.db.QueryRow(`SELECT id, pid, dir, agent, agent_version, started_at, ended_at, iterations, event, stop_reason, message, cost_usd, input_tokens, output_tokens, label
+		FROM runs WHERE id = ?`, id)
+	run, err := scanRun(row)
+	if err == sql.ErrNoRows {
+		return HistoryRun{}, nil, fmt.Errorf("no run %d in the history", id)
+	}
+	if err != nil {
+		return HistoryRun{}, nil, err
+	}
+
+	rows, err := h.db.Query(`SELECT iteration, agent, phase, started_at, duration_ms, exit_code, output_bytes, cost_usd, input_tokens, output_tokens, prompt_hash
+		FROM iterations WHERE run_id = ? ORDER BY id`, id)
+	if err != nil {
+		return HistoryRun{}, nil, err
+	}
+	defer rows.Close()
+	var iterations []HistoryIteration
+	for rows.Next() {
+		var (
+			it       HistoryIteration
+			started  string
+			exitCode sql.NullInt64
+			usage    usageScan
+		)
+		if err := rows.Scan(&it.Iteration, &it.Agent, &it.Phase, &started, &it.DurationMS, &exitCode, &it.OutputBytes, &usage.cost, &usage.in, &usage.out, &it.PromptHash); err != nil {
+			return HistoryRun{}, nil, err
+		}
+		it.StartedAt, _ = time.Parse(time.RFC3339Nano, started)
+		if exitCode.Valid {
+			code := int(exitCode.Int64)
+			it.ExitCode = &code
+		}
+		it.Usage = usage.usage()
+		iterations = append(iterations, it)
+	}
+	return run, iterations, rows.Err()
+}
+
+// usageScan receives the usage columns of a row.
+type usageScan struct {
+	cost    sql.NullFloat64
+	in, out sql.NullInt64
+}
+
+func (u usageScan) usage() *Usage {
+	if !u.cost.Valid {
+		return nil
+	}
+	return &Usage{CostUSD: u.cost.Float64, InputTokens: int(u.in.Int64), OutputTokens: int(u.out.Int64)}
+}
+
+func scanRun(row interface{ Scan(...any) error }) (HistoryRun, error) {
+	var (
+		run     HistoryRun
+		started string
+		ended   sql.NullString
+		usage   usageScan
+	)
+	if err := row.Scan(&run.ID, &run.PID, &run.Dir, &run.Agent, &run.AgentVersion, &started, &ended, &run.Iterations,
+		&run.Event, &run.StopReason, &run.Message, &usage.cost, &usage.in, &usage.out, &run.Label); err != nil {
+		return HistoryRun{}, err
+	}
+	run.StartedAt, _ = time.Parse(time.RFC3339Nano, started)
+	if ended.Valid {
+		t, _ := time.Parse(time.RFC3339Nano, ended.String)
+		run.EndedAt = &t
+	}
+	run.Usage = usage.usage()
+	return run, nil
+}
@@ -0,0 +1,195 @@
+package ralph
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// ErrHookFailed is returned by Loop.Run when PostHook failed and
+// PostHookAbort is set.
+var ErrHookFailed = errors.New("post-hook failed")
+
+// Environment variables describing the iteration to PreHook and PostHook.
+const (
+	HookIterationEnv  = "RALPH_ITERATION"
+	HookAgentEnv      = "RALPH_AGENT"
+	HookOutputFileEnv = "RALPH_OUTPUT_FILE"
+	HookExitCodeEnv   = "RALPH_EXIT_CODE"
+	// HookPromptOldFileEnv and HookPromptNewFileEnv hold the prompt before
+	// and after a change, for PromptHook.
+	HookPromptOldFileEnv = "RALPH_PROMPT_OLD_FILE"
+	HookPromptNewFileEnv = "RALPH_PROMPT_NEW_FILE"
+)
+
+// preHook runs PreHook before the agent. A failure is reported but does not
+// keep the agent from running.
+func (l *Loop) preHook(ctx context.Context) {
+	if _, err := l.runHook(ctx, "pre-hook", l.PreHook, nil); err != nil {
+		l.logf("⚠️ Pre-hook failed: %v\n", err)
+	}
+}
+
+// postHook runs PostHook after the agent with its output in a temporary
+// file. It returns ErrHookFailed if the hook failed and PostHookAbort is set.
+func (l *Loop) postHook(ctx context.Context, result Result) error {
+	f, err := os.CreateTemp("", "ralph-output-*.txt")
+	if err != nil {
+		l.logf("⚠️ Cannot run the post-hook: %v\n", err)
+		return nil
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(result.Output)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		l.logf("⚠️ Cannot run the post-hook: %v\n", err)
+		return nil
+	}
+
+	output, err := l.runHook(ctx, "post-hook", l.PostHook, []string{
+		HookOutputFileEnv + "=" + f.Name(),
+		HookExitCodeEnv + "=" + strconv.Itoa(result.ExitCode),
+	})
+	if err == nil || ctx.Err() != nil {
+		return nil
+	}
+	if !l.PostHookAbort {
+		l.logf("⚠️ Post-hook failed: %v\n", err)
+		return nil
+	}
+	l.logf("\n💥 Post-hook failed: %v. Stopping.\n", err)
+	l.emitStop(EventErrorAbort, StopReasonHookFailed, tail(output, OutputTailBytes))
+	return fmt.Errorf("%w: %v", ErrHookFailed, err)
+}
+
+// runHook runs a hook command with the iteration's environment plus env,
+// echoing its output to the log.
+func (l *Loop) runHook(ctx context.Context, name, command string, env []string) (string, error) {
+	l.logf("\n🪝 Running %s: %s ...\n", name, command)
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	setProcessGroup(cmd)
+	cmd.Env = append(os.Environ(),
+		HookIterationEnv+"="+strconv.Itoa(l.iteration),
+		HookAgentEnv+"="+l.AgentName,
+	)
+	cmd.Env = append(cmd.Env, env...)
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		l.logf("%s", out)
+		if out[len(out)-1] != '\n' {
+			l.logf("\n")
+		}
+	}
+	return string(out), err
+}
+
+// Environment variables describing the event to LifecycleHooks, on top of
+// RALPH_ITERATION and RALPH_AGENT.
+const (
+	HookEventEnv      = "RALPH_EVENT"
+	HookStopReasonEnv = "RALPH_STOP_REASON"
+	HookMessageEnv    = "RALPH_MESSAGE"
+	HookPhaseEnv      = "RALPH_PHASE"
+	// HookEventJSONEnv holds the whole status event as JSON.
+	HookEventJSONEnv = "RALPH_EVENT_JSON"
+)
+
+// LifecycleHooks are shell commands run when a run starts and ends. They
+// are run synchronously from Observe, so a run does not end before its hook
+// has finished.
+type LifecycleHooks struct {
+	// OnStart runs with the first event of the run.
+	OnStart string `yaml:"on_start"`
+	// OnComplete runs when the task is complete.
+	OnComplete string `yaml:"on_complete"`
+	// OnError runs when the run gives up: agent errors, a failed post-hook,
+	// a blocked agent or a spent iteration, cost or time budget.
+	OnError string `yaml:"on_error"`
+	// OnStall runs when the loop stopped making progress.
+	OnStall string `yaml:"on_stall"`
+	// OnCancel runs when the run was cancelled or stopped.
+	OnCancel string `yaml:"on_cancel"`
+
+	// Output receives the hooks' output (default: discarded).
+	Output io.Writer `yaml:"-"`
+	// OnHookError, if set, is called when a hook fails.
+	OnHookError func(hook string, err error) `yaml:"-"`
+
+	started bool
+}
+
+// Names returns the names of the hooks that are set, e.g. "on_start".
+func (h *LifecycleHooks) Names() []string {
+	var names []string
+	for _, hook := range []struct{ name, command string }{
+		{"on_start", h.OnStart}, {"on_complete", h.OnComplete}, {"on_error", h.OnError}, {"on_stall", h.OnStall}, {"on_cancel", h.OnCancel},
+	} {
+		if hook.command != "" {
+			names = append(names, hook.name)
+		}
+	}
+	return names
+}
+
+// Observe runs the hooks ev calls for. Use it as (part of) Loop.OnEvent.
+func (h *LifecycleHooks) Observe(ev StatusEvent) {
+	if !h.started {
+		h.started = true
+		h.run("on_start", h.OnStart, ev)
+	}
+	switch ev.Event {
+	case EventComplete:
+		h.run("on_complete", h.OnComplete, ev)
+	case EventErrorAbort, EventBlocked, EventMaxIterations, EventBudgetExceeded, EventDeadlineExceeded, EventPromptTooLarge:
+		h.run("on_error", h.OnError, ev)
+	case EventStalled:
+		h.run("on_stall", h.OnStall, ev)
+	case EventCancelled, EventCancelledGraceful:
+		h.run("on_cancel", h.OnCancel, ev)
+	}
+}
+
+func (h *LifecycleHooks) run(name, command string, ev StatusEvent) {
+	if command == "" {
+		return
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		h.fail(name, err)
+		return
+	}
+	// The run may be ending because its context was cancelled; the hook
+	// still gets to run.
+	cmd := exec.CommandContext(context.Background(), "sh", "-c", command)
+	setProcessGroup(cmd)
+	cmd.Env = append(os.Environ(),
+		HookEventEnv+"="+ev.Event,
+		HookIterationEnv+"="+strconv.Itoa(ev.Iteration),
+		HookAgentEnv+"="+ev.Agent,
+		HookStopReasonEnv+"="+ev.StopReason,
+		HookMessageEnv+"="+ev.Message,
+		HookPhaseEnv+"="+ev.Phase,
+		HookEventJSONEnv+"="+string(data),
+	)
+	out := h.Output
+	if out == nil {
+		out = io.Discard
+	}
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Run(); err != nil {
+		h.fail(name, err)
+	}
+}
+
+func (h *LifecycleHooks) fail(name string, err error) {
+	if h.OnHookError != nil {
+		h.OnHookError(name, err)
+	}
+}
@@ -0,0 +1,164 @@
+package ralph
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFile is the conventional file of paths kept out of the prompt, see
+// Loop.Ignore.
+const IgnoreFile = ".ralphignore"
+
+// Ignore is a set of gitignore-style patterns. Paths are matched relative to
+// the directory of the file the patterns come from; paths outside of it are
+// never ignored.
+type Ignore struct {
+	dir   string
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// LoadIgnore reads the patterns of an ignore file. A missing file returns a
+// nil Ignore, which ignores nothing.
+func LoadIgnore(path string) (*Ignore, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	ig, err := ParseIgnore(dir, string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ig, nil
+}
+
+// ParseIgnore parses patterns in gitignore syntax, one per line, for paths
+// relative to dir: blank lines and lines starting with # are skipped, ! re-
+// includes what an earlier pattern excluded, a trailing / matches only
+// directories, a pattern with a slash before its end is anchored to dir and
+// ** matches any number of directories.
+func ParseIgnore(dir, text string) (*Ignore, error) {
+	ig := &Ignore{dir: dir}
+	for n, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if !strings.HasSuffix(line, `\ `) {
+			line = strings.TrimRight(line, " \t")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var r ignoreRule
+		if strings.HasPrefix(line, "!") {
+			r.negate, line = true, line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly, line = true, strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		re, err := ignorePattern(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad pattern %q: %w", n+1, line, err)
+		}
+		r.re = re
+		ig.rules = append(ig.rules, r)
+	}
+	return ig, nil
+}
+
+// ignorePattern turns a gitignore pattern, without its ! and trailing
+// slash, into a regexp matching slash-separated relative paths.
+func ignorePattern(p string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	if !strings.Contains(strings.TrimPrefix(p, "**/"), "/") {
+		sb.WriteString("(?:.*/)?")
+	}
+	p = strings.TrimPrefix(p, "/")
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; {
+		case strings.HasPrefix(p[i:], "**/") && (i == 0 || p[i-1] == '/'):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(p[i:], "**") && i+2 == len(p) && (i == 0 || p[i-1] == '/'):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(p[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated [")
+			}
+			class := p[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(p):
+			i++
+			sb.WriteString(regexp.QuoteMeta(p[i : i+1]))
+		default:
+			sb.WriteString(regexp.QuoteMeta(p[i : i+1]))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+// Match reports whether the file at path, absolute or relative to the
+// working directory, is ignored, either itself or through one of its
+// directories. A nil Ignore matches nothing.
+func (ig *Ignore) Match(path string) bool {
+	if ig == nil || len(ig.rules) == 0 {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(ig.dir, abs)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i := range parts {
+		if ig.match(strings.Join(parts[:i+1], "/"), i < len(parts)-1) {
+			return true
+		}
+	}
+	return false
+}
+
+// match applies the rules to one relative path; the last matching rule
+// decides.
+func (ig *Ignore) match(rel string, dir bool) bool {
+	ignored := false
+	for _, r := range ig.rules {
+		if (dir || !r.dirOnly) && r.re.MatchString(rel) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,73 @@
+package ralph
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnoreMatch(t *testing.T) {
+	dir := t.TempDir()
+	ig, err := ParseIgnore(dir, `# secrets and build output
+*.log
+!keep.log
+/build
+node_modules/
+docs/**/draft.md
+src/**
+!src/main.go
+file\ with\ space\ 
+\#hash
+[ab].txt
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "node_modules"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"app.log", true},
+		{"deep/dir/app.log", true},
+		{"keep.log", false},
+		{"build", true},
+		{"build/out.bin", true},
+		{"sub/build", false},
+		{"node_modules/pkg/index.js", true},
+		{"lib/node_modules/x.js", true},
+		{"docs/draft.md", true},
+		{"docs/a/b/draft.md", true},
+		{"draft.md", false},
+		{"src/util.go", true},
+		{"src/main.go", false},
+		{"file with space ", true},
+		{"#hash", true},
+		{"a.txt", true},
+		{"c.txt", false},
+		{"README.md", false},
+		{"../outside.log", false},
+	}
+	for _, tt := range tests {
+		if got := ig.Match(filepath.Join(dir, tt.path)); got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	var none *Ignore
+	if none.Match(filepath.Join(dir, "app.log")) {
+		t.Error("a nil Ignore matched")
+	}
+	if _, err := ParseIgnore(dir, "[unterminated"); err == nil {
+		t.Error("ParseIgnore accepted an unterminated [")
+	}
+}
+
+func TestLoadIgnoreMissing(t *testing.T) {
+	ig, err := LoadIgnore(filepath.Join(t.TempDir(), IgnoreFile))
+	if ig != nil || err != nil {
+		t.Errorf("LoadIgnore of a missing file = %v, %v; want nil, nil", ig, err)
+	}
+}
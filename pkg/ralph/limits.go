@@ -0,0 +1,111 @@
+package ralph
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// I/O scheduling classes for ResourceLimits.IOClass.
+const (
+	IOClassIdle       = "idle"
+	IOClassBestEffort = "best-effort"
+	IOClassRealtime   = "realtime"
+)
+
+// ResourceLimits bound what the agent and every process it starts may use.
+// On Linux CPU and memory limits are enforced with a cgroup (v2) per agent
+// run, whose processes are killed together when they run out of memory;
+// elsewhere only the memory limit can be applied, as an rlimit.
+type ResourceLimits struct {
+	// CPUs caps CPU time at this many CPUs' worth, e.g. 1.5 (0 = no limit).
+	CPUs float64
+	// MemoryBytes caps memory use (0 = no limit).
+	MemoryBytes int64
+	// Nice lowers (or, for root, raises) the agent's scheduling priority
+	// (0 = unchanged).
+	Nice int
+	// IOClass is the agent's I/O scheduling class: IOClassIdle,
+	// IOClassBestEffort or IOClassRealtime ("" = unchanged). Linux only.
+	IOClass string
+}
+
+// String describes the limits, e.g. "2 CPUs, 4.0 GiB memory, nice 10".
+func (r *ResourceLimits) String() string {
+	var parts []string
+	if r.CPUs > 0 {
+		parts = append(parts, strconv.FormatFloat(r.CPUs, 'f', -1, 64)+" CPUs")
+	}
+	if r.MemoryBytes > 0 {
+		parts = append(parts, fmt.Sprintf("%.1f GiB memory", float64(r.MemoryBytes)/(1<<30)))
+	}
+	if r.Nice != 0 {
+		parts = append(parts, fmt.Sprintf("nice %d", r.Nice))
+	}
+	if r.IOClass != "" {
+		parts = append(parts, r.IOClass+" I/O")
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (r *ResourceLimits) validate() error {
+	switch r.IOClass {
+	case "", IOClassIdle, IOClassBestEffort, IOClassRealtime:
+	default:
+		return fmt.Errorf("unknown I/O class %q (want %s, %s or %s)", r.IOClass, IOClassIdle, IOClassBestEffort, IOClassRealtime)
+	}
+	if r.CPUs < 0 || r.MemoryBytes < 0 {
+		return fmt.Errorf("negative resource limit")
+	}
+	return nil
+}
+
+// limitedRun is an agent run under ResourceLimits.
+type limitedRun struct {
+	// cgroup is the run's cgroup directory, if it has one.
+	cgroup string
+	// release frees what the run held once it is over.
+	release func()
+}
+
+// close releases the run's resources and reports whether it was killed
+// for running out of memory.
+func (r *limitedRun) close() (oomKilled bool) {
+	if r == nil {
+		return false
+	}
+	if r.cgroup != "" {
+		oomKilled = cgroupOOMKilled(r.cgroup)
+	}
+	if r.release != nil {
+		r.release()
+	}
+	return oomKilled
+}
+
+// prefix makes cmd run under the commands in args, e.g. nice, passing its
+// own command line on.
+func prefix(cmd *exec.Cmd, args ...string) error {
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return err
+	}
+	cmd.Args = append(args, cmd.Args...)
+	cmd.Path = path
+	return nil
+}
+
+// schedulingPrefix returns the nice and ionice command line that applies
+// Nice and IOClass, if any.
+func (r *ResourceLimits) schedulingPrefix() []string {
+	var args []string
+	if r.Nice != 0 {
+		args = append(args, "nice", "-n", strconv.Itoa(r.Nice))
+	}
+	if r.IOClass != "" {
+		class := map[string]string{IOClassRealtime: "1", IOClassBestEffort: "2", IOClassIdle: "3"}[r.IOClass]
+		args = append(args, "ionice", "-c", class)
+	}
+	return args
+}
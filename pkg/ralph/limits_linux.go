@@ -0,0 +1,198 @@
+//go:build linux
+
+package ralph
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupPeriod is the CPU accounting period of cpu.max, in microseconds.
+const cgroupPeriod = 100000
+
+var cgroupRuns atomic.Int64
+
+// Check reports how the limits will be enforced, or why they cannot be.
+func (r *ResourceLimits) Check() (string, error) {
+	if err := r.validate(); err != nil {
+		return "", err
+	}
+	if r.CPUs == 0 && r.MemoryBytes == 0 {
+		return "nice/ionice", nil
+	}
+	dir, err := r.newCgroup()
+	if err != nil {
+		return "", err
+	}
+	_ = os.Remove(dir)
+	return "cgroup under " + filepath.Dir(dir), nil
+}
+
+// apply sets cmd up to run under the limits: in a new cgroup with the CPU
+// and memory limits, started directly inside it, and under nice and ionice.
+func (r *ResourceLimits) apply(cmd *exec.Cmd) (*limitedRun, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	run := &limitedRun{}
+	if r.CPUs > 0 || r.MemoryBytes > 0 {
+		dir, err := r.newCgroup()
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(dir)
+		if err != nil {
+			_ = os.Remove(dir)
+			return nil, err
+		}
+		run.cgroup = dir
+		run.release = func() {
+			f.Close()
+			// Kill whatever the agent left running, then remove the group.
+			_ = os.WriteFile(filepath.Join(dir, "cgroup.kill"), []byte("1"), 0)
+			for i := 0; i < 50 && os.Remove(dir) != nil; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(f.Fd())
+	}
+	if args := r.schedulingPrefix(); len(args) > 0 && cmd.Err == nil {
+		if err := prefix(cmd, args...); err != nil {
+			run.close()
+			return nil, err
+		}
+	}
+	return run, nil
+}
+
+// newCgroup creates a cgroup for one agent run with the CPU and memory
+// limits. It goes next to ralph's own cgroup when that is allowed, as in a
+// systemd user session, or below it when ralph runs in the root cgroup of
+// a container.
+func (r *ResourceLimits) newCgroup() (string, error) {
+	own, err := ownCgroup()
+	if err != nil {
+		return "", err
+	}
+	var controllers []string
+	if r.CPUs > 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if r.MemoryBytes > 0 {
+		controllers = append(controllers, "memory")
+	}
+	name := fmt.Sprintf("ralph-agent-%d-%d", os.Getpid(), cgroupRuns.Add(1))
+
+	var errs []error
+	for _, parent := range []string{own, filepath.Dir(own)} {
+		if !strings.HasPrefix(parent, cgroupRoot) {
+			continue
+		}
+		dir := filepath.Join(parent, name)
+		if err := r.setupCgroup(parent, dir, controllers); err != nil {
+			_ = os.Remove(dir)
+			errs = append(errs, err)
+			continue
+		}
+		return dir, nil
+	}
+	return "", fmt.Errorf("cannot create a cgroup for the resource limits (run ralph in a systemd user session or a container, or as root): %w", errors.Join(errs...))
+}
+
+func (r *ResourceLimits) setupCgroup(parent, dir string, controllers []string) error {
+	control := filepath.Join(parent, "cgroup.subtree_control")
+	for _, c := range controllers {
+		if !hasController(control, c) {
+			if err := os.WriteFile(control, []byte("+"+c), 0); err != nil {
+				return fmt.Errorf("enable %s controller in %s: %w", c, parent, err)
+			}
+		}
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	write := func(file, value string) error {
+		return os.WriteFile(filepath.Join(dir, file), []byte(value), 0)
+	}
+	if r.CPUs > 0 {
+		quota := int64(r.CPUs * cgroupPeriod)
+		if err := write("cpu.max", fmt.Sprintf("%d %d", quota, cgroupPeriod)); err != nil {
+			return err
+		}
+	}
+	if r.MemoryBytes > 0 {
+		if err := write("memory.max", strconv.FormatInt(r.MemoryBytes, 10)); err != nil {
+			return err
+		}
+		// Swapping would only slow the agent down instead of stopping it;
+		// not every kernel has swap accounting.
+		_ = write("memory.swap.max", "0")
+		// The agent and its tools go down together.
+		_ = write("memory.oom.group", "1")
+	}
+	return nil
+}
+
+// ownCgroup returns the directory of ralph's own cgroup v2.
+func ownCgroup() (string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("cgroups v2 are not mounted at %s", cgroupRoot)
+	}
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return filepath.Join(cgroupRoot, path), nil
+		}
+	}
+	return "", fmt.Errorf("ralph is not in a cgroup v2")
+}
+
+// hasController reports whether the cgroup file lists controller.
+func hasController(file, controller string) bool {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return false
+	}
+	for _, c := range strings.Fields(string(data)) {
+		if c == controller {
+			return true
+		}
+	}
+	return false
+}
+
+// cgroupOOMKilled reports whether the kernel killed a process of the cgroup
+// for exceeding its memory limit.
+func cgroupOOMKilled(dir string) bool {
+	f, err := os.Open(filepath.Join(dir, "memory.events"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if n, ok := strings.CutPrefix(sc.Text(), "oom_kill "); ok {
+			return n != "0"
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+//go:build !linux && !windows
+
+package ralph
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// Check reports how the limits will be enforced, or why they cannot be.
+func (r *ResourceLimits) Check() (string, error) {
+	if err := r.validate(); err != nil {
+		return "", err
+	}
+	if r.CPUs > 0 {
+		return "", fmt.Errorf("CPU limits need cgroups (Linux); use the docker sandbox's --sandbox-cpus")
+	}
+	if r.IOClass != "" {
+		return "", fmt.Errorf("I/O scheduling classes are only supported on Linux")
+	}
+	if r.MemoryBytes > 0 {
+		return "rlimit", nil
+	}
+	return "nice", nil
+}
+
+// apply sets cmd up to run under the limits: a shell sets the memory limit
+// as an rlimit for the agent and everything it starts, under nice.
+func (r *ResourceLimits) apply(cmd *exec.Cmd) (*limitedRun, error) {
+	if _, err := r.Check(); err != nil {
+		return nil, err
+	}
+	if cmd.Err != nil {
+		return &limitedRun{}, nil
+	}
+	if r.MemoryBytes > 0 {
+		kb := strconv.FormatInt(r.MemoryBytes/1024, 10)
+		if err := prefix(cmd, "sh", "-c", `ulimit -v `+kb+` && exec "$@"`, "sh"); err != nil {
+			return nil, err
+		}
+	}
+	if args := r.schedulingPrefix(); len(args) > 0 {
+		if err := prefix(cmd, args...); err != nil {
+			return nil, err
+		}
+	}
+	return &limitedRun{}, nil
+}
+
+func cgroupOOMKilled(string) bool { return false }
@@ -0,0 +1,20 @@
+//go:build windows
+
+package ralph
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// Check reports how the limits will be enforced, or why they cannot be.
+func (r *ResourceLimits) Check() (string, error) {
+	return "", fmt.Errorf("resource limits are not supported on Windows")
+}
+
+func (r *ResourceLimits) apply(cmd *exec.Cmd) (*limitedRun, error) {
+	_, err := r.Check()
+	return nil, err
+}
+
+func cgroupOOMKilled(string) bool { return false }
@@ -0,0 +1,202 @@
+package ralph
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Severities of a PromptFinding.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Rules a PromptLint checks.
+const (
+	LintStopSignal = "stop-signal"
+	LintSize       = "size"
+	LintMissing    = "missing-file"
+	LintConflict   = "conflict"
+	LintCompletion = "completion-criteria"
+)
+
+// PromptFinding is a problem PromptLint found in a prompt.
+type PromptFinding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	// Line is the 1-based line the finding is about, 0 for the whole prompt.
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+func (f PromptFinding) String() string {
+	if f.Line > 0 {
+		return fmt.Sprintf("line %d: %s", f.Line, f.Message)
+	}
+	return f.Message
+}
+
+// PromptLint checks a prompt for the common reasons loops go wrong.
+type PromptLint struct {
+	// StopSignals are the signals (or done file) the prompt should tell the
+	// agent about; empty skips the check, e.g. when a detector decides.
+	StopSignals []string
+	// ContextTokens is the agent's context window; 0 skips the size check.
+	ContextTokens int
+	// Dir resolves the files the prompt refers to (default: the working
+	// directory).
+	Dir string
+	// Ignore are files the prompt may refer to before they exist, e.g. the
+	// done file.
+	Ignore []string
+}
+
+// EstimateTokens estimates the tokens text takes, at about four bytes a
+// token as with cl100k-style tokenizers on English and code.
+func EstimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// Check lints text and returns its findings, in line order within each rule.
+func (p PromptLint) Check(text string) []PromptFinding {
+	var findings []PromptFinding
+	lines := strings.Split(text, "\n")
+
+	if len(p.StopSignals) > 0 {
+		mentioned := false
+		for _, s := range p.StopSignals {
+			mentioned = mentioned || strings.Contains(text, s)
+		}
+		if !mentioned {
+			findings = append(findings, PromptFinding{Rule: LintStopSignal, Severity: SeverityWarning,
+				Message: fmt.Sprintf("never mentions the stop signal %s; the agent cannot end the loop", strings.Join(p.StopSignals, " or "))})
+		}
+	}
+
+	if p.ContextTokens > 0 {
+		tokens := EstimateTokens(text)
+		switch {
+		case tokens > p.ContextTokens:
+			findings = append(findings, PromptFinding{Rule: LintSize, Severity: SeverityError,
+				Message: fmt.Sprintf("about %d tokens, more than the agent's %d-token context window", tokens, p.ContextTokens)})
+		case tokens > p.ContextTokens/2:
+			findings = append(findings, PromptFinding{Rule: LintSize, Severity: SeverityWarning,
+				Message: fmt.Sprintf("about %d tokens, over half the agent's %d-token context window; little is left for the work", tokens, p.ContextTokens)})
+		}
+	}
+
+	findings = append(findings, p.missingFiles(lines)...)
+	findings = append(findings, conflictingDirectives(lines)...)
+
+	if !completionCriteria.MatchString(text) {
+		findings = append(findings, PromptFinding{Rule: LintCompletion, Severity: SeverityWarning,
+			Message: `says nothing about when the task is complete; add completion criteria, e.g. "You are done when all tests pass"`})
+	}
+	return findings
+}
+
+var (
+	// fileReference matches `code spans` and Markdown link targets.
+	fileReference = regexp.MustCompile("`([^`\\s]+)`|\\]\\(([^)\\s]+)\\)")
+	// fileExtensions are those of the files a prompt is likely to name;
+	// other dotted words in code spans are more often identifiers.
+	fileExtensions = map[string]bool{
+		"md": true, "txt": true, "go": true, "mod": true, "py": true, "js": true, "ts": true, "tsx": true, "jsx": true,
+		"json": true, "yaml": true, "yml": true, "toml": true, "rs": true, "java": true, "kt": true, "rb": true, "php": true,
+		"sh": true, "c": true, "h": true, "cc": true, "cpp": true, "cs": true, "swift": true, "css": true, "html": true,
+		"sql": true, "xml": true, "csv": true, "ini": true, "cfg": true, "lock": true, "log": true,
+	}
+	// lineSuffix is the line (and column) in references like main.go:42.
+	lineSuffix = regexp.MustCompile(`:\d+(:\d+)?$`)
+	// creates marks lines that ask for a file to be made, which need not
+	// exist yet.
+	creates = regexp.MustCompile(`(?i)\b(create|add|write|generate|new|make|rename|move)(s|d)?\b`)
+)
+
+// missingFiles reports the files the prompt refers to that do not exist.
+func (p PromptLint) missingFiles(lines []string) []PromptFinding {
+	var findings []PromptFinding
+	ignore := map[string]bool{}
+	for _, f := range p.Ignore {
+		ignore[filepath.Clean(f)] = true
+	}
+	seen := map[string]bool{}
+	for i, line := range lines {
+		if creates.MatchString(line) {
+			continue
+		}
+		for _, m := range fileReference.FindAllStringSubmatch(line, -1) {
+			ref := m[1] + m[2]
+			if i := strings.IndexAny(ref, "#?"); i >= 0 && m[2] != "" {
+				ref = ref[:i]
+			}
+			ref = strings.TrimRight(lineSuffix.ReplaceAllString(ref, ""), ".,;:")
+			if !looksLikeFile(ref) || ignore[filepath.Clean(ref)] || seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			path := ref
+			if !filepath.IsAbs(path) && p.Dir != "" {
+				path = filepath.Join(p.Dir, path)
+			}
+			if _, err := os.Stat(path); err != nil {
+				findings = append(findings, PromptFinding{Rule: LintMissing, Severity: SeverityWarning, Line: i + 1,
+					Message: fmt.Sprintf("refers to %s, which does not exist", ref)})
+			}
+		}
+	}
+	return findings
+}
+
+// looksLikeFile reports whether a reference in a prompt names a file or
+// directory rather than a URL, command, pattern or identifier.
+func looksLikeFile(ref string) bool {
+	if ref == "" || strings.Contains(ref, "://") || strings.HasPrefix(ref, "mailto:") || strings.HasPrefix(ref, "-") ||
+		strings.Contains(ref, "...") || strings.ContainsAny(ref, "*?[]{}$<>|()='\"") {
+		return false
+	}
+	if strings.Contains(strings.Trim(ref, "/"), "/") || strings.HasPrefix(ref, "./") {
+		return true
+	}
+	ext := strings.TrimPrefix(filepath.Ext(ref), ".")
+	return fileExtensions[strings.ToLower(ext)]
+}
+
+// directive matches an instruction that always or never holds.
+var directive = regexp.MustCompile(`(?i)\b(always|must not|mustn't|must|never|do not|don't|should not|shouldn't|should)\s+([a-z][a-z'-]*(?:\s+[a-z][a-z'-]*){0,2})`)
+
+// conflictingDirectives reports instructions that say both to do and not
+// to do the same thing, e.g. "Always commit" and "Never commit".
+func conflictingDirectives(lines []string) []PromptFinding {
+	type use struct {
+		line int
+		text string
+	}
+	positive, negative := map[string]use{}, map[string]use{}
+	var findings []PromptFinding
+	for i, line := range lines {
+		for _, m := range directive.FindAllStringSubmatch(line, -1) {
+			action := strings.ToLower(m[2])
+			mine, other := positive, negative
+			switch strings.ToLower(m[1]) {
+			case "must not", "mustn't", "never", "do not", "don't", "should not", "shouldn't":
+				mine, other = negative, positive
+			}
+			if _, ok := mine[action]; !ok {
+				mine[action] = use{i + 1, m[0]}
+			}
+			if u, ok := other[action]; ok {
+				findings = append(findings, PromptFinding{Rule: LintConflict, Severity: SeverityWarning, Line: i + 1,
+					Message: fmt.Sprintf("%q contradicts %q on line %d", m[0], u.text, u.line)})
+				delete(other, action)
+			}
+		}
+	}
+	return findings
+}
+
+// completionCriteria matches a prompt that says when the task is done.
+var completionCriteria = regexp.MustCompile(`(?im)^#+\s*(completion|done|acceptance)|\b(criteria|definition of done|(done|complete|completed|finished)\s+(when|once|if)|(is|are)\s+(\w+\s+)?(done|complete|finished)\b|when\s+(all|every|the\s+tests?)\b|until\s+(all|every|the))`)
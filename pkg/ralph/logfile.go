@@ -0,0 +1,114 @@
+package ralph
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultLogMaxBytes is the size at which a log file is rotated.
+const DefaultLogMaxBytes = 10 << 20
+
+// LogFile is an io.Writer appending to a file that is rotated to Path+".1"
+// once it grows past MaxBytes. It is safe for concurrent use, so loop and
+// agent output can share it.
+type LogFile struct {
+	Path string
+	// MaxBytes is the rotation threshold (0 = never rotate).
+	MaxBytes int64
+	// Timestamps prefixes every line with the time it was written.
+	Timestamps bool
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	midLine bool
+}
+
+// Write appends p, rotating the file first if it would exceed MaxBytes.
+func (l *LogFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	data := p
+	if l.Timestamps {
+		data = l.stamp(p)
+	}
+
+	if l.f == nil {
+		if err := l.open(); err != nil {
+			return 0, err
+		}
+	}
+	if l.MaxBytes > 0 && l.size > 0 && l.size+int64(len(data)) > l.MaxBytes {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := l.f.Write(data)
+	l.size += int64(n)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the underlying file.
+func (l *LogFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// stamp prefixes each line starting in p with the current time.
+func (l *LogFile) stamp(p []byte) []byte {
+	prefix := []byte(time.Now().Format("2006-01-02T15:04:05.000Z07:00") + " ")
+	var out bytes.Buffer
+	for len(p) > 0 {
+		if !l.midLine {
+			out.Write(prefix)
+		}
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			out.Write(p)
+			l.midLine = true
+			break
+		}
+		out.Write(p[:i+1])
+		p = p[i+1:]
+		l.midLine = false
+	}
+	return out.Bytes()
+}
+
+func (l *LogFile) open() error {
+	f, err := os.OpenFile(l.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+func (l *LogFile) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	l.f = nil
+	if err := os.Rename(l.Path, l.Path+".1"); err != nil {
+		return err
+	}
+	return l.open()
+}
@@ -0,0 +1,1297 @@
+package ralph
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrMaxIterations is returned by Loop.Run when MaxIterations is reached
+// without the task completing.
+var ErrMaxIterations = errors.New("max iterations reached")
+
+// ErrStopped is returned by Loop.Run when Stop was called and the loop
+// wound down at an iteration boundary.
+var ErrStopped = errors.New("loop stopped")
+
+// ErrTooManyErrors is returned by Loop.Run when the agent failed
+// MaxConsecutiveErrors times in a row.
+var ErrTooManyErrors = errors.New("too many consecutive agent errors")
+
+// ErrBudgetExceeded is returned by Loop.Run when the agent's reported usage
+// reached MaxCostUSD or MaxTokens.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// ErrDeadlineExceeded is returned by Loop.Run when the run has been going
+// for MaxDuration.
+var ErrDeadlineExceeded = errors.New("max duration reached")
+
+// ErrBlocked is returned by Loop.Run when the agent printed one of
+// BlockedSignals: it is stuck and needs a human.
+var ErrBlocked = errors.New("agent is blocked")
+
+// ErrPromptTooLarge is returned by Loop.Run when the prompt was over
+// PromptMaxTokens and PromptOverflow said to refuse it.
+var ErrPromptTooLarge = errors.New("prompt too large")
+
+// ErrStalled is returned by Loop.Run when StallAfter consecutive iterations
+// made no progress.
+var ErrStalled = errors.New("loop stalled")
+
+// Loop repeatedly runs Agent against the prompt until the check command
+// passes, the agent prints a stop signal, or a limit is hit.
+type Loop struct {
+	// Agent does the work; AgentName labels it in status events.
+	Agent     Agent
+	AgentName string
+	// AgentVersion, if known, is reported in status events so runs can be
+	// reproduced with the same agent.
+	AgentVersion string
+
+	// PromptFiles are files or glob patterns re-read and concatenated before
+	// every iteration (default PROMPT.md).
+	PromptFiles []string
+	// PromptText, if set, is used as the prompt instead of PromptFiles.
+	PromptText string
+	// Task, if set, is the one item of a larger plan this run works on. The
+	// prompt can place it with {{task}}; otherwise it is appended.
+	Task string
+	// Issue, if set, is the GitHub issue the run works on, rendered by
+	// Issue.Markdown. The prompt can place it with {{issue}}; otherwise it
+	// is appended.
+	Issue string
+	// Check is a shell command; the loop completes as soon as it exits 0.
+	Check string
+	// ErrorLogFile receives the tail of a failed check or validation
+	// (default ralph-error.log). It is fed back into the next prompt.
+	ErrorLogFile string
+	// Carryover feeds the previous iteration's output into the next prompt:
+	// CarryoverTail or CarryoverSummary ("" = off). The prompt can place it
+	// with {{carryover}}; otherwise it is appended. CarryoverBytes caps it
+	// (default DefaultCarryoverBytes).
+	Carryover      string
+	CarryoverBytes int
+	// InjectDiff adds the changes made since the run started to every
+	// prompt: InjectDiffStat or InjectDiffFull ("" = off). The prompt can
+	// place them with {{diffstat}} and {{diff}} instead. InjectDiffBytes caps
+	// the diff (default DefaultInjectDiffBytes).
+	InjectDiff      string
+	InjectDiffBytes int
+	// MemoryFile, if set, collects the lines the agent prints starting with
+	// MemoryNotePrefix and is fed into every prompt, so that learnings
+	// outlive the iteration. The prompt can place it with {{memory}};
+	// otherwise it is appended.
+	MemoryFile string
+	// SpecsDir, if set, is a directory of specs re-read before every
+	// iteration and added to the prompt, each file under its path; see
+	// ReadSpecs for SpecsInclude and SpecsExclude. The prompt can place them
+	// with {{specs}}; otherwise they follow it.
+	SpecsDir     string
+	SpecsInclude []string
+	SpecsExclude []string
+	// Ignore, if set, keeps paths out of the prompt: files matched by the
+	// globs of PromptFiles, spec files, and the changes in injected diffs
+	// and in the reviewer's diff. Prompt files named without a glob are
+	// always read. See LoadIgnore.
+	Ignore *Ignore
+	// SignalFooter appends instructions telling the agent when and how to
+	// print the stop signal (or create DoneFile) and the blocked signal, so
+	// the prompt itself can stick to the task. The prompt can place them
+	// with {{signals}}.
+	SignalFooter bool
+	// FeedbackLines is how many trailing lines of failure output are kept
+	// (default MaxLogLines).
+	FeedbackLines int
+	// PromptWarnTokens and PromptMaxTokens bound the estimated size of the
+	// prompt sent to the agent, context included (see EstimateTokens): the
+	// loop warns once it grows over PromptWarnTokens and does what
+	// PromptOverflow says over PromptMaxTokens, as a prompt that overflows
+	// the agent's context window degrades its work. Zero means no bound.
+	PromptWarnTokens int
+	PromptMaxTokens  int
+	// PromptOverflow is PromptOverflowRefuse (the default), which stops the
+	// loop with ErrPromptTooLarge, or PromptOverflowTruncate.
+	PromptOverflow string
+
+	// StopSignals are literal tokens that mark the task complete.
+	StopSignals []string
+	// StopRegex, if set, marks the task complete when it matches the output.
+	StopRegex *regexp.Regexp
+	// Detector, if set, decides when the task is complete instead of
+	// StopSignals and StopRegex, which still end phases before the last.
+	Detector Detector
+	// BlockedSignals are literal tokens the agent prints when it is stuck
+	// and needs a human, e.g. RALPH_BLOCKED followed by the reason. They stop
+	// the loop with ErrBlocked.
+	BlockedSignals []string
+	// DoneFile, if set, is a file the agent can create instead of printing
+	// a stop signal, for agents that summarize or sanitize their output. Its
+	// contents become the completion message. The loop deletes it when the
+	// run starts and whenever it has read it.
+	DoneFile string
+	// StopImmediately kills the agent as soon as the line with a stop signal
+	// shows up in its live output instead of waiting for it to exit. It
+	// needs an Agent that implements StreamingAgent.
+	StopImmediately bool
+	// Validate is a shell command that must exit 0 for a stop signal to be
+	// honored. Its failing output is written to ErrorLogFile instead.
+	Validate string
+
+	// PreHook and PostHook are shell commands run before and after every
+	// agent run. RALPH_ITERATION and RALPH_AGENT describe the iteration;
+	// PostHook also gets the agent's output in the file RALPH_OUTPUT_FILE and
+	// its exit code in RALPH_EXIT_CODE. A failing PostHook stops the loop
+	// with ErrHookFailed if PostHookAbort is set.
+	PreHook       string
+	PostHook      string
+	PostHookAbort bool
+
+	// Guard is a shell command run after every iteration. When it fails, its
+	// output is fed back like a failed check and a stop signal printed in
+	// that iteration is ignored.
+	Guard string
+	// RevertOnFail undoes the changes an iteration made to the git work tree
+	// (including its commits) when Guard fails.
+	RevertOnFail bool
+
+	// Review, if set, is called after every iteration and decides whether
+	// the loop keeps the iteration's changes, reverts them or stops.
+	Review func(IterationReview) ReviewDecision
+	// PromptHook and ApprovePrompt gate a prompt that changed during the
+	// run, e.g. because the agent edited it or SetPrompt was called.
+	// PromptHook is a shell command that approves the change by exiting 0,
+	// with the old and new prompts in the files RALPH_PROMPT_OLD_FILE and
+	// RALPH_PROMPT_NEW_FILE; ApprovePrompt is asked next. ReviewSkip keeps
+	// the previous prompt until the prompt changes again; ReviewAbort stops
+	// the loop.
+	PromptHook    string
+	ApprovePrompt func(PromptChange) ReviewDecision
+	// NoPromptCommands turns off the shell and file template functions of
+	// the prompt. They run on the host, so they would get around a sandbox
+	// or an egress proxy the agent is held to. They are off anyway while
+	// the prompt differs from the one the run started with, unless the
+	// change was approved; a prompt set with SetPrompt counts as a change.
+	NoPromptCommands bool
+
+	// Reviewer, if set, is a second agent that reviews the work done since
+	// the run started: every ReviewEvery iterations (0 = never) and before a
+	// stop signal is accepted. A rejection is fed back like a failed check.
+	Reviewer     Agent
+	ReviewerName string
+	// ReviewPrompt instructs the reviewer (default DefaultReviewPrompt); the
+	// task and the diff are appended to it.
+	ReviewPrompt string
+	ReviewEvery  int
+
+	// Phases, if set, are worked through in order, each with its own prompt,
+	// stop signals and iteration limit. A phase's stop signal moves on to the
+	// next phase; Check, Validate and Reviewer only apply to the last one.
+	Phases []Phase
+
+	// MaxIterations stops the loop with ErrMaxIterations (0 = unlimited).
+	MaxIterations int
+	// MaxCostUSD and MaxTokens stop the loop with ErrBudgetExceeded once the
+	// usage reported by the agents reaches them (0 = unlimited). Agents that
+	// report no usage are not limited.
+	MaxCostUSD float64
+	MaxTokens  int
+	// MaxDuration stops the loop with ErrDeadlineExceeded at the first
+	// iteration boundary after the run has been going this long; a running
+	// agent is not interrupted (0 = unlimited).
+	MaxDuration time.Duration
+	// IterationTimeout kills an agent run that takes longer (0 = no limit).
+	IterationTimeout time.Duration
+	// Sleep is the rest between iterations (default DefaultSleep).
+	Sleep time.Duration
+	// MaxConsecutiveErrors aborts the loop with ErrTooManyErrors after this
+	// many agent runs in a row that exited non-zero, timed out or printed
+	// nothing; rate-limited runs are not counted (0 = disabled).
+	MaxConsecutiveErrors int
+	// Rotation, if set, takes turns: iteration n runs Rotation[(n-1) %
+	// len(Rotation)]. List an agent several times to weight it.
+	Rotation []NamedAgent
+	// Fallbacks are the agents to switch to, in order, when the current one
+	// hits a rate limit or fails FallbackAfter times in a row (default
+	// DefaultFallbackAfter). The last one sticks.
+	Fallbacks     []NamedAgent
+	FallbackAfter int
+	// RateLimitPattern recognizes rate-limit and quota errors in the output
+	// of a failed agent run (default DefaultRateLimitPattern).
+	RateLimitPattern *regexp.Regexp
+	// RateLimitWait is the rest after a rate-limited run; it doubles for
+	// every consecutive one up to MaxRateLimitWait (default DefaultRateLimitWait).
+	RateLimitWait time.Duration
+	// MaxBackoff caps the exponentially growing rest after consecutive agent
+	// errors (default DefaultMaxBackoff).
+	MaxBackoff time.Duration
+
+	// StallAfter aborts the loop with ErrStalled after this many consecutive
+	// iterations that changed nothing in the git work tree or repeated the
+	// previous iteration's output verbatim (0 = disabled).
+	StallAfter int
+
+	// ArtifactsDir, if set, receives an iter-NNNN directory per iteration
+	// holding the rendered prompt, the full agent output and run metadata.
+	ArtifactsDir string
+
+	// GitCommit stages and commits all changes after every iteration, but
+	// for the loop's own files (ErrorLogFile, DoneFile, StateFile and
+	// ArtifactsDir) and GitCommitOptions.Exclude.
+	GitCommit        bool
+	GitCommitOptions GitCommitOptions
+
+	// StateFile, if set, receives a State checkpoint after every iteration
+	// and when the run ends. See Resume.
+	StateFile string
+
+	// Log receives human-readable progress lines (default: discarded).
+	Log io.Writer
+	// Verbose adds prompt sizes and timings to Log.
+	Verbose bool
+	// Redactor, if set, masks secrets in Log, status events, the prompts
+	// passed to OnPrompt, artifacts and ErrorLogFile. Give the agent the
+	// same one for its output.
+	Redactor *Redactor
+	// OnEvent, if set, is called for every status event.
+	OnEvent func(StatusEvent)
+	// OnPrompt, if set, is called with the exact prompt of every iteration,
+	// templates rendered and context added, before the agent runs.
+	OnPrompt func(iteration int, prompt string)
+
+	stopInit       sync.Once
+	stopOnce       sync.Once
+	stopCh         chan struct{}
+	wakeInit       sync.Once
+	wakeCh         chan struct{}
+	pauseMu        sync.Mutex
+	resumeCh       chan struct{} // non-nil while paused
+	promptMu       sync.Mutex
+	promptOverride string
+	promptUpdated  bool
+	iteration      int
+	agentErrors    int
+	lastRun        *runStats
+	rateLimits     int
+	startTime      time.Time
+	stalls         int
+	lastOutputHash [sha256.Size]byte
+	promptHash     string
+	// instructions is the prompt promptHash is of; rejectedPromptHash is
+	// that of a change PromptHook or ApprovePrompt declined.
+	instructions       string
+	rejectedPromptHash string
+	// trustedPromptHash is that of the prompt the run or phase started
+	// with, or of the last approved change to it.
+	trustedPromptHash string
+	// promptWarned is set while the prompt is over PromptWarnTokens.
+	promptWarned   bool
+	previousOutput string
+	// usage totals the Usage of every agent run; hasUsage is set once an
+	// agent reported any.
+	usage    Usage
+	hasUsage bool
+	// payload is what the agent printed after the stop signal that
+	// completed the run, see CompletionPayload.
+	payload map[string]any
+	// runBase is HEAD when the run started, for diffs of the run's work.
+	runBase    string
+	reviewedAt int
+	phase      int
+	phaseStart int
+	// basePromptFiles, basePromptText and baseStopSignals are the loop's own
+	// settings, used by phases that do not override them.
+	basePromptFiles []string
+	basePromptText  string
+	baseStopSignals []string
+	// resumedPromptHash is the prompt hash of the checkpoint passed to
+	// Resume, to notice a prompt edited in between.
+	resumedPromptHash string
+}
+
+// Run executes the loop. It returns nil once the task is complete,
+// ErrMaxIterations when the iteration budget is spent, ErrStopped after a
+// graceful Stop, and ctx.Err() when cancelled.
+func (l *Loop) Run(ctx context.Context) error {
+	l.setDefaults()
+	if l.startTime.IsZero() {
+		l.startTime = time.Now()
+		// A leftover from an earlier run must not end this one.
+		if l.DoneFile != "" {
+			_ = os.Remove(l.DoneFile)
+		}
+	}
+	if l.runBase == "" {
+		l.runBase, _ = git(ctx, "rev-parse", "--verify", "HEAD")
+	}
+	if len(l.Phases) > 0 {
+		if l.basePromptFiles == nil {
+			l.basePromptFiles, l.basePromptText, l.baseStopSignals = l.PromptFiles, l.PromptText, l.StopSignals
+		}
+		l.enterPhase()
+	}
+
+	for {
+		if err := l.interrupted(ctx); err != nil {
+			return err
+		}
+		if err := l.waitWhilePaused(ctx); err != nil {
+			return err
+		}
+
+		// 1. Run Verification (Physics Check)
+		if l.Check != "" && l.finalPhase() {
+			l.logf("\n🔎 Running check: %s ...\n", l.Check)
+			checkStart := time.Now()
+			output, err := runShellCommand(ctx, l.Check)
+			l.debugf("🔧 Check took %s\n", time.Since(checkStart).Round(time.Millisecond))
+
+			if err == nil {
+				// Success! Clean up the error log so we don't confuse future runs
+				_ = os.Remove(l.ErrorLogFile)
+				l.logf("\n✅ Verification PASSED! Task complete.\n")
+				l.emitStop(EventComplete, StopReasonCheckPassed, "verification passed")
+				return nil
+			}
+
+			// Failure! PERSIST the error to a file (The Ralph Way)
+			l.logf("❌ Verification FAILED. Writing error tail to disk...\n")
+			l.writeErrorLog(output)
+		}
+
+		if elapsed := time.Since(l.startTime); l.MaxDuration > 0 && elapsed >= l.MaxDuration {
+			l.logf("\n⏰ Reached the max duration (%s). Stopping.\n", l.MaxDuration)
+			l.emitStop(EventDeadlineExceeded, StopReasonDeadlineExceeded, fmt.Sprintf("stopped after %s", elapsed.Round(time.Second)))
+			return ErrDeadlineExceeded
+		}
+		if reason := l.budgetExceeded(); reason != "" {
+			l.logf("\n💸 Budget exceeded: %s. Stopping.\n", reason)
+			l.emitStop(EventBudgetExceeded, StopReasonBudgetExceeded, reason)
+			return ErrBudgetExceeded
+		}
+		if p := l.currentPhase(); p != nil && p.MaxIterations > 0 && l.iteration-l.phaseStart >= p.MaxIterations {
+			if !l.finalPhase() {
+				l.logf("\n⏭️  Phase %s used up its %d iterations. Moving on.\n", p.Name, p.MaxIterations)
+				l.completePhase(fmt.Sprintf("stopped after %d iterations", p.MaxIterations))
+				continue
+			}
+			l.logf("\n🛑 Reached max iterations of phase %s (%d). Stopping.\n", p.Name, p.MaxIterations)
+			l.emitStop(EventMaxIterations, StopReasonMaxIterations, fmt.Sprintf("phase %s stopped after %d iterations", p.Name, p.MaxIterations))
+			return ErrMaxIterations
+		}
+		if l.MaxIterations > 0 && l.iteration >= l.MaxIterations {
+			l.logf("\n🛑 Reached max iterations (%d). Stopping.\n", l.MaxIterations)
+			l.emitStop(EventMaxIterations, StopReasonMaxIterations, fmt.Sprintf("stopped after %d iterations", l.iteration))
+			return ErrMaxIterations
+		}
+
+		// 2. Read Base Prompt
+		updated := l.takePromptUpdate()
+		if updated {
+			l.logf("\n📝 Using the prompt updated during the run.\n")
+			l.emit(EventPromptUpdated, "")
+		}
+		instructions, err := l.readPrompt()
+		if err != nil {
+			l.logf("❌ Error: %v\n", err)
+			if !l.rest(ctx, l.Sleep) {
+				return l.interrupted(ctx)
+			}
+			continue
+		}
+
+		// 3. Construct Prompt with Context
+		// The agent may edit the prompt itself; a new phase's prompt is
+		// not a change.
+		hash, promptChanged := hashString(instructions), ""
+		if l.promptHash == "" || l.iteration <= l.phaseStart {
+			l.trustedPromptHash = hash
+			if updated {
+				// Text from SetPrompt is not what the run started with.
+				l.trustedPromptHash = hashString("")
+			}
+		}
+		switch {
+		case l.resumedPromptHash != "":
+			if l.resumedPromptHash != hash {
+				promptChanged = "since the checkpoint"
+			}
+			l.resumedPromptHash = ""
+		case hash == l.rejectedPromptHash:
+			instructions, hash = l.instructions, l.promptHash
+		case l.promptHash != "" && l.promptHash != hash && l.iteration > l.phaseStart:
+			promptChanged = fmt.Sprintf("since iteration %d", l.iteration)
+			if l.PromptHook == "" && l.ApprovePrompt == nil {
+				break
+			}
+			switch l.approvePrompt(ctx, PromptChange{Iteration: l.iteration + 1, Old: l.instructions, New: instructions}) {
+			case ReviewApprove:
+				l.trustedPromptHash = hash
+			case ReviewSkip:
+				l.logf("🚫 Keeping the previous prompt: the change was not approved.\n")
+				l.emit(EventPromptRejected, "prompt changed "+promptChanged+"; keeping the previous version")
+				l.rejectedPromptHash = hash
+				instructions, hash, promptChanged = l.instructions, l.promptHash, ""
+			case ReviewAbort:
+				l.Stop()
+				return l.interrupted(ctx)
+			}
+		}
+		l.promptHash, l.instructions = hash, instructions
+		fullPrompt, ok := l.limitPrompt(l.buildPrompt(ctx, instructions))
+		if !ok {
+			reason := fmt.Sprintf("the prompt is about %d tokens, over the limit of %d", EstimateTokens(fullPrompt), l.PromptMaxTokens)
+			l.logf("\n📏 Not sending the prompt: %s. Stopping.\n", reason)
+			l.emitStop(EventPromptTooLarge, StopReasonPromptTooLarge, reason)
+			return ErrPromptTooLarge
+		}
+
+		l.iteration++
+		if len(l.Rotation) > 0 {
+			l.rotate()
+			l.logf("\n⚡ Running Agent iteration %d (%s)...\n", l.iteration, l.AgentName)
+		} else {
+			l.logf("\n⚡ Running Agent iteration %d...\n", l.iteration)
+		}
+		l.debugf("🔧 Prompt: %d bytes (%d instructions, %d context)\n", len(fullPrompt), len(instructions), len(fullPrompt)-len(instructions))
+		l.emit(EventIterationStart, "")
+		if promptChanged != "" {
+			l.logf("📝 The prompt changed %s.\n", promptChanged)
+			l.emit(EventPromptChanged, "prompt changed "+promptChanged)
+		}
+		if l.OnPrompt != nil {
+			l.OnPrompt(l.iteration, l.Redactor.Redact(fullPrompt))
+		}
+		if l.PreHook != "" {
+			l.preHook(ctx)
+		}
+
+		// 4. Run Agent (Fresh Malloc)
+		agentCtx, cancelAgent := ctx, context.CancelFunc(func() {})
+		if l.IterationTimeout > 0 {
+			agentCtx, cancelAgent = context.WithTimeout(ctx, l.IterationTimeout)
+		}
+		var treeBefore string
+		if l.StallAfter > 0 {
+			treeBefore, _ = WorkTreeFingerprint(ctx)
+		}
+		var snapshot *gitSnapshot
+		if l.Review != nil || (l.Guard != "" && l.RevertOnFail) {
+			if snapshot, err = takeGitSnapshot(ctx, l.StateFile, l.ErrorLogFile, l.ArtifactsDir, l.MemoryFile); err != nil {
+				l.logf("⚠️ Cannot snapshot the work tree, this iteration will not be reverted: %v\n", err)
+			}
+		}
+		agentStart := time.Now()
+		result, err := l.runAgent(agentCtx, fullPrompt)
+		agentDuration := time.Since(agentStart)
+		timedOut := agentCtx.Err() == context.DeadlineExceeded
+		cancelAgent()
+		l.debugf("\n🔧 Agent finished in %s with exit code %d, %d bytes of output\n", agentDuration.Round(time.Millisecond), result.ExitCode, len(result.Output))
+
+		if err != nil {
+			if ctx.Err() != nil {
+				l.emitStop(EventCancelled, StopReasonCancelled, "")
+				return ctx.Err()
+			}
+			if timedOut {
+				l.logf("\n⏱️ Agent timed out after %s. Killed.\n", l.IterationTimeout)
+				l.emit(EventTimeout, fmt.Sprintf("agent exceeded %s", l.IterationTimeout))
+			} else if result.OOMKilled {
+				l.logf("\n💥 Agent ran out of memory and was killed.\n")
+				l.emit(EventOOMKilled, "agent exceeded its memory limit")
+				l.writeErrorLog("The previous iteration was killed for exceeding its memory limit. Use less memory: avoid loading large files at once and run fewer processes in parallel.")
+			} else {
+				l.logf("\n⚠️ Agent process exited with error: %v\n", err)
+			}
+		} else if strings.TrimSpace(result.Output) == "" {
+			l.logf("\n⚠️ Agent produced no output.\n")
+		}
+		// Read before anything commits or reverts the work tree.
+		doneMessage, done := l.takeDoneFile()
+
+		l.addUsage(result.Usage)
+		if result.Usage != nil {
+			l.logf("💰 Iteration %d: %s (run total: %s)\n", l.iteration, result.Usage, l.usage)
+		} else if (l.MaxCostUSD > 0 || l.MaxTokens > 0) && !l.hasUsage && l.iteration == 1 {
+			l.logf("⚠️ %s reports no usage; the budget cannot be enforced.\n", l.AgentName)
+		}
+		l.lastRun = &runStats{
+			durationMS:  agentDuration.Milliseconds(),
+			outputBytes: len(result.Output),
+			exitCode:    result.ExitCode,
+			outputTail:  tail(result.Output, OutputTailBytes),
+			usage:       result.Usage,
+		}
+		l.emitEvent(l.lastRun.apply(StatusEvent{Event: EventIterationEnd}))
+
+		// Rate limits get their own wait and do not count as agent failures.
+		rateLimited := err != nil && !timedOut && l.RateLimitPattern.MatchString(result.Output)
+		switch {
+		case rateLimited:
+		case err != nil || strings.TrimSpace(result.Output) == "":
+			l.agentErrors++
+		default:
+			l.agentErrors = 0
+		}
+
+		if l.PostHook != "" && ctx.Err() == nil {
+			if err := l.postHook(ctx, result); err != nil {
+				return err
+			}
+		}
+
+		guardFailed := l.Guard != "" && ctx.Err() == nil && !l.guard(ctx, snapshot)
+
+		stalled := l.StallAfter > 0 && l.checkStall(ctx, treeBefore, result.Output)
+		if l.StallAfter <= 0 {
+			l.lastOutputHash = sha256.Sum256([]byte(result.Output))
+		}
+		if l.Carryover != "" {
+			l.previousOutput = result.Output
+		}
+		l.checkpoint("")
+
+		if l.ArtifactsDir != "" {
+			meta := IterationMeta{
+				Iteration:    l.iteration,
+				Agent:        l.AgentName,
+				AgentVersion: l.AgentVersion,
+				StartedAt:    agentStart,
+				DurationMS:   agentDuration.Milliseconds(),
+				ExitCode:     result.ExitCode,
+				TimedOut:     timedOut,
+			}
+			if err != nil {
+				meta.Error = err.Error()
+			}
+			l.saveArtifacts(fullPrompt, result, meta)
+		}
+
+		agentCommits := l.recordAgentCommits(ctx, result.Commits)
+		if l.GitCommit {
+			l.commitIteration(ctx, result.Output, agentCommits)
+		}
+
+		skipped := false
+		if l.Review != nil && ctx.Err() == nil {
+			switch l.review(ctx, snapshot, result.Output) {
+			case ReviewSkip:
+				skipped = true
+			case ReviewAbort:
+				l.Stop()
+				return l.interrupted(ctx)
+			}
+		}
+
+		// Notes survive a reverted iteration: what did not work is worth
+		// remembering too.
+		if l.MemoryFile != "" {
+			l.recordNotes(result.Output)
+		}
+
+		// 5. Check for the stop signal
+		if !timedOut && !guardFailed && !skipped {
+			signal, detected, payload, ok := l.detectCompletion(ctx, result.Output, doneMessage, done)
+			switch {
+			case ok && !l.finalPhase():
+				l.logf("\n✅ Agent reported %s. Phase %s complete.\n", signal, l.currentPhase().Name)
+				l.completePhase(detected)
+				stalled = false
+			case ok && l.validate(ctx, signal) &&
+				(l.Reviewer == nil || l.peerReview(ctx, instructions, fmt.Sprintf("Agent reported %s", signal))):
+				_ = os.Remove(l.ErrorLogFile)
+				l.logf("\n✅ Agent reported %s. Task complete.\n", signal)
+				if done && doneMessage != "" {
+					l.logf("💬 %s\n", doneMessage)
+				}
+				l.payload = payload
+				l.emitStop(EventComplete, StopReasonStopSignal, detected)
+				return nil
+			}
+		}
+
+		if !skipped {
+			if signal, reason, ok := DetectBlocked(result.Output, l.BlockedSignals); ok {
+				l.logf("\n🚧 Agent reported %s: %s\nStopping for a human to help.\n", signal, reason)
+				l.emitStop(EventBlocked, StopReasonBlocked, reason)
+				return ErrBlocked
+			}
+		}
+
+		if l.Reviewer != nil && l.ReviewEvery > 0 && l.iteration%l.ReviewEvery == 0 && l.reviewedAt != l.iteration && ctx.Err() == nil {
+			l.peerReview(ctx, instructions, fmt.Sprintf("Iteration %d done", l.iteration))
+		}
+
+		if stalled {
+			l.logf("\n🧊 No progress for %d consecutive iterations. Stopping.\n", l.stalls)
+			l.emitStop(EventStalled, StopReasonStalled, fmt.Sprintf("no progress for %d consecutive iterations", l.stalls))
+			return ErrStalled
+		}
+
+		switched := l.fallBack(rateLimited)
+		if l.MaxConsecutiveErrors > 0 && l.agentErrors >= l.MaxConsecutiveErrors {
+			reason := "no output"
+			if err != nil {
+				reason = err.Error()
+			}
+			l.logf("\n💥 Agent failed %d times in a row (last: %s). Giving up.\n", l.agentErrors, reason)
+			l.emitStop(EventErrorAbort, StopReasonAgentErrors, fmt.Sprintf("agent failed %d consecutive times, last: %s", l.agentErrors, reason))
+			return ErrTooManyErrors
+		}
+
+		if err := l.interrupted(ctx); err != nil {
+			return err
+		}
+
+		delay := l.backoff()
+		switch {
+		case switched:
+			l.logf("\n🔄 Iteration finished. Resting for %s...\n", delay)
+		case rateLimited:
+			l.rateLimits++
+			delay = l.rateLimitDelay()
+			l.logf("\n🚦 Agent hit a rate limit. Waiting %s before retrying...\n", delay)
+			l.emitEvent(StatusEvent{Event: EventRateLimited, Message: fmt.Sprintf("waiting %s", delay), WaitMS: delay.Milliseconds()})
+		default:
+			l.rateLimits = 0
+			if l.agentErrors > 0 && delay > l.Sleep {
+				l.logf("\n🔄 Iteration finished. Backing off for %s after %d consecutive agent errors...\n", delay.Round(time.Millisecond), l.agentErrors)
+			} else {
+				l.logf("\n🔄 Iteration finished. Resting for %s...\n", delay)
+			}
+		}
+
+		if !l.rest(ctx, delay) {
+			return l.interrupted(ctx)
+		}
+	}
+}
+
+// Iteration returns the number of agent iterations started so far.
+func (l *Loop) Iteration() int {
+	return l.iteration
+}
+
+// Payload returns the JSON object the agent printed after the stop signal
+// that completed the run, or nil.
+func (l *Loop) Payload() map[string]any {
+	return l.payload
+}
+
+// Usage returns the cost and token totals of the run so far, and whether
+// the agent reported any.
+func (l *Loop) Usage() (Usage, bool) {
+	return l.usage, l.hasUsage
+}
+
+// addUsage adds an agent run's usage to the run totals.
+func (l *Loop) addUsage(u *Usage) {
+	if u != nil {
+		l.usage.Add(*u)
+		l.hasUsage = true
+	}
+}
+
+func (l *Loop) setDefaults() {
+	if len(l.PromptFiles) == 0 {
+		l.PromptFiles = []string{PromptFile}
+	}
+	if l.ErrorLogFile == "" {
+		l.ErrorLogFile = ErrorLogFile
+	}
+	if l.InjectDiffBytes <= 0 {
+		l.InjectDiffBytes = DefaultInjectDiffBytes
+	}
+	if l.CarryoverBytes <= 0 {
+		l.CarryoverBytes = DefaultCarryoverBytes
+	}
+	if l.FeedbackLines <= 0 {
+		l.FeedbackLines = MaxLogLines
+	}
+	if l.Sleep == 0 {
+		l.Sleep = DefaultSleep
+	}
+	if l.RateLimitPattern == nil {
+		l.RateLimitPattern = DefaultRateLimitPattern
+	}
+	if l.RateLimitWait <= 0 {
+		l.RateLimitWait = DefaultRateLimitWait
+	}
+	if l.FallbackAfter <= 0 {
+		l.FallbackAfter = DefaultFallbackAfter
+	}
+	if l.MaxBackoff < l.Sleep {
+		l.MaxBackoff = max(DefaultMaxBackoff, l.Sleep)
+	}
+	if l.Log == nil {
+		l.Log = io.Discard
+	}
+}
+
+// Stop asks the loop to finish the current iteration and then return
+// ErrStopped. It is safe to call from any goroutine, more than once.
+func (l *Loop) Stop() {
+	l.stopOnce.Do(func() { close(l.stopChan()) })
+}
+
+// Pause asks the loop to wait after the current iteration until Continue is
+// called. It is safe to call from any goroutine.
+func (l *Loop) Pause() {
+	l.pauseMu.Lock()
+	defer l.pauseMu.Unlock()
+	if l.resumeCh == nil {
+		l.resumeCh = make(chan struct{})
+	}
+}
+
+// Paused reports whether the loop has been asked to pause.
+func (l *Loop) Paused() bool {
+	l.pauseMu.Lock()
+	defer l.pauseMu.Unlock()
+	return l.resumeCh != nil
+}
+
+// Continue lets a paused loop go on with the next iteration.
+func (l *Loop) Continue() {
+	l.pauseMu.Lock()
+	defer l.pauseMu.Unlock()
+	if l.resumeCh != nil {
+		close(l.resumeCh)
+		l.resumeCh = nil
+	}
+}
+
+// waitWhilePaused blocks while the loop is paused. A Stop or cancellation
+// ends the wait and is returned like from interrupted.
+func (l *Loop) waitWhilePaused(ctx context.Context) error {
+	l.pauseMu.Lock()
+	resume := l.resumeCh
+	l.pauseMu.Unlock()
+	if resume == nil {
+		return nil
+	}
+
+	l.logf("\n⏸️  Paused after iteration %d. Waiting to be resumed...\n", l.iteration)
+	l.emit(EventPaused, "")
+	select {
+	case <-resume:
+		l.logf("▶️  Resumed.\n")
+		l.emit(EventResumed, "")
+		return nil
+	case <-l.stopChan():
+	case <-ctx.Done():
+	}
+	return l.interrupted(ctx)
+}
+
+func (l *Loop) stopChan() chan struct{} {
+	l.stopInit.Do(func() { l.stopCh = make(chan struct{}) })
+	return l.stopCh
+}
+
+func (l *Loop) stopRequested() bool {
+	select {
+	case <-l.stopChan():
+		return true
+	default:
+		return false
+	}
+}
+
+// interrupted returns the error Run should exit with if ctx was cancelled
+// or Stop was called, emitting the matching status event; otherwise nil.
+func (l *Loop) interrupted(ctx context.Context) error {
+	if ctx.Err() != nil {
+		l.emitStop(EventCancelled, StopReasonCancelled, "")
+		return ctx.Err()
+	}
+	if l.stopRequested() {
+		l.logf("\n👋 Stopped gracefully after %d iterations.\n", l.iteration)
+		l.emitStop(EventCancelledGraceful, StopReasonStopped, "")
+		return ErrStopped
+	}
+	return nil
+}
+
+// backoff returns how long to rest before the next iteration: Sleep normally,
+// doubling with every consecutive agent error up to MaxBackoff, with jitter
+// so that several loops sharing an agent account do not retry in lockstep.
+func (l *Loop) backoff() time.Duration {
+	if l.agentErrors == 0 {
+		return l.Sleep
+	}
+	d := l.Sleep
+	for i := 0; i < l.agentErrors && d < l.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, l.MaxBackoff)
+	// Full jitter over the upper half: [d/2, d).
+	return d/2 + rand.N(d/2+1)
+}
+
+// rateLimitDelay doubles RateLimitWait for every consecutive rate-limited
+// run, up to MaxRateLimitWait.
+func (l *Loop) rateLimitDelay() time.Duration {
+	d := l.RateLimitWait
+	for i := 1; i < l.rateLimits && d < MaxRateLimitWait; i++ {
+		d *= 2
+	}
+	return min(d, max(MaxRateLimitWait, l.RateLimitWait))
+}
+
+// rest waits for d, returning false if ctx is cancelled or Stop is called
+// first. It ends early at the MaxDuration deadline.
+func (l *Loop) rest(ctx context.Context, d time.Duration) bool {
+	if l.MaxDuration > 0 {
+		d = max(0, min(d, time.Until(l.startTime.Add(l.MaxDuration))))
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-l.stopChan():
+		return false
+	case <-l.wakeChan():
+		return true
+	case <-time.After(d):
+		return true
+	}
+}
+
+// SkipRest cuts the current (or next) rest between iterations short. It is
+// safe to call from any goroutine.
+func (l *Loop) SkipRest() {
+	select {
+	case l.wakeChan() <- struct{}{}:
+	default:
+	}
+}
+
+func (l *Loop) wakeChan() chan struct{} {
+	l.wakeInit.Do(func() { l.wakeCh = make(chan struct{}, 1) })
+	return l.wakeCh
+}
+
+func (l *Loop) logf(format string, args ...any) {
+	io.WriteString(l.Log, l.Redactor.Redact(fmt.Sprintf(format, args...)))
+}
+
+// debugf logs only in verbose mode.
+func (l *Loop) debugf(format string, args ...any) {
+	if l.Verbose {
+		l.logf(format, args...)
+	}
+}
+
+// runStats are the measurements of the most recent agent run.
+type runStats struct {
+	durationMS  int64
+	outputBytes int
+	exitCode    int
+	outputTail  string
+	usage       *Usage
+}
+
+// apply copies the measurements into ev.
+func (r *runStats) apply(ev StatusEvent) StatusEvent {
+	if r == nil {
+		return ev
+	}
+	exitCode := r.exitCode
+	ev.DurationMS = r.durationMS
+	ev.OutputBytes = r.outputBytes
+	ev.AgentExitCode = &exitCode
+	ev.OutputTail = r.outputTail
+	ev.Usage = r.usage
+	return ev
+}
+
+// tail returns at most the last n bytes of s, trimmed of surrounding
+// whitespace and without splitting a UTF-8 sequence.
+func tail(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= n {
+		return s
+	}
+	s = s[len(s)-n:]
+	for len(s) > 0 && !utf8.RuneStart(s[0]) {
+		s = s[1:]
+	}
+	return s
+}
+
+// emitStop reports a final event, carrying the stop reason and the last
+// agent run's measurements.
+func (l *Loop) emitStop(event, reason, message string) {
+	l.checkpoint(reason)
+	l.emitEvent(l.lastRun.apply(StatusEvent{Event: event, Message: message, StopReason: reason, Payload: l.payload}))
+}
+
+func (l *Loop) emit(event, message string) {
+	l.emitEvent(StatusEvent{Event: event, Message: message})
+}
+
+// emitEvent fills in the run-wide fields of ev and reports it.
+func (l *Loop) emitEvent(ev StatusEvent) {
+	if l.OnEvent == nil {
+		return
+	}
+	ev.Agent = l.AgentName
+	ev.AgentVersion = l.AgentVersion
+	ev.Iteration = l.iteration
+	ev.Timestamp = time.Now()
+	ev.PID = os.Getpid()
+	ev.StartedAt = l.startTime
+	if p := l.currentPhase(); p != nil {
+		ev.Phase = p.Name
+	}
+	if l.hasUsage {
+		total := l.usage
+		ev.TotalUsage = &total
+	}
+	ev.Message = l.Redactor.Redact(ev.Message)
+	ev.OutputTail = l.Redactor.Redact(ev.OutputTail)
+	ev.Payload = l.Redactor.redactPayload(ev.Payload)
+	l.OnEvent(ev)
+}
+
+// validate runs the Validate command after the agent claimed completion and
+// reports whether the claim holds.
+func (l *Loop) validate(ctx context.Context, signal string) bool {
+	if l.Validate == "" {
+		return true
+	}
+
+	l.logf("\n🔎 Agent reported %s. Validating: %s ...\n", signal, l.Validate)
+	output, err := runShellCommand(ctx, l.Validate)
+	if err == nil {
+		return true
+	}
+
+	l.logf("❌ Validation FAILED. Ignoring %s and writing error tail to disk...\n", signal)
+	l.writeErrorLog(output)
+	l.emit(EventValidationFailed, fmt.Sprintf("%s rejected: %v", signal, err))
+	return false
+}
+
+// guard runs the Guard command after an iteration and reports whether it
+// passed. On failure its output becomes feedback for the next iteration and,
+// if snapshot is set, the iteration's changes are reverted.
+func (l *Loop) guard(ctx context.Context, snapshot *gitSnapshot) bool {
+	l.logf("\n🛡️  Running guard: %s ...\n", l.Guard)
+	output, err := runShellCommand(ctx, l.Guard)
+	if err == nil {
+		return true
+	}
+
+	l.logf("❌ Guard FAILED.\n")
+	l.emit(EventGuardFailed, err.Error())
+	if snapshot != nil {
+		if rerr := snapshot.restore(ctx); rerr != nil {
+			l.logf("⚠️ Failed to revert the iteration: %v\n", rerr)
+		} else {
+			l.logf("⏪ Reverted the changes of iteration %d.\n", l.iteration)
+			l.emit(EventReverted, fmt.Sprintf("work tree reset to %.12s", snapshot.head))
+			output = fmt.Sprintf("The changes from the previous iteration broke `%s` and were reverted.\n\n%s", l.Guard, output)
+		}
+	}
+	l.writeErrorLog(output)
+	return false
+}
+
+// review hands the finished iteration to the Review callback and carries
+// out a skip by reverting the iteration's changes.
+func (l *Loop) review(ctx context.Context, snapshot *gitSnapshot, output string) ReviewDecision {
+	r := IterationReview{Iteration: l.iteration, Output: output}
+	if snapshot != nil {
+		r.DiffStat = snapshot.diffStat(ctx)
+	}
+	decision := l.Review(r)
+	if decision != ReviewSkip {
+		return decision
+	}
+
+	if snapshot == nil {
+		l.logf("⚠️ Cannot revert iteration %d outside a git repository; keeping its changes.\n", l.iteration)
+	} else if err := snapshot.restore(ctx); err != nil {
+		l.logf("⚠️ Failed to revert the iteration: %v\n", err)
+	} else {
+		l.logf("⏪ Reverted the changes of iteration %d.\n", l.iteration)
+		l.emit(EventReverted, "rejected in review")
+	}
+	l.writeErrorLog("The changes from the previous iteration were rejected by the reviewer and reverted. Try a different approach.")
+	return decision
+}
+
+// checkStall updates the consecutive no-progress counter and reports whether
+// it has reached StallAfter. An iteration made no progress if the work tree
+// fingerprint is unchanged or its output is identical to the previous one.
+func (l *Loop) checkStall(ctx context.Context, treeBefore, output string) bool {
+	outputHash := sha256.Sum256([]byte(output))
+	sameOutput := l.iteration > 1 && outputHash == l.lastOutputHash
+	l.lastOutputHash = outputHash
+
+	sameTree := false
+	if treeBefore != "" {
+		treeAfter, err := WorkTreeFingerprint(ctx)
+		sameTree = err == nil && treeAfter == treeBefore
+	}
+
+	if sameTree || sameOutput {
+		l.stalls++
+	} else {
+		l.stalls = 0
+	}
+	return l.stalls >= l.StallAfter
+}
+
+// commitIteration records the iteration's changes as a git commit. Failures
+// are logged but never stop the loop.
+func (l *Loop) commitIteration(ctx context.Context, output string, agentCommits int) {
+	opts := l.GitCommitOptions
+	opts.Exclude = append([]string{l.ErrorLogFile, l.DoneFile, l.StateFile, l.ArtifactsDir}, opts.Exclude...)
+	hash, err := GitCommitAll(ctx, iterationCommitMessage(l.iteration, l.AgentName, output), opts)
+	switch {
+	case err != nil:
+		l.logf("⚠️ Failed to commit iteration %d: %v\n", l.iteration, err)
+	case hash == "" && agentCommits > 0:
+		// The agent committed everything itself.
+	case hash == "":
+		l.logf("📭 No changes to commit.\n")
+	default:
+		l.logf("📝 Committed iteration %d as %s\n", l.iteration, hash)
+		l.emit(EventCommitted, hash)
+	}
+}
+
+// recordAgentCommits reports the commits the agent made itself and returns
+// how many there were. Hashes that do not name a commit, say because the
+// model merely wrote something that looks like the agent's report, are
+// ignored.
+func (l *Loop) recordAgentCommits(ctx context.Context, hashes []string) int {
+	n := 0
+	for _, hash := range hashes {
+		if _, err := git(ctx, "rev-parse", "--verify", "--quiet", hash+"^{commit}"); err != nil {
+			continue
+		}
+		n++
+		l.logf("📝 %s committed %s\n", l.AgentName, hash)
+		l.emit(EventCommitted, hash)
+	}
+	return n
+}
+
+func (l *Loop) writeErrorLog(content string) {
+	lines := strings.Split(content, "\n")
+
+	var finalContent string
+
+	if len(lines) > l.FeedbackLines {
+		startIndex := len(lines) - l.FeedbackLines
+		tail := strings.Join(lines[startIndex:], "\n")
+		finalContent = fmt.Sprintf("... [TRUNCATED: Removed %d lines of earlier output. Showing last %d lines] ...\n%s", startIndex, l.FeedbackLines, tail)
+	} else {
+		finalContent = content
+	}
+
+	err := os.WriteFile(l.ErrorLogFile, []byte(l.Redactor.Redact(finalContent)), 0644)
+	if err != nil {
+		l.logf("⚠️ Failed to write error log: %v\n", err)
+	}
+}
+
+// detectCompletion checks for completion: done reports the done file, whose
+// contents were doneMessage; otherwise output is checked with the Detector
+// in the last phase and with the stop signals before it. It returns what
+// was found, a message for the status event and the stop signal's payload.
+func (l *Loop) detectCompletion(ctx context.Context, output, doneMessage string, done bool) (signal, message string, payload map[string]any, ok bool) {
+	if done {
+		signal = "done file " + l.DoneFile
+		if doneMessage == "" {
+			doneMessage = signal + " detected"
+		}
+		return signal, doneMessage, nil, true
+	}
+	if l.Detector != nil && l.finalPhase() {
+		signal, ok = l.Detector.Detect(ctx, output)
+		return signal, signal + " detected", nil, ok
+	}
+	if signal, ok = DetectStopSignal(output, l.StopSignals, l.StopRegex); !ok {
+		return "", "", nil, false
+	}
+	return signal, fmt.Sprintf("stop signal %s detected", signal), CompletionPayload(output, signal), true
+}
+
+// takeDoneFile reads and deletes DoneFile, reporting whether the agent had
+// created it.
+func (l *Loop) takeDoneFile() (string, bool) {
+	if l.DoneFile == "" {
+		return "", false
+	}
+	data, err := os.ReadFile(l.DoneFile)
+	if err != nil {
+		return "", false
+	}
+	if err := os.Remove(l.DoneFile); err != nil {
+		l.logf("⚠️ Failed to remove %s: %v\n", l.DoneFile, err)
+	}
+	return strings.TrimSpace(string(data)), true
+}
+
+// DetectBlocked reports the first of signals that appears in output and
+// the agent's explanation: the text after its last occurrence, or else the
+// line before it.
+func DetectBlocked(output string, signals []string) (signal, reason string, ok bool) {
+	for _, s := range signals {
+		i := strings.LastIndex(output, s)
+		if i < 0 {
+			continue
+		}
+		reason = strings.TrimSpace(output[i+len(s):])
+		if reason == "" {
+			before := strings.Split(strings.TrimSpace(output[:i]), "\n")
+			reason = strings.TrimSpace(before[len(before)-1])
+		}
+		if reason = strings.TrimLeft(reason, ":-— \t"); reason == "" {
+			reason = "no reason given"
+		}
+		if len(reason) > OutputTailBytes {
+			reason = strings.ToValidUTF8(reason[:OutputTailBytes], "") + "…"
+		}
+		return s, reason, true
+	}
+	return "", "", false
+}
+
+// CompletionPayload returns the JSON object that follows the last stop
+// signal in output on the same line, e.g. the fields of
+//
+//	RALPH_DONE {"summary": "Added the parser", "files_changed": 12}
+//
+// or nil if there is none.
+func CompletionPayload(output, signal string) map[string]any {
+	if signal == "" {
+		return nil
+	}
+	for end := len(output); ; {
+		i := strings.LastIndex(output[:end], signal)
+		if i < 0 {
+			return nil
+		}
+		rest := strings.TrimLeft(output[i+len(signal):], " \t")
+		if strings.HasPrefix(rest, "{") {
+			var payload map[string]any
+			if json.NewDecoder(strings.NewReader(rest)).Decode(&payload) == nil {
+				return payload
+			}
+		}
+		end = i
+	}
+}
+
+// ParseStopSignals splits a comma-separated list of stop signals, dropping empty entries.
+func ParseStopSignals(value string) []string {
+	var signals []string
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			signals = append(signals, s)
+		}
+	}
+	return signals
+}
+
+// runAgent runs the agent once. With StopImmediately it watches the live
+// output and kills the agent as soon as a stop signal appears; being killed
+// that way counts as a clean exit.
+func (l *Loop) runAgent(ctx context.Context, prompt string) (Result, error) {
+	agent, ok := l.Agent.(StreamingAgent)
+	if !l.StopImmediately || !ok {
+		return l.Agent.Run(ctx, prompt)
+	}
+
+	agentCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := &signalWatcher{signals: l.StopSignals, re: l.StopRegex, found: cancel}
+	result, err := agent.RunStreaming(agentCtx, prompt, w.write)
+	if signal := w.detected(); signal != "" && ctx.Err() == nil {
+		l.logf("\n🏁 Agent printed %s; stopped it early.\n", signal)
+		err = nil
+	}
+	return result, err
+}
+
+// signalWatchWindow is how much recent output is searched for a stop signal,
+// so that signals split across chunks are still found.
+const signalWatchWindow = 8 << 10
+
+// signalWatcher scans streamed output for a stop signal and calls found the
+// first time one appears.
+type signalWatcher struct {
+	signals []string
+	re      *regexp.Regexp
+	found   func()
+
+	mu     sync.Mutex
+	ansi   ansiState
+	window []byte
+	signal string
+}
+
+func (w *signalWatcher) write(p []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.signal != "" {
+		return
+	}
+	w.window = w.ansi.strip(w.window, p)
+	if over := len(w.window) - signalWatchWindow; over > 0 {
+		w.window = append(w.window[:0], w.window[over:]...)
+	}
+	if signal, ok := DetectStopSignal(string(w.window), w.signals, w.re); ok {
+		// Let the agent finish the line first: it may hold a payload.
+		rest := w.window[strings.LastIndex(string(w.window), signal)+len(signal):]
+		if !strings.Contains(string(rest), "\n") {
+			return
+		}
+		w.signal = signal
+		w.found()
+	}
+}
+
+func (w *signalWatcher) detected() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.signal
+}
+
+// DetectStopSignal reports the first stop signal that appears in the agent
+// output, falling back to the text matched by re (if any).
+func DetectStopSignal(output string, signals []string, re *regexp.Regexp) (string, bool) {
+	for _, s := range signals {
+		if strings.Contains(output, s) {
+			return s, true
+		}
+	}
+	if re != nil {
+		if loc := re.FindStringIndex(output); loc != nil {
+			return output[loc[0]:loc[1]], true
+		}
+	}
+	return "", false
+}
+
+func runShellCommand(ctx context.Context, command string) (string, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	setProcessGroup(cmd)
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
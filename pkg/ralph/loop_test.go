@@ -0,0 +1,255 @@
+package ralph
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestLoop(agent Agent) *Loop {
+	return &Loop{
+		Agent:          agent,
+		AgentName:      "mock",
+		PromptText:     "Work on the task.",
+		StopSignals:    []string{DefaultStopSignal},
+		BlockedSignals: []string{"RALPH_BLOCKED"},
+		Sleep:          time.Millisecond,
+		MaxIterations:  10,
+	}
+}
+
+// TestLoopStops runs the mock agent through the ways a run ends; the CLI
+// maps the errors to exit codes.
+func TestLoopStops(t *testing.T) {
+	const working = "- output: still working\n"
+	tests := []struct {
+		name      string
+		fixture   string
+		setup     func(*Loop)
+		want      error
+		wantEvent string
+	}{
+		{
+			name:      "complete",
+			fixture:   working + "- output: \"all done\\n" + DefaultStopSignal + "\"\n",
+			want:      nil,
+			wantEvent: EventComplete,
+		},
+		{
+			name:      "max iterations",
+			fixture:   working,
+			setup:     func(l *Loop) { l.MaxIterations = 2 },
+			want:      ErrMaxIterations,
+			wantEvent: EventMaxIterations,
+		},
+		{
+			name:      "stalled",
+			fixture:   working,
+			setup:     func(l *Loop) { l.StallAfter = 2 },
+			want:      ErrStalled,
+			wantEvent: EventStalled,
+		},
+		{
+			name:      "agent errors",
+			fixture:   "- output: crashed\n  exit_code: 1\n",
+			setup:     func(l *Loop) { l.MaxConsecutiveErrors = 2 },
+			want:      ErrTooManyErrors,
+			wantEvent: EventErrorAbort,
+		},
+		{
+			name:      "deadline",
+			fixture:   working,
+			setup:     func(l *Loop) { l.MaxDuration = time.Nanosecond },
+			want:      ErrDeadlineExceeded,
+			wantEvent: EventDeadlineExceeded,
+		},
+		{
+			name:      "blocked",
+			fixture:   "- output: \"RALPH_BLOCKED: need the API key\"\n",
+			want:      ErrBlocked,
+			wantEvent: EventBlocked,
+		},
+		{
+			name:      "prompt too large",
+			fixture:   working,
+			setup:     func(l *Loop) { l.PromptMaxTokens = 1 },
+			want:      ErrPromptTooLarge,
+			wantEvent: EventPromptTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdirRepo(t, map[string]string{"README.md": "test\n", "mock.yaml": tt.fixture})
+			l := newTestLoop(&CommandAgent{AgentDef: AgentDef{Mock: &MockAgent{Fixture: "mock.yaml"}}})
+			if tt.setup != nil {
+				tt.setup(l)
+			}
+			var last StatusEvent
+			l.OnEvent = func(ev StatusEvent) {
+				if ev.Terminal() {
+					last = ev
+				}
+			}
+			if err := l.Run(context.Background()); !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("Run = %v, want %v", err, tt.want)
+			}
+			if last.Event != tt.wantEvent {
+				t.Errorf("final event = %q, want %q", last.Event, tt.wantEvent)
+			}
+		})
+	}
+}
+
+func TestLoopMockAgent(t *testing.T) {
+	chdirRepo(t, map[string]string{"README.md": "test\n"})
+	agent := &CommandAgent{AgentDef: AgentDef{Mock: &MockAgent{DoneAfter: 3}}}
+	l := newTestLoop(agent)
+	if err := l.Run(context.Background()); err != nil {
+		t.Fatalf("Run = %v", err)
+	}
+	if n := l.Iteration(); n != 3 {
+		t.Errorf("completed after %d iterations, want 3", n)
+	}
+}
+
+func TestDetectStopSignal(t *testing.T) {
+	signals := []string{"RALPH_DONE", "ALL_DONE"}
+	tests := []struct {
+		output string
+		want   string
+		ok     bool
+	}{
+		{"working on it", "", false},
+		{"done\nRALPH_DONE\n", "RALPH_DONE", true},
+		{"ALL_DONE", "ALL_DONE", true},
+		{"RALPH_DONE and ALL_DONE", "RALPH_DONE", true},
+		{"ralph_done", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := DetectStopSignal(tt.output, signals, nil)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("DetectStopSignal(%q) = %q, %v; want %q, %v", tt.output, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDetectBlocked(t *testing.T) {
+	signals := []string{"RALPH_BLOCKED"}
+	tests := []struct {
+		output string
+		reason string
+		ok     bool
+	}{
+		{"all good", "", false},
+		{"RALPH_BLOCKED: need the API key", "need the API key", true},
+		{"I cannot reach the database.\nRALPH_BLOCKED", "I cannot reach the database.", true},
+		{"RALPH_BLOCKED", "no reason given", true},
+		{"RALPH_BLOCKED first\nRALPH_BLOCKED — second", "second", true},
+	}
+	for _, tt := range tests {
+		_, reason, ok := DetectBlocked(tt.output, signals)
+		if reason != tt.reason || ok != tt.ok {
+			t.Errorf("DetectBlocked(%q) = %q, %v; want %q, %v", tt.output, reason, ok, tt.reason, tt.ok)
+		}
+	}
+}
+
+func TestCompletionPayload(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string // the payload as JSON, "" for none
+	}{
+		{"RALPH_DONE", ""},
+		{`RALPH_DONE {"summary": "Added the parser", "files_changed": 12}`, `{"files_changed":12,"summary":"Added the parser"}`},
+		{"RALPH_DONE\n{\"summary\": \"next line\"}", ""},
+		{`RALPH_DONE {"summary": "first"}` + "\nRALPH_DONE", `{"summary":"first"}`},
+		{`RALPH_DONE {"summary": "first"}` + "\n" + `RALPH_DONE {"summary": "last"}`, `{"summary":"last"}`},
+		{`RALPH_DONE {not json}`, ""},
+		{`print RALPH_DONE {"a": 1} when done`, `{"a":1}`},
+	}
+	for _, tt := range tests {
+		got := ""
+		if payload := CompletionPayload(tt.output, "RALPH_DONE"); payload != nil {
+			data, _ := json.Marshal(payload)
+			got = string(data)
+		}
+		if got != tt.want {
+			t.Errorf("CompletionPayload(%q) = %s, want %s", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestLoopRevertOnFail(t *testing.T) {
+	chdirRepo(t, map[string]string{
+		"README.md": "test\n",
+		"mock.yaml": "- output: edited\n  files:\n    README.md: broken\n    new.txt: new\n",
+	})
+	agent := &CommandAgent{AgentDef: AgentDef{Mock: &MockAgent{Fixture: "mock.yaml"}}}
+	l := newTestLoop(agent)
+	l.MaxIterations = 1
+	l.Guard = "grep -q test README.md"
+	l.RevertOnFail = true
+	if err := l.Run(context.Background()); !errors.Is(err, ErrMaxIterations) {
+		t.Fatalf("Run = %v, want %v", err, ErrMaxIterations)
+	}
+	if data, _ := os.ReadFile("README.md"); string(data) != "test\n" {
+		t.Errorf("README.md = %q, want it reverted", data)
+	}
+	if _, err := os.Stat("new.txt"); !os.IsNotExist(err) {
+		t.Errorf("new.txt still exists after the revert")
+	}
+}
+
+// scriptAgent plays back results in order, the last one repeating. A
+// non-zero exit code makes the run fail.
+type scriptAgent struct {
+	results []Result
+	runs    int
+}
+
+func (a *scriptAgent) Run(ctx context.Context, prompt string) (Result, error) {
+	r := a.results[min(a.runs, len(a.results)-1)]
+	a.runs++
+	if r.ExitCode != 0 {
+		return r, fmt.Errorf("agent exited with status %d", r.ExitCode)
+	}
+	return r, nil
+}
+
+func TestLoopBudget(t *testing.T) {
+	tests := []struct {
+		name  string
+		usage Usage
+		setup func(*Loop)
+	}{
+		{"cost", Usage{CostUSD: 0.6}, func(l *Loop) { l.MaxCostUSD = 1 }},
+		{"tokens", Usage{InputTokens: 400, OutputTokens: 200}, func(l *Loop) { l.MaxTokens = 1000 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chdirRepo(t, map[string]string{"README.md": "test\n"})
+			agent := &scriptAgent{results: []Result{{Output: "expensive", Usage: &tt.usage}}}
+			l := newTestLoop(agent)
+			tt.setup(l)
+			var last StatusEvent
+			l.OnEvent = func(ev StatusEvent) {
+				if ev.Terminal() {
+					last = ev
+				}
+			}
+			if err := l.Run(context.Background()); !errors.Is(err, ErrBudgetExceeded) {
+				t.Fatalf("Run = %v, want %v", err, ErrBudgetExceeded)
+			}
+			if last.Event != EventBudgetExceeded {
+				t.Errorf("final event = %q, want %q", last.Event, EventBudgetExceeded)
+			}
+			if agent.runs != 2 {
+				t.Errorf("agent ran %d times, want 2", agent.runs)
+			}
+		})
+	}
+}
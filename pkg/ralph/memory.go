@@ -0,0 +1,87 @@
+package ralph
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MemoryNotePrefix starts a line of agent output that the loop records in
+// MemoryFile, e.g. "RALPH_NOTE: the integration tests need docker running".
+const MemoryNotePrefix = "RALPH_NOTE:"
+
+// MaxMemoryBytes bounds how much of the memory file goes into the prompt;
+// the oldest notes are dropped first.
+const MaxMemoryBytes = 8000
+
+// memoryInstructions tell the agent how to add to its memory.
+const memoryInstructions = "To remember something for later iterations (a gotcha, a decision, a command that works), print it on a line of its own starting with " + MemoryNotePrefix
+
+// recordNotes appends the notes in output to MemoryFile, skipping ones it
+// already holds.
+func (l *Loop) recordNotes(output string) {
+	existing, _ := os.ReadFile(l.MemoryFile)
+	var notes []string
+	for _, line := range strings.Split(StripANSI(output), "\n") {
+		note, ok := strings.CutPrefix(strings.TrimSpace(line), MemoryNotePrefix)
+		if note = strings.TrimSpace(note); !ok || note == "" {
+			continue
+		}
+		entry := "- " + note + "\n"
+		if strings.Contains("\n"+string(existing)+strings.Join(notes, ""), "\n"+entry) {
+			continue
+		}
+		notes = append(notes, entry)
+	}
+	if len(notes) == 0 {
+		return
+	}
+
+	err := os.MkdirAll(filepath.Dir(l.MemoryFile), 0755)
+	var f *os.File
+	if err == nil {
+		f, err = os.OpenFile(l.MemoryFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	}
+	if err == nil {
+		text := strings.Join(notes, "")
+		if len(existing) > 0 && !strings.HasSuffix(string(existing), "\n") {
+			text = "\n" + text
+		}
+		_, err = f.WriteString(text)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		l.logf("⚠️ Failed to write the memory file: %v\n", err)
+		return
+	}
+	l.logf("🧠 Recorded %d note(s) in %s\n", len(notes), l.MemoryFile)
+	l.emit(EventNoteRecorded, strings.TrimSpace(strings.Join(notes, "")))
+}
+
+// memory returns the newest MaxMemoryBytes of MemoryFile, cut at a line
+// boundary, or "" if it is empty or missing.
+func (l *Loop) memory() string {
+	data, err := os.ReadFile(l.MemoryFile)
+	if err != nil {
+		return ""
+	}
+	text := strings.TrimSpace(string(data))
+	if len(text) > MaxMemoryBytes {
+		text = text[len(text)-MaxMemoryBytes:]
+		if i := strings.IndexByte(text, '\n'); i >= 0 {
+			text = text[i+1:]
+		}
+	}
+	return text
+}
+
+// memorySection formats the memory for the prompt.
+func memorySection(memory string) string {
+	if memory == "" {
+		return fmt.Sprintf("## Memory\n\nNo learnings recorded yet. %s", memoryInstructions)
+	}
+	return fmt.Sprintf("## Memory\n\nLearnings recorded in earlier iterations:\n\n%s\n\n%s", memory, memoryInstructions)
+}
@@ -0,0 +1,161 @@
+package ralph
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the iteration
+// duration histogram. Agent runs take anywhere from seconds to an hour.
+var durationBuckets = []float64{5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+
+// Loop states reported by the ralph_loop_state gauge.
+var loopStates = []string{"running", "paused", "complete", "cancelled", "stalled", "max_iterations", "budget_exceeded", "deadline_exceeded", "error_abort"}
+
+// Metrics aggregates status events into Prometheus metrics and serves them
+// in the text exposition format. Feed it from Loop.OnEvent via Observe.
+type Metrics struct {
+	mu            sync.Mutex
+	iterations    int
+	iteration     int
+	agentErrors   int
+	rateLimits    int
+	timeouts      int
+	oomKills      int
+	state         string
+	bucketCounts  []int
+	durationSum   float64
+	durationCount int
+	usage         Usage
+}
+
+// Observe updates the metrics from ev.
+func (m *Metrics) Observe(ev StatusEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.bucketCounts == nil {
+		m.bucketCounts = make([]int, len(durationBuckets))
+	}
+
+	m.iteration = ev.Iteration
+	if ev.TotalUsage != nil {
+		m.usage = *ev.TotalUsage
+	}
+	switch ev.Event {
+	case EventIterationStart:
+		m.iterations++
+		m.state = "running"
+	case EventIterationEnd:
+		secs := time.Duration(ev.DurationMS * int64(time.Millisecond)).Seconds()
+		for i, le := range durationBuckets {
+			if secs <= le {
+				m.bucketCounts[i]++
+			}
+		}
+		m.durationSum += secs
+		m.durationCount++
+		if ev.AgentExitCode != nil && *ev.AgentExitCode != 0 {
+			m.agentErrors++
+		}
+	case EventRateLimited:
+		m.rateLimits++
+	case EventTimeout:
+		m.timeouts++
+	case EventOOMKilled:
+		m.oomKills++
+	case EventPaused:
+		m.state = "paused"
+	case EventResumed:
+		m.state = "running"
+	case EventComplete:
+		m.state = "complete"
+	case EventCancelled, EventCancelledGraceful:
+		m.state = "cancelled"
+	case EventStalled:
+		m.state = "stalled"
+	case EventMaxIterations:
+		m.state = "max_iterations"
+	case EventBudgetExceeded:
+		m.state = "budget_exceeded"
+	case EventDeadlineExceeded:
+		m.state = "deadline_exceeded"
+	case EventErrorAbort:
+		m.state = "error_abort"
+	case EventBlocked:
+		m.state = "blocked"
+	case EventPromptTooLarge:
+		m.state = "prompt_too_large"
+	}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	counter := func(name, help string, v int) {
+		fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, v)
+	}
+	counter("ralph_iterations_total", "Agent iterations started.", m.iterations)
+	counter("ralph_agent_errors_total", "Agent runs that exited non-zero or were killed.", m.agentErrors)
+	counter("ralph_agent_timeouts_total", "Agent runs killed by the iteration timeout.", m.timeouts)
+	counter("ralph_rate_limits_total", "Agent runs that hit a rate limit.", m.rateLimits)
+	counter("ralph_agent_oom_kills_total", "Agent runs killed for exceeding the memory limit.", m.oomKills)
+
+	fmt.Fprintf(cw, "# HELP ralph_cost_usd_total Agent cost reported by the agent, in US dollars.\n# TYPE ralph_cost_usd_total counter\nralph_cost_usd_total %g\n", m.usage.CostUSD)
+	fmt.Fprintf(cw, "# HELP ralph_tokens_total Tokens reported by the agent.\n# TYPE ralph_tokens_total counter\n")
+	fmt.Fprintf(cw, "ralph_tokens_total{type=\"input\"} %d\n", m.usage.InputTokens)
+	fmt.Fprintf(cw, "ralph_tokens_total{type=\"output\"} %d\n", m.usage.OutputTokens)
+	fmt.Fprintf(cw, "ralph_tokens_total{type=\"cache_read\"} %d\n", m.usage.CacheReadTokens)
+	fmt.Fprintf(cw, "ralph_tokens_total{type=\"cache_creation\"} %d\n", m.usage.CacheCreationTokens)
+
+	fmt.Fprintf(cw, "# HELP ralph_iteration Current iteration number.\n# TYPE ralph_iteration gauge\nralph_iteration %d\n", m.iteration)
+
+	fmt.Fprintf(cw, "# HELP ralph_loop_state Current loop state (1 for the active state).\n# TYPE ralph_loop_state gauge\n")
+	for _, s := range loopStates {
+		v := 0
+		if s == m.state {
+			v = 1
+		}
+		fmt.Fprintf(cw, "ralph_loop_state{state=%q} %d\n", s, v)
+	}
+
+	fmt.Fprintf(cw, "# HELP ralph_iteration_duration_seconds Agent run time per iteration.\n# TYPE ralph_iteration_duration_seconds histogram\n")
+	for i, le := range durationBuckets {
+		n := 0
+		if m.bucketCounts != nil {
+			n = m.bucketCounts[i]
+		}
+		fmt.Fprintf(cw, "ralph_iteration_duration_seconds_bucket{le=\"%g\"} %d\n", le, n)
+	}
+	fmt.Fprintf(cw, "ralph_iteration_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.durationCount)
+	fmt.Fprintf(cw, "ralph_iteration_duration_seconds_sum %g\n", m.durationSum)
+	fmt.Fprintf(cw, "ralph_iteration_duration_seconds_count %d\n", m.durationCount)
+	return cw.n, cw.err
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
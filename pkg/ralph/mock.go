@@ -0,0 +1,126 @@
+package ralph
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MockAgent makes an agent play back scripted responses instead of running
+// anything, to try out hooks, status integrations and CI wiring without
+// spending tokens. See AgentDef.Mock.
+type MockAgent struct {
+	// Fixture is a YAML file with a list of MockSteps, one per run; the last
+	// one repeats. Without it every run prints a line about the prompt.
+	Fixture string `yaml:"fixture"`
+	// Delay is how long every run takes, on top of each step's own delay.
+	Delay time.Duration `yaml:"delay"`
+	// DoneAfter is the run that ends with the stop signal (0 = leave it to
+	// the fixture).
+	DoneAfter int `yaml:"done_after"`
+	// Signal is the stop signal printed at DoneAfter (default
+	// DefaultStopSignal).
+	Signal string `yaml:"signal"`
+}
+
+// MockStep is one scripted run of a MockAgent.
+type MockStep struct {
+	// Output is printed line by line; {{iteration}} is replaced with the
+	// run's number.
+	Output string `yaml:"output"`
+	// Delay is how long the run takes before it prints anything.
+	Delay time.Duration `yaml:"delay"`
+	// ExitCode is the run's exit status; non-zero makes it fail.
+	ExitCode int `yaml:"exit_code"`
+	// Files are written, relative to the working directory, before the
+	// output is printed, so the loop sees the agent change things.
+	Files map[string]string `yaml:"files"`
+}
+
+// LoadMockSteps reads the steps of a fixture file.
+func LoadMockSteps(path string) ([]MockStep, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var steps []MockStep
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&steps); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("%s: no steps", path)
+	}
+	return steps, nil
+}
+
+// step returns what run n (from 1) does.
+func (m *MockAgent) step(n int, prompt string) (MockStep, error) {
+	step := MockStep{Output: fmt.Sprintf("Mock agent run {{iteration}}: pretending to work on a %d-byte prompt.", len(prompt))}
+	if m.Fixture != "" {
+		steps, err := LoadMockSteps(m.Fixture)
+		if err != nil {
+			return MockStep{}, err
+		}
+		step = steps[min(n, len(steps))-1]
+	}
+	step.Output = strings.ReplaceAll(step.Output, "{{iteration}}", strconv.Itoa(n))
+	if m.DoneAfter > 0 && n >= m.DoneAfter {
+		signal := m.Signal
+		if signal == "" {
+			signal = DefaultStopSignal
+		}
+		step.Output = strings.TrimRight(step.Output, "\n") + "\n" + signal
+	}
+	return step, nil
+}
+
+// runMock is RunStreaming for mock agents. The fixture is read on every
+// run, so it can be edited while the loop runs.
+func (a *CommandAgent) runMock(ctx context.Context, prompt string, w io.Writer, capture *ringBuffer) (Result, error) {
+	a.mockRuns++
+	step, err := a.Mock.step(a.mockRuns, prompt)
+	if err != nil {
+		return Result{ExitCode: -1}, err
+	}
+	if a.Trace != nil {
+		fmt.Fprintf(a.Trace, "🔧 Mock: run %d, fixture %q\n", a.mockRuns, a.Mock.Fixture)
+	}
+	select {
+	case <-time.After(a.Mock.Delay + step.Delay):
+	case <-ctx.Done():
+		return Result{ExitCode: -1}, ctx.Err()
+	}
+	for _, name := range sortedKeys(step.Files) {
+		if dir := filepath.Dir(name); dir != "." {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return Result{ExitCode: -1}, err
+			}
+		}
+		if err := os.WriteFile(name, []byte(step.Files[name]), 0644); err != nil {
+			return Result{ExitCode: -1}, err
+		}
+	}
+	for _, line := range strings.SplitAfter(step.Output, "\n") {
+		if line != "" {
+			_, _ = io.WriteString(w, line)
+		}
+	}
+	if !strings.HasSuffix(step.Output, "\n") {
+		_, _ = io.WriteString(w, "\n")
+	}
+	result := Result{Output: capture.String(), ExitCode: step.ExitCode}
+	if step.ExitCode != 0 {
+		return result, fmt.Errorf("mock agent exited with status %d", step.ExitCode)
+	}
+	return result, nil
+}
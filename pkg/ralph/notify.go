@@ -0,0 +1,61 @@
+package ralph
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// desktopNotifyTimeout bounds how long a notification helper may run.
+const desktopNotifyTimeout = 10 * time.Second
+
+// DesktopNotify shows ev as a native desktop notification using the
+// platform's stock tooling: osascript on macOS, notify-send on Linux and
+// PowerShell on Windows.
+func DesktopNotify(ev StatusEvent) error {
+	title := "ralph: " + strings.ReplaceAll(ev.Event, "_", " ")
+	body := fmt.Sprintf("%s finished after %d iteration(s)", ev.Agent, ev.Iteration)
+	if ev.Message != "" {
+		body += "\n" + ev.Message
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), desktopNotifyTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(body), appleScriptString(title))
+		cmd = exec.CommandContext(ctx, "osascript", "-e", script)
+	case "windows":
+		script := fmt.Sprintf(`[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+$t = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$x = $t.GetElementsByTagName('text')
+$x.Item(0).AppendChild($t.CreateTextNode(%s)) | Out-Null
+$x.Item(1).AppendChild($t.CreateTextNode(%s)) | Out-Null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('ralph').Show([Windows.UI.Notifications.ToastNotification]::new($t))`,
+			powerShellString(title), powerShellString(body))
+		cmd = exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	default:
+		cmd = exec.CommandContext(ctx, "notify-send", "--app-name=ralph", title, body)
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s: %w: %s", cmd.Args[0], err, msg)
+		}
+		return fmt.Errorf("%s: %w", cmd.Args[0], err)
+	}
+	return nil
+}
+
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func powerShellString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,82 @@
+package ralph
+
+import (
+	"fmt"
+	"os"
+)
+
+// Phase is one stage of a run that works through several prompts in turn,
+// e.g. a planning loop that writes a plan followed by a building loop that
+// carries it out.
+type Phase struct {
+	Name string `yaml:"name"`
+	// Prompt is the phase's prompt file or glob (default: the loop's
+	// PromptText or PromptFiles).
+	Prompt string `yaml:"prompt"`
+	// StopSignal is a comma-separated list of tokens that end the phase
+	// (default: the loop's StopSignals).
+	StopSignal string `yaml:"stop_signal"`
+	// MaxIterations moves on to the next phase, or ends the run with
+	// ErrMaxIterations in the last one, after this many iterations of the
+	// phase (0 = unlimited).
+	MaxIterations int `yaml:"max_iterations"`
+}
+
+// SkipToPhase makes the loop continue with the named phase. Call it before
+// Run.
+func (l *Loop) SkipToPhase(name string) error {
+	for i, p := range l.Phases {
+		if p.Name == name {
+			l.phase = i
+			l.phaseStart = l.iteration
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown phase %q", name)
+}
+
+// currentPhase returns the phase being worked on, or nil without phases.
+func (l *Loop) currentPhase() *Phase {
+	if len(l.Phases) == 0 {
+		return nil
+	}
+	return &l.Phases[l.phase]
+}
+
+// finalPhase reports whether completing the current phase completes the
+// run; it is always true without phases.
+func (l *Loop) finalPhase() bool {
+	return l.phase >= len(l.Phases)-1
+}
+
+// enterPhase switches the prompt and stop signals to the current phase's.
+func (l *Loop) enterPhase() {
+	p := l.Phases[l.phase]
+	l.PromptFiles, l.PromptText = l.basePromptFiles, l.basePromptText
+	if p.Prompt != "" {
+		l.PromptFiles, l.PromptText = []string{p.Prompt}, ""
+	}
+	l.StopSignals = l.baseStopSignals
+	if signals := ParseStopSignals(p.StopSignal); len(signals) > 0 {
+		l.StopSignals = signals
+	}
+	l.logf("\n📐 Phase %d/%d: %s\n", l.phase+1, len(l.Phases), p.Name)
+	l.emit(EventPhaseStarted, p.Name)
+}
+
+// completePhase ends the current phase and starts the next one. Feedback
+// and a prompt set with SetPrompt belong to the finished phase and are
+// dropped.
+func (l *Loop) completePhase(reason string) {
+	p := l.Phases[l.phase]
+	_ = os.Remove(l.ErrorLogFile)
+	l.promptMu.Lock()
+	l.promptOverride, l.promptUpdated = "", false
+	l.promptMu.Unlock()
+	l.emit(EventPhaseComplete, fmt.Sprintf("%s: %s", p.Name, reason))
+
+	l.phase++
+	l.phaseStart = l.iteration
+	l.stalls = 0
+	l.enterPhase()
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package ralph
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts cmd in its own process group so a Ctrl+C in the
+// terminal reaches ralph only; ralph decides what happens to the agent.
+// When cmd's context is done the whole group is killed, taking down any test
+// runners or servers the agent spawned along with it.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		if errors.Is(err, syscall.ESRCH) {
+			return nil
+		}
+		return err
+	}
+}
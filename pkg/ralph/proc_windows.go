@@ -0,0 +1,34 @@
+//go:build windows
+
+package ralph
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// setProcessGroup starts cmd in a new process group so a Ctrl+C in the
+// console reaches ralph only; ralph decides what happens to the agent.
+// Windows has no signal for a whole group, so when cmd's context is done the
+// process tree is killed with taskkill, taking down any test runners or
+// servers the agent spawned along with it.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+	cmd.Cancel = func() error {
+		kill := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid))
+		kill.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+		if kill.Run() == nil {
+			return nil
+		}
+		// taskkill is missing or the tree is already gone; make sure the
+		// agent itself goes down.
+		err := cmd.Process.Kill()
+		if errors.Is(err, os.ErrProcessDone) {
+			return nil
+		}
+		return err
+	}
+}
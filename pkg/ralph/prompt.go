@@ -0,0 +1,360 @@
+package ralph
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// PromptSeparator joins the contents of multiple prompt files.
+const PromptSeparator = "\n\n---\n\n"
+
+// PromptData is the data available to PROMPT.md when it is rendered as a
+// text/template before every iteration.
+type PromptData struct {
+	Iteration   int
+	Agent       string
+	StartTime   time.Time
+	ElapsedTime time.Duration
+}
+
+// SetPrompt replaces the prompt from the next iteration on; an empty text
+// goes back to PromptText or PromptFiles. The new prompt is gated by
+// PromptHook and ApprovePrompt like any other change to it. It is safe to
+// call from any goroutine.
+func (l *Loop) SetPrompt(text string) {
+	l.promptMu.Lock()
+	defer l.promptMu.Unlock()
+	l.promptOverride = text
+	l.promptUpdated = true
+}
+
+// takePromptUpdate reports whether SetPrompt was called since the last call.
+func (l *Loop) takePromptUpdate() bool {
+	l.promptMu.Lock()
+	defer l.promptMu.Unlock()
+	updated := l.promptUpdated
+	l.promptUpdated = false
+	return updated
+}
+
+// readPrompt returns the raw prompt for this iteration.
+func (l *Loop) readPrompt() (string, error) {
+	l.promptMu.Lock()
+	override := l.promptOverride
+	l.promptMu.Unlock()
+	if override != "" {
+		return override, nil
+	}
+	if l.PromptText != "" {
+		return l.PromptText, nil
+	}
+
+	files, err := expandPromptFiles(l.PromptFiles, l.Ignore)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 1 {
+		data, err := os.ReadFile(files[0])
+		if err != nil {
+			return "", fmt.Errorf("%s not found", files[0])
+		}
+		return string(data), nil
+	}
+
+	// Several sources: label each one so the agent can tell them apart.
+	parts := make([]string, 0, len(files))
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return "", fmt.Errorf("%s not found", f)
+		}
+		parts = append(parts, fmt.Sprintf("<!-- %s -->\n%s", f, strings.TrimRight(string(data), "\n")))
+	}
+	return strings.Join(parts, PromptSeparator), nil
+}
+
+// expandPromptFiles resolves glob patterns in order, dropping duplicates
+// and the matches of ignore.
+// Literal paths are kept even if missing so the caller reports them.
+func expandPromptFiles(patterns []string, ignore *Ignore) ([]string, error) {
+	var files []string
+	seen := map[string]bool{}
+	for _, p := range patterns {
+		matches := []string{p}
+		glob := strings.ContainsAny(p, "*?[")
+		if glob {
+			var err error
+			if matches, err = filepath.Glob(p); err != nil {
+				return nil, fmt.Errorf("bad prompt pattern %q: %w", p, err)
+			}
+		}
+		for _, m := range matches {
+			if glob && ignore.Match(m) {
+				continue
+			}
+			if !seen[m] {
+				seen[m] = true
+				files = append(files, m)
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no prompt files match %s", strings.Join(patterns, ", "))
+	}
+	return files, nil
+}
+
+// promptName labels the prompt source in messages and template errors.
+func (l *Loop) promptName() string {
+	if l.PromptText != "" {
+		return "prompt"
+	}
+	return strings.Join(l.PromptFiles, "+")
+}
+
+// buildPrompt renders the prompt template and injects failure feedback from
+// the previous attempt. Templates that fail to parse or execute are used
+// verbatim so plain prompts containing stray braces keep working.
+func (l *Loop) buildPrompt(ctx context.Context, instructions string) string {
+	feedback := l.feedback()
+	carryover := l.carryover(ctx)
+	memory := ""
+	if l.MemoryFile != "" {
+		memory = l.memory()
+	}
+	specs := ""
+	if l.SpecsDir != "" {
+		specs = l.specs()
+	}
+	feedbackUsed, taskUsed, carryoverUsed, memoryUsed, diffUsed, signalsUsed, specsUsed, issueUsed := false, false, false, false, false, false, false, false
+
+	commandsOff := l.promptCommandsOff(instructions)
+	funcs := template.FuncMap{
+		// shell returns the combined output of a shell command, even if it fails.
+		"shell": func(command string) (string, error) {
+			if commandsOff != "" {
+				return "", fmt.Errorf("shell is off: %s", commandsOff)
+			}
+			out, _ := runShellCommand(ctx, command)
+			return strings.TrimRight(out, "\n"), nil
+		},
+		"file": func(path string) (string, error) {
+			if commandsOff != "" {
+				return "", fmt.Errorf("file is off: %s", commandsOff)
+			}
+			data, err := os.ReadFile(path)
+			return string(data), err
+		},
+		"feedback": func() string {
+			feedbackUsed = true
+			return feedback
+		},
+		"task": func() string {
+			taskUsed = true
+			return l.Task
+		},
+		"issue": func() string {
+			issueUsed = true
+			return l.Issue
+		},
+		"carryover": func() string {
+			carryoverUsed = true
+			return carryover
+		},
+		"memory": func() string {
+			memoryUsed = true
+			return memory
+		},
+		"specs": func() string {
+			specsUsed = true
+			return specs
+		},
+		"signals": func() string {
+			signalsUsed = true
+			return l.signalFooter()
+		},
+		"diffstat": func() string {
+			diffUsed = true
+			return l.runDiff(ctx, true)
+		},
+		"diff": func() string {
+			diffUsed = true
+			return l.runDiff(ctx, false)
+		},
+	}
+
+	data := PromptData{
+		Iteration:   l.iteration + 1,
+		Agent:       l.AgentName,
+		StartTime:   l.startTime,
+		ElapsedTime: time.Since(l.startTime).Round(time.Second),
+	}
+
+	rendered, err := renderTemplate(l.promptName(), instructions, funcs, data)
+	if err != nil {
+		l.logf("⚠️ Prompt template error, using %s verbatim: %v\n", l.promptName(), err)
+		rendered = strings.ReplaceAll(instructions, FeedbackPlaceholder, feedback)
+		feedbackUsed = strings.Contains(instructions, FeedbackPlaceholder)
+		rendered = strings.ReplaceAll(rendered, TaskPlaceholder, l.Task)
+		taskUsed = strings.Contains(instructions, TaskPlaceholder)
+		rendered = strings.ReplaceAll(rendered, IssuePlaceholder, l.Issue)
+		issueUsed = strings.Contains(instructions, IssuePlaceholder)
+		rendered = strings.ReplaceAll(rendered, CarryoverPlaceholder, carryover)
+		carryoverUsed = strings.Contains(instructions, CarryoverPlaceholder)
+		rendered = strings.ReplaceAll(rendered, MemoryPlaceholder, memory)
+		memoryUsed = strings.Contains(instructions, MemoryPlaceholder)
+		rendered = strings.ReplaceAll(rendered, SignalsPlaceholder, l.signalFooter())
+		signalsUsed = strings.Contains(instructions, SignalsPlaceholder)
+		rendered = strings.ReplaceAll(rendered, SpecsPlaceholder, specs)
+		specsUsed = strings.Contains(instructions, SpecsPlaceholder)
+		// Only run git for the diffs the prompt asks for.
+		if strings.Contains(instructions, DiffstatPlaceholder) {
+			rendered = strings.ReplaceAll(rendered, DiffstatPlaceholder, l.runDiff(ctx, true))
+		}
+		if strings.Contains(instructions, DiffPlaceholder) {
+			rendered = strings.ReplaceAll(rendered, DiffPlaceholder, l.runDiff(ctx, false))
+		}
+		diffUsed = strings.Contains(instructions, DiffstatPlaceholder) || strings.Contains(instructions, DiffPlaceholder)
+	}
+
+	if specs != "" && !specsUsed {
+		rendered += "\n\n" + specs
+	}
+	if l.Issue != "" && !issueUsed {
+		rendered += "\n\n" + l.Issue
+	}
+	if l.Task != "" && !taskUsed {
+		rendered += fmt.Sprintf("\n\n## Current task\n\n%s\n\nWork on this task only.", l.Task)
+	}
+	if l.InjectDiff != "" && !diffUsed {
+		rendered += "\n\n" + l.diffSection(ctx)
+	}
+	if l.MemoryFile != "" && !memoryUsed {
+		rendered += "\n\n" + memorySection(memory)
+	}
+	if carryover != "" && !carryoverUsed {
+		rendered += "\n\n" + l.carryoverSection(carryover)
+	}
+	if footer := l.signalFooter(); l.SignalFooter && footer != "" && !signalsUsed {
+		rendered += "\n\n" + footer
+	}
+	if feedbackUsed || feedback == "" {
+		return rendered
+	}
+	// Inject the error (Feedback Loop)
+	return rendered + "\n\n" + feedback
+}
+
+// promptCommandsOff returns why the shell and file template functions may
+// not run for the prompt instructions, or "" if they may.
+func (l *Loop) promptCommandsOff(instructions string) string {
+	switch {
+	case l.NoPromptCommands:
+		return "the agent is sandboxed or its network is restricted"
+	case l.trustedPromptHash != "" && hashString(instructions) != l.trustedPromptHash:
+		return "the prompt changed during the run without approval"
+	}
+	return ""
+}
+
+func renderTemplate(name, text string, funcs template.FuncMap, data any) (string, error) {
+	tmpl, err := template.New(name).Funcs(funcs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// feedback returns the failure section for the prompt, or "" if the previous
+// attempt did not fail verification.
+func (l *Loop) feedback() string {
+	// Check if an error log exists from the verification step
+	errorContent, err := os.ReadFile(l.ErrorLogFile)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("!!! PREVIOUS ATTEMPT FAILED !!!\nI have written the verification logs to '%s'.\nHere is the TAIL of the output (most relevant errors):\n```\n%s\n```\nFix this error based on the file content.", l.ErrorLogFile, string(errorContent))
+}
+
+// signalFooter tells the agent how to end the loop or ask for help, given
+// the current phase's signals; "" if the agent has neither to do.
+func (l *Loop) signalFooter() string {
+	var sb strings.Builder
+	if len(l.StopSignals) > 0 && !(l.Detector != nil && l.finalPhase()) {
+		sb.WriteString("## When you are done\n\nWhen, and only when, the task is fully done and verified, print this line on its own:\n\n")
+		sb.WriteString(l.StopSignals[0])
+		if l.DoneFile != "" {
+			fmt.Fprintf(&sb, "\n\nInstead, you may create the file %s with a short summary of what you did.", l.DoneFile)
+		}
+		sb.WriteString("\n\nDo not print it otherwise, not even to say you will print it later.")
+	}
+	if len(l.BlockedSignals) > 0 {
+		if sb.Len() > 0 {
+			sb.WriteString("\n\n")
+		}
+		fmt.Fprintf(&sb, "## If you are stuck\n\nIf you cannot go on without a human (missing access, unclear requirements), print %s followed by what you need, and stop.", l.BlockedSignals[0])
+	}
+	return sb.String()
+}
+
+// PromptChange is a change to the prompt made during a run, for
+// Loop.ApprovePrompt.
+type PromptChange struct {
+	// Iteration is the iteration that would use the new prompt.
+	Iteration int
+	// Old and New are the raw prompts, before templating.
+	Old, New string
+}
+
+// approvePrompt asks PromptHook, then ApprovePrompt, whether to use a
+// changed prompt. A hook that cannot run declines it.
+func (l *Loop) approvePrompt(ctx context.Context, change PromptChange) ReviewDecision {
+	l.logf("\n📝 %s changed since the last iteration and needs approval.\n", l.promptName())
+	if l.PromptHook != "" {
+		dir, err := os.MkdirTemp("", "ralph-prompt-")
+		if err == nil {
+			defer os.RemoveAll(dir)
+			err = os.WriteFile(filepath.Join(dir, "old.md"), []byte(change.Old), 0644)
+		}
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, "new.md"), []byte(change.New), 0644)
+		}
+		if err == nil {
+			_, err = l.runHook(ctx, "prompt hook", l.PromptHook, []string{
+				HookPromptOldFileEnv + "=" + filepath.Join(dir, "old.md"),
+				HookPromptNewFileEnv + "=" + filepath.Join(dir, "new.md"),
+			})
+		}
+		if err != nil {
+			l.logf("⚠️ Prompt hook declined the change: %v\n", err)
+			return ReviewSkip
+		}
+	}
+	if l.ApprovePrompt != nil {
+		return l.ApprovePrompt(change)
+	}
+	return ReviewApprove
+}
+
+// specs returns the specs section of the prompt, or "" if there are no
+// specs.
+func (l *Loop) specs() string {
+	specs, err := ReadSpecs(l.SpecsDir, l.SpecsInclude, l.SpecsExclude, l.Ignore)
+	if err != nil {
+		l.logf("⚠️ Cannot read the specs: %v\n", err)
+		return ""
+	}
+	if len(specs) == 0 {
+		return ""
+	}
+	return specsSection(specs)
+}
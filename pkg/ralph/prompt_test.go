@@ -0,0 +1,118 @@
+package ralph
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestBuildPromptCommands(t *testing.T) {
+	const prompt = `Output: {{shell "echo hi"}}`
+	tests := []struct {
+		name       string
+		noCommands bool
+		trusted    string
+		want       string
+	}{
+		{"run", false, prompt, "Output: hi"},
+		{"sandboxed", true, prompt, prompt},
+		{"changed without approval", false, "Output: none", prompt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var log strings.Builder
+			l := &Loop{NoPromptCommands: tt.noCommands, Log: &log}
+			l.trustedPromptHash = hashString(tt.trusted)
+			if got := l.buildPrompt(context.Background(), prompt); got != tt.want {
+				t.Errorf("buildPrompt = %q, want %q", got, tt.want)
+			}
+			if off := tt.want == prompt; off != strings.Contains(log.String(), "shell is off") {
+				t.Errorf("log = %q", log.String())
+			}
+		})
+	}
+}
+
+func TestBuildPromptVerbatimDiff(t *testing.T) {
+	chdirRepo(t, map[string]string{"README.md": "test\n"})
+	base := runGit(t, "rev-parse", "HEAD")
+	writeFiles(t, map[string]string{"README.md": "changed\n"})
+	l := &Loop{InjectDiff: InjectDiffStat, InjectDiffBytes: DefaultInjectDiffBytes, Log: io.Discard, runBase: base}
+	// The stray braces make the template fail, so the prompt is used
+	// verbatim.
+	got := l.buildPrompt(context.Background(), "Changes: {{diffstat}}\n{{diff}}\nKeep {{ this.\n")
+	if strings.Contains(got, "{{diff") {
+		t.Errorf("placeholders left in the prompt:\n%s", got)
+	}
+	if !strings.Contains(got, "+changed") || !strings.Contains(got, "README.md | 2") {
+		t.Errorf("diff missing from the prompt:\n%s", got)
+	}
+	if strings.Contains(got, "## Changes so far") {
+		t.Errorf("diff appended although the prompt placed it:\n%s", got)
+	}
+}
+
+func TestGitWorkDiffTruncatesRunes(t *testing.T) {
+	chdirRepo(t, map[string]string{"README.md": "test\n"})
+	base := runGit(t, "rev-parse", "HEAD")
+	writeFiles(t, map[string]string{"README.md": strings.Repeat("é", 1000) + "\n"})
+	full, err := gitWorkDiff(context.Background(), base, nil, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for limit := len(full) - 100; limit < len(full)-90; limit++ {
+		diff, err := gitWorkDiff(context.Background(), base, nil, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !utf8.ValidString(diff) {
+			t.Fatalf("diff truncated to %d bytes is not valid UTF-8", limit)
+		}
+	}
+}
+
+// setPromptAgent calls SetPrompt with next during its first run and records
+// the prompts it is sent.
+type setPromptAgent struct {
+	loop    *Loop
+	next    string
+	prompts []string
+}
+
+func (a *setPromptAgent) Run(ctx context.Context, prompt string) (Result, error) {
+	if len(a.prompts) == 0 {
+		a.loop.SetPrompt(a.next)
+	}
+	a.prompts = append(a.prompts, prompt)
+	return Result{Output: "working"}, nil
+}
+
+func TestSetPromptIsNotTrusted(t *testing.T) {
+	const next = `Output: {{shell "echo hi"}}`
+	tests := []struct {
+		name    string
+		approve func(PromptChange) ReviewDecision
+		want    string
+	}{
+		{"no approval configured", nil, next},
+		{"approved", func(PromptChange) ReviewDecision { return ReviewApprove }, "Output: hi"},
+		{"declined", func(PromptChange) ReviewDecision { return ReviewSkip }, "Work on the task."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := &setPromptAgent{next: next}
+			l := newTestLoop(agent)
+			l.Log, l.MaxIterations, l.ApprovePrompt = io.Discard, 2, tt.approve
+			agent.loop = l
+			if err := l.Run(context.Background()); !errors.Is(err, ErrMaxIterations) {
+				t.Fatalf("Run = %v, want ErrMaxIterations", err)
+			}
+			if len(agent.prompts) != 2 || !strings.HasPrefix(agent.prompts[1], tt.want) {
+				t.Errorf("second prompt = %q, want it to start with %q", agent.prompts[len(agent.prompts)-1], tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package ralph
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// Modes for Loop.PromptOverflow.
+const (
+	// PromptOverflowRefuse stops the loop rather than send the prompt.
+	PromptOverflowRefuse = "refuse"
+	// PromptOverflowTruncate cuts the middle out of the prompt, keeping the
+	// instructions at its start and the latest feedback at its end.
+	PromptOverflowTruncate = "truncate"
+)
+
+// limitPrompt applies PromptWarnTokens and PromptMaxTokens to the prompt
+// about to be sent. It reports false if the prompt must not be sent.
+func (l *Loop) limitPrompt(prompt string) (string, bool) {
+	tokens := EstimateTokens(prompt)
+	if l.PromptMaxTokens > 0 && tokens > l.PromptMaxTokens {
+		if l.PromptOverflow != PromptOverflowTruncate {
+			return prompt, false
+		}
+		l.logf("✂️  The prompt is about %d tokens, over the limit of %d: cutting out its middle.\n", tokens, l.PromptMaxTokens)
+		return truncatePrompt(prompt, l.PromptMaxTokens), true
+	}
+	warn := l.PromptWarnTokens > 0 && tokens > l.PromptWarnTokens
+	if warn && !l.promptWarned {
+		l.logf("⚠️ The prompt is about %d tokens, over the warning threshold of %d.\n", tokens, l.PromptWarnTokens)
+	}
+	l.promptWarned = warn
+	return prompt, true
+}
+
+// truncatePrompt cuts the middle out of prompt so that it fits in
+// maxTokens, leaving a note where it was cut.
+func truncatePrompt(prompt string, maxTokens int) string {
+	maxBytes := maxTokens * 4
+	if len(prompt) <= maxBytes {
+		return prompt
+	}
+	// Make room for the note with the widest count it can hold.
+	keep := maxBytes - len(truncationNote(len(prompt)))
+	if keep <= 0 {
+		return prompt[:runeStart(prompt, maxBytes)]
+	}
+	head := runeStart(prompt, keep/2)
+	tail := len(prompt) - (keep - keep/2)
+	for tail < len(prompt) && !utf8.RuneStart(prompt[tail]) {
+		tail++
+	}
+	return prompt[:head] + truncationNote(tail-head) + prompt[tail:]
+}
+
+// truncationNote marks where truncatePrompt cut bytes out.
+func truncationNote(cut int) string {
+	return fmt.Sprintf("\n\n... [prompt truncated: %d bytes cut to fit the size limit] ...\n\n", cut)
+}
+
+// runeStart moves i back to the start of the rune it falls in.
+func runeStart(s string, i int) int {
+	for i > 0 && i < len(s) && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return i
+}
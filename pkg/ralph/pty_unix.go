@@ -0,0 +1,71 @@
+//go:build !windows
+
+package ralph
+
+import (
+	"io"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// ptySize is the terminal size reported to agents run under a PTY.
+var ptySize = &pty.Winsize{Rows: 50, Cols: 160}
+
+// runWithPTY runs cmd with its stdout and stderr on a new pseudo-terminal,
+// copying everything it prints to out. Stdin is left alone so a prompt
+// piped on stdin is not echoed back into the output.
+func runWithPTY(cmd *exec.Cmd, out io.Writer) error {
+	master, tty, err := pty.Open()
+	if err != nil {
+		return err
+	}
+	defer master.Close()
+	if err := pty.Setsize(master, ptySize); err != nil {
+		tty.Close()
+		return err
+	}
+	// pty leaves master blocking; reading it through the poller instead
+	// lets closing it end the copy below.
+	if err := syscall.SetNonblock(int(master.Fd()), true); err != nil {
+		tty.Close()
+		return err
+	}
+
+	// The terminal must become the agent's controlling terminal, which
+	// takes a new session; a session leader also leads its own process
+	// group, so killing the group on cancel still works.
+	cmd.Stdout, cmd.Stderr = tty, tty
+	cmd.SysProcAttr.Setpgid = false
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 1
+
+	err = cmd.Start()
+	tty.Close()
+	if err != nil {
+		return err
+	}
+
+	copied := make(chan struct{})
+	go func() {
+		// Reading the master fails with EIO once every holder of the
+		// terminal has exited; that is the normal end of output.
+		_, _ = io.Copy(out, master)
+		close(copied)
+	}()
+
+	err = cmd.Wait()
+	// Background processes the agent left behind may keep the terminal
+	// open; don't wait on them forever. Once master is closed the copy
+	// stops, so nothing writes to out after this returns.
+	select {
+	case <-copied:
+	case <-time.After(AgentWaitDelay):
+		master.Close()
+		<-copied
+	}
+	return err
+}
@@ -0,0 +1,40 @@
+//go:build !windows
+
+package ralph
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunWithPTYBackgroundProcess(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits AgentWaitDelay")
+	}
+	// The background loop keeps the terminal open, and writing to it, after
+	// the agent exits.
+	cmd := exec.CommandContext(context.Background(), "sh", "-c", "trap '' HUP; while :; do echo tick; sleep 0.1; done & echo started")
+	setProcessGroup(cmd)
+	t.Cleanup(func() {
+		if cmd.Process != nil {
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
+	})
+	var out strings.Builder
+	start := time.Now()
+	if err := runWithPTY(cmd, &out); err != nil {
+		t.Fatal(err)
+	}
+	// Reading out races with the copy unless runWithPTY stopped it.
+	time.Sleep(300 * time.Millisecond)
+	if got := out.String(); !strings.Contains(got, "started") {
+		t.Errorf("output = %q, want it to contain started", got)
+	}
+	if d := time.Since(start); d > AgentWaitDelay+5*time.Second {
+		t.Errorf("runWithPTY took %s", d)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package ralph
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+)
+
+// runWithPTY is not available on Windows.
+func runWithPTY(cmd *exec.Cmd, out io.Writer) error {
+	return errors.New("running the agent under a pseudo-terminal is not supported on Windows")
+}
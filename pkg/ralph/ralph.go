@@ -0,0 +1,228 @@
+// Package ralph implements the Ralph loop: run an AI coding agent against the
+// same prompt over and over, feeding verification failures back in, until the
+// task is done.
+//
+// The ralph command is a thin CLI around Loop; embedders construct a Loop
+// with their own Agent and OnEvent callback instead of shelling out to it.
+package ralph
+
+import (
+	"context"
+	"regexp"
+	"time"
+)
+
+// Defaults used by the CLI and by a zero Loop.
+const (
+	PromptFile   = "PROMPT.md"
+	ErrorLogFile = "ralph-error.log"
+	MaxLogLines  = 300
+
+	// DefaultStopSignal is the token the agent prints once the task is done.
+	DefaultStopSignal = "RALPH_DONE"
+	// DefaultBlockedSignal is the token the agent prints when it is stuck
+	// and needs a human.
+	DefaultBlockedSignal = "RALPH_BLOCKED"
+	// DefaultDoneFile is the file the agent can create instead, see
+	// Loop.DoneFile.
+	DefaultDoneFile = ".ralph_done"
+	// FeedbackPlaceholder marks where failure output goes in the prompt. It is
+	// also a valid template action, so it works in templated prompts too.
+	FeedbackPlaceholder = "{{feedback}}"
+	// TaskPlaceholder marks where Loop.Task goes in the prompt.
+	TaskPlaceholder = "{{task}}"
+	// CarryoverPlaceholder marks where the previous iteration's output goes
+	// in the prompt. See Loop.Carryover.
+	CarryoverPlaceholder = "{{carryover}}"
+	// MemoryPlaceholder marks where the memory file goes in the prompt. See
+	// Loop.MemoryFile.
+	MemoryPlaceholder = "{{memory}}"
+	// SignalsPlaceholder marks where the stop and blocked signal
+	// instructions go in the prompt. See Loop.SignalFooter.
+	SignalsPlaceholder = "{{signals}}"
+	// SpecsPlaceholder marks where the specs go in the prompt. See
+	// Loop.SpecsDir.
+	SpecsPlaceholder = "{{specs}}"
+	// IssuePlaceholder marks where the GitHub issue goes in the prompt. See
+	// Loop.Issue.
+	IssuePlaceholder = "{{issue}}"
+	// DiffPlaceholder and DiffstatPlaceholder mark where the changes made
+	// since the run started go in the prompt, as a diff or a diffstat. See
+	// Loop.InjectDiff.
+	DiffPlaceholder     = "{{diff}}"
+	DiffstatPlaceholder = "{{diffstat}}"
+
+	// DefaultSleep is the rest between iterations.
+	DefaultSleep = 2 * time.Second
+	// DefaultMaxBackoff caps the rest after repeated agent errors.
+	DefaultMaxBackoff = 5 * time.Minute
+	// DefaultRateLimitWait is the rest after the agent reports a rate limit.
+	DefaultRateLimitWait = 5 * time.Minute
+	// MaxRateLimitWait caps the wait after consecutive rate limits.
+	MaxRateLimitWait = time.Hour
+	// OutputTailBytes bounds the output excerpt carried by status events.
+	OutputTailBytes = 500
+)
+
+// DefaultRateLimitPattern matches the rate-limit and quota errors printed by
+// the supported agent CLIs.
+var DefaultRateLimitPattern = regexp.MustCompile(`(?i)\b429\b|rate[ _-]?limit|too many requests|overloaded|quota|usage limit`)
+
+// Agent runs a single iteration of work for a prompt.
+type Agent interface {
+	Run(ctx context.Context, prompt string) (Result, error)
+}
+
+// StreamingAgent is an Agent that can report its output while it runs,
+// which lets the loop stop it as soon as it prints a stop signal.
+type StreamingAgent interface {
+	Agent
+	// RunStreaming is Run, calling onOutput with every chunk of output as
+	// it arrives.
+	RunStreaming(ctx context.Context, prompt string, onOutput func([]byte)) (Result, error)
+}
+
+// Result is the outcome of one agent invocation.
+type Result struct {
+	// Output is the agent's combined stdout and stderr.
+	Output string
+	// ExitCode is the agent process exit status, or -1 if it did not run
+	// to completion (killed, failed to start).
+	ExitCode int
+	// Usage is the run's cost and token counts, if the agent reports them.
+	Usage *Usage
+	// Commits are the hashes of the commits the agent reported making, for
+	// agents with a CommitPattern.
+	Commits []string
+	// OOMKilled reports that the agent was killed for exceeding its memory
+	// limit (see CommandAgent.Limits).
+	OOMKilled bool
+}
+
+// IterationReview describes a finished iteration to Loop.Review.
+type IterationReview struct {
+	Iteration int
+	// Output is the agent's output.
+	Output string
+	// DiffStat summarizes the iteration's changes to the git work tree; it
+	// is empty outside a repository.
+	DiffStat string
+}
+
+// ReviewDecision is the outcome of Loop.Review.
+type ReviewDecision int
+
+const (
+	// ReviewApprove keeps the iteration and goes on.
+	ReviewApprove ReviewDecision = iota
+	// ReviewSkip reverts the iteration's changes, tells the agent so, and
+	// goes on.
+	ReviewSkip
+	// ReviewAbort stops the loop as if Stop had been called.
+	ReviewAbort
+)
+
+// Status event names.
+const (
+	EventIterationStart    = "iteration_start"
+	EventIterationEnd      = "iteration_end"
+	EventComplete          = "complete"
+	EventCancelled         = "cancelled"
+	EventCancelledGraceful = "cancelled_graceful"
+	EventTimeout           = "timeout"
+	EventOOMKilled         = "oom_killed"
+	EventMaxIterations     = "max_iterations_reached"
+	EventBudgetExceeded    = "budget_exceeded"
+	EventDeadlineExceeded  = "deadline_exceeded"
+	EventCommitted         = "committed"
+	EventRateLimited       = "rate_limited"
+	EventStalled           = "stalled"
+	EventValidationFailed  = "validation_failed"
+	EventErrorAbort        = "error_abort"
+	EventBlocked           = "blocked"
+	EventPromptTooLarge    = "prompt_too_large"
+	EventGuardFailed       = "guard_failed"
+	EventReverted          = "reverted"
+	EventPaused            = "paused"
+	EventResumed           = "resumed"
+	EventPromptUpdated     = "prompt_updated"
+	EventPromptChanged     = "prompt_changed"
+	EventPromptRejected    = "prompt_rejected"
+	EventReviewApproved    = "review_approved"
+	EventReviewRejected    = "review_rejected"
+	EventPhaseStarted      = "phase_started"
+	EventPhaseComplete     = "phase_complete"
+	EventNoteRecorded      = "note_recorded"
+	EventAgentSwitched     = "agent_switched"
+	// EventScheduled and EventScheduleSkipped come from the CLI's --schedule
+	// mode between runs, not from a Loop.
+	EventScheduled       = "scheduled"
+	EventScheduleSkipped = "schedule_skipped"
+	// EventAgentOutput carries a chunk of live agent output. The loop itself
+	// never emits it; the CLI's JSON output mode and API do.
+	EventAgentOutput = "agent_output_chunk"
+)
+
+// StatusEvent is reported to Loop.OnEvent whenever the loop changes state.
+type StatusEvent struct {
+	Event     string    `json:"event"`
+	Agent     string    `json:"agent"`
+	Iteration int       `json:"iteration"`
+	Timestamp time.Time `json:"timestamp"`
+	Message   string    `json:"message,omitempty"`
+	// AgentVersion is what the agent printed for its version, if anything.
+	AgentVersion string `json:"agent_version,omitempty"`
+	// Phase names the phase the loop is in, for loops with Phases.
+	Phase string `json:"phase,omitempty"`
+	// PID and StartedAt identify the run that emitted the event.
+	PID       int       `json:"pid"`
+	StartedAt time.Time `json:"started_at"`
+	// WaitMS is how long the loop will wait before the next iteration.
+	WaitMS int64 `json:"wait_ms,omitempty"`
+
+	// DurationMS, OutputBytes and AgentExitCode describe the agent run of
+	// the iteration; they are set on iteration_end and on final events.
+	DurationMS    int64 `json:"duration_ms,omitempty"`
+	OutputBytes   int   `json:"output_bytes,omitempty"`
+	AgentExitCode *int  `json:"agent_exit_code,omitempty"`
+	// OutputTail is the end of the agent output, at most OutputTailBytes.
+	OutputTail string `json:"output_tail,omitempty"`
+	// Usage is the agent run's cost and token counts, on iteration_end and
+	// final events, for agents that report them. TotalUsage sums up the
+	// whole run, reviews and summaries included.
+	Usage      *Usage `json:"usage,omitempty"`
+	TotalUsage *Usage `json:"total_usage,omitempty"`
+	// StopReason explains why the run ended; set on final events only.
+	StopReason string `json:"stop_reason,omitempty"`
+	// Payload holds the fields of the JSON object the agent printed after
+	// its stop signal, e.g. RALPH_DONE {"summary": "..."}; set on the final
+	// event of a completed run.
+	Payload map[string]any `json:"payload,omitempty"`
+	// Chunk is the output carried by agent_output_chunk events.
+	Chunk string `json:"chunk,omitempty"`
+}
+
+// Stop reasons reported in StatusEvent.StopReason.
+const (
+	StopReasonCheckPassed      = "check_passed"
+	StopReasonStopSignal       = "stop_signal"
+	StopReasonMaxIterations    = "max_iterations"
+	StopReasonBudgetExceeded   = "budget_exceeded"
+	StopReasonDeadlineExceeded = "deadline_exceeded"
+	StopReasonStalled          = "stalled"
+	StopReasonAgentErrors      = "agent_errors"
+	StopReasonBlocked          = "blocked"
+	StopReasonPromptTooLarge   = "prompt_too_large"
+	StopReasonHookFailed       = "hook_failed"
+	StopReasonCancelled        = "cancelled"
+	StopReasonStopped          = "stopped"
+)
+
+// Terminal reports whether the event ends a run.
+func (e StatusEvent) Terminal() bool {
+	switch e.Event {
+	case EventComplete, EventCancelled, EventCancelledGraceful, EventMaxIterations, EventBudgetExceeded, EventDeadlineExceeded, EventStalled, EventErrorAbort, EventBlocked, EventPromptTooLarge:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package ralph
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RedactedText replaces the secrets a Redactor finds.
+const RedactedText = "[REDACTED]"
+
+// DefaultSecretPatterns match the API keys and tokens of common services.
+var DefaultSecretPatterns = []string{
+	`\bsk-[A-Za-z0-9_-]{20,}`,               // Anthropic, OpenAI, OpenRouter
+	`\bgh[pousr]_[A-Za-z0-9]{36,}`,          // GitHub
+	`\bgithub_pat_[A-Za-z0-9_]{22,}`,        // GitHub fine-grained
+	`\bglpat-[A-Za-z0-9_-]{20,}`,            // GitLab
+	`\bAKIA[0-9A-Z]{16}\b`,                  // AWS access key ID
+	`\bAIza[0-9A-Za-z_-]{35}`,               // Google
+	`\bxox[abposr]-[A-Za-z0-9-]{10,}`,       // Slack
+	`(?i)\bbearer [A-Za-z0-9._~+/-]{20,}=*`, // Authorization headers
+}
+
+// DefaultSecretEnv are the names of the environment variables whose values
+// are secrets, as patterns.
+var DefaultSecretEnv = []string{"*_API_KEY", "*_TOKEN", "*_SECRET", "*_PASSWORD"}
+
+// minSecretValue is the length below which environment values are not
+// masked: they would match all over the place.
+const minSecretValue = 8
+
+// maxRedactHold bounds the partial line a RedactWriter holds back.
+const maxRedactHold = 4 << 10
+
+// Redactor masks secrets in text: the matches of patterns and the values
+// of environment variables. A nil Redactor masks nothing.
+type Redactor struct {
+	re *regexp.Regexp
+}
+
+// NewRedactor returns a Redactor for the regular expressions patterns and
+// the literal secrets values.
+func NewRedactor(patterns, values []string) (*Redactor, error) {
+	var alts []string
+	for _, p := range patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("bad redact pattern %q: %w", p, err)
+		}
+		alts = append(alts, "(?:"+p+")")
+	}
+	// Longest first, so that no value is masked only in part.
+	values = append([]string(nil), values...)
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, v := range values {
+		if len(v) >= minSecretValue {
+			alts = append(alts, regexp.QuoteMeta(v))
+		}
+	}
+	if len(alts) == 0 {
+		return nil, nil
+	}
+	return &Redactor{re: regexp.MustCompile(strings.Join(alts, "|"))}, nil
+}
+
+// SecretEnvValues returns the values of the variables of env, in
+// os.Environ form, whose names match one of names; names are patterns as
+// in path.Match.
+func SecretEnvValues(env []string, names []string) []string {
+	var values []string
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		for _, n := range names {
+			if ok, _ := path.Match(n, k); ok {
+				values = append(values, v)
+				break
+			}
+		}
+	}
+	return values
+}
+
+// Redact returns s with every secret replaced by RedactedText.
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	return r.re.ReplaceAllLiteralString(s, RedactedText)
+}
+
+// redactPayload redacts the string values of an agent's stop payload.
+func (r *Redactor) redactPayload(payload map[string]any) map[string]any {
+	if r == nil || payload == nil {
+		return payload
+	}
+	redacted := make(map[string]any, len(payload))
+	for k, v := range payload {
+		if s, ok := v.(string); ok {
+			v = r.Redact(s)
+		}
+		redacted[k] = v
+	}
+	return redacted
+}
+
+// Writer returns a writer that redacts what it passes on to w. It passes
+// on whole lines, so that a secret written in pieces is still found, and
+// holds back the rest until Flush.
+func (r *Redactor) Writer(w io.Writer) *RedactWriter {
+	return &RedactWriter{r: r, w: w}
+}
+
+// RedactWriter is the writer of Redactor.Writer.
+type RedactWriter struct {
+	r   *Redactor
+	w   io.Writer
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (rw *RedactWriter) Write(p []byte) (int, error) {
+	if rw.r == nil {
+		return rw.w.Write(p)
+	}
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.buf = append(rw.buf, p...)
+	n := bytes.LastIndexAny(rw.buf, "\n\r") + 1
+	if n == 0 {
+		if len(rw.buf) < maxRedactHold {
+			return len(p), nil
+		}
+		n = len(rw.buf)
+	}
+	_, err := io.WriteString(rw.w, rw.r.Redact(string(rw.buf[:n])))
+	rw.buf = append(rw.buf[:0], rw.buf[n:]...)
+	return len(p), err
+}
+
+// Flush passes on the partial line held back.
+func (rw *RedactWriter) Flush() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if len(rw.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(rw.w, rw.r.Redact(string(rw.buf)))
+	rw.buf = rw.buf[:0]
+	return err
+}
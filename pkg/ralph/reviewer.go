@@ -0,0 +1,74 @@
+package ralph
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ReviewApprovedSignal is what the reviewer agent prints to accept the
+// builder's work. Anything else counts as a rejection.
+const ReviewApprovedSignal = "RALPH_APPROVED"
+
+// MaxReviewDiffBytes bounds the diff handed to the reviewer.
+const MaxReviewDiffBytes = 100_000
+
+// reviewRejectedHeader starts the feedback written for a rejection.
+const reviewRejectedHeader = "A reviewer rejected the changes made so far:\n\n"
+
+// DefaultReviewPrompt instructs the reviewer agent. The builder's task and
+// the diff of its work are appended.
+const DefaultReviewPrompt = `You are reviewing changes another AI agent made to this repository.
+Do not modify any files. Check whether the changes below correctly and
+completely accomplish the task, and look for bugs, missing tests and
+unfinished work.
+
+If the work is correct and complete, reply with the single word ` + ReviewApprovedSignal + `.
+Otherwise do not print that word; list the concrete problems the other agent
+must fix next.`
+
+// peerReview runs the Reviewer agent over the work done since the run
+// started and reports whether it approved. A rejection is written to the
+// error log, which feeds it into the builder's next prompt.
+func (l *Loop) peerReview(ctx context.Context, instructions, reason string) bool {
+	l.reviewedAt = l.iteration
+
+	diff, err := gitWorkDiff(ctx, l.runBase, l.Ignore, MaxReviewDiffBytes)
+	if err != nil {
+		diff = fmt.Sprintf("(diff unavailable: %v)", err)
+	} else if diff == "" {
+		diff = "(no changes)"
+	}
+	prompt := l.ReviewPrompt
+	if prompt == "" {
+		prompt = DefaultReviewPrompt
+	}
+	prompt = fmt.Sprintf("%s\n\n## The task\n\n%s\n\n## The changes so far\n\n```diff\n%s\n```\n", strings.TrimRight(prompt, "\n"), strings.TrimSpace(instructions), diff)
+
+	l.logf("\n🧑‍⚖️ %s. Asking %s to review...\n", reason, l.ReviewerName)
+	result, err := l.Reviewer.Run(ctx, prompt)
+	l.addUsage(result.Usage)
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		l.logf("⚠️ Reviewer failed: %v\n", err)
+		l.emit(EventReviewRejected, fmt.Sprintf("reviewer failed: %v", err))
+		return false
+	}
+	if strings.Contains(result.Output, ReviewApprovedSignal) {
+		// An earlier rejection has been dealt with.
+		if data, err := os.ReadFile(l.ErrorLogFile); err == nil && strings.HasPrefix(string(data), reviewRejectedHeader) {
+			_ = os.Remove(l.ErrorLogFile)
+		}
+		l.logf("\n✅ %s approved the changes.\n", l.ReviewerName)
+		l.emit(EventReviewApproved, "")
+		return true
+	}
+
+	l.logf("\n❌ %s rejected the changes. Writing the review to disk...\n", l.ReviewerName)
+	l.writeErrorLog(reviewRejectedHeader + strings.TrimSpace(result.Output))
+	l.emit(EventReviewRejected, tail(result.Output, OutputTailBytes))
+	return false
+}
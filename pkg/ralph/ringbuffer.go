@@ -0,0 +1,50 @@
+package ralph
+
+import "fmt"
+
+// DefaultMaxOutputBytes is how much agent output is kept per iteration.
+const DefaultMaxOutputBytes = 10 << 20
+
+// ringBuffer is an io.Writer that keeps only the last size bytes written.
+type ringBuffer struct {
+	buf     []byte
+	size    int
+	start   int // index of the oldest byte once the buffer is full
+	dropped int64
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{size: size}
+}
+
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(p) >= r.size {
+		r.dropped += int64(len(r.buf) + len(p) - r.size)
+		r.buf = append(r.buf[:0], p[len(p)-r.size:]...)
+		r.start = 0
+		return n, nil
+	}
+	if room := r.size - len(r.buf); room > 0 {
+		k := min(room, len(p))
+		r.buf = append(r.buf, p[:k]...)
+		p = p[k:]
+	}
+	for len(p) > 0 {
+		k := copy(r.buf[r.start:], p)
+		r.dropped += int64(k)
+		r.start = (r.start + k) % r.size
+		p = p[k:]
+	}
+	return n, nil
+}
+
+// String returns the retained output, prefixed with a marker if the
+// beginning was discarded.
+func (r *ringBuffer) String() string {
+	s := string(r.buf[r.start:]) + string(r.buf[:r.start])
+	if r.dropped > 0 {
+		s = fmt.Sprintf("[... %d bytes of earlier output discarded ...]\n", r.dropped) + s
+	}
+	return s
+}
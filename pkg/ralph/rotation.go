@@ -0,0 +1,11 @@
+package ralph
+
+// rotate makes the agent of Rotation whose turn the current iteration is
+// the loop's agent.
+func (l *Loop) rotate() {
+	if len(l.Rotation) == 0 {
+		return
+	}
+	next := l.Rotation[(l.iteration-1)%len(l.Rotation)]
+	l.Agent, l.AgentName, l.AgentVersion = next.Agent, next.Name, next.Version
+}
@@ -0,0 +1,229 @@
+package ralph
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultSandboxImage is the image a Sandbox runs without one set. ralph
+// does not provide it: build it from an image with the agent installed.
+const DefaultSandboxImage = "ralph-sandbox"
+
+// sandboxRuns numbers the containers of this process.
+var sandboxRuns atomic.Int64
+
+// Sandbox runs agents in Docker containers instead of on the host. The
+// working directory is bind-mounted at the same path, so paths in prompts
+// and output mean the same inside and out; the agent runs as the current
+// user with HOME=/tmp, so the files it writes are not owned by root.
+//
+// With Devcontainer set, agents run in the project's devcontainer instead,
+// through the devcontainer CLI, with the toolchain the developers use.
+type Sandbox struct {
+	// Image is the container image (default DefaultSandboxImage). It must
+	// have the agent installed.
+	Image string
+	// Network is passed to docker run --network, e.g. "none" ("" = Docker's
+	// default bridge network, which the agent needs to reach its API).
+	Network string
+	// CPUs and Memory limit the container, e.g. "2" and "4g" ("" = no limit).
+	CPUs   string
+	Memory string
+	// Mounts are extra bind mounts, "src:dst[:ro]", e.g. to hand the agent
+	// its credentials: "~/.claude:/tmp/.claude". A leading ~ is expanded.
+	Mounts []string
+	// Env names host environment variables passed into the container, e.g.
+	// ANTHROPIC_API_KEY. The agent's own Env and EnvFiles always are.
+	Env []string
+
+	// Devcontainer runs the agent in the devcontainer of Workspace (default:
+	// found from the working directory up, see FindDevcontainer). Image,
+	// Network, CPUs, Memory and Mounts do not apply: devcontainer.json
+	// decides. Prompt files go to PromptDir, which must be inside the
+	// workspace (default: its .ralph directory).
+	Devcontainer bool
+	Workspace    string
+	PromptDir    string
+
+	mu        sync.Mutex
+	container *devcontainer
+}
+
+func (s *Sandbox) image() string {
+	if s.Image == "" {
+		return DefaultSandboxImage
+	}
+	return s.Image
+}
+
+// String describes the sandbox for banners, e.g. "docker:node:22, network none".
+func (s *Sandbox) String() string {
+	if s.Devcontainer {
+		if ws, err := s.workspace(); err == nil {
+			return "devcontainer of " + ws
+		}
+		return "devcontainer"
+	}
+	parts := []string{"docker:" + s.image()}
+	if s.Network != "" {
+		parts = append(parts, "network "+s.Network)
+	}
+	if s.CPUs != "" {
+		parts = append(parts, s.CPUs+" CPUs")
+	}
+	if s.Memory != "" {
+		parts = append(parts, s.Memory+" memory")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Check reports where the docker or devcontainer CLI is, or why the
+// sandbox cannot run.
+func (s *Sandbox) Check(ctx context.Context) (string, error) {
+	if s.Devcontainer {
+		if _, err := s.workspace(); err != nil {
+			return "", err
+		}
+		path, err := exec.LookPath("devcontainer")
+		if err != nil {
+			return "", fmt.Errorf("devcontainer CLI not found on PATH (npm install -g @devcontainers/cli)")
+		}
+		return path, nil
+	}
+	path, err := exec.LookPath("docker")
+	if err != nil {
+		return "", fmt.Errorf("docker not found on PATH (needed by the sandbox)")
+	}
+	for _, m := range s.Mounts {
+		if _, _, err := splitMount(m); err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+// CheckImage reports an image that is not present locally, which docker
+// run would try to pull.
+func (s *Sandbox) CheckImage(ctx context.Context) error {
+	if s.Devcontainer {
+		return nil
+	}
+	if exec.CommandContext(ctx, "docker", "image", "inspect", s.image()).Run() != nil {
+		return fmt.Errorf("sandbox image %s is not available locally; docker will try to pull it", s.image())
+	}
+	return nil
+}
+
+// splitMount splits mount into the host path, with ~ expanded, and the
+// rest ("dst[:ro]"), after checking that the host path exists.
+func splitMount(mount string) (src, target string, err error) {
+	i := strings.Index(mount, ":")
+	// A Windows drive letter is not a separator.
+	if runtime.GOOS == "windows" && i == 1 {
+		if j := strings.Index(mount[2:], ":"); j >= 0 {
+			i = j + 2
+		} else {
+			i = -1
+		}
+	}
+	if i <= 0 || i == len(mount)-1 {
+		return "", "", fmt.Errorf("invalid sandbox mount %q (want src:dst[:ro])", mount)
+	}
+	src, target = mount[:i], mount[i+1:]
+	if rest, found := strings.CutPrefix(src, "~"); found {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", err
+		}
+		src = filepath.Join(home, rest)
+	}
+	if _, err := os.Stat(src); err != nil {
+		return "", "", fmt.Errorf("sandbox mount %s: %w", mount, err)
+	}
+	return src, target, nil
+}
+
+// wrap turns cmd, built to run on the host, into a docker run of the same
+// command line. promptFile, if set, is mounted read-only. def supplies the
+// names of the variables to pass in.
+func (s *Sandbox) wrap(ctx context.Context, cmd *exec.Cmd, promptFile string, def AgentDef, tty bool) error {
+	if s.Devcontainer {
+		return s.wrapDevcontainer(ctx, cmd, promptFile, def)
+	}
+	dir := cmd.Dir
+	if dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		dir = wd
+	}
+	name := fmt.Sprintf("ralph-%d-%d", os.Getpid(), sandboxRuns.Add(1))
+	args := []string{"run", "--rm", "--init", "-i", "--name", name,
+		"-v", dir + ":" + dir, "-w", dir, "-e", "HOME=/tmp"}
+	if tty {
+		args = append(args, "-t")
+	}
+	if uid, gid := os.Getuid(), os.Getgid(); uid >= 0 {
+		args = append(args, "--user", strconv.Itoa(uid)+":"+strconv.Itoa(gid))
+	}
+	if s.Network != "" {
+		args = append(args, "--network", s.Network)
+	}
+	if s.CPUs != "" {
+		args = append(args, "--cpus", s.CPUs)
+	}
+	if s.Memory != "" {
+		args = append(args, "--memory", s.Memory)
+	}
+	for _, m := range s.Mounts {
+		src, target, err := splitMount(m)
+		if err != nil {
+			return err
+		}
+		args = append(args, "-v", src+":"+target)
+	}
+	if promptFile != "" {
+		args = append(args, "-v", promptFile+":"+promptFile+":ro")
+	}
+	// docker run -e NAME takes the value from its own environment, which
+	// is the agent's.
+	names := append([]string{}, s.Env...)
+	names = append(names, sortedKeys(def.Env)...)
+	names = append(names, sortedKeys(def.EnvFiles)...)
+	seen := map[string]bool{}
+	for _, n := range names {
+		if !seen[n] {
+			seen[n] = true
+			args = append(args, "-e", n)
+		}
+	}
+	args = append(args, s.image())
+	args = append(args, cmd.Args...)
+
+	docker, err := exec.LookPath("docker")
+	if err != nil {
+		return fmt.Errorf("docker not found on PATH (needed by the sandbox)")
+	}
+	cmd.Path = docker
+	cmd.Args = append([]string{"docker"}, args...)
+	cmd.Err = nil
+	// Killing the docker CLI leaves the container running.
+	cancel := cmd.Cancel
+	cmd.Cancel = func() error {
+		_ = exec.Command("docker", "kill", name).Run()
+		if cancel != nil {
+			return cancel()
+		}
+		return cmd.Process.Kill()
+	}
+	return nil
+}
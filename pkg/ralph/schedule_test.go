@@ -0,0 +1,60 @@
+package ralph
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// A Wednesday.
+	from := time.Date(2025, time.January, 15, 10, 30, 0, 0, time.Local)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2025, time.January, 15, 10, 31, 0, 0, time.Local)},
+		{"0 2 * * *", time.Date(2025, time.January, 16, 2, 0, 0, 0, time.Local)},
+		{"*/15 * * * *", time.Date(2025, time.January, 15, 10, 45, 0, 0, time.Local)},
+		{"5-10/5 11 * * *", time.Date(2025, time.January, 15, 11, 5, 0, 0, time.Local)},
+		{"0 9 * * mon-fri", time.Date(2025, time.January, 16, 9, 0, 0, 0, time.Local)},
+		{"0 0 * * 7", time.Date(2025, time.January, 19, 0, 0, 0, 0, time.Local)},
+		{"0 0 1 MAR *", time.Date(2025, time.March, 1, 0, 0, 0, 0, time.Local)},
+		{"30 10 1,15 * *", time.Date(2025, time.February, 1, 10, 30, 0, 0, time.Local)},
+		// Both day fields restricted: either one matches.
+		{"0 0 20 * sat", time.Date(2025, time.January, 18, 0, 0, 0, 0, time.Local)},
+		{"@hourly", time.Date(2025, time.January, 15, 11, 0, 0, 0, time.Local)},
+		{"@monthly", time.Date(2025, time.February, 1, 0, 0, 0, 0, time.Local)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.expr)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("ParseSchedule(%q).Next = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"@often",
+	} {
+		if _, err := ParseSchedule(expr); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded, want an error", expr)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package ralph
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrReplayExhausted is returned by an agent replaying a session once every
+// recorded run has been played back.
+var ErrReplayExhausted = errors.New("no more agent runs in the recording")
+
+// SessionRun is one agent run in a session recording. A recording is a
+// file with one SessionRun per line, as JSON, in the order the runs
+// happened: the loop's agents, reviewers and summaries alike.
+type SessionRun struct {
+	// Agent is the agent's executable or API.
+	Agent      string    `json:"agent"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS int64     `json:"duration_ms"`
+	// Prompt and Output are redacted by the agent's Redactor.
+	Prompt   string   `json:"prompt"`
+	Output   string   `json:"output"`
+	ExitCode int      `json:"exit_code"`
+	Error    string   `json:"error,omitempty"`
+	Usage    *Usage   `json:"usage,omitempty"`
+	Commits  []string `json:"commits,omitempty"`
+}
+
+// SessionRecorder appends the runs of the agents it is set on to a
+// recording; see CommandAgent.Record.
+type SessionRecorder struct {
+	mu  sync.Mutex
+	f   *os.File
+	err error
+}
+
+// CreateSession creates (or truncates) a recording at path.
+func CreateSession(path string) (*SessionRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionRecorder{f: f}, nil
+}
+
+// Close closes the recording and returns the first error recording a run
+// met, if any.
+func (r *SessionRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.f.Close(); r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+func (r *SessionRecorder) record(run SessionRun) {
+	data, err := json.Marshal(run)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		_, err = r.f.Write(append(data, '\n'))
+	}
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// SessionReplay plays a recording back in place of running agents; see
+// CommandAgent.Replay. Only what the agents printed is replayed, not what
+// they changed in the work tree.
+type SessionReplay struct {
+	// OnMismatch, if set, is called when a run's prompt differs from the
+	// recorded one, which means the replay has diverged from the recording.
+	OnMismatch func(run int)
+	// OnExhausted, if set, is called when a run is asked for after the last
+	// recorded one.
+	OnExhausted func()
+
+	mu   sync.Mutex
+	runs []SessionRun
+	next int
+}
+
+// OpenSession reads the recording at path.
+func OpenSession(path string) (*SessionReplay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var runs []SessionRun
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<30)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var run SessionRun
+		if err := json.Unmarshal([]byte(line), &run); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		runs = append(runs, run)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("%s: no agent runs recorded", path)
+	}
+	return &SessionReplay{runs: runs}, nil
+}
+
+// Len returns the number of recorded runs.
+func (s *SessionReplay) Len() int {
+	return len(s.runs)
+}
+
+// take returns the next recorded run and its number, from 1.
+func (s *SessionReplay) take() (SessionRun, int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.next == len(s.runs) {
+		return SessionRun{}, 0, false
+	}
+	s.next++
+	return s.runs[s.next-1], s.next, true
+}
+
+// runReplay is RunStreaming for an agent replaying a recording: the next
+// recorded run is written to w, without any delay.
+func (a *CommandAgent) runReplay(ctx context.Context, prompt string, w io.Writer) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{ExitCode: -1}, err
+	}
+	run, n, ok := a.Replay.take()
+	if !ok {
+		if a.Replay.OnExhausted != nil {
+			a.Replay.OnExhausted()
+		}
+		return Result{ExitCode: -1}, ErrReplayExhausted
+	}
+	// Prompts are recorded redacted.
+	if run.Prompt != a.Redactor.Redact(prompt) && a.Replay.OnMismatch != nil {
+		a.Replay.OnMismatch(n)
+	}
+	if a.Trace != nil {
+		fmt.Fprintf(a.Trace, "🔧 Replay: run %d of %d, recorded %s\n", n, a.Replay.Len(), run.StartedAt.Format(time.RFC3339))
+	}
+	_, _ = io.WriteString(w, run.Output)
+	result := Result{Output: run.Output, ExitCode: run.ExitCode, Usage: run.Usage, Commits: run.Commits}
+	if run.Error != "" {
+		return result, errors.New(run.Error)
+	}
+	return result, nil
+}
+
+// recordRun adds a finished run to the recording, if there is one.
+func (a *CommandAgent) recordRun(prompt string, start time.Time, result Result, err error) {
+	if a.Record == nil {
+		return
+	}
+	run := SessionRun{
+		Agent:      a.Binary(),
+		StartedAt:  start,
+		DurationMS: time.Since(start).Milliseconds(),
+		Prompt:     prompt,
+		Output:     result.Output,
+		ExitCode:   result.ExitCode,
+		Usage:      result.Usage,
+		Commits:    result.Commits,
+	}
+	switch {
+	case a.API != "":
+		run.Agent = a.API
+	case a.Mock != nil:
+		run.Agent = "mock"
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	a.Record.record(run)
+}
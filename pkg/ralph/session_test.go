@@ -0,0 +1,92 @@
+package ralph
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReplayRedacted(t *testing.T) {
+	const secret = "s3cret-value-1234"
+	redactor, err := NewRedactor(nil, []string{secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	rec, err := CreateSession(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recording := &CommandAgent{AgentDef: AgentDef{Mock: &MockAgent{}}, Redactor: redactor, Record: rec}
+	prompt := "Use the token " + secret + "."
+	if _, err := recording.Run(context.Background(), prompt); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), secret) {
+		t.Fatalf("recording holds the secret: %s", data)
+	}
+
+	// A recording made without redaction has the secret in its output.
+	var run SessionRun
+	if err := json.Unmarshal(data, &run); err != nil {
+		t.Fatal(err)
+	}
+	run.Output = "leaked " + secret + "\n"
+	data, _ = json.Marshal(run)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := OpenSession(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatch := false
+	replay.OnMismatch = func(int) { mismatch = true }
+	var stream, output strings.Builder
+	agent := &CommandAgent{Redactor: redactor, Replay: replay, Stream: &stream}
+	result, err := agent.RunStreaming(context.Background(), prompt, func(p []byte) { output.Write(p) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mismatch {
+		t.Error("replaying the recorded prompt reported a mismatch")
+	}
+	for name, got := range map[string]string{"result": result.Output, "stream": stream.String(), "onOutput": output.String()} {
+		if strings.Contains(got, secret) || !strings.Contains(got, RedactedText) {
+			t.Errorf("%s = %q, want the secret redacted", name, got)
+		}
+	}
+}
+
+func TestRunStreamingRedacted(t *testing.T) {
+	const secret = "s3cret-value-1234"
+	redactor, err := NewRedactor(nil, []string{secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixture := filepath.Join(t.TempDir(), "fixture.yaml")
+	if err := os.WriteFile(fixture, []byte("- output: leaked "+secret+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var stream, output strings.Builder
+	agent := &CommandAgent{AgentDef: AgentDef{Mock: &MockAgent{Fixture: fixture}}, Redactor: redactor, Stream: &stream}
+	if _, err := agent.RunStreaming(context.Background(), "Work on the task.", func(p []byte) { output.Write(p) }); err != nil {
+		t.Fatal(err)
+	}
+	for name, got := range map[string]string{"stream": stream.String(), "onOutput": output.String()} {
+		if strings.Contains(got, secret) || !strings.Contains(got, RedactedText) {
+			t.Errorf("%s = %q, want the secret redacted", name, got)
+		}
+	}
+}
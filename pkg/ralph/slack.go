@@ -0,0 +1,58 @@
+package ralph
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// NewSlackNotifier returns a Webhook that posts readable messages to a Slack
+// incoming webhook URL instead of raw events. Pair it with SlackWorthy to
+// avoid flooding the channel.
+func NewSlackNotifier(url string) *Webhook {
+	return &Webhook{URL: url, Encode: slackMessage}
+}
+
+// SlackWorthy reports whether ev deserves a Slack message: every event that
+// ends the run and, when every is positive, the end of every Nth iteration.
+func SlackWorthy(ev StatusEvent, every int) bool {
+	if ev.Terminal() {
+		return true
+	}
+	return every > 0 && ev.Event == EventIterationEnd && ev.Iteration%every == 0
+}
+
+var slackIcons = map[string]string{
+	EventComplete:          "✅",
+	EventCancelled:         "🛑",
+	EventCancelledGraceful: "✋",
+	EventMaxIterations:     "🔢",
+	EventBudgetExceeded:    "💸",
+	EventDeadlineExceeded:  "⏰",
+	EventStalled:           "🧊",
+	EventErrorAbort:        "❌",
+	EventBlocked:           "🚧",
+	EventPromptTooLarge:    "📏",
+	EventIterationEnd:      "🔁",
+}
+
+func slackMessage(ev StatusEvent) ([]byte, error) {
+	icon := slackIcons[ev.Event]
+	if icon == "" {
+		icon = "ℹ️"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s *ralph %s* — agent `%s`, iteration %d", icon, strings.ReplaceAll(ev.Event, "_", " "), ev.Agent, ev.Iteration)
+	if !ev.StartedAt.IsZero() {
+		fmt.Fprintf(&b, ", running for %s", ev.Timestamp.Sub(ev.StartedAt).Round(time.Second))
+	}
+	if ev.Message != "" {
+		fmt.Fprintf(&b, "\n%s", ev.Message)
+	}
+	if ev.OutputTail != "" {
+		fmt.Fprintf(&b, "\n```%s```", strings.ReplaceAll(ev.OutputTail, "```", "'''"))
+	}
+	return json.Marshal(map[string]string{"text": b.String()})
+}
@@ -0,0 +1,112 @@
+package ralph
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SpecsDir is the conventional directory of specs, see Loop.SpecsDir.
+const SpecsDir = "specs"
+
+// DefaultSpecsInclude selects the Markdown files of a specs directory.
+var DefaultSpecsInclude = []string{"*.md"}
+
+// SpecFile is a file of a specs directory.
+type SpecFile struct {
+	// Path is relative to the working directory, with forward slashes.
+	Path string
+	Text string
+}
+
+// ReadSpecs returns the files under dir that match one of include (default
+// DefaultSpecsInclude) and none of exclude, in path order so that names
+// like 01-overview.md set it. Patterns are matched against the path
+// relative to dir, or against the file name if they contain no slash; a
+// "dir/**" pattern matches everything under dir. Files matched by ignore
+// are left out too. A missing dir has no specs.
+func ReadSpecs(dir string, include, exclude []string, ignore *Ignore) ([]SpecFile, error) {
+	if len(include) == 0 {
+		include = DefaultSpecsInclude
+	}
+	for _, p := range append(include[:len(include):len(include)], exclude...) {
+		if _, err := path.Match(strings.TrimSuffix(p, "/**"), ""); err != nil {
+			return nil, fmt.Errorf("bad specs pattern %q: %w", p, err)
+		}
+	}
+	var specs []SpecFile
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == dir && os.IsNotExist(err) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if ignore.Match(p) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !matchSpec(rel, include) || matchSpec(rel, exclude) {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		specs = append(specs, SpecFile{Path: filepath.ToSlash(p), Text: string(data)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Path < specs[j].Path })
+	return specs, nil
+}
+
+// matchSpec reports whether the path rel matches one of patterns.
+func matchSpec(rel string, patterns []string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "/**"); ok {
+			n := strings.Count(prefix, "/") + 1
+			parts := strings.Split(rel, "/")
+			if len(parts) > n {
+				if ok, _ := path.Match(prefix, strings.Join(parts[:n], "/")); ok {
+					return true
+				}
+			}
+			continue
+		}
+		name := rel
+		if !strings.Contains(p, "/") {
+			name = path.Base(rel)
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// specsSection is the prompt section with the specs, each under its path.
+func specsSection(specs []SpecFile) string {
+	var sb strings.Builder
+	sb.WriteString("## Specs\n\nThe specifications of what to build. Follow them; if they need to change, say why.")
+	for _, s := range specs {
+		fmt.Fprintf(&sb, "\n\n### %s\n\n%s", s.Path, strings.TrimRight(s.Text, "\n"))
+	}
+	return sb.String()
+}
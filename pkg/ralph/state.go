@@ -0,0 +1,122 @@
+package ralph
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// State is a checkpoint of a loop, written after every iteration so a run
+// can be resumed after a crash, a reboot or an intentional stop.
+type State struct {
+	Agent     string    `json:"agent"`
+	Iteration int       `json:"iteration"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// PromptHash and LastOutputHash are hex SHA-256 digests of the prompt
+	// instructions and the agent output of the last iteration.
+	PromptHash     string `json:"prompt_hash,omitempty"`
+	LastOutputHash string `json:"last_output_hash,omitempty"`
+	// Stalls and AgentErrors carry the consecutive-iteration counters.
+	Stalls      int `json:"stalls,omitempty"`
+	AgentErrors int `json:"agent_errors,omitempty"`
+	// Phase is the phase being worked on and PhaseStart the iteration it
+	// started after, for loops with Phases.
+	Phase      string `json:"phase,omitempty"`
+	PhaseStart int    `json:"phase_start,omitempty"`
+	// BaseCommit is HEAD when the run started.
+	BaseCommit string `json:"base_commit,omitempty"`
+	// Usage totals the cost and tokens of the run so far.
+	Usage *Usage `json:"usage,omitempty"`
+	// Finished is set once the run has ended for good (complete, stalled or
+	// aborted); such a run cannot be resumed. A run that used up its
+	// iterations, budget or time can, with a higher limit.
+	Finished   bool   `json:"finished"`
+	StopReason string `json:"stop_reason,omitempty"`
+}
+
+// LoadState reads a checkpoint written by a Loop with StateFile set.
+func LoadState(path string) (State, error) {
+	var st State
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return st, err
+	}
+	err = json.Unmarshal(data, &st)
+	return st, err
+}
+
+// Resume makes the next Run continue from st: iteration numbers, the start
+// time, the phase and the stall and error counters pick up where they left
+// off. Set Phases before calling it.
+func (l *Loop) Resume(st State) {
+	if st.Phase != "" && l.SkipToPhase(st.Phase) == nil {
+		l.phaseStart = st.PhaseStart
+	}
+	l.iteration = st.Iteration
+	l.startTime = st.StartedAt
+	l.stalls = st.Stalls
+	l.agentErrors = st.AgentErrors
+	l.resumedPromptHash = st.PromptHash
+	l.runBase = st.BaseCommit
+	l.addUsage(st.Usage)
+	if h, err := hex.DecodeString(st.LastOutputHash); err == nil && len(h) == sha256.Size {
+		copy(l.lastOutputHash[:], h)
+	}
+}
+
+// checkpoint writes the loop state to StateFile, if set.
+func (l *Loop) checkpoint(stopReason string) {
+	if l.StateFile == "" {
+		return
+	}
+	st := State{
+		Agent:       l.AgentName,
+		Iteration:   l.iteration,
+		StartedAt:   l.startTime,
+		UpdatedAt:   time.Now(),
+		PromptHash:  l.promptHash,
+		Stalls:      l.stalls,
+		AgentErrors: l.agentErrors,
+		StopReason:  stopReason,
+		BaseCommit:  l.runBase,
+	}
+	if p := l.currentPhase(); p != nil {
+		st.Phase, st.PhaseStart = p.Name, l.phaseStart
+	}
+	if l.hasUsage {
+		usage := l.usage
+		st.Usage = &usage
+	}
+	if l.lastOutputHash != ([sha256.Size]byte{}) {
+		st.LastOutputHash = hex.EncodeToString(l.lastOutputHash[:])
+	}
+	switch stopReason {
+	case "", StopReasonCancelled, StopReasonStopped, StopReasonMaxIterations, StopReasonBudgetExceeded, StopReasonDeadlineExceeded, StopReasonBlocked, StopReasonPromptTooLarge:
+	default:
+		st.Finished = true
+	}
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(l.StateFile), 0755)
+	}
+	if err == nil {
+		// Write-then-rename so a crash mid-write never leaves a torn file.
+		tmp := l.StateFile + ".tmp"
+		if err = os.WriteFile(tmp, append(data, '\n'), 0644); err == nil {
+			err = os.Rename(tmp, l.StateFile)
+		}
+	}
+	if err != nil {
+		l.logf("⚠️ Failed to write state file: %v\n", err)
+	}
+}
+
+func hashString(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,161 @@
+package ralph
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Periods HistoryStats.Trend can be grouped by.
+const (
+	PeriodDay   = "day"
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+)
+
+// RunStats aggregates a group of recorded runs.
+type RunStats struct {
+	Runs int `json:"runs"`
+	// Completed counts the runs that ended with the task complete;
+	// SuccessRate is their share of Finished, the runs that ended at all.
+	Completed   int     `json:"completed"`
+	Finished    int     `json:"finished"`
+	SuccessRate float64 `json:"success_rate"`
+	// AvgIterationsToComplete averages the iterations of completed runs.
+	AvgIterationsToComplete float64 `json:"avg_iterations_to_complete"`
+	AvgDurationSeconds      float64 `json:"avg_duration_seconds"`
+	// AvgCostUSD averages the runs whose agent reported a cost (CostRuns).
+	AvgCostUSD   float64 `json:"avg_cost_usd"`
+	TotalCostUSD float64 `json:"total_cost_usd"`
+	CostRuns     int     `json:"cost_runs"`
+
+	iterations, durations float64
+}
+
+// AgentStats is RunStats for one agent.
+type AgentStats struct {
+	Agent string `json:"agent"`
+	RunStats
+}
+
+// PeriodStats is RunStats for the runs started in one period.
+type PeriodStats struct {
+	// Period is its first day, e.g. "2026-10-12".
+	Period string `json:"period"`
+	RunStats
+}
+
+// HistoryStats summarizes recorded runs, see SummarizeRuns.
+type HistoryStats struct {
+	RunStats
+	Agents []AgentStats `json:"agents"`
+	// StopReasons counts how the runs ended; runs that never did are
+	// "unfinished".
+	StopReasons map[string]int `json:"stop_reasons"`
+	// Trend is grouped by By, oldest first.
+	By    string        `json:"by"`
+	Trend []PeriodStats `json:"trend"`
+}
+
+func (s *RunStats) add(r HistoryRun) {
+	s.Runs++
+	if r.EndedAt == nil {
+		return
+	}
+	s.Finished++
+	s.durations += r.EndedAt.Sub(r.StartedAt).Seconds()
+	if r.Event == EventComplete {
+		s.Completed++
+		s.iterations += float64(r.Iterations)
+	}
+	if r.Usage != nil {
+		s.CostRuns++
+		s.TotalCostUSD += r.Usage.CostUSD
+	}
+}
+
+func (s *RunStats) finish() {
+	if s.Finished > 0 {
+		s.SuccessRate = float64(s.Completed) / float64(s.Finished)
+		s.AvgDurationSeconds = s.durations / float64(s.Finished)
+	}
+	if s.Completed > 0 {
+		s.AvgIterationsToComplete = s.iterations / float64(s.Completed)
+	}
+	if s.CostRuns > 0 {
+		s.AvgCostUSD = s.TotalCostUSD / float64(s.CostRuns)
+	}
+}
+
+// periodStart returns the first day of the period t falls in.
+func periodStart(t time.Time, by string) time.Time {
+	t = t.Local()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	switch by {
+	case PeriodWeek:
+		// Weeks start on Monday.
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case PeriodMonth:
+		return day.AddDate(0, 0, 1-day.Day())
+	default:
+		return day
+	}
+}
+
+// SummarizeRuns aggregates runs overall, per agent, per stop reason and
+// per period (PeriodDay, PeriodWeek or PeriodMonth).
+func SummarizeRuns(runs []HistoryRun, by string) (HistoryStats, error) {
+	switch by {
+	case PeriodDay, PeriodWeek, PeriodMonth:
+	default:
+		return HistoryStats{}, fmt.Errorf("unknown period %q (want %s, %s or %s)", by, PeriodDay, PeriodWeek, PeriodMonth)
+	}
+	stats := HistoryStats{By: by, StopReasons: map[string]int{}}
+	agents := map[string]*AgentStats{}
+	periods := map[string]*PeriodStats{}
+	for _, r := range runs {
+		stats.add(r)
+
+		a := agents[r.Agent]
+		if a == nil {
+			a = &AgentStats{Agent: r.Agent}
+			agents[r.Agent] = a
+		}
+		a.add(r)
+
+		key := periodStart(r.StartedAt, by).Format(time.DateOnly)
+		p := periods[key]
+		if p == nil {
+			p = &PeriodStats{Period: key}
+			periods[key] = p
+		}
+		p.add(r)
+
+		reason := r.StopReason
+		switch {
+		case reason != "":
+		case r.Event != "":
+			reason = r.Event
+		default:
+			reason = "unfinished"
+		}
+		stats.StopReasons[reason]++
+	}
+	stats.finish()
+	for _, a := range agents {
+		a.finish()
+		stats.Agents = append(stats.Agents, *a)
+	}
+	sort.Slice(stats.Agents, func(i, j int) bool {
+		if stats.Agents[i].Runs != stats.Agents[j].Runs {
+			return stats.Agents[i].Runs > stats.Agents[j].Runs
+		}
+		return stats.Agents[i].Agent < stats.Agents[j].Agent
+	})
+	for _, p := range periods {
+		p.finish()
+		stats.Trend = append(stats.Trend, *p)
+	}
+	sort.Slice(stats.Trend, func(i, j int) bool { return stats.Trend[i].Period < stats.Trend[j].Period })
+	return stats, nil
+}
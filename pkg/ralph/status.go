@@ -0,0 +1,72 @@
+package ralph
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Status file modes.
+const (
+	StatusModeOverwrite = "overwrite"
+	StatusModeAppend    = "append"
+)
+
+// DefaultStatusMaxBytes is the size at which an append-mode status log is rotated.
+const DefaultStatusMaxBytes = 10 << 20
+
+// StatusWriter persists status events to a file, either keeping only the
+// latest event (overwrite) or one JSON object per line (append).
+type StatusWriter struct {
+	Path string
+	Mode string
+	// MaxBytes rotates an append-mode log to Path+".1" once it grows past
+	// this size (0 = never rotate).
+	MaxBytes int64
+}
+
+// Write records event according to the writer's mode.
+func (w *StatusWriter) Write(event StatusEvent) error {
+	switch w.Mode {
+	case "", StatusModeOverwrite:
+		return WriteStatusFile(w.Path, event)
+	case StatusModeAppend:
+		return w.append(event)
+	default:
+		return fmt.Errorf("unknown status mode %q", w.Mode)
+	}
+}
+
+func (w *StatusWriter) append(event StatusEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if w.MaxBytes > 0 {
+		if info, err := os.Stat(w.Path); err == nil && info.Size()+int64(len(data))+1 > w.MaxBytes {
+			if err := os.Rename(w.Path, w.Path+".1"); err != nil {
+				return err
+			}
+		}
+	}
+
+	f, err := os.OpenFile(w.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// WriteStatusFile overwrites path with event encoded as indented JSON.
+func WriteStatusFile(path string, event StatusEvent) error {
+	data, err := json.MarshalIndent(event, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,66 @@
+package ralph
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// todoItemPattern matches a Markdown checklist item: "- [ ] text" or "- [x] text".
+var todoItemPattern = regexp.MustCompile(`^(\s*[-*+]\s+\[)([ xX])(\]\s+)(.*?)\s*$`)
+
+// TodoItem is a checklist item of a plan file such as fix_plan.md or TODO.md.
+type TodoItem struct {
+	Text string
+	Done bool
+	// Line is the item's 1-based line number.
+	Line int
+}
+
+// ReadTodo returns the checklist items of the Markdown file at path, in
+// order. Other lines are ignored.
+func ReadTodo(path string) ([]TodoItem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var items []TodoItem
+	for i, line := range strings.Split(string(data), "\n") {
+		if m := todoItemPattern.FindStringSubmatch(line); m != nil && m[4] != "" {
+			items = append(items, TodoItem{Text: m[4], Done: m[2] != " ", Line: i + 1})
+		}
+	}
+	return items, nil
+}
+
+// CheckOffTodo marks the first open item with the given text as done. An
+// item that is already checked off is left alone.
+func CheckOffTodo(path, text string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(data), "\n")
+	done := false
+	for i, line := range lines {
+		m := todoItemPattern.FindStringSubmatchIndex(line)
+		if m == nil || line[m[8]:m[9]] != text {
+			continue
+		}
+		if line[m[4]:m[5]] != " " {
+			done = true
+			continue
+		}
+		lines[i] = line[:m[4]] + "x" + line[m[5]:]
+		return os.WriteFile(path, []byte(strings.Join(lines, "\n")), info.Mode().Perm())
+	}
+	if done {
+		return nil
+	}
+	return fmt.Errorf("%s no longer lists %q", path, text)
+}
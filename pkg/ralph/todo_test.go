@@ -0,0 +1,71 @@
+package ralph
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTodo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "TODO.md")
+	plan := `# Plan
+
+- [x] set up the project
+- [ ] write the parser
+  * [ ] handle comments
++ [X] add a README
+- [ ]
+- not a checklist item
+- [ ] write the parser
+`
+	if err := os.WriteFile(path, []byte(plan), 0644); err != nil {
+		t.Fatal(err)
+	}
+	items, err := ReadTodo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TodoItem{
+		{Text: "set up the project", Done: true, Line: 3},
+		{Text: "write the parser", Line: 4},
+		{Text: "handle comments", Line: 5},
+		{Text: "add a README", Done: true, Line: 6},
+		{Text: "write the parser", Line: 9},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Fatalf("ReadTodo = %+v, want %+v", items, want)
+	}
+
+	tests := []struct {
+		text    string
+		wantErr bool
+		line    int // the line checked off, 0 for none
+	}{
+		{"write the parser", false, 4},
+		{"write the parser", false, 9},
+		// Checked off already: left alone.
+		{"write the parser", false, 0},
+		{"handle comments", false, 5},
+		{"add a README", false, 0},
+		{"something else", true, 0},
+	}
+	for _, tt := range tests {
+		before, _ := ReadTodo(path)
+		err := CheckOffTodo(path, tt.text)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("CheckOffTodo(%q) = %v, want error %v", tt.text, err, tt.wantErr)
+		}
+		after, _ := ReadTodo(path)
+		for i := range after {
+			if changed := after[i].Done != before[i].Done; changed != (after[i].Line == tt.line) {
+				t.Errorf("CheckOffTodo(%q): line %d done = %v", tt.text, after[i].Line, after[i].Done)
+			}
+		}
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "\n  * [x] handle comments\n") {
+		t.Errorf("indentation and bullet not kept:\n%s", data)
+	}
+}
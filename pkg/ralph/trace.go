@@ -0,0 +1,275 @@
+package ralph
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracer turns status events into OpenTelemetry spans — one root span per
+// run and a child span per iteration — and exports them with OTLP over
+// HTTP/JSON. Feed it from Loop.OnEvent via Observe.
+type Tracer struct {
+	// Endpoint is the OTLP traces URL, e.g. http://localhost:4318/v1/traces.
+	Endpoint string
+	Headers  map[string]string
+	Service  string
+	Client   *http.Client
+	// OnError, if set, is called when an export fails.
+	OnError func(error)
+
+	mu      sync.Mutex
+	traceID string
+	root    *span
+	current *span
+	queue   chan *span
+	done    chan struct{}
+}
+
+// NewTracerFromEnv configures a Tracer from the standard OTEL_* environment
+// variables. It returns nil when no OTLP endpoint is configured or traces
+// are disabled.
+func NewTracerFromEnv() *Tracer {
+	if os.Getenv("OTEL_SDK_DISABLED") == "true" || os.Getenv("OTEL_TRACES_EXPORTER") == "none" {
+		return nil
+	}
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			return nil
+		}
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	headers := parseOTelHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	for k, v := range parseOTelHeaders(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS")) {
+		headers[k] = v
+	}
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "ralph"
+	}
+	return &Tracer{Endpoint: endpoint, Headers: headers, Service: service}
+}
+
+// parseOTelHeaders parses the "k1=v1,k2=v2" format of OTEL_EXPORTER_OTLP_HEADERS.
+func parseOTelHeaders(s string) map[string]string {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		if dv, err := url.QueryUnescape(strings.TrimSpace(v)); err == nil {
+			v = dv
+		}
+		headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return headers
+}
+
+type span struct {
+	id     string
+	parent string
+	name   string
+	start  time.Time
+	end    time.Time
+	attrs  map[string]any
+	failed bool
+}
+
+// Start launches the export goroutine. Call Close to flush and stop it.
+func (t *Tracer) Start() {
+	if t.Client == nil {
+		t.Client = &http.Client{Timeout: webhookTimeout}
+	}
+	t.traceID = randomHex(16)
+	t.queue = make(chan *span, webhookQueueSize)
+	t.done = make(chan struct{})
+	go func() {
+		defer close(t.done)
+		for s := range t.queue {
+			if err := t.export([]*span{s}); err != nil && t.OnError != nil {
+				t.OnError(err)
+			}
+		}
+	}()
+}
+
+// Close ends any open spans, then waits up to timeout for the export of
+// everything recorded so far.
+func (t *Tracer) Close(timeout time.Duration) {
+	t.mu.Lock()
+	now := time.Now()
+	t.finish(t.current, now)
+	t.current = nil
+	t.finish(t.root, now)
+	t.root = nil
+	t.mu.Unlock()
+
+	close(t.queue)
+	select {
+	case <-t.done:
+	case <-time.After(timeout):
+	}
+}
+
+// Observe records ev on the run and iteration spans.
+func (t *Tracer) Observe(ev StatusEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.root == nil {
+		start := ev.StartedAt
+		if start.IsZero() {
+			start = ev.Timestamp
+		}
+		t.root = &span{id: randomHex(8), name: "ralph.run", start: start, attrs: map[string]any{"ralph.agent": ev.Agent}}
+	}
+
+	switch ev.Event {
+	case EventIterationStart:
+		t.finish(t.current, ev.Timestamp)
+		t.current = &span{
+			id:     randomHex(8),
+			parent: t.root.id,
+			name:   "ralph.iteration",
+			start:  ev.Timestamp,
+			attrs: map[string]any{
+				"ralph.agent":     ev.Agent,
+				"ralph.iteration": ev.Iteration,
+			},
+		}
+	case EventIterationEnd:
+		if t.current != nil {
+			t.current.end = ev.Timestamp
+			t.current.attrs["ralph.output_bytes"] = ev.OutputBytes
+			t.current.attrs["ralph.stop_signal_detected"] = false
+			if ev.AgentExitCode != nil {
+				t.current.attrs["ralph.agent.exit_code"] = *ev.AgentExitCode
+				t.current.failed = *ev.AgentExitCode != 0
+			}
+		}
+	case EventValidationFailed:
+		if t.current != nil {
+			t.current.attrs["ralph.stop_signal_detected"] = true
+			t.current.attrs["ralph.validation_failed"] = true
+		}
+	}
+
+	if ev.Terminal() {
+		if t.current != nil && ev.StopReason == StopReasonStopSignal {
+			t.current.attrs["ralph.stop_signal_detected"] = true
+		}
+		t.finish(t.current, ev.Timestamp)
+		t.current = nil
+		t.root.attrs["ralph.iterations"] = ev.Iteration
+		t.root.attrs["ralph.stop_reason"] = ev.StopReason
+		t.root.failed = ev.Event == EventErrorAbort || ev.Event == EventStalled || ev.Event == EventBlocked || ev.Event == EventPromptTooLarge
+		t.finish(t.root, ev.Timestamp)
+		t.root = nil
+	}
+}
+
+// finish queues s for export, using end if it has no end time yet.
+func (t *Tracer) finish(s *span, end time.Time) {
+	if s == nil {
+		return
+	}
+	if s.end.IsZero() {
+		s.end = end
+	}
+	select {
+	case t.queue <- s:
+	default:
+		if t.OnError != nil {
+			t.OnError(fmt.Errorf("trace export queue full, span %s dropped", s.name))
+		}
+	}
+}
+
+func (t *Tracer) export(spans []*span) error {
+	var out []map[string]any
+	for _, s := range spans {
+		var attrs []map[string]any
+		for k, v := range s.attrs {
+			attrs = append(attrs, otelAttr(k, v))
+		}
+		js := map[string]any{
+			"traceId":           t.traceID,
+			"spanId":            s.id,
+			"name":              s.name,
+			"kind":              1, // SPAN_KIND_INTERNAL
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+			"attributes":        attrs,
+		}
+		if s.parent != "" {
+			js["parentSpanId"] = s.parent
+		}
+		if s.failed {
+			js["status"] = map[string]any{"code": 2} // STATUS_CODE_ERROR
+		}
+		out = append(out, js)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": []any{otelAttr("service.name", t.Service)}},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "ralph"},
+				"spans": out,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, t.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp %s: %s", t.Endpoint, resp.Status)
+	}
+	return nil
+}
+
+func otelAttr(key string, v any) map[string]any {
+	var value map[string]any
+	switch v := v.(type) {
+	case int:
+		value = map[string]any{"intValue": strconv.Itoa(v)}
+	case bool:
+		value = map[string]any{"boolValue": v}
+	default:
+		value = map[string]any{"stringValue": fmt.Sprint(v)}
+	}
+	return map[string]any{"key": key, "value": value}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
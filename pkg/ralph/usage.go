@@ -0,0 +1,194 @@
+package ralph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Agent output formats, see AgentDef.Format.
+const (
+	// FormatText is plain output, passed through as is.
+	FormatText = "text"
+	// FormatClaudeJSON is Claude Code's --output-format json or stream-json:
+	// the text is extracted for display and stop-signal detection, and the
+	// cost and token counts of the final result message are recorded.
+	FormatClaudeJSON = "claude-json"
+)
+
+// Usage is what agent runs cost, for agents that report it.
+type Usage struct {
+	CostUSD             float64 `json:"cost_usd"`
+	InputTokens         int     `json:"input_tokens"`
+	OutputTokens        int     `json:"output_tokens"`
+	CacheReadTokens     int     `json:"cache_read_tokens,omitempty"`
+	CacheCreationTokens int     `json:"cache_creation_tokens,omitempty"`
+	// Turns is the number of agentic turns (model round trips).
+	Turns int `json:"turns,omitempty"`
+}
+
+// Add accumulates o into u.
+func (u *Usage) Add(o Usage) {
+	u.CostUSD += o.CostUSD
+	u.InputTokens += o.InputTokens
+	u.OutputTokens += o.OutputTokens
+	u.CacheReadTokens += o.CacheReadTokens
+	u.CacheCreationTokens += o.CacheCreationTokens
+	u.Turns += o.Turns
+}
+
+// Tokens returns the total token count, cached input included.
+func (u Usage) Tokens() int {
+	return u.InputTokens + u.OutputTokens + u.CacheReadTokens + u.CacheCreationTokens
+}
+
+// String summarizes u for progress lines. A zero cost is left out: agents
+// such as local models report tokens only.
+func (u Usage) String() string {
+	s := fmt.Sprintf("%d tokens in / %d out", u.InputTokens+u.CacheReadTokens+u.CacheCreationTokens, u.OutputTokens)
+	if u.CostUSD > 0 {
+		s = fmt.Sprintf("$%.4f, %s", u.CostUSD, s)
+	}
+	if u.Turns > 0 {
+		s += fmt.Sprintf(", %d turns", u.Turns)
+	}
+	return s
+}
+
+// budgetExceeded returns why the run's usage is over MaxCostUSD or
+// MaxTokens, or "" if it is not.
+func (l *Loop) budgetExceeded() string {
+	switch {
+	case l.MaxCostUSD > 0 && l.usage.CostUSD >= l.MaxCostUSD:
+		return fmt.Sprintf("spent $%.4f of $%.2f", l.usage.CostUSD, l.MaxCostUSD)
+	case l.MaxTokens > 0 && l.usage.Tokens() >= l.MaxTokens:
+		return fmt.Sprintf("used %d of %d tokens", l.usage.Tokens(), l.MaxTokens)
+	}
+	return ""
+}
+
+// claudeMessage is the subset of a Claude Code JSON message ralph reads.
+type claudeMessage struct {
+	Type    string `json:"type"`
+	Message struct {
+		Content []struct {
+			Type  string         `json:"type"`
+			Text  string         `json:"text"`
+			Name  string         `json:"name"`
+			Input map[string]any `json:"input"`
+		} `json:"content"`
+	} `json:"message"`
+	Result       string   `json:"result"`
+	TotalCostUSD *float64 `json:"total_cost_usd"`
+	// CostUSD is what older versions call TotalCostUSD.
+	CostUSD  *float64 `json:"cost_usd"`
+	NumTurns int      `json:"num_turns"`
+	Usage    struct {
+		InputTokens              int `json:"input_tokens"`
+		OutputTokens             int `json:"output_tokens"`
+		CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+		CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	} `json:"usage"`
+}
+
+// toolInputKeys are the tool arguments shown next to a tool call, in order
+// of preference.
+var toolInputKeys = []string{"command", "file_path", "path", "pattern", "url", "description"}
+
+// claudeJSONWriter turns Claude Code's JSON output into readable text for w
+// and records the usage of the result message. Lines that are not JSON
+// messages, such as error output, pass through unchanged.
+type claudeJSONWriter struct {
+	w io.Writer
+
+	mu        sync.Mutex
+	buf       []byte
+	wroteText bool
+	usage     *Usage
+}
+
+func (c *claudeJSONWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buf = append(c.buf, p...)
+	for {
+		i := strings.IndexByte(string(c.buf), '\n')
+		if i < 0 {
+			break
+		}
+		line := string(c.buf[:i])
+		c.buf = c.buf[i+1:]
+		c.line(line)
+	}
+	return len(p), nil
+}
+
+// close handles an unterminated last line and returns the recorded usage.
+func (c *claudeJSONWriter) close() *Usage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.buf) > 0 {
+		c.line(string(c.buf))
+		c.buf = nil
+	}
+	return c.usage
+}
+
+func (c *claudeJSONWriter) line(line string) {
+	trimmed := strings.TrimSpace(line)
+	var msg claudeMessage
+	if !strings.HasPrefix(trimmed, "{") || json.Unmarshal([]byte(trimmed), &msg) != nil || msg.Type == "" {
+		io.WriteString(c.w, strings.TrimRight(line, "\r")+"\n")
+		return
+	}
+
+	switch msg.Type {
+	case "assistant":
+		for _, block := range msg.Message.Content {
+			switch block.Type {
+			case "text":
+				if text := strings.TrimSpace(block.Text); text != "" {
+					io.WriteString(c.w, text+"\n")
+					c.wroteText = true
+				}
+			case "tool_use":
+				io.WriteString(c.w, "🔧 "+block.Name+toolSummary(block.Input)+"\n")
+			}
+		}
+	case "result":
+		// With --output-format json the result is the only text there is.
+		if !c.wroteText && strings.TrimSpace(msg.Result) != "" {
+			io.WriteString(c.w, strings.TrimSpace(msg.Result)+"\n")
+		}
+		u := Usage{
+			InputTokens:         msg.Usage.InputTokens,
+			OutputTokens:        msg.Usage.OutputTokens,
+			CacheReadTokens:     msg.Usage.CacheReadInputTokens,
+			CacheCreationTokens: msg.Usage.CacheCreationInputTokens,
+			Turns:               msg.NumTurns,
+		}
+		switch {
+		case msg.TotalCostUSD != nil:
+			u.CostUSD = *msg.TotalCostUSD
+		case msg.CostUSD != nil:
+			u.CostUSD = *msg.CostUSD
+		}
+		c.usage = &u
+	}
+}
+
+// toolSummary returns the most telling argument of a tool call, shortened.
+func toolSummary(input map[string]any) string {
+	for _, k := range toolInputKeys {
+		if v, ok := input[k].(string); ok && v != "" {
+			v = strings.Join(strings.Fields(v), " ")
+			if r := []rune(v); len(r) > 100 {
+				v = string(r[:97]) + "..."
+			}
+			return ": " + v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,134 @@
+package ralph
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Webhook defaults.
+const (
+	DefaultWebhookRetries = 3
+	webhookQueueSize      = 256
+	webhookTimeout        = 10 * time.Second
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the request body, keyed
+// with the webhook secret, as "sha256=<hex>".
+const SignatureHeader = "X-Ralph-Signature"
+
+// Webhook delivers status events to an HTTP endpoint as JSON POSTs. Events
+// are queued and sent in order from a background goroutine so a slow
+// endpoint never holds up the loop.
+type Webhook struct {
+	URL    string
+	Secret string
+	// Retries is how many times a failed delivery is retried with
+	// exponential backoff (default DefaultWebhookRetries).
+	Retries int
+	Client  *http.Client
+	// Encode builds the request body for an event (default: the event as
+	// JSON).
+	Encode func(StatusEvent) ([]byte, error)
+	// OnError, if set, is called for events that could not be delivered.
+	OnError func(StatusEvent, error)
+
+	queue chan StatusEvent
+	done  chan struct{}
+}
+
+// Start launches the delivery goroutine. Call Close to flush and stop it.
+func (w *Webhook) Start() {
+	if w.Client == nil {
+		w.Client = &http.Client{Timeout: webhookTimeout}
+	}
+	if w.Retries <= 0 {
+		w.Retries = DefaultWebhookRetries
+	}
+	w.queue = make(chan StatusEvent, webhookQueueSize)
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		for ev := range w.queue {
+			if err := w.Send(context.Background(), ev); err != nil && w.OnError != nil {
+				w.OnError(ev, err)
+			}
+		}
+	}()
+}
+
+// Notify queues ev for delivery, dropping it if the queue is full.
+func (w *Webhook) Notify(ev StatusEvent) {
+	select {
+	case w.queue <- ev:
+	default:
+		if w.OnError != nil {
+			w.OnError(ev, fmt.Errorf("webhook queue full, event dropped"))
+		}
+	}
+}
+
+// Close waits up to timeout for queued events to be delivered.
+func (w *Webhook) Close(timeout time.Duration) {
+	close(w.queue)
+	select {
+	case <-w.done:
+	case <-time.After(timeout):
+	}
+}
+
+// Send posts ev synchronously, retrying failed attempts.
+func (w *Webhook) Send(ctx context.Context, ev StatusEvent) error {
+	encode := w.Encode
+	if encode == nil {
+		encode = func(ev StatusEvent) ([]byte, error) { return json.Marshal(ev) }
+	}
+	body, err := encode(ev)
+	if err != nil {
+		return err
+	}
+
+	delay := time.Second
+	for attempt := 0; ; attempt++ {
+		err = w.post(ctx, ev.Event, body)
+		if err == nil || attempt >= w.Retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+			delay *= 2
+		}
+	}
+}
+
+func (w *Webhook) post(ctx context.Context, event string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Ralph-Event", event)
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s: %s", w.URL, resp.Status)
+	}
+	return nil
+}
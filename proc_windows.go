@@ -0,0 +1,31 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// Windows has no user signals, so loops cannot be paused from outside.
+var (
+	pauseSignal  os.Signal
+	resumeSignal os.Signal
+)
+
+// processAlive reports whether a process with the given PID exists.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	// On Windows FindProcess opens a handle and fails if the process is gone.
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = p.Release()
+	return true
+}
+
+// interruptProcess stops p. Windows cannot deliver Ctrl+C to a process
+// without a shared console, so it is killed.
+func interruptProcess(p *os.Process) {
+	_ = p.Kill()
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"ralph/pkg/ralph"
+)
+
+// racer is one agent taking part in a --race.
+type racer struct {
+	agent  string
+	branch string
+	dir    string
+	cmd    *exec.Cmd
+	code   int
+	// complete is set once the racer's loop reported the task complete.
+	complete bool
+}
+
+// runRace runs the same prompt with several agents at once, each as a child
+// `ralph run` on its own branch and worktree. The first agent whose loop
+// completes wins; the others are aborted and their worktrees and branches
+// removed. Output is multiplexed with an agent prefix and the children's
+// status events, labelled with their agent, feed this process's sinks.
+func runRace(cfg Config, argv []string) int {
+	var agents []string
+	for _, a := range strings.Split(cfg.Race, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			agents = append(agents, a)
+		}
+	}
+	switch {
+	case len(agents) < 2:
+		fmt.Fprintln(console, "❌ Error: --race needs at least two agents, e.g. --race claude,gemini")
+		return 2
+	case cfg.AgentCmd != "":
+		fmt.Fprintln(console, "❌ Error: --race and --agent-cmd are mutually exclusive; define custom agents under agents: in ralph.yaml")
+		return 2
+	case len(cfg.Workdirs.values) > 0, cfg.Resume, cfg.Interactive, cfg.TUI:
+		fmt.Fprintln(console, "❌ Error: --race cannot be combined with --workdir, --resume, --interactive or --tui")
+		return 2
+	}
+
+	ctx := context.Background()
+	orig, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		return 1
+	}
+	root, err := ralph.GitTopLevel(ctx)
+	if err != nil {
+		fmt.Fprintf(console, "❌ Error: --race needs a git repository: %v\n", err)
+		return 2
+	}
+	rel, err := filepath.Rel(root, orig)
+	if err != nil {
+		rel = "."
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		return 1
+	}
+
+	flags, agentArgs, passthrough := splitAgentArgs(argv)
+	childArgs := childRunArgs(orig, flags)
+
+	events := &ralph.Loop{AgentName: "race"}
+	flush, err := attachSinks(&cfg, events)
+	if err != nil {
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		return 2
+	}
+	defer flush()
+	var emitMu sync.Mutex
+	emit := func(ev ralph.StatusEvent) {
+		if events.OnEvent != nil {
+			emitMu.Lock()
+			events.OnEvent(ev)
+			emitMu.Unlock()
+		}
+	}
+
+	name := time.Now().Format("20060102-150405")
+	width := 0
+	for _, a := range agents {
+		width = max(width, len(a))
+	}
+	out := &prefixPrinter{}
+	racers := make([]*racer, 0, len(agents))
+
+	fmt.Fprintf(console, "🏁 Racing %s\n", strings.Join(agents, " vs "))
+	finished := make(chan *racer, len(agents))
+	for _, agent := range agents {
+		r := &racer{agent: agent, branch: WorktreeBranchPrefix + "race-" + name + "-" + agent}
+		r.dir = filepath.Join(root, WorktreesDir, "race-"+name+"-"+agent)
+		if err := ralph.GitAddWorktree(ctx, r.dir, r.branch); err != nil {
+			fmt.Fprintf(console, "❌ Error: %v\n", err)
+			abortRacers(racers, nil)
+			removeRacers(ctx, racers, nil)
+			return 1
+		}
+		racers = append(racers, r)
+		fmt.Fprintf(console, "🌿 %s: %s (branch %s)\n", agent, r.dir, r.branch)
+
+		label := fmt.Sprintf("[%-*s] ", width, agent)
+		args := append(childArgs[:len(childArgs):len(childArgs)], "--agent", agent)
+		if passthrough {
+			args = append(append(args, "--"), agentArgs...)
+		}
+		r.cmd = exec.Command(exe, args...)
+		r.cmd.Dir = filepath.Join(r.dir, rel)
+		r.cmd.Stderr = out.writer(label)
+		stdout, err := r.cmd.StdoutPipe()
+		if err == nil {
+			err = r.cmd.Start()
+		}
+		if err != nil {
+			fmt.Fprintf(console, "❌ Error: %v\n", err)
+			r.cmd = nil
+			abortRacers(racers, nil)
+			removeRacers(ctx, racers, nil)
+			return 1
+		}
+		agentOut := out.writer(label)
+		go func() {
+			// Agent output arrives wrapped in events; the rest are status
+			// events to pass on.
+			dec := json.NewDecoder(stdout)
+			for {
+				var ev ralph.StatusEvent
+				if err := dec.Decode(&ev); err != nil {
+					io.Copy(io.Discard, stdout)
+					break
+				}
+				switch {
+				case ev.Event == ralph.EventAgentOutput:
+					io.WriteString(agentOut, ev.Chunk)
+				default:
+					r.complete = r.complete || ev.Event == ralph.EventComplete
+					emit(ev)
+				}
+			}
+			r.cmd.Wait()
+			r.code = r.cmd.ProcessState.ExitCode()
+			finished <- r
+		}()
+	}
+
+	// Ctrl+C reaches the children directly through the terminal; a SIGTERM
+	// is passed on to them.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	var winner *racer
+	result := 0
+	for left := len(racers); left > 0; {
+		select {
+		case <-sigs:
+			abortRacers(racers, nil)
+		case r := <-finished:
+			left--
+			if winner == nil && r.complete && r.code == 0 {
+				winner = r
+				fmt.Fprintf(console, "\n🏆 %s finished first. Stopping the others...\n", r.agent)
+				abortRacers(racers, r)
+			}
+			if result == 0 {
+				result = r.code
+			}
+		}
+	}
+	out.flush()
+
+	if winner == nil {
+		fmt.Fprintln(console, "\n🤷 No agent completed the task. Their work is kept for review:")
+		for _, r := range racers {
+			fmt.Fprintf(console, "   %s: %s (branch %s, exit %d)\n", r.agent, r.dir, r.branch, r.code)
+		}
+		return result
+	}
+
+	removeRacers(ctx, racers, winner)
+	if err := os.Chdir(winner.dir); err == nil {
+		if hash, err := ralph.GitCommitAll(ctx, "ralph: uncommitted changes at the end of the run", cfg.gitCommitOptions()); err != nil {
+			fmt.Fprintf(console, "⚠️ Failed to commit the worktree's remaining changes: %v\n", err)
+		} else if hash != "" {
+			fmt.Fprintf(console, "📝 Committed remaining changes as %s\n", hash)
+		}
+		os.Chdir(orig)
+	}
+	base := orDefault(ralph.GitState(ctx).Branch, "HEAD")
+	fmt.Fprintf(console, "\n🏆 %s won. Its work is on branch %s.\n", winner.agent, winner.branch)
+	fmt.Fprintln(console, "   Review:  git log -p "+base+".."+winner.branch)
+	fmt.Fprintln(console, "   Merge:   git merge "+winner.branch)
+	fmt.Fprintln(console, "   Discard: git worktree remove --force "+winner.dir+" && git branch -D "+winner.branch)
+	return 0
+}
+
+// childRunArgs returns the arguments of a child `ralph run` of a race or
+// bench, working in a worktree of orig with the given flags. The children
+// write nothing but their own work tree; everything that listens, prompts or
+// writes shared files stays with this process.
+func childRunArgs(orig string, flags []string) []string {
+	args := []string{"run"}
+	if _, err := os.Stat(ConfigFile); err == nil {
+		args = append(args, "--config", filepath.Join(orig, ConfigFile))
+	}
+	args = append(args, flags...)
+	return append(args, "--race=", "--worktree=false", "--schedule=", "--output=json", "--tui=false",
+		"--web=", "--api-addr=", "--metrics-addr=", "--log-file=", "--status-file=", "--webhook-url=", "--notify-slack=", "--notify-desktop=false",
+		"--prompt-base", orig)
+}
+
+// abortRacers aborts every racer but keep. Racers that already exited are
+// unaffected.
+func abortRacers(racers []*racer, keep *racer) {
+	for _, r := range racers {
+		if r != keep && r.cmd != nil {
+			abortProcess(r.cmd.Process)
+		}
+	}
+}
+
+// removeRacers removes the worktrees and branches of every racer but keep.
+func removeRacers(ctx context.Context, racers []*racer, keep *racer) {
+	for _, r := range racers {
+		if r == keep {
+			continue
+		}
+		if err := ralph.GitRemoveWorktree(ctx, r.dir); err != nil {
+			fmt.Fprintf(console, "⚠️ Failed to remove %s: %v\n", r.dir, err)
+			continue
+		}
+		if err := ralph.GitDeleteBranch(ctx, r.branch); err != nil {
+			fmt.Fprintf(console, "⚠️ Failed to delete branch %s: %v\n", r.branch, err)
+		}
+	}
+}
+
+// abortProcess stops a child ralph right away: the first interrupt asks it
+// to stop after the iteration, the second aborts the iteration.
+func abortProcess(p *os.Process) {
+	interruptProcess(p)
+	time.Sleep(100 * time.Millisecond)
+	interruptProcess(p)
+}
+
+// prefixPrinter interleaves several output streams on stdout line by line,
+// each line labelled with its stream's prefix.
+type prefixPrinter struct {
+	mu      sync.Mutex
+	writers []*prefixWriter
+}
+
+func (p *prefixPrinter) writer(prefix string) *prefixWriter {
+	w := &prefixWriter{p: p, prefix: prefix}
+	p.mu.Lock()
+	p.writers = append(p.writers, w)
+	p.mu.Unlock()
+	return w
+}
+
+// flush prints the unterminated last lines.
+func (p *prefixPrinter) flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, w := range p.writers {
+		if len(w.buf) > 0 {
+			fmt.Fprintf(console, "%s%s\n", w.prefix, w.buf)
+			w.buf = nil
+		}
+	}
+}
+
+type prefixWriter struct {
+	p      *prefixPrinter
+	prefix string
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(b []byte) (int, error) {
+	w.p.mu.Lock()
+	defer w.p.mu.Unlock()
+	w.buf = append(w.buf, b...)
+	for {
+		i := strings.IndexByte(string(w.buf), '\n')
+		if i < 0 {
+			break
+		}
+		fmt.Fprintf(console, "%s%s\n", w.prefix, strings.TrimRight(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(b), nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"ralph/pkg/ralph"
+)
+
+// runScheduled starts a fresh run every time the --schedule cron expression
+// fires, until interrupted. Runs never overlap: fire times missed while a run
+// was going are skipped, and so is a fire time at which another loop holds
+// the project lock.
+func runScheduled(cfg Config, argv []string) int {
+	sched, err := ralph.ParseSchedule(cfg.Schedule)
+	if err != nil {
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		return 2
+	}
+
+	// Scheduler events go to the same sinks as the runs' own, except for
+	// the ones that listen on a port.
+	sinkCfg := cfg
+	sinkCfg.MetricsAddr = ""
+	agentName := cfg.Agent
+	if cfg.AgentCmd != "" {
+		agentName = ralph.AgentName(cfg.AgentCmd)
+	}
+	events := &ralph.Loop{AgentName: agentName}
+	flush, err := attachSinks(&sinkCfg, events)
+	if err != nil {
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		return 2
+	}
+	defer flush()
+	emit := func(event, msg string, wait time.Duration) {
+		if events.OnEvent != nil {
+			events.OnEvent(ralph.StatusEvent{Event: event, Agent: agentName, Timestamp: time.Now(), PID: os.Getpid(), Message: msg, WaitMS: wait.Milliseconds()})
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	result := 0
+	var last time.Time
+	for run := 1; ; run++ {
+		now := time.Now()
+		next := sched.Next(now)
+		if next.IsZero() {
+			fmt.Fprintf(console, "❌ Error: schedule %q never fires\n", cfg.Schedule)
+			return 2
+		}
+		if !last.IsZero() {
+			if missed := countFires(sched, last, now); missed > 0 {
+				fmt.Fprintf(console, "⏭️  Skipped %d scheduled run(s) that fell during the previous one.\n", missed)
+				emit(ralph.EventScheduleSkipped, fmt.Sprintf("%d run(s) overlapped the previous one", missed), 0)
+			}
+		}
+		fmt.Fprintf(console, "\n⏰ Schedule %q: next run at %s\n", cfg.Schedule, next.Format(time.DateTime))
+		emit(ralph.EventScheduled, fmt.Sprintf("next run at %s", next.Format(time.RFC3339)), time.Until(next))
+
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(console, "\n👋 Scheduler stopped.")
+			return result
+		case <-time.After(time.Until(next)):
+		}
+		last = next
+
+		if pid := readLockPID(LockFile); pid > 0 && pid != os.Getpid() && processAlive(pid) {
+			fmt.Fprintf(console, "⏭️  Skipping the run due at %s: another loop (PID %d) is running.\n", next.Format(time.DateTime), pid)
+			emit(ralph.EventScheduleSkipped, fmt.Sprintf("another loop (PID %d) is running", pid), 0)
+			continue
+		}
+
+		fmt.Fprintf(console, "\n🗓️  Scheduled run %d\n", run)
+		code, interrupted := runOnce(cfg, argv)
+		if result == 0 {
+			result = code
+		}
+		if interrupted || ctx.Err() != nil {
+			return result
+		}
+	}
+}
+
+// countFires counts the times sched fires after from and up to to.
+func countFires(sched *ralph.Schedule, from, to time.Time) int {
+	n := 0
+	for t := sched.Next(from); !t.IsZero() && !t.After(to); t = sched.Next(t) {
+		n++
+	}
+	return n
+}
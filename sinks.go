@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"ralph/pkg/ralph"
+)
+
+// WebhookSecretEnv supplies the webhook signing secret without putting it
+// on the command line.
+const WebhookSecretEnv = "RALPH_WEBHOOK_SECRET"
+
+// sinkFlushTimeout bounds how long ralph waits on exit for queued events.
+const sinkFlushTimeout = 15 * time.Second
+
+// attachSinks routes the loop's status events to every configured
+// destination, after the given extra sinks. The returned function flushes
+// them and must be called once the loop has finished.
+func attachSinks(cfg *Config, loop *ralph.Loop, extra ...func(ralph.StatusEvent)) (func(), error) {
+	var (
+		sinks   = extra
+		closers []func()
+	)
+
+	if cfg.StatusFile != "" {
+		status := &ralph.StatusWriter{Path: cfg.StatusFile, Mode: cfg.StatusMode, MaxBytes: cfg.StatusMaxBytes}
+		sinks = append(sinks, func(ev ralph.StatusEvent) {
+			if err := status.Write(ev); err != nil {
+				fmt.Fprintf(console, "⚠️ Failed to write status file: %v\n", err)
+			}
+		})
+	}
+
+	if cfg.WebhookURL != "" {
+		secret := cfg.WebhookSecret
+		if secret == "" {
+			secret = os.Getenv(WebhookSecretEnv)
+		}
+		hook := &ralph.Webhook{
+			URL:    cfg.WebhookURL,
+			Secret: secret,
+			OnError: func(ev ralph.StatusEvent, err error) {
+				fmt.Fprintf(console, "⚠️ Failed to deliver %s event to webhook: %v\n", ev.Event, err)
+			},
+		}
+		hook.Start()
+		sinks = append(sinks, hook.Notify)
+		closers = append(closers, func() { hook.Close(sinkFlushTimeout) })
+	}
+
+	if cfg.NotifySlack != "" {
+		slack := ralph.NewSlackNotifier(cfg.NotifySlack)
+		slack.OnError = func(ev ralph.StatusEvent, err error) {
+			fmt.Fprintf(console, "⚠️ Failed to notify Slack of %s: %v\n", ev.Event, err)
+		}
+		slack.Start()
+		every := cfg.NotifySlackEvery
+		sinks = append(sinks, func(ev ralph.StatusEvent) {
+			if ralph.SlackWorthy(ev, every) {
+				slack.Notify(ev)
+			}
+		})
+		closers = append(closers, func() { slack.Close(sinkFlushTimeout) })
+	}
+
+	if cfg.NotifyDesktop {
+		sinks = append(sinks, func(ev ralph.StatusEvent) {
+			if !ev.Terminal() {
+				return
+			}
+			if err := ralph.DesktopNotify(ev); err != nil {
+				fmt.Fprintf(console, "⚠️ Failed to show desktop notification: %v\n", err)
+			}
+		})
+	}
+
+	if cfg.MetricsAddr != "" {
+		ln, err := net.Listen("tcp", cfg.MetricsAddr)
+		if err != nil {
+			for _, c := range closers {
+				c()
+			}
+			return nil, fmt.Errorf("metrics: %w", err)
+		}
+		metrics := &ralph.Metrics{}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+		srv := &http.Server{Handler: mux}
+		go func() {
+			if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fmt.Fprintf(console, "⚠️ Metrics server stopped: %v\n", err)
+			}
+		}()
+		sinks = append(sinks, metrics.Observe)
+		closers = append(closers, func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			srv.Shutdown(ctx)
+		})
+	}
+
+	if len(cfg.Hooks.Names()) > 0 {
+		hooks := cfg.Hooks
+		hooks.Output = loop.Log
+		hooks.OnHookError = func(hook string, err error) {
+			fmt.Fprintf(console, "⚠️ Hook %s failed: %v\n", hook, err)
+		}
+		sinks = append(sinks, hooks.Observe)
+	}
+
+	if audit, _ := cfg.auditLog(); audit != nil {
+		sinks = append(sinks, audit.Observe)
+	}
+
+	if !cfg.NoHistory {
+		if history, err := ralph.OpenHistory(HistoryFile); err != nil {
+			fmt.Fprintf(console, "⚠️ Cannot record the run in the history: %v\n", err)
+		} else {
+			failed := false
+			history.OnError = func(err error) {
+				if !failed {
+					failed = true
+					fmt.Fprintf(console, "⚠️ Failed to record the run in the history: %v\n", err)
+				}
+			}
+			sinks = append(sinks, history.Observe)
+			loop.OnPrompt = history.ObservePrompt
+			closers = append(closers, func() { history.Close() })
+		}
+	}
+
+	if tracer := ralph.NewTracerFromEnv(); tracer != nil {
+		tracer.OnError = func(err error) {
+			fmt.Fprintf(console, "⚠️ Failed to export trace: %v\n", err)
+		}
+		tracer.Start()
+		sinks = append(sinks, tracer.Observe)
+		closers = append(closers, func() { tracer.Close(sinkFlushTimeout) })
+	}
+
+	if len(sinks) > 0 {
+		loop.OnEvent = func(ev ralph.StatusEvent) {
+			for _, sink := range sinks {
+				sink(ev)
+			}
+		}
+	}
+	return func() {
+		for _, c := range closers {
+			c()
+		}
+	}, nil
+}
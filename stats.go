@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"ralph/pkg/ralph"
+)
+
+// runStats aggregates the runs recorded in HistoryFile.
+func runStats(argv []string) int {
+	fs := flag.NewFlagSet("ralph stats", flag.ExitOnError)
+	days := fs.Int("days", 0, "Only count runs started in the last this many days (0 = all).")
+	by := fs.String("by", ralph.PeriodWeek, "Group the trend by day, week or month.")
+	agent := fs.String("agent", "", "Only count runs of this agent.")
+	asJSON := fs.Bool("json", false, "Print the statistics as JSON.")
+	_ = fs.Parse(argv)
+
+	if _, err := os.Stat(HistoryFile); err != nil {
+		fmt.Printf("❌ Error: no history in this directory (%s)\n", HistoryFile)
+		return 1
+	}
+	history, err := ralph.OpenHistory(HistoryFile)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return 1
+	}
+	defer history.Close()
+	all, err := history.Runs(0)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return 1
+	}
+	var runs []ralph.HistoryRun
+	since := time.Now().AddDate(0, 0, -*days)
+	for _, r := range all {
+		if (*days <= 0 || r.StartedAt.After(since)) && (*agent == "" || r.Agent == *agent) {
+			runs = append(runs, r)
+		}
+	}
+	stats, err := ralph.SummarizeRuns(runs, *by)
+	if err != nil {
+		fmt.Printf("❌ Error: invalid --by: %v\n", err)
+		return 2
+	}
+	if *asJSON {
+		printJSON(stats)
+		return 0
+	}
+	if stats.Runs == 0 {
+		fmt.Println("No runs recorded yet.")
+		return 0
+	}
+
+	fmt.Printf("📈 Ralph stats (%d runs)\n", stats.Runs)
+	fmt.Printf("   Success rate:          %s\n", successRate(stats.RunStats))
+	fmt.Printf("   Iterations to finish:  %s on average\n", avgIterations(stats.RunStats))
+	fmt.Printf("   Run duration:          %s on average\n", avgDuration(stats.RunStats))
+	if stats.CostRuns > 0 {
+		fmt.Printf("   Cost per run:          %s on average, $%.2f in total (%d runs reported it)\n", avgCost(stats.RunStats), stats.TotalCostUSD, stats.CostRuns)
+	} else {
+		fmt.Println("   Cost per run:          not reported by the agents")
+	}
+
+	fmt.Println("\nBy agent:")
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "AGENT\tRUNS\tSUCCESS\tITERATIONS\tDURATION\tCOST/RUN")
+	for _, a := range stats.Agents {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", a.Agent, a.Runs, successRate(a.RunStats), avgIterations(a.RunStats), avgDuration(a.RunStats), avgCost(a.RunStats))
+	}
+	tw.Flush()
+
+	fmt.Println("\nHow runs ended:")
+	reasons := make([]string, 0, len(stats.StopReasons))
+	for r := range stats.StopReasons {
+		reasons = append(reasons, r)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if n, m := stats.StopReasons[reasons[i]], stats.StopReasons[reasons[j]]; n != m {
+			return n > m
+		}
+		return reasons[i] < reasons[j]
+	})
+	tw = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, r := range reasons {
+		n := stats.StopReasons[r]
+		fmt.Fprintf(tw, "   %s\t%d\t%.0f%%\n", r, n, 100*float64(n)/float64(stats.Runs))
+	}
+	tw.Flush()
+
+	fmt.Printf("\nBy %s:\n", stats.By)
+	tw = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FROM\tRUNS\tSUCCESS\tITERATIONS\tCOST/RUN")
+	for _, p := range stats.Trend {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", p.Period, p.Runs, successRate(p.RunStats), avgIterations(p.RunStats), avgCost(p.RunStats))
+	}
+	tw.Flush()
+	return 0
+}
+
+func successRate(s ralph.RunStats) string {
+	if s.Finished == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f%% (%d/%d)", 100*s.SuccessRate, s.Completed, s.Finished)
+}
+
+func avgIterations(s ralph.RunStats) string {
+	if s.Completed == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f", s.AvgIterationsToComplete)
+}
+
+func avgDuration(s ralph.RunStats) string {
+	if s.Finished == 0 {
+		return "-"
+	}
+	return time.Duration(s.AvgDurationSeconds * float64(time.Second)).Round(time.Second).String()
+}
+
+func avgCost(s ralph.RunStats) string {
+	if s.CostRuns == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("$%.2f", s.AvgCostUSD)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"ralph/pkg/ralph"
+)
+
+// runStatus prints a summary of the latest event from the status file.
+func runStatus(args []string) int {
+	fs := flag.NewFlagSet("ralph status", flag.ExitOnError)
+	configPath := fs.String("config", ConfigFile, "Path to the project configuration file.")
+	statusFile := fs.String("status-file", "", "Status file to read (default: status_file from ralph.yaml, else "+DefaultStatusFile+").")
+	asJSON := fs.Bool("json", false, "Print the latest raw status event instead of a summary.")
+	_ = fs.Parse(args)
+
+	path := *statusFile
+	if path == "" {
+		cfg := defaultConfig()
+		if err := loadConfigFile(*configPath, &cfg); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("❌ Error: %v\n", err)
+			return 1
+		}
+		path = cfg.StatusFile
+	}
+	if path == "" {
+		path = DefaultStatusFile
+	}
+
+	data, err := readLatestStatus(path)
+	if err != nil {
+		fmt.Printf("❌ Error: no status available: %v\n", err)
+		return 1
+	}
+	if *asJSON {
+		fmt.Println(string(data))
+		return 0
+	}
+
+	var ev ralph.StatusEvent
+	if err := json.Unmarshal(data, &ev); err != nil {
+		fmt.Printf("❌ Error: %s: %v\n", path, err)
+		return 1
+	}
+	printStatus(path, ev, time.Now())
+	return 0
+}
+
+// readLatestStatus returns the most recent event in the status file.
+// Append-mode files hold one event per line; the last one is current.
+func readLatestStatus(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 && bytes.HasPrefix(data[i+1:], []byte("{")) {
+		data = data[i+1:]
+	}
+	return data, nil
+}
+
+func printStatus(path string, ev ralph.StatusEvent, now time.Time) {
+	alive := !ev.Terminal() && processAlive(ev.PID)
+
+	state := "stopped"
+	switch {
+	case alive:
+		state = fmt.Sprintf("running (pid %d)", ev.PID)
+	case !ev.Terminal():
+		state = fmt.Sprintf("dead (pid %d is gone without a final event)", ev.PID)
+	}
+
+	end := ev.Timestamp
+	if alive {
+		end = now
+	}
+
+	fmt.Printf("📊 Ralph status (%s)\n", path)
+	fmt.Printf("   State:      %s\n", state)
+	if ev.AgentVersion != "" {
+		fmt.Printf("   Agent:      %s (%s)\n", ev.Agent, ev.AgentVersion)
+	} else {
+		fmt.Printf("   Agent:      %s\n", ev.Agent)
+	}
+	fmt.Printf("   Iteration:  %d\n", ev.Iteration)
+	if !ev.StartedAt.IsZero() {
+		fmt.Printf("   Elapsed:    %s (started %s)\n", end.Sub(ev.StartedAt).Round(time.Second), ev.StartedAt.Local().Format(time.DateTime))
+	}
+	if ev.Phase != "" {
+		fmt.Printf("   Phase:      %s\n", ev.Phase)
+	}
+	if ev.TotalUsage != nil {
+		fmt.Printf("   Usage:      %s\n", ev.TotalUsage)
+	}
+	last := fmt.Sprintf("%s, %s ago", ev.Event, now.Sub(ev.Timestamp).Round(time.Second))
+	if ev.Message != "" {
+		last += ": " + ev.Message
+	}
+	fmt.Printf("   Last event: %s\n", last)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// runSubmit queues a loop with the daemon. Flags after -- are passed to
+// `ralph run`.
+func runSubmit(argv []string) int {
+	fs := flag.NewFlagSet("ralph submit", flag.ExitOnError)
+	addr := fs.String("daemon", "", "Daemon address: unix:PATH or HOST:PORT (default: unix:~/.ralph/"+DaemonSocket+").")
+	token := fs.String("token", "", "Token of a daemon listening on HOST:PORT (default: $"+DaemonTokenEnv+").")
+	workdir := fs.String("workdir", ".", "Directory to run the loop in.")
+	agent := fs.String("agent", "", "Agent to use (default: the one configured in the workdir).")
+	prompt := fs.String("prompt", "", "Prompt file, relative to the workdir (default: the configured one).")
+	promptText := fs.String("prompt-text", "", "Inline prompt text.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage:\n  ralph submit [flags] [-- run flags]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(argv)
+
+	dir, err := filepath.Abs(*workdir)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return 2
+	}
+	task := Task{Workdir: dir, Agent: *agent, Prompt: *prompt, PromptText: *promptText, Args: fs.Args()}
+	body, _ := json.Marshal(task)
+
+	client, base := daemonClient(*addr)
+	resp, err := client.Do(daemonRequest(http.MethodPost, base+"/tasks", *token, bytes.NewReader(body)))
+	if err != nil {
+		fmt.Printf("❌ Error: cannot reach the daemon (is `ralph daemon` running?): %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(resp.Body)
+		fmt.Printf("❌ Error: %s\n", strings.TrimSpace(string(msg)))
+		return 1
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("📥 Queued task %d (log: %s)\n", task.ID, task.Log)
+	return 0
+}
+
+// runQueue lists the daemon's tasks, or cancels one.
+func runQueue(argv []string) int {
+	fs := flag.NewFlagSet("ralph queue", flag.ExitOnError)
+	addr := fs.String("daemon", "", "Daemon address: unix:PATH or HOST:PORT (default: unix:~/.ralph/"+DaemonSocket+").")
+	token := fs.String("token", "", "Token of a daemon listening on HOST:PORT (default: $"+DaemonTokenEnv+").")
+	cancel := fs.Int("cancel", 0, "Cancel the task with this ID; a running task stops after its current iteration.")
+	asJSON := fs.Bool("json", false, "Print the tasks as JSON.")
+	_ = fs.Parse(argv)
+
+	client, base := daemonClient(*addr)
+	if *cancel > 0 {
+		resp, err := client.Do(daemonRequest(http.MethodDelete, fmt.Sprintf("%s/tasks/%d", base, *cancel), *token, nil))
+		if err != nil {
+			fmt.Printf("❌ Error: cannot reach the daemon (is `ralph daemon` running?): %v\n", err)
+			return 1
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			msg, _ := io.ReadAll(resp.Body)
+			fmt.Printf("❌ Error: %s\n", strings.TrimSpace(string(msg)))
+			return 1
+		}
+		fmt.Printf("🛑 Cancelled task %d.\n", *cancel)
+		return 0
+	}
+
+	resp, err := client.Do(daemonRequest(http.MethodGet, base+"/tasks", *token, nil))
+	if err != nil {
+		fmt.Printf("❌ Error: cannot reach the daemon (is `ralph daemon` running?): %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		fmt.Printf("❌ Error: %s\n", strings.TrimSpace(string(msg)))
+		return 1
+	}
+	var tasks []Task
+	if err := json.NewDecoder(resp.Body).Decode(&tasks); err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return 1
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(tasks)
+		return 0
+	}
+	if len(tasks) == 0 {
+		fmt.Println("The queue is empty.")
+		return 0
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATE\tEXIT\tSUBMITTED\tDURATION\tWORKDIR")
+	for _, t := range tasks {
+		exit, duration := "-", "-"
+		if t.ExitCode != nil {
+			exit = fmt.Sprint(*t.ExitCode)
+		}
+		if t.StartedAt != nil {
+			end := time.Now()
+			if t.FinishedAt != nil {
+				end = *t.FinishedAt
+			}
+			duration = end.Sub(*t.StartedAt).Round(time.Second).String()
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", t.ID, t.State, exit, t.SubmittedAt.Local().Format(time.DateTime), duration, t.Workdir)
+	}
+	tw.Flush()
+	return 0
+}
+
+// daemonClient returns an HTTP client and base URL for a daemon address.
+func daemonClient(addr string) (*http.Client, string) {
+	if addr == "" {
+		addr = unixAddrPrefix + filepath.Join(defaultDaemonDir(), DaemonSocket)
+	}
+	path, ok := strings.CutPrefix(addr, unixAddrPrefix)
+	if !ok {
+		return &http.Client{Timeout: 30 * time.Second}, "http://" + addr
+	}
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}, "http://ralph"
+}
+
+// daemonRequest returns a request to the daemon carrying token, or
+// $RALPH_DAEMON_TOKEN if token is empty. Requests that change the queue are
+// marked as JSON, as the daemon requires.
+func daemonRequest(method, url, token string, body io.Reader) *http.Request {
+	req, _ := http.NewRequest(method, url, body)
+	if method != http.MethodGet {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token == "" {
+		token = os.Getenv(DaemonTokenEnv)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
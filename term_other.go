@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// cbreak is not implemented on this platform; keyboard controls are off.
+func cbreak(f *os.File) (func(), error) {
+	return nil, errors.New("single-key input is not supported on this platform")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"ralph/pkg/ralph"
+)
+
+// runTodo works through the open checklist items of a plan file, running one
+// loop per item with the item as the loop's task and checking it off once
+// that loop completes. The file is re-read before every item, so the agent
+// may add, reorder or split items as it goes. It stops at the first loop
+// that does not complete. adjust is passed on to runLoop.
+//
+// Checking an item off is committed, and only the first item's loop checks
+// for uncommitted changes: later ones start from what the previous item
+// left behind.
+func runTodo(path string, argv []string, adjust func(*Config)) (int, bool) {
+	ctx := context.Background()
+	first := true
+	for {
+		items, err := ralph.ReadTodo(path)
+		if err != nil {
+			fmt.Fprintf(console, "❌ Error: --todo: %v\n", err)
+			return 2, false
+		}
+		var next *ralph.TodoItem
+		done := 0
+		for i := range items {
+			switch {
+			case items[i].Done:
+				done++
+			case next == nil:
+				next = &items[i]
+			}
+		}
+		if next == nil {
+			fmt.Fprintf(console, "\n🎉 All %d tasks in %s are done.\n", len(items), path)
+			return 0, false
+		}
+
+		fmt.Fprintf(console, "\n📋 [%d/%d] %s\n", done+1, len(items), next.Text)
+		var commit ralph.GitCommitOptions
+		code, interrupted := runLoop(argv, func(cfg *Config) {
+			if adjust != nil {
+				adjust(cfg)
+			}
+			cfg.Task = next.Text
+			// Only the task that was running can be resumed.
+			cfg.Resume = cfg.Resume && first
+			cfg.AllowDirty = cfg.AllowDirty || !first
+			commit = cfg.gitCommitOptions()
+		})
+		first = false
+		if code != 0 || interrupted {
+			return code, interrupted
+		}
+		if err := ralph.CheckOffTodo(path, next.Text); err != nil {
+			fmt.Fprintf(console, "❌ Error: %v\n", err)
+			return 1, false
+		}
+		fmt.Fprintf(console, "☑️  Checked off in %s: %s\n", path, next.Text)
+		if ralph.GitState(ctx).Repo {
+			if _, err := ralph.GitCommitFile(ctx, path, "ralph: check off "+next.Text, commit); err != nil {
+				fmt.Fprintf(console, "⚠️ Failed to commit %s: %v\n", path, err)
+			}
+		}
+	}
+}
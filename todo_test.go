@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// chdirRepo makes the working directory a new git repository holding files,
+// committed, for the duration of the test.
+func chdirRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	orig, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(orig) })
+	for _, kv := range []string{"GIT_AUTHOR_NAME=t", "GIT_AUTHOR_EMAIL=t@example.com", "GIT_COMMITTER_NAME=t", "GIT_COMMITTER_EMAIL=t@example.com"} {
+		k, v, _ := strings.Cut(kv, "=")
+		t.Setenv(k, v)
+	}
+	files[".gitignore"] = StateDir + "/\n"
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runGit(t, "init", "-q", "-b", "work")
+	runGit(t, "add", "-A")
+	runGit(t, "commit", "-q", "-m", "init")
+	return dir
+}
+
+func runGit(t *testing.T, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestRunTodoTwoItems(t *testing.T) {
+	chdirRepo(t, map[string]string{
+		"PROMPT.md": "Work on the task. Print RALPH_DONE when it is complete.\n",
+		"TODO.md":   "- [ ] first\n- [ ] second\n",
+	})
+	argv := []string{"--agent", "mock", "--mock-done-after", "1", "--no-keys", "--no-history", "--sleep", "1ms", "--status-file", ""}
+	code, interrupted := runTodo("TODO.md", argv, nil)
+	if code != 0 || interrupted {
+		t.Fatalf("runTodo = %d, %v; want 0, false", code, interrupted)
+	}
+	data, err := os.ReadFile("TODO.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "- [x] first\n- [x] second\n"; string(data) != want {
+		t.Errorf("TODO.md = %q, want %q", data, want)
+	}
+	if status := runGit(t, "status", "--porcelain", "--untracked-files=no"); status != "" {
+		t.Errorf("checking off left uncommitted changes:\n%s", status)
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"ralph/pkg/ralph"
+)
+
+// Dashboard limits.
+const (
+	tuiOutputLines  = 1000
+	tuiHistoryLines = 8
+	tuiLogLines     = 3
+)
+
+// Messages delivered to the dashboard model.
+type (
+	tuiEventMsg  ralph.StatusEvent
+	tuiOutputMsg string
+	tuiLogMsg    string
+	tuiTickMsg   time.Time
+)
+
+// tuiIteration is one row of the iteration history.
+type tuiIteration struct {
+	n        int
+	duration time.Duration
+	exitCode *int
+	note     string
+}
+
+// dashboard is the --tui front end. It owns the terminal for the whole run:
+// the loop's log, the agent's output and anything else printed to the console
+// are routed into the model instead.
+type dashboard struct {
+	program *tea.Program
+	model   *tuiModel
+	console io.Writer
+	pipe    *os.File
+	wg      sync.WaitGroup
+	final   []string
+}
+
+// useTUI prepares the dashboard and redirects the loop's and the agent's
+// output into it. The returned sink feeds it the loop's status events; the
+// dashboard itself only takes over the screen once start is called.
+func useTUI(loop *ralph.Loop, agent *ralph.CommandAgent) (*dashboard, func(ralph.StatusEvent)) {
+	d := &dashboard{}
+	m := &tuiModel{
+		loop:     loop,
+		agent:    loop.AgentName,
+		maxIter:  loop.MaxIterations,
+		status:   "starting",
+		showOut:  true,
+		keysHint: "p pause/resume · s skip rest · v show/hide output · q quit after this iteration · ctrl+c abort",
+	}
+	d.model = m
+	d.program = tea.NewProgram(m, tea.WithAltScreen(), tea.WithOutput(os.Stdout))
+
+	out := ralph.NewANSIStripper(&lineWriter{emit: func(s string) { d.program.Send(tuiOutputMsg(s)) }})
+	logs := ralph.NewANSIStripper(&lineWriter{emit: func(s string) { d.program.Send(tuiLogMsg(s)) }})
+	loop.Log = logs
+	agent.Stream = out
+	if agent.Trace != nil {
+		agent.Trace = logs
+	}
+	return d, func(ev ralph.StatusEvent) { d.program.Send(tuiEventMsg(ev)) }
+}
+
+// start takes over the terminal. cancel aborts the run on a second Ctrl+C.
+func (d *dashboard) start(cancel context.CancelFunc) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	// Stray prints (signal handling, sink errors) would corrupt the screen,
+	// so they become log lines too.
+	d.console, d.pipe = console, w
+	console = w
+	go func() {
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			d.program.Send(tuiLogMsg(ralph.StripANSI(sc.Text())))
+		}
+	}()
+
+	d.model.cancel = cancel
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		if _, err := d.program.Run(); err == nil {
+			d.final = d.model.logs
+		}
+	}()
+	return nil
+}
+
+// stop gives the screen back and repeats the last log lines, which tell how
+// the run ended.
+func (d *dashboard) stop() {
+	d.program.Quit()
+	d.wg.Wait()
+	if d.pipe != nil {
+		d.pipe.Close()
+		console = d.console
+	}
+	for _, l := range d.final {
+		fmt.Fprintln(console, l)
+	}
+}
+
+// tuiModel is the Bubble Tea model of the dashboard.
+type tuiModel struct {
+	loop     *ralph.Loop
+	cancel   context.CancelFunc
+	agent    string
+	maxIter  int
+	keysHint string
+
+	width, height int
+	iteration     int
+	startedAt     time.Time
+	iterStart     time.Time
+	restUntil     time.Time
+	status        string
+	history       []tuiIteration
+	output        []string
+	logs          []string
+	showOut       bool
+	interrupts    int
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return tuiTick()
+}
+
+func tuiTick() tea.Cmd {
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg { return tuiTickMsg(t) })
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+	case tuiTickMsg:
+		return m, tuiTick()
+	case tuiOutputMsg:
+		m.output = appendLimited(m.output, string(msg), tuiOutputLines)
+	case tuiLogMsg:
+		if s := strings.TrimSpace(string(msg)); s != "" {
+			m.logs = appendLimited(m.logs, s, tuiLogLines)
+		}
+	case tuiEventMsg:
+		m.event(ralph.StatusEvent(msg))
+	case tea.KeyMsg:
+		m.key(msg.String())
+	}
+	return m, nil
+}
+
+func (m *tuiModel) event(ev ralph.StatusEvent) {
+	if m.startedAt.IsZero() && !ev.StartedAt.IsZero() {
+		m.startedAt = ev.StartedAt
+	}
+	switch ev.Event {
+	case ralph.EventIterationStart:
+		m.iteration = ev.Iteration
+		m.iterStart = ev.Timestamp
+		m.restUntil = time.Time{}
+		m.status = "running"
+	case ralph.EventIterationEnd:
+		m.history = append(m.history, tuiIteration{
+			n:        ev.Iteration,
+			duration: time.Duration(ev.DurationMS) * time.Millisecond,
+			exitCode: ev.AgentExitCode,
+		})
+		m.status = "resting"
+		m.restUntil = ev.Timestamp.Add(m.loop.Sleep)
+	case ralph.EventRateLimited:
+		m.status = "resting"
+		m.restUntil = ev.Timestamp.Add(time.Duration(ev.WaitMS) * time.Millisecond)
+	case ralph.EventPaused, ralph.EventResumed:
+		m.status = ev.Event
+	case ralph.EventAgentSwitched:
+		m.agent = ev.Agent
+	case ralph.EventTimeout, ralph.EventOOMKilled, ralph.EventPromptChanged, ralph.EventValidationFailed, ralph.EventGuardFailed, ralph.EventReverted, ralph.EventCommitted:
+		if n := len(m.history); n > 0 && m.history[n-1].n == ev.Iteration {
+			m.history[n-1].note = strings.TrimSpace(m.history[n-1].note + " " + ev.Event)
+		} else {
+			m.status = ev.Event
+		}
+	}
+	if ev.Terminal() {
+		m.status = ev.Event
+		if ev.StopReason != "" {
+			m.status += " (" + ev.StopReason + ")"
+		}
+	}
+}
+
+func (m *tuiModel) key(k string) {
+	switch k {
+	case "p":
+		if m.loop.Paused() {
+			m.loop.Continue()
+			m.status = "running"
+		} else {
+			m.loop.Pause()
+			m.status = "pausing after this iteration"
+		}
+	case "s":
+		m.loop.SkipRest()
+	case "v":
+		m.showOut = !m.showOut
+	case "q":
+		m.loop.Stop()
+		m.status = "stopping after this iteration"
+	case "ctrl+c":
+		m.interrupts++
+		if m.interrupts == 1 || m.cancel == nil {
+			m.loop.Stop()
+			m.status = "stopping after this iteration (ctrl+c again to abort)"
+			return
+		}
+		m.status = "aborting"
+		m.cancel()
+	}
+}
+
+func (m *tuiModel) View() string {
+	if m.width == 0 {
+		return ""
+	}
+	var b strings.Builder
+	line := func(format string, args ...any) {
+		b.WriteString(truncate(fmt.Sprintf(format, args...), m.width))
+		b.WriteByte('\n')
+	}
+
+	iter := fmt.Sprint(m.iteration)
+	if m.maxIter > 0 {
+		iter += fmt.Sprintf("/%d", m.maxIter)
+	}
+	elapsed := time.Duration(0)
+	if !m.startedAt.IsZero() {
+		elapsed = time.Since(m.startedAt).Round(time.Second)
+	}
+	status := m.status
+	switch {
+	case status == "running" && !m.iterStart.IsZero():
+		status += fmt.Sprintf(" for %s", time.Since(m.iterStart).Round(time.Second))
+	case status == "resting" && time.Now().Before(m.restUntil):
+		status += fmt.Sprintf(", next in %s", time.Until(m.restUntil).Round(time.Second))
+	}
+	line("🎯 ralph · %s · iteration %s · elapsed %s", m.agent, iter, elapsed)
+	line("   %s", status)
+	line("%s", strings.Repeat("─", m.width))
+
+	history := m.history
+	if len(history) > tuiHistoryLines {
+		history = history[len(history)-tuiHistoryLines:]
+	}
+	for _, it := range history {
+		code := "-"
+		if it.exitCode != nil {
+			code = fmt.Sprint(*it.exitCode)
+		}
+		line(" #%-4d %8s  exit %-3s %s", it.n, it.duration.Round(time.Second), code, it.note)
+	}
+	if len(history) == 0 {
+		line(" no finished iterations yet")
+	}
+	line("%s", strings.Repeat("─", m.width))
+
+	// Whatever is left of the screen goes to the agent output.
+	used := 3 + max(len(history), 1) + 1 + len(m.logs) + 2
+	rows := m.height - used
+	if m.showOut {
+		out := m.output
+		if rows > 0 && len(out) > rows {
+			out = out[len(out)-rows:]
+		}
+		for _, l := range out {
+			line("%s", l)
+		}
+		rows -= len(out)
+	} else {
+		line(" (agent output hidden, press v to show)")
+		rows--
+	}
+	for ; rows > 0; rows-- {
+		b.WriteByte('\n')
+	}
+
+	line("%s", strings.Repeat("─", m.width))
+	for _, l := range m.logs {
+		line("%s", l)
+	}
+	b.WriteString(truncate(" "+m.keysHint, m.width))
+	return b.String()
+}
+
+// lineWriter splits a byte stream into lines for emit, dropping carriage
+// returns.
+type lineWriter struct {
+	mu   sync.Mutex
+	buf  []byte
+	emit func(string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, c := range p {
+		switch c {
+		case '\r':
+		case '\n':
+			w.emit(string(w.buf))
+			w.buf = w.buf[:0]
+		default:
+			w.buf = append(w.buf, c)
+		}
+	}
+	return len(p), nil
+}
+
+func appendLimited(lines []string, s string, limit int) []string {
+	lines = append(lines, s)
+	if len(lines) > limit {
+		lines = lines[len(lines)-limit:]
+	}
+	return lines
+}
+
+// truncate cuts s to at most width runes and replaces tabs, which would
+// otherwise throw off the layout.
+func truncate(s string, width int) string {
+	s = strings.ReplaceAll(s, "\t", "    ")
+	r := []rune(s)
+	if len(r) > width {
+		return string(r[:width])
+	}
+	return s
+}
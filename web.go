@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"ralph/pkg/ralph"
+)
+
+// Tokens for the web dashboard and the control API, without putting them on
+// the command line.
+const (
+	WebTokenEnv = "RALPH_WEB_TOKEN"
+	APITokenEnv = "RALPH_API_TOKEN"
+)
+
+// DefaultWebAddr is where `ralph serve` listens unless --web says otherwise.
+const DefaultWebAddr = ":8080"
+
+// runServe is `ralph run` with the web dashboard switched on.
+func runServe(argv []string) int {
+	return run(append([]string{"--web", DefaultWebAddr}, argv...))
+}
+
+// serveWeb starts the web dashboard for loop on cfg.Web and tees the agent's
+// output into it. Without a configured token a random one is generated; the
+// returned URL includes it. The returned function shuts the server down.
+func serveWeb(cfg *Config, loop *ralph.Loop, agent *ralph.CommandAgent) (func(ralph.StatusEvent), string, func(), error) {
+	token, err := httpToken(cfg.WebToken, WebTokenEnv)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	dash := &ralph.Dashboard{API: ralph.API{Loop: loop, Token: token}}
+	base, shutdown, err := listenHTTP(cfg.Web, "Web dashboard", dash)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("web dashboard: %w", err)
+	}
+	agent.Stream = io.MultiWriter(agent.Stream, dash)
+	return dash.Observe, base + "/?token=" + token, func() {
+		dash.Close()
+		shutdown()
+	}, nil
+}
+
+// serveAPI starts the control API for loop on cfg.APIAddr and tees the
+// agent's output into its event stream. Without a configured token a random
+// one is generated; the returned description includes it. The returned
+// function shuts the server down.
+func serveAPI(cfg *Config, loop *ralph.Loop, agent *ralph.CommandAgent) (func(ralph.StatusEvent), string, func(), error) {
+	token, err := httpToken(cfg.APIToken, APITokenEnv)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	api := &ralph.API{Loop: loop, Token: token}
+	base, shutdown, err := listenHTTP(cfg.APIAddr, "Control API", api)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("control API: %w", err)
+	}
+	agent.Stream = io.MultiWriter(agent.Stream, api)
+	return api.Observe, base + " (Authorization: Bearer " + token + ")", func() {
+		api.Close()
+		shutdown()
+	}, nil
+}
+
+// httpToken returns the configured token, else the one in $env, else a
+// random one.
+func httpToken(configured, env string) (string, error) {
+	if configured != "" {
+		return configured, nil
+	}
+	if token := os.Getenv(env); token != "" {
+		return token, nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// listenHTTP serves h on addr in the background. It returns the base URL to
+// reach it and a function shutting it down.
+func listenHTTP(addr, what string, h http.Handler) (string, func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", nil, err
+	}
+	srv := &http.Server{Handler: h}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(console, "⚠️ %s stopped: %v\n", what, err)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port), func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// runWorkdirs runs the loop in each directory in turn, as if ralph had been
+// started there: ralph.yaml, the prompt, the lock and relative paths given on
+// the command line all resolve against that directory. It stops early when
+// the user interrupts a run and returns the first non-zero exit code and
+// whether a run was interrupted.
+func runWorkdirs(dirs []string, argv []string) (int, bool) {
+	orig, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		return 1, false
+	}
+	defer os.Chdir(orig)
+
+	result := 0
+	for i, dir := range dirs {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(orig, dir)
+		}
+		fmt.Fprintf(console, "\n📂 [%d/%d] %s\n", i+1, len(dirs), dir)
+
+		code, interrupted := 2, false
+		if err := os.Chdir(dir); err != nil {
+			fmt.Fprintf(console, "❌ Error: %v\n", err)
+		} else {
+			code, interrupted = runHere(argv)
+		}
+		if result == 0 {
+			result = code
+		}
+		if interrupted {
+			return result, true
+		}
+	}
+	return result, false
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+
+	"ralph/pkg/ralph"
+)
+
+// WorktreesDir holds the worktrees created by --worktree, relative to the
+// repository root.
+var WorktreesDir = filepath.Join(StateDir, "worktrees")
+
+// WorktreeBranchPrefix prefixes the branches created by --worktree.
+const WorktreeBranchPrefix = "ralph/"
+
+// runHere runs the loop for the current directory, on a separate worktree
+// when --worktree is set. It returns the exit code and whether the user
+// interrupted the run.
+func runHere(argv []string) (int, bool) {
+	cfg, _, err := parseConfig("ralph run", argv, nil)
+	if err != nil {
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		return 2, false
+	}
+	if cfg.Worktree {
+		return runInWorktree(&cfg, argv)
+	}
+	if cfg.GithubPR || cfg.PromptFromIssue != "" {
+		return runReported(&cfg, argv)
+	}
+	return runTasks(cfg.Todo, argv, nil)
+}
+
+// runTasks runs one loop, or one per open item of the todo plan if set.
+func runTasks(todo string, argv []string, adjust func(*Config)) (int, bool) {
+	if todo != "" {
+		return runTodo(todo, argv, adjust)
+	}
+	return runLoop(argv, adjust)
+}
+
+// runInWorktree runs the loop, or the todo plan, on a new branch checked out
+// in its own git worktree, leaving the current checkout untouched, and offers
+// to merge the branch back once the run is over, or opens a pull request
+// for it with --github-pr. With --prompt-from-issue it comments on the issue.
+func runInWorktree(cfg *Config, argv []string) (int, bool) {
+	ctx := context.Background()
+	orig, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		return 1, false
+	}
+	root, err := ralph.GitTopLevel(ctx)
+	if err != nil {
+		fmt.Fprintf(console, "❌ Error: --worktree needs a git repository: %v\n", err)
+		return 2, false
+	}
+	base := ralph.GitState(ctx).Branch
+	var report *runReport
+	prTarget := ""
+	if cfg.GithubPR {
+		if prTarget, err = prBase(ctx, cfg, base); err != nil {
+			fmt.Fprintf(console, "❌ Error: %v\n", err)
+			return 2, false
+		}
+	}
+	if cfg.GithubPR || cfg.PromptFromIssue != "" {
+		report = &runReport{}
+	}
+
+	name := time.Now().Format("20060102-150405")
+	branch := WorktreeBranchPrefix + name
+	dir := filepath.Join(root, WorktreesDir, name)
+	if err := ralph.GitAddWorktree(ctx, dir, branch); err != nil {
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		return 1, false
+	}
+	fmt.Fprintf(console, "🌿 Worktree: %s (branch %s)\n", dir, branch)
+
+	// Run from the same subdirectory of the new checkout. Prompt and config
+	// files that are not committed are read from the original checkout.
+	rel, err := filepath.Rel(root, orig)
+	if err != nil {
+		rel = "."
+	}
+	workdir := filepath.Join(dir, rel)
+	if err := os.Chdir(workdir); err != nil {
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		return 1, false
+	}
+	if _, err := os.Stat(ConfigFile); err != nil {
+		if _, err := os.Stat(filepath.Join(orig, ConfigFile)); err == nil {
+			argv = append([]string{"--config", filepath.Join(orig, ConfigFile)}, argv...)
+		}
+	}
+	code, interrupted := runTasks(cfg.Todo, argv, func(cfg *Config) {
+		cfg.PromptBase = orig
+		cfg.report = report
+	})
+
+	if hash, err := ralph.GitCommitAll(ctx, "ralph: uncommitted changes at the end of the run", cfg.gitCommitOptions()); err != nil {
+		fmt.Fprintf(console, "⚠️ Failed to commit the worktree's remaining changes: %v\n", err)
+	} else if hash != "" {
+		fmt.Fprintf(console, "📝 Committed remaining changes as %s\n", hash)
+	}
+	if err := os.Chdir(orig); err != nil {
+		fmt.Fprintf(console, "❌ Error: %v\n", err)
+		return 1, interrupted
+	}
+
+	fmt.Fprintf(console, "\n🌿 The run's work is on branch %s.\n", branch)
+	if cfg.GithubPR && code == 0 && !interrupted {
+		prURL, err := openPR(ctx, cfg, report, branch, prTarget)
+		if err != nil {
+			fmt.Fprintf(console, "❌ Error: %v\n", err)
+			return 1, false
+		}
+		commentOnIssue(ctx, report, prURL)
+		fmt.Fprintln(console, "   Discard: git worktree remove --force "+dir+" && git branch -D "+branch)
+		return code, interrupted
+	}
+	if report != nil && code == 0 && !interrupted {
+		commentOnIssue(ctx, report, "")
+	}
+	if code == 0 && !interrupted && base != "" && isTerminal(os.Stdin) && confirm(fmt.Sprintf("Merge %s into %s now?", branch, base)) {
+		if err := ralph.GitMerge(ctx, branch); err != nil {
+			fmt.Fprintf(console, "❌ Merge failed: %v\n", err)
+			return 1, false
+		}
+		fmt.Fprintf(console, "✅ Merged %s into %s\n", branch, base)
+		if err := ralph.GitRemoveWorktree(ctx, dir); err != nil {
+			fmt.Fprintf(console, "⚠️ Failed to remove the worktree: %v\n", err)
+		}
+		return code, interrupted
+	}
+
+	fmt.Fprintln(console, "   Review:  git log -p "+orDefault(base, "HEAD")+".."+branch)
+	fmt.Fprintln(console, "   Merge:   git merge "+branch)
+	fmt.Fprintln(console, "   PR:      git push -u origin "+branch+" && gh pr create --head "+branch)
+	fmt.Fprintln(console, "   Discard: git worktree remove --force "+dir+" && git branch -D "+branch)
+	return code, interrupted
+}
+
+// isTerminal reports whether f is an interactive terminal.
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// confirm asks a yes/no question on the terminal; the default is no.
+func confirm(question string) bool {
+	fmt.Fprintf(console, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}